@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"time"
+)
+
+// creditChargeTypes are the charges account credit pays before the payment method is charged
+var creditChargeTypes = map[string]bool{"medlemskap": true, "klippekort": true}
+
+// migrateAccountCredit creates the append-only ledger of members' account credit. A member's
+// balance is the sum of their movements. Charges record how much of them credit paid.
+func migrateAccountCredit(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS credit_ledger (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		amount INTEGER NOT NULL,
+		balance INTEGER NOT NULL,
+		charge_id INTEGER,
+		description TEXT DEFAULT '',
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (charge_id) REFERENCES charges(id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	columns := []string{
+		"ALTER TABLE charges ADD COLUMN credit_applied INTEGER DEFAULT 0",
+		"ALTER TABLE credit_notes ADD COLUMN credited_amount INTEGER DEFAULT 0",
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+
+	// Credit is a prepayment from the customer until it is used
+	_, err = db.Exec("INSERT OR IGNORE INTO account_mappings (kind, key, account) VALUES ('settlement', 'credit', '2900')")
+	return err
+}
+
+// GetCreditBalance returns a member's account credit in øre
+func (db *Database) GetCreditBalance(userID int64) (int, error) {
+	var balance int
+	err := db.Conn.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM credit_ledger WHERE user_id = ?", userID).Scan(&balance)
+	return balance, err
+}
+
+// addCreditMovement appends a movement to a member's account credit. A charge ID of 0 is left
+// empty. The balance can not go below zero. Returns the balance.
+func (db *Database) addCreditMovement(userID int64, kind string, amount int, chargeID int64, description string, now time.Time) (int, error) {
+	balance, err := db.GetCreditBalance(userID)
+	if err != nil {
+		return 0, err
+	}
+	balance += amount
+	if balance < 0 {
+		return 0, fmt.Errorf("ikke nok tilgodehavende, saldoen er %.2f kr", float64(balance-amount)/100)
+	}
+
+	var charge interface{}
+	if chargeID != 0 {
+		charge = chargeID
+	}
+	_, err = db.Conn.Exec(`INSERT INTO credit_ledger (user_id, kind, amount, balance, charge_id, description, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, userID, kind, amount, balance, charge, description, now)
+	return balance, err
+}
+
+// GetCreditHistory returns the movements on a member's account credit, newest first
+func (db *Database) GetCreditHistory(userID int64) ([]models.CreditEntry, error) {
+	rows, err := db.Conn.Query(`SELECT id, user_id, amount, kind, COALESCE(description, ''), charge_id, balance, created_at
+		FROM credit_ledger WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.CreditEntry
+	for rows.Next() {
+		var e models.CreditEntry
+		var chargeID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Amount, &e.Kind, &e.Description, &chargeID, &e.Balance, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if chargeID.Valid {
+			id := int(chargeID.Int64)
+			e.ChargeID = &id
+		}
+		history = append(history, e)
+	}
+	return history, rows.Err()
+}
+
+// creditFor returns how much of a charge the payer's account credit covers
+func (db *Database) creditFor(payerID int64, amount int, chargeType string) (int, error) {
+	if amount <= 0 || !creditChargeTypes[chargeType] {
+		return 0, nil
+	}
+	balance, err := db.GetCreditBalance(payerID)
+	if err != nil {
+		return 0, err
+	}
+	if balance > amount {
+		return amount, nil
+	}
+	return balance, nil
+}
+
+// creditCharge books money owed to a member on their account credit instead of paying it back
+// to the payment method. The negative charge keeps the credit in the ledger with the revenue it
+// reverses. Members linked to a household are credited to the household payer.
+func (db *Database) creditCharge(userID int64, amount int, kind, description, chargeType string, now time.Time) error {
+	payerID, err := db.billingUserFor(userID)
+	if err != nil {
+		return err
+	}
+	var beneficiaryID interface{}
+	if payerID != userID {
+		beneficiaryID = userID
+	}
+
+	result, err := db.Conn.Exec(`INSERT INTO charges (user_id, amount, currency, status, description, type, charge_date, created_at,
+		beneficiary_user_id, payment_method_type) VALUES (?, ?, 'NOK', ?, ?, ?, ?, ?, ?, ?)`,
+		payerID, -amount, models.ChargeStatusSucceeded, description, chargeType, now, now, beneficiaryID, models.PaymentMethodTypeCredit)
+	if err != nil {
+		return err
+	}
+	chargeID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = db.addCreditMovement(payerID, kind, amount, chargeID, description, now)
+	return err
+}
+
+// reverseCreditApplied gives back the credit a charge used when the charge fails afterwards
+func (db *Database) reverseCreditApplied(c *refundableCharge, now time.Time) error {
+	if c.CreditApplied == 0 {
+		return nil
+	}
+	_, err := db.addCreditMovement(int64(c.UserID), models.CreditReversed, c.CreditApplied, int64(c.ID), c.Description, now)
+	return err
+}
+
+// AdminAdjustCredit adds credit to a member's account, e.g. as compensation, or takes it off
+// with a negative amount
+func (db *Database) AdminAdjustCredit(userID int64, amount int, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	if amount == 0 {
+		return fmt.Errorf("beløpet kan ikke være 0")
+	}
+
+	balance, err := db.addCreditMovement(userID, models.CreditAdjustment, amount, 0, reason, now)
+	if err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("%+.2f kr, ny saldo %.2f kr", float64(amount)/100, float64(balance)/100)
+	if err := db.recordAdminAction(userID, models.AdminActionAdjustCredit, reason, details, now); err != nil {
+		return err
+	}
+
+	if amount > 0 {
+		message := fmt.Sprintf("Du har fått %.2f kr til gode, som trekkes fra neste betaling for medlemskap eller klippekort.", float64(amount)/100)
+		if err := db.CreateNotification(userID, "credit_added", "Du har fått penger til gode", message); err != nil {
+			log.Printf("Could not notify user %d about credit: %v", userID, err)
+		}
+	}
+	return nil
+}
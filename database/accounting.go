@@ -0,0 +1,354 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"math"
+	"strings"
+	"time"
+)
+
+// vatAccounts are the accounts output MVA is booked on for each rate, from the standard
+// chart of accounts (NS 4102)
+var vatAccounts = map[int]string{25: "2700", 15: "2701", 12: "2702"}
+
+// vatCodes are the SAF-T standard MVA codes for output MVA at each rate. Sales without MVA,
+// such as education, are outside the MVA act.
+var vatCodes = map[int]string{25: "3", 15: "31", 12: "33", 0: "6"}
+
+// noVATCode is the SAF-T standard MVA code for a business not registered for MVA
+const noVATCode = "0"
+
+// migrateAccounting creates the accounts charges are booked on in the accounting export
+func migrateAccounting(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS account_mappings (
+		kind TEXT NOT NULL,
+		key TEXT NOT NULL,
+		account TEXT NOT NULL,
+		PRIMARY KEY (kind, key)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// Sales revenue with and without MVA, and money received in the bank or owed by companies
+	_, err = db.Exec(`INSERT OR IGNORE INTO account_mappings (kind, key, account) VALUES
+		('revenue', 'medlemskap', '3000'), ('revenue', 'klippekort', '3010'), ('revenue', 'utdanninger', '3100'),
+		('settlement', 'card', '1920'), ('settlement', 'vipps', '1920'), ('settlement', 'invoice', '1500')`)
+	return err
+}
+
+// GetAccountMappings returns the accounts charges are booked on, revenue first. Revenue
+// mappings carry the MVA rate and code of their charge type.
+func (db *Database) GetAccountMappings() ([]models.AccountMapping, error) {
+	rows, err := db.Conn.Query("SELECT kind, key, account FROM account_mappings ORDER BY kind DESC, key")
+	if err != nil {
+		return nil, err
+	}
+	var mappings []models.AccountMapping
+	for rows.Next() {
+		var m models.AccountMapping
+		if err := rows.Scan(&m.Kind, &m.Key, &m.Account); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	seller, err := db.GetBusinessDetails()
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range mappings {
+		if m.Kind != models.AccountMappingRevenue {
+			continue
+		}
+		rate, code, err := db.vatFor(m.Key, seller.VATRegistered)
+		if err != nil {
+			return nil, err
+		}
+		mappings[i].VATRate, mappings[i].VATCode = rate, code
+	}
+	return mappings, nil
+}
+
+// SaveAccountMapping sets the account a charge type or payment method is booked on
+func (db *Database) SaveAccountMapping(mapping models.AccountMapping) error {
+	if mapping.Kind != models.AccountMappingRevenue && mapping.Kind != models.AccountMappingSettlement {
+		return fmt.Errorf("ugyldig kontotype")
+	}
+	mapping.Key = strings.TrimSpace(mapping.Key)
+	if mapping.Key == "" {
+		return fmt.Errorf("type mangler")
+	}
+	mapping.Account = strings.TrimSpace(mapping.Account)
+	if len(mapping.Account) != 4 || strings.Trim(mapping.Account, "0123456789") != "" {
+		return fmt.Errorf("kontonummeret må ha 4 siffer")
+	}
+
+	_, err := db.Conn.Exec(`INSERT INTO account_mappings (kind, key, account) VALUES (?, ?, ?)
+		ON CONFLICT(kind, key) DO UPDATE SET account = excluded.account`, mapping.Kind, mapping.Key, mapping.Account)
+	return err
+}
+
+// vatFor returns the MVA rate and code of a charge type
+func (db *Database) vatFor(chargeType string, vatRegistered bool) (int, string, error) {
+	if !vatRegistered {
+		return 0, noVATCode, nil
+	}
+	rate, err := db.vatRateFor(chargeType)
+	if err != nil {
+		return 0, "", err
+	}
+	return rate, vatCodes[rate], nil
+}
+
+// splitVAT breaks the MVA out of an amount that includes it, rounding the same way for
+// refunds as for sales
+func splitVAT(amount, rate int) (int, int) {
+	if amount < 0 {
+		net, vat := splitVAT(-amount, rate)
+		return -net, -vat
+	}
+	line := receiptLine("", amount, rate)
+	return line.NetAmount, line.VATAmount
+}
+
+// accountingAccounts looks up the account of each mapping
+type accountingAccounts map[string]string
+
+func (a accountingAccounts) get(kind, key string) string {
+	if account, ok := a[kind+":"+key]; ok {
+		return account
+	}
+	// Charge types without a mapping of their own are booked as ordinary sales
+	if kind == models.AccountMappingRevenue {
+		return "3000"
+	}
+	return "1920"
+}
+
+// GetAccountingEntries returns the sales, refunds and credits to book for a period, oldest
+// first: charges members paid, credit notes written and company invoices sent between from and
+// to. Company shares of memberships are booked from the company invoice, not the charge. The
+// part of a charge or refund paid with account credit is booked on the credit account. Credit
+// an admin adds by hand is not a sale and is left to the accountant. MVA is broken out at the
+// charge type's current rate, the same as on receipts.
+func (db *Database) GetAccountingEntries(from, to time.Time) ([]models.AccountingEntry, error) {
+	mappings, err := db.GetAccountMappings()
+	if err != nil {
+		return nil, err
+	}
+	accounts := accountingAccounts{}
+	for _, m := range mappings {
+		accounts[m.Kind+":"+m.Key] = m.Account
+	}
+	seller, err := db.GetBusinessDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Conn.Query(`
+		SELECT c.charge_date, COALESCE('K-' || r.number, 'B-' || c.id), CASE WHEN c.amount < 0 THEN 'credit' ELSE 'sale' END,
+		       c.description, 'M' || u.id, u.name, COALESCE(c.type, ''), COALESCE(NULLIF(c.payment_method_type, ''), 'card'),
+		       c.amount - COALESCE(c.credit_applied, 0)
+		FROM charges c JOIN users u ON c.user_id = u.id LEFT JOIN receipts r ON r.charge_id = c.id
+		WHERE c.company_id IS NULL AND c.amount != COALESCE(c.credit_applied, 0)
+		AND c.status IN ('succeeded', 'refunded', 'partially_refunded', 'disputed') AND c.charge_date >= ? AND c.charge_date < ?
+		UNION ALL
+		SELECT c.charge_date, COALESCE('K-' || r.number, 'B-' || c.id), 'sale', c.description, 'M' || u.id, u.name,
+		       COALESCE(c.type, ''), 'credit', c.credit_applied
+		FROM charges c JOIN users u ON c.user_id = u.id LEFT JOIN receipts r ON r.charge_id = c.id
+		WHERE c.company_id IS NULL AND c.credit_applied > 0
+		AND c.status IN ('succeeded', 'refunded', 'partially_refunded', 'disputed') AND c.charge_date >= ? AND c.charge_date < ?
+		UNION ALL
+		SELECT n.created_at, n.number, 'credit_note', n.reason || ' (' || c.description || ')', 'M' || u.id, u.name,
+		       COALESCE(c.type, ''), COALESCE(NULLIF(c.payment_method_type, ''), 'card'), -(n.amount - COALESCE(n.credited_amount, 0))
+		FROM credit_notes n JOIN charges c ON n.charge_id = c.id JOIN users u ON n.user_id = u.id
+		WHERE n.amount != COALESCE(n.credited_amount, 0) AND n.created_at >= ? AND n.created_at < ?
+		UNION ALL
+		SELECT n.created_at, n.number, 'credit_note', n.reason || ' (' || c.description || ')', 'M' || u.id, u.name,
+		       COALESCE(c.type, ''), 'credit', -n.credited_amount
+		FROM credit_notes n JOIN charges c ON n.charge_id = c.id JOIN users u ON n.user_id = u.id
+		WHERE n.credited_amount > 0 AND n.created_at >= ? AND n.created_at < ?
+		UNION ALL
+		SELECT i.created_at, 'F-' || i.id, 'company_invoice', 'Bedriftsfaktura ' || i.period, 'B' || co.id, co.name,
+		       'medlemskap', 'invoice', i.amount
+		FROM company_invoices i JOIN companies co ON i.company_id = co.id
+		WHERE i.created_at >= ? AND i.created_at < ?
+		ORDER BY 1, 2`, from, to, from, to, from, to, from, to, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AccountingEntry
+	for rows.Next() {
+		var e models.AccountingEntry
+		var date string
+		if err := rows.Scan(&date, &e.Voucher, &e.Kind, &e.Description, &e.CustomerID, &e.CustomerName,
+			&e.ChargeType, &e.PaymentMethodType, &e.Amount); err != nil {
+			return nil, err
+		}
+		// Dates come back as text from the union
+		e.Date, err = parseSQLiteTime(date)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		e.VATRate, e.VATCode, err = db.vatFor(e.ChargeType, seller.VATRegistered)
+		if err != nil {
+			return nil, err
+		}
+		e.NetAmount, e.VATAmount = splitVAT(e.Amount, e.VATRate)
+		e.VATAccount = vatAccounts[e.VATRate]
+		e.SettlementAccount = accounts.get(models.AccountMappingSettlement, e.PaymentMethodType)
+		e.RevenueAccount = accounts.get(models.AccountMappingRevenue, e.ChargeType)
+	}
+	return entries, nil
+}
+
+// parseSQLiteTime reads a time the SQLite driver stored as text
+func parseSQLiteTime(value string) (time.Time, error) {
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02T15:04:05.999999999-07:00",
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02T15:04:05Z",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("ugyldig tidspunkt: %q", value)
+}
+
+// GetDeferredRevenue returns the revenue members have paid for but not used on a date. Unused
+// klipp on active cards are valued at what was paid per klipp for the card, less refunds.
+// Active memberships are valued at the share of the last paid period still to run.
+func (db *Database) GetDeferredRevenue(asOf time.Time) (*models.DeferredRevenue, error) {
+	seller, err := db.GetBusinessDetails()
+	if err != nil {
+		return nil, err
+	}
+	deferred := &models.DeferredRevenue{AsOf: asOf}
+
+	rows, err := db.Conn.Query(`
+		SELECT uk.user_id, u.name, kp.name, uk.remaining_klipp,
+		       COALESCE((SELECT SUM(c.amount - COALESCE(c.refunded_amount, 0)) FROM charges c
+		                 WHERE c.user_klippekort_id = uk.id AND c.status IN ('succeeded', 'partially_refunded', 'disputed')), 0),
+		       COALESCE((SELECT SUM(c.klipp - COALESCE((SELECT SUM(n.klipp_removed) FROM credit_notes n WHERE n.charge_id = c.id), 0))
+		                 FROM charges c
+		                 WHERE c.user_klippekort_id = uk.id AND c.status IN ('succeeded', 'partially_refunded', 'disputed')), 0)
+		FROM user_klippekort uk
+		JOIN users u ON uk.user_id = u.id
+		JOIN klippekort_packages kp ON uk.package_id = kp.id
+		WHERE uk.is_active = TRUE AND uk.remaining_klipp > 0 AND uk.expiry_date > ?
+		ORDER BY u.name, uk.id`, asOf)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		line := models.DeferredRevenueLine{ChargeType: "klippekort"}
+		var paid, klipp int
+		if err := rows.Scan(&line.UserID, &line.MemberName, &line.Description, &line.Units, &paid, &klipp); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if paid <= 0 || klipp <= 0 {
+			continue
+		}
+		line.TotalUnits = klipp
+		line.Amount = paid * line.Units / klipp
+		if line.Amount > paid {
+			line.Amount = paid
+		}
+		deferred.Lines = append(deferred.Lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Conn.Query(`
+		SELECT um.user_id, u.name, m.name, um.renewal_date FROM user_memberships um
+		JOIN users u ON um.user_id = u.id
+		JOIN memberships m ON um.membership_id = m.id
+		WHERE um.status = 'active' AND um.renewal_date > ?
+		ORDER BY u.name, um.id`, asOf)
+	if err != nil {
+		return nil, err
+	}
+	var memberships []models.DeferredRevenueLine
+	var renewals []time.Time
+	for rows.Next() {
+		line := models.DeferredRevenueLine{ChargeType: "medlemskap"}
+		var renewal time.Time
+		if err := rows.Scan(&line.UserID, &line.MemberName, &line.Description, &renewal); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		memberships = append(memberships, line)
+		renewals = append(renewals, renewal)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, line := range memberships {
+		renewal := renewals[i]
+		periodStart := renewal.AddDate(0, -1, 0)
+		// The period is paid for at its start, or when the member joined
+		var paid int
+		err := db.Conn.QueryRow(`SELECT COALESCE(SUM(amount - COALESCE(refunded_amount, 0)), 0) FROM charges
+			WHERE COALESCE(beneficiary_user_id, user_id) = ? AND type = 'medlemskap' AND amount > 0
+			AND status IN ('succeeded', 'partially_refunded', 'disputed', 'invoiced') AND charge_date >= ? AND charge_date <= ?`,
+			line.UserID, periodStart.AddDate(0, 0, -1), asOf).Scan(&paid)
+		if err != nil {
+			return nil, err
+		}
+		if paid <= 0 {
+			continue
+		}
+		line.TotalUnits = int(math.Round(renewal.Sub(periodStart).Hours() / 24))
+		line.Units = int(math.Ceil(renewal.Sub(asOf).Hours() / 24))
+		if line.Units > line.TotalUnits {
+			line.Units = line.TotalUnits
+		}
+		line.Amount = paid * line.Units / line.TotalUnits
+		deferred.Lines = append(deferred.Lines, line)
+	}
+
+	for i := range deferred.Lines {
+		line := &deferred.Lines[i]
+		rate, _, err := db.vatFor(line.ChargeType, seller.VATRegistered)
+		if err != nil {
+			return nil, err
+		}
+		line.NetAmount, _ = splitVAT(line.Amount, rate)
+		if line.ChargeType == "klippekort" {
+			deferred.Klippekort += line.NetAmount
+		} else {
+			deferred.Memberships += line.NetAmount
+		}
+	}
+	deferred.Total = deferred.Klippekort + deferred.Memberships
+	return deferred, nil
+}
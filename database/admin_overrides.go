@@ -0,0 +1,554 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"strings"
+	"time"
+)
+
+// migrateAdminOverrides creates the log of changes admins make by hand to a member's account
+// and lets a membership carry a custom price that replaces the plan's price
+func migrateAdminOverrides(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS admin_actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		details TEXT DEFAULT '',
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE user_memberships ADD COLUMN custom_price INTEGER")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	return nil
+}
+
+// checkAdminReason makes sure an override comes with a reason before anything is changed
+func checkAdminReason(reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("en begrunnelse er påkrevd")
+	}
+	return nil
+}
+
+// recordAdminAction logs an override on a member's account with its reason
+func (db *Database) recordAdminAction(userID int64, action, reason, details string, now time.Time) error {
+	return writeAdminAction(db.Conn, userID, action, reason, details, now)
+}
+
+// writeAdminAction is recordAdminAction with the connection or a transaction, so an override
+// is only logged when it is made and the other way round
+func writeAdminAction(w dbWriter, userID int64, action, reason, details string, now time.Time) error {
+	_, err := w.Exec("INSERT INTO admin_actions (user_id, action, reason, details, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, action, strings.TrimSpace(reason), details, now)
+	return err
+}
+
+// GetAdminActions returns the overrides made on a member's account, newest first
+func (db *Database) GetAdminActions(userID int64) ([]models.AdminAction, error) {
+	rows, err := db.Conn.Query(`SELECT id, user_id, action, reason, details, created_at
+		FROM admin_actions WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []models.AdminAction
+	for rows.Next() {
+		var a models.AdminAction
+		if err := rows.Scan(&a.ID, &a.UserID, &a.Action, &a.Reason, &a.Details, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// currentUserMembershipID returns the member's ongoing membership
+func (db *Database) currentUserMembershipID(userID int64) (int64, error) {
+	var id int64
+	err := db.Conn.QueryRow(`SELECT id FROM user_memberships
+		WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')
+		ORDER BY created_at DESC LIMIT 1`, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("bruker har ingen aktivt medlemskap")
+	}
+	return id, err
+}
+
+// customMembershipPrice returns the price an admin has set for a membership on a plan, or nil.
+// The custom price only holds for the plan the membership is on when it was set.
+func (db *Database) customMembershipPrice(userMembershipID, membershipID int64) (*int, error) {
+	var price sql.NullInt64
+	err := db.Conn.QueryRow("SELECT custom_price FROM user_memberships WHERE id = ? AND membership_id = ?",
+		userMembershipID, membershipID).Scan(&price)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil || !price.Valid {
+		return nil, err
+	}
+	value := int(price.Int64)
+	return &value, nil
+}
+
+// AdminAssignMembership gives a member a plan without charging for it. The first renewal
+// is billed as usual a month later, or the plan ends by itself if it is fixed-term.
+func (db *Database) AdminAssignMembership(userID, membershipID int64, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	existing, err := db.GetUserMembership(userID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("bruker har allerede et aktivt medlemskap")
+	}
+	membership, err := db.GetMembershipByID(membershipID)
+	if err != nil {
+		return err
+	}
+
+	startDate := now.Format("2006-01-02")
+	renewalDate := now.AddDate(0, 1, 0).Format("2006-01-02")
+	endDate := now.AddDate(0, membership.CommitmentMonths, 0).Format("2006-01-02")
+	bindingEnd := endDate
+	if termEnd, ok := MembershipTermEnd(*membership, now); ok {
+		renewalDate = termEnd.Format("2006-01-02")
+		endDate = renewalDate
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO user_memberships (user_id, membership_id, status, start_date, renewal_date, end_date, binding_end, last_billed, created_at)
+		VALUES (?, ?, 'active', ?, ?, ?, ?, ?, ?)`,
+		userID, membershipID, startDate, renewalDate, endDate, bindingEnd, startDate, now)
+	if err != nil {
+		return err
+	}
+	if err := writeMembershipPeriod(tx, userID, membershipID, now); err != nil {
+		return err
+	}
+	if err := writeAdminAction(tx, userID, models.AdminActionAssignMembership, reason, membership.Name, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminChangeMembership moves a member to another plan right away, keeping the billing
+// period and binding and without charging or crediting the difference. The new plan is
+// priced from today, without the custom price of the old one.
+func (db *Database) AdminChangeMembership(userID, membershipID int64, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	userMembershipID, err := db.currentUserMembershipID(userID)
+	if err != nil {
+		return err
+	}
+	membership, err := db.GetMembershipByID(membershipID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE user_memberships SET membership_id = ?, scheduled_membership_id = NULL, plan_since = ?, custom_price = NULL
+		WHERE id = ?`, membershipID, now.Format("2006-01-02"), userMembershipID)
+	if err != nil {
+		return err
+	}
+	if err := writeMembershipPeriod(tx, userID, membershipID, now); err != nil {
+		return err
+	}
+	if err := writeAdminAction(tx, userID, models.AdminActionChangeMembership, reason, membership.Name, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminEndMembership ends a member's membership on the given date, regardless of binding
+func (db *Database) AdminEndMembership(userID int64, endDate time.Time, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	userMembershipID, err := db.currentUserMembershipID(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE user_memberships SET status = 'cancelled', end_date = ? WHERE id = ?",
+		endDate.Format("2006-01-02"), userMembershipID)
+	if err != nil {
+		return err
+	}
+	if err := writeFreezeEnd(tx, userID, now); err != nil {
+		return err
+	}
+	if err := writeMembershipPeriodEnd(tx, userID, endDate); err != nil {
+		return err
+	}
+	if err := writeAdminAction(tx, userID, models.AdminActionEndMembership, reason, endDate.Format("02.01.2006"), now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminSetMembershipStatus corrects the status of a member's newest membership, including one
+// that was ended by mistake. Freezes are started and ended to match the new status.
+func (db *Database) AdminSetMembershipStatus(userID int64, status, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	switch status {
+	case "active", "paused", "freeze_requested", "cancelled":
+	default:
+		return fmt.Errorf("ugyldig status: %s", status)
+	}
+
+	var userMembershipID, membershipID int64
+	var oldStatus string
+	err := db.Conn.QueryRow("SELECT id, membership_id, status FROM user_memberships WHERE user_id = ? ORDER BY created_at DESC LIMIT 1",
+		userID).Scan(&userMembershipID, &membershipID, &oldStatus)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("bruker har ingen medlemskap")
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if status == "cancelled" {
+		_, err = tx.Exec("UPDATE user_memberships SET status = ?, end_date = ? WHERE id = ?", status, now.Format("2006-01-02"), userMembershipID)
+	} else {
+		_, err = tx.Exec("UPDATE user_memberships SET status = ? WHERE id = ?", status, userMembershipID)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status == "paused":
+		err = writeFreezeStart(tx, userID, now)
+	case oldStatus == "paused":
+		err = writeFreezeEnd(tx, userID, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A membership brought back from an ended status gets a history period again
+	wasEnded := oldStatus == "cancelled" || oldStatus == "expired"
+	if status == "cancelled" && !wasEnded {
+		err = writeMembershipPeriodEnd(tx, userID, now)
+	} else if status != "cancelled" && wasEnded {
+		if _, err = tx.Exec("UPDATE user_memberships SET end_date = NULL WHERE id = ?", userMembershipID); err == nil {
+			err = writeMembershipPeriod(tx, userID, membershipID, now)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeAdminAction(tx, userID, models.AdminActionSetStatus, reason, fmt.Sprintf("%s → %s", oldStatus, status), now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminSetBindingEnd moves the end of a member's binding period
+func (db *Database) AdminSetBindingEnd(userID int64, bindingEnd time.Time, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	userMembershipID, err := db.currentUserMembershipID(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE user_memberships SET binding_end = ? WHERE id = ?", bindingEnd.Format("2006-01-02"), userMembershipID)
+	if err != nil {
+		return err
+	}
+	if err := writeAdminAction(tx, userID, models.AdminActionSetBindingEnd, reason, bindingEnd.Format("02.01.2006"), now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminGrantFreeMonths gives a member free months by moving their next renewal ahead
+func (db *Database) AdminGrantFreeMonths(userID int64, months int, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	if months < 1 || months > 12 {
+		return fmt.Errorf("antall gratis måneder må være mellom 1 og 12")
+	}
+	userMembershipID, err := db.currentUserMembershipID(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var renewalDate time.Time
+	if err := tx.QueryRow("SELECT renewal_date FROM user_memberships WHERE id = ?", userMembershipID).Scan(&renewalDate); err != nil {
+		return err
+	}
+	renewalDate = renewalDate.AddDate(0, months, 0)
+
+	_, err = tx.Exec("UPDATE user_memberships SET renewal_date = ? WHERE id = ?", renewalDate.Format("2006-01-02"), userMembershipID)
+	if err != nil {
+		return err
+	}
+	details := fmt.Sprintf("%d mnd, neste fornyelse %s", months, renewalDate.Format("02.01.2006"))
+	if err := writeAdminAction(tx, userID, models.AdminActionGrantFreeMonths, reason, details, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminSetCustomPrice sets the monthly price a member pays for their membership in øre,
+// or goes back to the plan's price when price is nil
+func (db *Database) AdminSetCustomPrice(userID int64, price *int, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	if price != nil && *price < 0 {
+		return fmt.Errorf("prisen kan ikke være negativ")
+	}
+	userMembershipID, err := db.currentUserMembershipID(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE user_memberships SET custom_price = ? WHERE id = ?", price, userMembershipID)
+	if err != nil {
+		return err
+	}
+	details := "standardpris"
+	if price != nil {
+		details = fmt.Sprintf("%.2f kr", float64(*price)/100)
+	}
+	if err := writeAdminAction(tx, userID, models.AdminActionSetCustomPrice, reason, details, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminGrantKlippekort gives a member a klippekort from a package without charging for it
+func (db *Database) AdminGrantKlippekort(userID, packageID int64, klipp int, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	if klipp < 1 {
+		return fmt.Errorf("antall klipp må være minst 1")
+	}
+
+	var name string
+	var validDays int
+	err := db.Conn.QueryRow("SELECT name, valid_days FROM klippekort_packages WHERE id = ?", packageID).Scan(&name, &validDays)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("klippekort-pakke ikke funnet")
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO user_klippekort (user_id, package_id, total_klipp, remaining_klipp, expiry_date, purchase_date, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, TRUE)`, userID, packageID, klipp, klipp, now.AddDate(0, 0, validDays), now)
+	if err != nil {
+		return err
+	}
+	klippekortID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err := writeKlippMovement(tx, klippekortID, 0, models.KlippAdjustment, klipp, 0, packageID, strings.TrimSpace(reason), now); err != nil {
+		return err
+	}
+	if err := writeAdminAction(tx, userID, models.AdminActionGrantKlippekort, reason, fmt.Sprintf("%s, %d klipp", name, klipp), now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminAdjustKlippekort sets the remaining klipp and expiry date of one of a member's klippekort
+func (db *Database) AdminAdjustKlippekort(userID, klippekortID int64, remaining int, expiryDate time.Time, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	if remaining < 0 {
+		return fmt.Errorf("antall klipp kan ikke være negativt")
+	}
+
+	var total, oldRemaining int
+	err := db.Conn.QueryRow("SELECT total_klipp, remaining_klipp FROM user_klippekort WHERE id = ? AND user_id = ?",
+		klippekortID, userID).Scan(&total, &oldRemaining)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("klippekort ikke funnet")
+	}
+	if err != nil {
+		return err
+	}
+	if remaining > total {
+		total = remaining
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE user_klippekort SET total_klipp = ?, expiry_date = ?, is_active = TRUE WHERE id = ?",
+		total, expiryDate, klippekortID)
+	if err != nil {
+		return err
+	}
+	if err := writeKlippekortBalance(tx, klippekortID, remaining, strings.TrimSpace(reason), now); err != nil {
+		return err
+	}
+	if err := reopenKlippekort(tx, klippekortID); err != nil {
+		return err
+	}
+	details := fmt.Sprintf("%d → %d klipp, utløper %s", oldRemaining, remaining, expiryDate.Format("02.01.2006"))
+	if err := writeAdminAction(tx, userID, models.AdminActionAdjustKlippekort, reason, details, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminExtendKlippekort moves the expiry date of one of a member's klippekort, e.g. after an
+// injury. A card that already expired gets back the klipp it lost unless they were rolled over.
+func (db *Database) AdminExtendKlippekort(userID, klippekortID int64, expiryDate time.Time, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	if !expiryDate.After(now) {
+		return fmt.Errorf("ny utløpsdato må være frem i tid")
+	}
+
+	var oldExpiry time.Time
+	var expiredAt, rolledOverAt sql.NullTime
+	var expiredKlipp int
+	err := db.Conn.QueryRow(`SELECT expiry_date, expired_at, COALESCE(expired_klipp, 0), rolled_over_at
+		FROM user_klippekort WHERE id = ? AND user_id = ?`, klippekortID, userID).Scan(&oldExpiry, &expiredAt, &expiredKlipp, &rolledOverAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("klippekort ikke funnet")
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE user_klippekort SET expiry_date = ?, is_active = TRUE WHERE id = ?", expiryDate, klippekortID); err != nil {
+		return err
+	}
+	details := fmt.Sprintf("%s → %s", oldExpiry.Format("02.01.2006"), expiryDate.Format("02.01.2006"))
+	if expiredAt.Valid && !rolledOverAt.Valid && expiredKlipp > 0 {
+		if _, err := writeKlippMovement(tx, klippekortID, 0, models.KlippAdjustment, expiredKlipp, 0, 0, strings.TrimSpace(reason), now); err != nil {
+			return err
+		}
+		details += fmt.Sprintf(", %d utløpte klipp gjenopprettet", expiredKlipp)
+	}
+	if err := reopenKlippekort(tx, klippekortID); err != nil {
+		return err
+	}
+	if err := writeAdminAction(tx, userID, models.AdminActionExtendKlippekort, reason, details, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// reopenKlippekort forgets that a klippekort expired, so it can expire and be reminded about again
+func reopenKlippekort(w dbWriter, klippekortID int64) error {
+	if _, err := w.Exec("UPDATE user_klippekort SET expired_at = NULL, expired_klipp = 0 WHERE id = ? AND rolled_over_at IS NULL",
+		klippekortID); err != nil {
+		return err
+	}
+	_, err := w.Exec("DELETE FROM klippekort_reminders WHERE user_klippekort_id = ?", klippekortID)
+	return err
+}
+
+// GetAllUserKlippekort returns all of a member's klippekort, including used up and expired ones,
+// so an admin can adjust any of them
+func (db *Database) GetAllUserKlippekort(userID int64) ([]models.KlippekortWithDetails, error) {
+	rows, err := db.Conn.Query(`
+		SELECT uk.id, uk.user_id, uk.package_id, uk.total_klipp, uk.remaining_klipp, uk.expiry_date, uk.purchase_date, uk.is_active,
+		       kp.name, kp.category
+		FROM user_klippekort uk
+		JOIN klippekort_packages kp ON uk.package_id = kp.id
+		WHERE uk.user_id = ?
+		ORDER BY uk.expiry_date DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var klippekort []models.KlippekortWithDetails
+	for rows.Next() {
+		var k models.KlippekortWithDetails
+		if err := rows.Scan(&k.UserKlippekort.ID, &k.UserKlippekort.UserID, &k.UserKlippekort.PackageID,
+			&k.TotalKlipp, &k.RemainingKlipp, &k.ExpiryDate, &k.PurchaseDate, &k.UserKlippekort.IsActive,
+			&k.Name, &k.Category); err != nil {
+			return nil, err
+		}
+		klippekort = append(klippekort, k)
+	}
+	return klippekort, rows.Err()
+}
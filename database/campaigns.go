@@ -0,0 +1,279 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"time"
+)
+
+// migrateCampaigns creates the campaign tables and turns existing special offer plans into campaigns
+func migrateCampaigns(db *sql.DB) error {
+	campaignsTableSQL := `
+	CREATE TABLE IF NOT EXISTS campaigns (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT DEFAULT '',
+		membership_id INTEGER NOT NULL,
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		eligibility TEXT NOT NULL DEFAULT 'all',
+		lapsed_months INTEGER DEFAULT 0,
+		discount_price INTEGER,
+		discount_months INTEGER DEFAULT 0,
+		free_weeks INTEGER DEFAULT 0,
+		active BOOLEAN DEFAULT TRUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (membership_id) REFERENCES memberships(id)
+	);
+	`
+	campaignRedemptionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS campaign_redemptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		campaign_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		user_membership_id INTEGER NOT NULL,
+		redeemed_at DATE NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (campaign_id, user_id),
+		FOREIGN KEY (campaign_id) REFERENCES campaigns(id),
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (user_membership_id) REFERENCES user_memberships(id)
+	);
+	`
+	for _, stmt := range []string{campaignsTableSQL, campaignRedemptionsTableSQL} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM campaigns").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	// Special offer plans used to be shown to everyone without a membership, keep them running
+	// for first-time members for the rest of the season
+	now := time.Now()
+	seasonStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	_, err := db.Exec(`
+		INSERT INTO campaigns (name, description, membership_id, start_date, end_date, eligibility)
+		SELECT name, description, id, ?, ?, ?
+		FROM memberships WHERE is_special_offer = TRUE AND active = TRUE`,
+		seasonStart.Format("2006-01-02"), seasonStart.AddDate(0, 3, -1).Format("2006-01-02"), models.CampaignForFirstTime)
+	return err
+}
+
+// CampaignEligible checks whether a member with the given membership history can redeem a campaign.
+// Campaigns are for signups, so members with an ongoing membership are never eligible.
+func CampaignEligible(campaign models.Campaign, history []models.MembershipPeriod, studentVerified bool, now time.Time) bool {
+	if !campaign.IsLive(now) {
+		return false
+	}
+
+	var lastEnded *time.Time
+	for _, period := range history {
+		if period.EndedAt == nil {
+			return false
+		}
+		if lastEnded == nil || period.EndedAt.After(*lastEnded) {
+			lastEnded = period.EndedAt
+		}
+	}
+
+	switch campaign.Eligibility {
+	case models.CampaignForEveryone:
+		return true
+	case models.CampaignForFirstTime:
+		return len(history) == 0
+	case models.CampaignForLapsed:
+		return lastEnded != nil && !lastEnded.AddDate(0, campaign.LapsedMonths, 0).After(now)
+	case models.CampaignForStudents:
+		return studentVerified
+	}
+	return false
+}
+
+const campaignColumns = `
+	SELECT c.id, c.name, c.description, c.membership_id, m.name, m.price, m.is_special_offer,
+	       c.start_date, c.end_date, c.eligibility, c.lapsed_months, c.discount_price, c.discount_months, c.free_weeks, c.active,
+	       (SELECT COUNT(*) FROM campaign_redemptions r WHERE r.campaign_id = c.id)
+	FROM campaigns c JOIN memberships m ON c.membership_id = m.id`
+
+func scanCampaign(scanner interface{ Scan(...interface{}) error }) (*models.Campaign, error) {
+	var c models.Campaign
+	var discountPrice sql.NullInt64
+	err := scanner.Scan(&c.ID, &c.Name, &c.Description, &c.MembershipID, &c.MembershipName, &c.RegularPrice, &c.SpecialOfferPlan,
+		&c.StartDate, &c.EndDate, &c.Eligibility, &c.LapsedMonths, &discountPrice, &c.DiscountMonths, &c.FreeWeeks, &c.Active,
+		&c.Redemptions)
+	if err != nil {
+		return nil, err
+	}
+	if discountPrice.Valid {
+		price := int(discountPrice.Int64)
+		c.DiscountPrice = &price
+	}
+	return &c, nil
+}
+
+func (db *Database) queryCampaigns(query string, args ...interface{}) ([]models.Campaign, error) {
+	rows, err := db.Conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []models.Campaign
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, *c)
+	}
+	return campaigns, rows.Err()
+}
+
+// GetCampaigns returns all campaigns with their redemption counts, newest first
+func (db *Database) GetCampaigns() ([]models.Campaign, error) {
+	return db.queryCampaigns(campaignColumns + " ORDER BY c.start_date DESC, c.id DESC")
+}
+
+// GetCampaign returns a single campaign
+func (db *Database) GetCampaign(id int64) (*models.Campaign, error) {
+	return scanCampaign(db.Conn.QueryRow(campaignColumns+" WHERE c.id = ?", id))
+}
+
+// GetLiveCampaigns returns the active campaigns that can be redeemed on a date
+func (db *Database) GetLiveCampaigns(now time.Time) ([]models.Campaign, error) {
+	today := now.Format("2006-01-02")
+	return db.queryCampaigns(campaignColumns+`
+		WHERE c.active = TRUE AND m.active = TRUE AND c.start_date <= ? AND c.end_date >= ?
+		ORDER BY c.end_date, c.id`, today, today)
+}
+
+// GetEligibleCampaigns returns the live campaigns a member can redeem and has not redeemed before
+func (db *Database) GetEligibleCampaigns(userID int64, now time.Time) ([]models.Campaign, error) {
+	live, err := db.GetLiveCampaigns(now)
+	if err != nil || len(live) == 0 {
+		return nil, err
+	}
+	history, err := db.GetMembershipHistory(userID)
+	if err != nil {
+		return nil, err
+	}
+	studentVerified, err := db.HasValidDiscountVerification(userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var eligible []models.Campaign
+	for _, campaign := range live {
+		if !CampaignEligible(campaign, history, studentVerified, now) {
+			continue
+		}
+		var redeemed int
+		if err := db.Conn.QueryRow("SELECT COUNT(*) FROM campaign_redemptions WHERE campaign_id = ? AND user_id = ?",
+			campaign.ID, userID).Scan(&redeemed); err != nil {
+			return nil, err
+		}
+		if redeemed == 0 {
+			eligible = append(eligible, campaign)
+		}
+	}
+	return eligible, nil
+}
+
+// SaveCampaign creates a campaign, or updates it when the ID is set
+func (db *Database) SaveCampaign(campaign models.Campaign) (int64, error) {
+	switch campaign.Eligibility {
+	case models.CampaignForEveryone, models.CampaignForFirstTime, models.CampaignForLapsed, models.CampaignForStudents:
+	default:
+		return 0, fmt.Errorf("ugyldig målgruppe: %s", campaign.Eligibility)
+	}
+	if campaign.Name == "" {
+		return 0, fmt.Errorf("kampanjen må ha et navn")
+	}
+	if campaign.EndDate.Before(campaign.StartDate) {
+		return 0, fmt.Errorf("sluttdato kan ikke være før startdato")
+	}
+	if campaign.DiscountPrice != nil && *campaign.DiscountPrice < 0 {
+		return 0, fmt.Errorf("kampanjepris kan ikke være negativ")
+	}
+	if campaign.FreeWeeks < 0 || campaign.DiscountMonths < 0 || campaign.LapsedMonths < 0 {
+		return 0, fmt.Errorf("antall uker og måneder kan ikke være negativt")
+	}
+	if _, err := db.GetMembershipByID(int64(campaign.MembershipID)); err != nil {
+		return 0, fmt.Errorf("ugyldig medlemskap")
+	}
+
+	var discountPrice interface{}
+	if campaign.DiscountPrice != nil {
+		discountPrice = *campaign.DiscountPrice
+	}
+	args := []interface{}{campaign.Name, campaign.Description, campaign.MembershipID,
+		campaign.StartDate.Format("2006-01-02"), campaign.EndDate.Format("2006-01-02"), campaign.Eligibility,
+		campaign.LapsedMonths, discountPrice, campaign.DiscountMonths, campaign.FreeWeeks, campaign.Active}
+
+	if campaign.ID == 0 {
+		result, err := db.Conn.Exec(`INSERT INTO campaigns (name, description, membership_id, start_date, end_date, eligibility,
+			lapsed_months, discount_price, discount_months, free_weeks, active) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, args...)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+
+	_, err := db.Conn.Exec(`UPDATE campaigns SET name = ?, description = ?, membership_id = ?, start_date = ?, end_date = ?,
+		eligibility = ?, lapsed_months = ?, discount_price = ?, discount_months = ?, free_weeks = ?, active = ?
+		WHERE id = ?`, append(args, campaign.ID)...)
+	return int64(campaign.ID), err
+}
+
+// DeactivateCampaign stops a campaign. Members who already redeemed it keep their terms.
+func (db *Database) DeactivateCampaign(id int64) error {
+	_, err := db.Conn.Exec("UPDATE campaigns SET active = FALSE WHERE id = ?", id)
+	return err
+}
+
+// memberPriceFor returns what a member pays for their plan on a date,
+// including a reduced campaign price while it lasts. A custom price set by an admin replaces both.
+func (db *Database) memberPriceFor(userMembershipID, membershipID int64, memberSince, date time.Time) (int, error) {
+	customPrice, err := db.customMembershipPrice(userMembershipID, membershipID)
+	if err != nil {
+		return 0, err
+	}
+	if customPrice != nil {
+		return *customPrice, nil
+	}
+
+	price, err := db.memberPriceAt(membershipID, memberSince, date)
+	if err != nil {
+		return 0, err
+	}
+
+	var discountPrice, discountMonths int
+	var redeemedAt time.Time
+	err = db.Conn.QueryRow(`
+		SELECT c.discount_price, c.discount_months, r.redeemed_at
+		FROM campaign_redemptions r JOIN campaigns c ON r.campaign_id = c.id
+		WHERE r.user_membership_id = ? AND c.membership_id = ? AND c.discount_price IS NOT NULL`,
+		userMembershipID, membershipID).Scan(&discountPrice, &discountMonths, &redeemedAt)
+	if err == sql.ErrNoRows {
+		return price, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if discountMonths > 0 && !date.Before(redeemedAt.AddDate(0, discountMonths, 0)) {
+		return price, nil
+	}
+	if discountPrice < price {
+		return discountPrice, nil
+	}
+	return price, nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"kjernekraft/models"
+)
+
+// migrateCharges creates the charges table used as the billing ledger
+func migrateCharges(db *sql.DB) error {
+	chargesTableSQL := `
+	CREATE TABLE IF NOT EXISTS charges (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		payment_method_id INTEGER,
+		stripe_charge_id TEXT DEFAULT '',
+		amount INTEGER NOT NULL,
+		currency TEXT DEFAULT 'NOK',
+		status TEXT DEFAULT 'pending',
+		description TEXT,
+		type TEXT,
+		charge_date DATETIME NOT NULL,
+		failure_reason TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (payment_method_id) REFERENCES payment_methods(id)
+	);
+	`
+	_, err := db.Exec(chargesTableSQL)
+	return err
+}
+
+// GetUserCharges returns a user's charges, newest first. A household payer also sees
+// the charges for the members they pay for, marked with the member's name.
+func (db *Database) GetUserCharges(userID int64, chargeType string) ([]models.ChargeWithDetails, error) {
+	rows, err := db.Conn.Query(`
+		SELECT c.id, c.user_id, c.payment_method_id, c.stripe_charge_id, c.amount, c.currency, c.status, c.description, c.type,
+		       c.charge_date, c.failure_reason, c.created_at, COALESCE(c.refunded_amount, 0), b.name, r.number,
+		       COALESCE(c.payment_method_type, ''), COALESCE(c.provider_reference, ''), COALESCE(c.credit_applied, 0),
+		       CASE WHEN pm.type = 'vipps' THEN 'vipps' ELSE NULLIF(pm.brand, '') END,
+		       CASE WHEN pm.type = 'vipps' THEN NULLIF(substr(pm.phone_number, -4), '') ELSE NULLIF(pm.last4, '') END
+		FROM charges c LEFT JOIN users b ON c.beneficiary_user_id = b.id
+		LEFT JOIN receipts r ON r.charge_id = c.id
+		LEFT JOIN payment_methods pm ON pm.id = c.payment_method_id
+		WHERE c.user_id = ? AND (? = '' OR c.type = ?)
+		ORDER BY c.charge_date DESC, c.id DESC`, userID, chargeType, chargeType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var charges []models.ChargeWithDetails
+	for rows.Next() {
+		var c models.ChargeWithDetails
+		var paymentMethodID sql.NullInt64
+		var stripeChargeID, failureReason, beneficiaryName, brand, last4 sql.NullString
+		var receiptNumber sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.UserID, &paymentMethodID, &stripeChargeID, &c.Amount, &c.Currency, &c.Status,
+			&c.Description, &c.Type, &c.ChargeDate, &failureReason, &c.CreatedAt, &c.RefundedAmount, &beneficiaryName, &receiptNumber,
+			&c.PaymentMethodType, &c.ProviderReference, &c.CreditApplied, &brand, &last4); err != nil {
+			return nil, err
+		}
+		c.StripeChargeID = stripeChargeID.String
+		if paymentMethodID.Valid {
+			id := int(paymentMethodID.Int64)
+			c.PaymentMethodID = &id
+		}
+		if failureReason.Valid {
+			c.FailureReason = &failureReason.String
+		}
+		if beneficiaryName.Valid {
+			c.BeneficiaryName = &beneficiaryName.String
+		}
+		if brand.Valid && last4.Valid {
+			c.PaymentMethodBrand = &brand.String
+			c.PaymentMethodLast4 = &last4.String
+		}
+		if receiptNumber.Valid {
+			number := int(receiptNumber.Int64)
+			c.ReceiptNumber = &number
+		}
+		charges = append(charges, c)
+	}
+	return charges, rows.Err()
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"sort"
+	"time"
+)
+
+// migrateClosures creates the closures table and adds cancellation columns to events
+func migrateClosures(db *sql.DB) error {
+	closuresTableSQL := `
+	CREATE TABLE IF NOT EXISTS closures (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	if _, err := db.Exec(closuresTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("ALTER TABLE events ADD COLUMN cancelled BOOLEAN DEFAULT FALSE"); err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	if _, err := db.Exec("ALTER TABLE events ADD COLUMN cancellation_reason TEXT DEFAULT ''"); err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	return nil
+}
+
+// easterSunday calculates the date of Easter Sunday for a year (anonymous Gregorian algorithm)
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := ((h + l - 7*m + 114) % 31) + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// NorwegianHolidays returns the Norwegian public holidays (helligdager) for a year
+func NorwegianHolidays(year int) []models.Closure {
+	easter := easterSunday(year)
+	fixed := func(month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	days := []struct {
+		name string
+		date time.Time
+	}{
+		{"1. nyttårsdag", fixed(time.January, 1)},
+		{"Skjærtorsdag", easter.AddDate(0, 0, -3)},
+		{"Langfredag", easter.AddDate(0, 0, -2)},
+		{"1. påskedag", easter},
+		{"2. påskedag", easter.AddDate(0, 0, 1)},
+		{"Arbeidernes dag", fixed(time.May, 1)},
+		{"Grunnlovsdag", fixed(time.May, 17)},
+		{"Kristi himmelfartsdag", easter.AddDate(0, 0, 39)},
+		{"1. pinsedag", easter.AddDate(0, 0, 49)},
+		{"2. pinsedag", easter.AddDate(0, 0, 50)},
+		{"1. juledag", fixed(time.December, 25)},
+		{"2. juledag", fixed(time.December, 26)},
+	}
+
+	holidays := make([]models.Closure, 0, len(days))
+	for _, d := range days {
+		holidays = append(holidays, models.Closure{
+			Name:      d.name,
+			StartDate: d.date,
+			EndDate:   d.date,
+			IsHoliday: true,
+		})
+	}
+	return holidays
+}
+
+// GetClosures returns all closures (custom and public holidays) overlapping the given date range
+func (db *Database) GetClosures(from, to time.Time) ([]models.Closure, error) {
+	fromKey := from.Format("2006-01-02")
+	toKey := to.Format("2006-01-02")
+
+	query := `SELECT id, name, start_date, end_date, created_at FROM closures
+	          WHERE DATE(end_date) >= DATE(?) AND DATE(start_date) <= DATE(?)
+	          ORDER BY start_date ASC`
+	rows, err := db.Conn.Query(query, fromKey, toKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var closures []models.Closure
+	for rows.Next() {
+		var c models.Closure
+		if err := rows.Scan(&c.ID, &c.Name, &c.StartDate, &c.EndDate, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		closures = append(closures, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for year := from.Year(); year <= to.Year(); year++ {
+		for _, holiday := range NorwegianHolidays(year) {
+			dateKey := holiday.StartDate.Format("2006-01-02")
+			if dateKey >= fromKey && dateKey <= toKey {
+				closures = append(closures, holiday)
+			}
+		}
+	}
+
+	sort.SliceStable(closures, func(i, j int) bool {
+		return closures[i].StartDate.Before(closures[j].StartDate)
+	})
+	return closures, nil
+}
+
+// GetClosureForDate returns the closure covering the given date, or nil if the studio is open
+func (db *Database) GetClosureForDate(date time.Time) (*models.Closure, error) {
+	closures, err := db.GetClosures(date, date)
+	if err != nil {
+		return nil, err
+	}
+	if len(closures) == 0 {
+		return nil, nil
+	}
+	return &closures[0], nil
+}
+
+// CreateClosure stores a custom closure period
+func (db *Database) CreateClosure(closure models.Closure) (int64, error) {
+	if closure.EndDate.Before(closure.StartDate) {
+		return 0, fmt.Errorf("sluttdato kan ikke være før startdato")
+	}
+
+	res, err := db.Conn.Exec(
+		"INSERT INTO closures (name, start_date, end_date, created_at) VALUES (?, ?, ?, ?)",
+		closure.Name, closure.StartDate.Format("2006-01-02"), closure.EndDate.Format("2006-01-02"), time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// DeleteClosure removes a custom closure. Events already cancelled stay cancelled.
+func (db *Database) DeleteClosure(closureID int64) error {
+	_, err := db.Conn.Exec("DELETE FROM closures WHERE id = ?", closureID)
+	return err
+}
+
+// CancelEvent marks an event as cancelled, gives back klipp used for it and notifies everyone signed up for it
+func (db *Database) CancelEvent(eventID int64, reason string) error {
+	event, err := db.GetEventByID(eventID)
+	if err != nil {
+		return err
+	}
+	if event.IsCancelled {
+		return nil
+	}
+
+	_, err = db.Conn.Exec("UPDATE events SET cancelled = TRUE, cancellation_reason = ? WHERE id = ?", reason, eventID)
+	if err != nil {
+		return err
+	}
+	if err := db.refundEventKlipp(eventID, time.Now()); err != nil {
+		return err
+	}
+
+	rows, err := db.Conn.Query("SELECT user_id FROM event_signups WHERE event_id = ?", eventID)
+	if err != nil {
+		return err
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+
+	title := fmt.Sprintf("Avlyst: %s", event.Title)
+	message := fmt.Sprintf("%s %s er avlyst (%s).", event.Title, event.StartTime.Format("02.01.2006 15:04"), reason)
+	for _, userID := range userIDs {
+		if err := db.CreateNotification(userID, "class_cancelled", title, message); err != nil {
+			log.Printf("Warning: Could not notify user %d about cancelled event %d: %v", userID, eventID, err)
+		}
+	}
+	return nil
+}
+
+// CancelEventsInClosures cancels all events that fall on a closed day within the given range.
+// Closures that only partly overlap the range only cancel the events inside it.
+func (db *Database) CancelEventsInClosures(from, to time.Time) (int, error) {
+	closures, err := db.GetClosures(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	cancelled := 0
+	for _, closure := range closures {
+		// Dates in this format sort as strings
+		startKey, endKey := closure.StartDate.Format("2006-01-02"), closure.EndDate.Format("2006-01-02")
+		if fromKey := from.Format("2006-01-02"); fromKey > startKey {
+			startKey = fromKey
+		}
+		if toKey := to.Format("2006-01-02"); toKey < endKey {
+			endKey = toKey
+		}
+
+		rows, err := db.Conn.Query(
+			`SELECT id FROM events WHERE cancelled = FALSE AND DATE(start_time) >= DATE(?) AND DATE(start_time) <= DATE(?)`,
+			startKey, endKey,
+		)
+		if err != nil {
+			return cancelled, err
+		}
+		var eventIDs []int64
+		for rows.Next() {
+			var eventID int64
+			if err := rows.Scan(&eventID); err != nil {
+				rows.Close()
+				return cancelled, err
+			}
+			eventIDs = append(eventIDs, eventID)
+		}
+		rows.Close()
+
+		for _, eventID := range eventIDs {
+			if err := db.CancelEvent(eventID, closure.Name); err != nil {
+				return cancelled, err
+			}
+			cancelled++
+		}
+	}
+	return cancelled, nil
+}
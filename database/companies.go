@@ -0,0 +1,657 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// CompanyInvoiceDueDays is how long a company has to pay its monthly invoice
+const CompanyInvoiceDueDays = 14
+
+// migrateCompanies creates company accounts, their enrolled employees and monthly invoices.
+// The company's share of a membership is a charge on the employee with status 'invoiced',
+// which is collected on the company's invoice instead of being charged to a card.
+func migrateCompanies(db *sql.DB) error {
+	tables := []string{
+		`CREATE TABLE IF NOT EXISTS companies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			org_number TEXT DEFAULT '',
+			invoice_email TEXT DEFAULT '',
+			email_domain TEXT DEFAULT '',
+			invite_code TEXT UNIQUE COLLATE NOCASE,
+			subsidy_type TEXT NOT NULL,
+			subsidy_value INTEGER DEFAULT 0,
+			active BOOLEAN DEFAULT TRUE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS company_plans (
+			company_id INTEGER NOT NULL,
+			membership_id INTEGER NOT NULL,
+			PRIMARY KEY (company_id, membership_id),
+			FOREIGN KEY (company_id) REFERENCES companies(id),
+			FOREIGN KEY (membership_id) REFERENCES memberships(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS company_admins (
+			company_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			PRIMARY KEY (company_id, user_id),
+			FOREIGN KEY (company_id) REFERENCES companies(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS company_employees (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			enrolled_at DATETIME NOT NULL,
+			left_at DATETIME,
+			FOREIGN KEY (company_id) REFERENCES companies(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS company_invoices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER NOT NULL,
+			period TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			status TEXT DEFAULT 'sent',
+			due_date DATE NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, period),
+			FOREIGN KEY (company_id) REFERENCES companies(id)
+		)`,
+	}
+	for _, table := range tables {
+		if _, err := db.Exec(table); err != nil {
+			return err
+		}
+	}
+
+	columns := []string{
+		"ALTER TABLE charges ADD COLUMN company_id INTEGER REFERENCES companies(id)",
+		"ALTER TABLE charges ADD COLUMN invoice_id INTEGER REFERENCES company_invoices(id)",
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+
+	// Employees who joined by email domain wait for a company admin to approve them. Those
+	// enrolled before approvals were needed stay enrolled.
+	_, err := db.Exec("ALTER TABLE company_employees ADD COLUMN approved_at DATETIME")
+	if err == nil {
+		_, err = db.Exec("UPDATE company_employees SET approved_at = enrolled_at")
+	}
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	return nil
+}
+
+const companyColumns = `c.id, c.name, c.org_number, c.invoice_email, c.email_domain, COALESCE(c.invite_code, ''), c.subsidy_type, c.subsidy_value, c.active, c.created_at,
+	(SELECT COUNT(*) FROM company_employees e WHERE e.company_id = c.id AND e.left_at IS NULL AND e.approved_at IS NOT NULL)`
+
+// scanCompanies reads companies and their plans and admins
+func (db *Database) scanCompanies(rows *sql.Rows) ([]models.Company, error) {
+	var companies []models.Company
+	for rows.Next() {
+		var c models.Company
+		if err := rows.Scan(&c.ID, &c.Name, &c.OrgNumber, &c.InvoiceEmail, &c.EmailDomain, &c.InviteCode, &c.SubsidyType,
+			&c.SubsidyValue, &c.Active, &c.CreatedAt, &c.EnrolledCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		companies = append(companies, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range companies {
+		planRows, err := db.Conn.Query("SELECT membership_id FROM company_plans WHERE company_id = ? ORDER BY membership_id", companies[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for planRows.Next() {
+			var id int
+			if err := planRows.Scan(&id); err != nil {
+				planRows.Close()
+				return nil, err
+			}
+			companies[i].MembershipIDs = append(companies[i].MembershipIDs, id)
+		}
+		planRows.Close()
+
+		adminRows, err := db.Conn.Query(`SELECT u.id, u.email FROM company_admins a JOIN users u ON a.user_id = u.id
+			WHERE a.company_id = ? ORDER BY u.email`, companies[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for adminRows.Next() {
+			var id int
+			var email string
+			if err := adminRows.Scan(&id, &email); err != nil {
+				adminRows.Close()
+				return nil, err
+			}
+			companies[i].AdminUserIDs = append(companies[i].AdminUserIDs, id)
+			companies[i].AdminEmails = append(companies[i].AdminEmails, email)
+		}
+		adminRows.Close()
+	}
+	return companies, nil
+}
+
+// GetCompanies returns all company accounts with their number of enrolled employees
+func (db *Database) GetCompanies() ([]models.Company, error) {
+	rows, err := db.Conn.Query("SELECT " + companyColumns + " FROM companies c ORDER BY c.active DESC, c.name")
+	if err != nil {
+		return nil, err
+	}
+	return db.scanCompanies(rows)
+}
+
+// GetCompany returns a company account by ID
+func (db *Database) GetCompany(companyID int64) (*models.Company, error) {
+	rows, err := db.Conn.Query("SELECT "+companyColumns+" FROM companies c WHERE c.id = ?", companyID)
+	if err != nil {
+		return nil, err
+	}
+	companies, err := db.scanCompanies(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(companies) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &companies[0], nil
+}
+
+// GetCompanyForEmployee returns the company a member is enrolled in, or nil
+func (db *Database) GetCompanyForEmployee(userID int64) (*models.Company, error) {
+	return db.companyForEmployee(userID, true)
+}
+
+// GetPendingCompanyForEmployee returns the company a member has asked to join and is waiting
+// for a company admin to approve them in, or nil
+func (db *Database) GetPendingCompanyForEmployee(userID int64) (*models.Company, error) {
+	return db.companyForEmployee(userID, false)
+}
+
+// companyForEmployee returns the company a member is approved in, or waiting for approval in
+func (db *Database) companyForEmployee(userID int64, approved bool) (*models.Company, error) {
+	var companyID int64
+	err := db.Conn.QueryRow(`SELECT e.company_id FROM company_employees e JOIN companies c ON e.company_id = c.id
+		WHERE e.user_id = ? AND e.left_at IS NULL AND (e.approved_at IS NOT NULL) = ? AND c.active = TRUE`, userID, approved).Scan(&companyID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.GetCompany(companyID)
+}
+
+// GetCompanyForAdmin returns the company a member manages, or nil
+func (db *Database) GetCompanyForAdmin(userID int64) (*models.Company, error) {
+	var companyID int64
+	err := db.Conn.QueryRow("SELECT company_id FROM company_admins WHERE user_id = ? ORDER BY company_id LIMIT 1", userID).Scan(&companyID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.GetCompany(companyID)
+}
+
+// SaveCompany creates or updates a company account with its plans and admins
+func (db *Database) SaveCompany(company models.Company) (int64, error) {
+	company.Name = strings.TrimSpace(company.Name)
+	company.EmailDomain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(company.EmailDomain), "@"))
+	company.InviteCode = strings.ToUpper(strings.TrimSpace(company.InviteCode))
+	if company.Name == "" {
+		return 0, fmt.Errorf("bedriften må ha et navn")
+	}
+	if company.EmailDomain == "" && company.InviteCode == "" {
+		return 0, fmt.Errorf("oppgi et e-postdomene eller en invitasjonskode")
+	}
+	switch company.SubsidyType {
+	case models.CompanySubsidyFull:
+	case models.CompanySubsidyPercent:
+		if company.SubsidyValue <= 0 || company.SubsidyValue > 100 {
+			return 0, fmt.Errorf("prosenttilskudd må være mellom 1 og 100")
+		}
+	case models.CompanySubsidyFixed:
+		if company.SubsidyValue <= 0 {
+			return 0, fmt.Errorf("tilskuddet må være større enn 0")
+		}
+	default:
+		return 0, fmt.Errorf("ugyldig tilskuddstype: %s", company.SubsidyType)
+	}
+	if len(company.MembershipIDs) == 0 {
+		return 0, fmt.Errorf("bedriften må betale for minst ett medlemskap")
+	}
+
+	var adminIDs []int64
+	for _, email := range company.AdminEmails {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		var userID int64
+		err := db.Conn.QueryRow("SELECT id FROM users WHERE email = ? COLLATE NOCASE", email).Scan(&userID)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("fant ingen bruker med e-postadressen %s", email)
+		}
+		if err != nil {
+			return 0, err
+		}
+		adminIDs = append(adminIDs, userID)
+	}
+
+	var inviteCode interface{}
+	if company.InviteCode != "" {
+		inviteCode = company.InviteCode
+	}
+	args := []interface{}{company.Name, company.OrgNumber, company.InvoiceEmail, company.EmailDomain, inviteCode,
+		company.SubsidyType, company.SubsidyValue, company.Active}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	companyID := int64(company.ID)
+	if companyID == 0 {
+		result, err := tx.Exec(`INSERT INTO companies (name, org_number, invoice_email, email_domain, invite_code, subsidy_type, subsidy_value, active)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, args...)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				return 0, fmt.Errorf("invitasjonskoden %s er allerede i bruk", company.InviteCode)
+			}
+			return 0, err
+		}
+		if companyID, err = result.LastInsertId(); err != nil {
+			return 0, err
+		}
+	} else {
+		_, err := tx.Exec(`UPDATE companies SET name = ?, org_number = ?, invoice_email = ?, email_domain = ?, invite_code = ?,
+			subsidy_type = ?, subsidy_value = ?, active = ? WHERE id = ?`, append(args, companyID)...)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				return 0, fmt.Errorf("invitasjonskoden %s er allerede i bruk", company.InviteCode)
+			}
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM company_plans WHERE company_id = ?", companyID); err != nil {
+		return 0, err
+	}
+	for _, membershipID := range company.MembershipIDs {
+		if _, err := tx.Exec("INSERT INTO company_plans (company_id, membership_id) VALUES (?, ?)", companyID, membershipID); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM company_admins WHERE company_id = ?", companyID); err != nil {
+		return 0, err
+	}
+	for _, userID := range adminIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO company_admins (company_id, user_id) VALUES (?, ?)", companyID, userID); err != nil {
+			return 0, err
+		}
+	}
+	return companyID, tx.Commit()
+}
+
+// DeactivateCompany ends a company account. Its employees pay their full price from their next payment.
+func (db *Database) DeactivateCompany(companyID int64) error {
+	_, err := db.Conn.Exec("UPDATE companies SET active = FALSE WHERE id = ?", companyID)
+	return err
+}
+
+// EnrollInCompany enrolls a member in a company account with the company's invitation code.
+// Without a code, a member whose email is on the company's domain asks to join, and is only
+// enrolled once one of the company's admins approves them, since email addresses are not
+// verified. Returns the company and whether the member waits for approval.
+func (db *Database) EnrollInCompany(userID int64, inviteCode string, now time.Time) (*models.Company, bool, error) {
+	existing, err := db.GetCompanyForEmployee(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return nil, false, fmt.Errorf("du er allerede med i bedriftsavtalen til %s", existing.Name)
+	}
+	pending, err := db.GetPendingCompanyForEmployee(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if pending != nil {
+		return nil, false, fmt.Errorf("du venter allerede på godkjenning fra %s", pending.Name)
+	}
+
+	var name, email string
+	if err := db.Conn.QueryRow("SELECT name, email FROM users WHERE id = ?", userID).Scan(&name, &email); err != nil {
+		return nil, false, err
+	}
+
+	companies, err := db.GetCompanies()
+	if err != nil {
+		return nil, false, err
+	}
+	inviteCode = strings.TrimSpace(inviteCode)
+	var company *models.Company
+	for i := range companies {
+		if !companies[i].Active {
+			continue
+		}
+		if inviteCode != "" && strings.EqualFold(companies[i].InviteCode, inviteCode) {
+			company = &companies[i]
+			break
+		}
+		if inviteCode == "" && companies[i].MatchesEmail(email) {
+			company = &companies[i]
+			break
+		}
+	}
+	if company == nil {
+		if inviteCode != "" {
+			return nil, false, fmt.Errorf("ugyldig invitasjonskode")
+		}
+		return nil, false, fmt.Errorf("fant ingen bedriftsavtale for e-postadressen din")
+	}
+
+	if inviteCode != "" {
+		_, err = db.Conn.Exec("INSERT INTO company_employees (company_id, user_id, enrolled_at, approved_at) VALUES (?, ?, ?, ?)",
+			company.ID, userID, now, now)
+		return company, false, err
+	}
+
+	if len(company.AdminUserIDs) == 0 {
+		return nil, false, fmt.Errorf("%s har ingen som kan godkjenne deg, be om invitasjonskoden fra bedriften", company.Name)
+	}
+	_, err = db.Conn.Exec("INSERT INTO company_employees (company_id, user_id, enrolled_at) VALUES (?, ?, ?)", company.ID, userID, now)
+	if err != nil {
+		return nil, false, err
+	}
+	message := fmt.Sprintf("%s (%s) vil bli med i bedriftsavtalen. Godkjenn eller avvis på bedriftssiden.", name, email)
+	for _, adminID := range company.AdminUserIDs {
+		if err := db.CreateNotification(int64(adminID), "company", "Ny ansatt venter på godkjenning", message); err != nil {
+			log.Printf("Could not notify user %d about company enrollment: %v", adminID, err)
+		}
+	}
+	return company, true, nil
+}
+
+// ApproveCompanyEmployee enrolls a member who asked to join by email domain. Only the company's
+// admins can approve.
+func (db *Database) ApproveCompanyEmployee(requestedBy, userID int64, now time.Time) error {
+	company, err := db.GetPendingCompanyForEmployee(userID)
+	if err != nil {
+		return err
+	}
+	if company == nil {
+		return fmt.Errorf("brukeren venter ikke på godkjenning")
+	}
+	if !company.IsAdmin(int(requestedBy)) {
+		return fmt.Errorf("bare bedriftens administratorer kan godkjenne ansatte")
+	}
+
+	_, err = db.Conn.Exec(`UPDATE company_employees SET approved_at = ?
+		WHERE company_id = ? AND user_id = ? AND left_at IS NULL AND approved_at IS NULL`, now, company.ID, userID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Du er godkjent i bedriftsavtalen til %s.", company.Name)
+	if err := db.CreateNotification(userID, "company", "Bedriftsavtalen er godkjent", message); err != nil {
+		log.Printf("Could not notify user %d about company enrollment: %v", userID, err)
+	}
+	return nil
+}
+
+// LeaveCompany ends a member's enrollment, or turns down a request to join. The company stops
+// paying from the member's next payment. Either the member or one of the company's admins can do this.
+func (db *Database) LeaveCompany(requestedBy, userID int64, now time.Time) error {
+	company, err := db.GetCompanyForEmployee(userID)
+	pending := false
+	if err == nil && company == nil {
+		company, err = db.GetPendingCompanyForEmployee(userID)
+		pending = true
+	}
+	if err != nil {
+		return err
+	}
+	if company == nil {
+		return fmt.Errorf("brukeren er ikke med i en bedriftsavtale")
+	}
+	if requestedBy != userID && !company.IsAdmin(int(requestedBy)) {
+		return fmt.Errorf("bare bedriftens administratorer kan melde ut ansatte")
+	}
+
+	_, err = db.Conn.Exec("UPDATE company_employees SET left_at = ? WHERE company_id = ? AND user_id = ? AND left_at IS NULL",
+		now, company.ID, userID)
+	if err != nil {
+		return err
+	}
+	if requestedBy != userID {
+		title, message := "Bedriftsavtalen er avsluttet", fmt.Sprintf("%s betaler ikke lenger for medlemskapet ditt. Fra neste betaling betaler du selv.", company.Name)
+		if pending {
+			title, message = "Bedriftsavtalen er avvist", fmt.Sprintf("%s har ikke godkjent deg i bedriftsavtalen.", company.Name)
+		}
+		if err := db.CreateNotification(userID, "company", title, message); err != nil {
+			log.Printf("Could not notify user %d about company enrollment: %v", userID, err)
+		}
+	}
+	return nil
+}
+
+// GetCompanyEmployees returns the members enrolled in a company account, those waiting for
+// approval first and those who left last
+func (db *Database) GetCompanyEmployees(companyID int64) ([]models.CompanyEmployee, error) {
+	rows, err := db.Conn.Query(`
+		SELECT e.id, e.company_id, e.user_id, u.name, u.email, COALESCE(m.name, ''), e.enrolled_at, e.approved_at, e.left_at
+		FROM company_employees e
+		JOIN users u ON e.user_id = u.id
+		LEFT JOIN user_memberships um ON um.user_id = e.user_id AND um.status IN ('active', 'paused', 'freeze_requested')
+		LEFT JOIN memberships m ON um.membership_id = m.id
+		WHERE e.company_id = ?
+		ORDER BY e.left_at IS NOT NULL, e.approved_at IS NOT NULL, u.name`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var employees []models.CompanyEmployee
+	for rows.Next() {
+		var e models.CompanyEmployee
+		var approvedAt, leftAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.CompanyID, &e.UserID, &e.Name, &e.Email, &e.MembershipName, &e.EnrolledAt, &approvedAt, &leftAt); err != nil {
+			return nil, err
+		}
+		if approvedAt.Valid {
+			e.ApprovedAt = &approvedAt.Time
+		}
+		if leftAt.Valid {
+			e.LeftAt = &leftAt.Time
+		}
+		employees = append(employees, e)
+	}
+	return employees, rows.Err()
+}
+
+// billMembership bills a membership payment. An enrolled employee's company share is put on the
+// company's next invoice and only the rest is charged to the member's card.
+func (db *Database) billMembership(userID, membershipID int64, price int, description string) error {
+	company, err := db.GetCompanyForEmployee(userID)
+	if err != nil {
+		return err
+	}
+	share := 0
+	if company != nil && company.Covers(int(membershipID)) {
+		share = company.CompanyShare(price)
+	}
+
+	if share > 0 {
+		now := time.Now()
+		_, err := db.Conn.Exec(`INSERT INTO charges (user_id, amount, currency, status, description, type, charge_date, created_at, company_id)
+			VALUES (?, ?, 'NOK', 'invoiced', ?, 'medlemskap', ?, ?, ?)`,
+			userID, share, fmt.Sprintf("%s, betalt av %s", description, company.Name), now, now, company.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if price-share > 0 {
+		return db.SimulateBilling(userID, price-share, description, "medlemskap")
+	}
+	return nil
+}
+
+// GenerateCompanyInvoices puts every company share charged before this month on one invoice per
+// company for last month. A month is only invoiced once, so running it again does nothing.
+// Returns the number of invoices created.
+func (db *Database) GenerateCompanyInvoices(now time.Time) (int, error) {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	period := monthStart.AddDate(0, -1, 0).Format("2006-01")
+
+	rows, err := db.Conn.Query(`
+		SELECT company_id, SUM(amount) FROM charges
+		WHERE company_id IS NOT NULL AND invoice_id IS NULL AND status = 'invoiced' AND charge_date < ?
+		AND company_id NOT IN (SELECT company_id FROM company_invoices WHERE period = ?)
+		GROUP BY company_id`, monthStart, period)
+	if err != nil {
+		return 0, err
+	}
+	type due struct {
+		companyID int64
+		amount    int
+	}
+	var invoices []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.companyID, &d.amount); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		invoices = append(invoices, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, d := range invoices {
+		tx, err := db.Conn.Begin()
+		if err != nil {
+			return created, err
+		}
+		result, err := tx.Exec("INSERT INTO company_invoices (company_id, period, amount, due_date, created_at) VALUES (?, ?, ?, ?, ?)",
+			d.companyID, period, d.amount, now.AddDate(0, 0, CompanyInvoiceDueDays).Format("2006-01-02"), now)
+		if err != nil {
+			tx.Rollback()
+			return created, err
+		}
+		invoiceID, err := result.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return created, err
+		}
+		_, err = tx.Exec(`UPDATE charges SET invoice_id = ?
+			WHERE company_id = ? AND invoice_id IS NULL AND status = 'invoiced' AND charge_date < ?`,
+			invoiceID, d.companyID, monthStart)
+		if err != nil {
+			tx.Rollback()
+			return created, err
+		}
+		if err := tx.Commit(); err != nil {
+			return created, err
+		}
+		created++
+
+		company, err := db.GetCompany(d.companyID)
+		if err != nil {
+			return created, err
+		}
+		message := fmt.Sprintf("Fakturaen for %s på %.2f kr er klar og forfaller om %d dager.", period, float64(d.amount)/100, CompanyInvoiceDueDays)
+		for _, adminID := range company.AdminUserIDs {
+			if err := db.CreateNotification(int64(adminID), "company_invoice", "Ny bedriftsfaktura", message); err != nil {
+				log.Printf("Could not notify user %d about company invoice: %v", adminID, err)
+			}
+		}
+	}
+	return created, nil
+}
+
+// GetCompanyInvoices returns a company's invoices, or every company's when companyID is 0, newest first
+func (db *Database) GetCompanyInvoices(companyID int64) ([]models.CompanyInvoice, error) {
+	rows, err := db.Conn.Query(`
+		SELECT i.id, i.company_id, c.name, i.period, i.amount, i.status, i.due_date, i.created_at
+		FROM company_invoices i JOIN companies c ON i.company_id = c.id
+		WHERE ? = 0 OR i.company_id = ?
+		ORDER BY i.period DESC, c.name`, companyID, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []models.CompanyInvoice
+	for rows.Next() {
+		var i models.CompanyInvoice
+		if err := rows.Scan(&i.ID, &i.CompanyID, &i.CompanyName, &i.Period, &i.Amount, &i.Status, &i.DueDate, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, i)
+	}
+	return invoices, rows.Err()
+}
+
+// GetCompanyInvoice returns an invoice with one line per membership payment
+func (db *Database) GetCompanyInvoice(invoiceID int64) (*models.CompanyInvoice, error) {
+	var i models.CompanyInvoice
+	err := db.Conn.QueryRow(`
+		SELECT i.id, i.company_id, c.name, i.period, i.amount, i.status, i.due_date, i.created_at
+		FROM company_invoices i JOIN companies c ON i.company_id = c.id
+		WHERE i.id = ?`, invoiceID).Scan(&i.ID, &i.CompanyID, &i.CompanyName, &i.Period, &i.Amount, &i.Status, &i.DueDate, &i.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Conn.Query(`
+		SELECT ch.id, ch.user_id, u.name, ch.description, ch.amount, ch.charge_date
+		FROM charges ch JOIN users u ON ch.user_id = u.id
+		WHERE ch.invoice_id = ?
+		ORDER BY u.name, ch.charge_date`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line models.CompanyInvoiceLine
+		if err := rows.Scan(&line.ChargeID, &line.UserID, &line.Name, &line.Description, &line.Amount, &line.ChargeDate); err != nil {
+			return nil, err
+		}
+		i.Lines = append(i.Lines, line)
+	}
+	return &i, rows.Err()
+}
+
+// MarkCompanyInvoicePaid records that a company has paid an invoice
+func (db *Database) MarkCompanyInvoicePaid(invoiceID int64) error {
+	result, err := db.Conn.Exec("UPDATE company_invoices SET status = 'paid' WHERE id = ?", invoiceID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("fant ikke fakturaen")
+	}
+	_, err = db.Conn.Exec("UPDATE charges SET status = 'succeeded' WHERE invoice_id = ?", invoiceID)
+	return err
+}
@@ -0,0 +1,341 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"strings"
+	"time"
+)
+
+// migrateCoupons creates the coupon and coupon redemption tables
+func migrateCoupons(db *sql.DB) error {
+	couponsTableSQL := `
+	CREATE TABLE IF NOT EXISTS coupons (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		description TEXT DEFAULT '',
+		discount_type TEXT NOT NULL,
+		discount_value INTEGER NOT NULL,
+		duration_months INTEGER DEFAULT 1,
+		product_types TEXT DEFAULT 'medlemskap,klippekort',
+		max_redemptions INTEGER DEFAULT 0,
+		per_user_limit INTEGER DEFAULT 1,
+		valid_from DATE,
+		valid_until DATE,
+		active BOOLEAN DEFAULT TRUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	couponRedemptionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS coupon_redemptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		coupon_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		product_type TEXT NOT NULL,
+		product_name TEXT DEFAULT '',
+		reference_id INTEGER NOT NULL,
+		original_amount INTEGER NOT NULL,
+		discount_amount INTEGER NOT NULL,
+		payments_discounted INTEGER DEFAULT 1,
+		redeemed_at DATETIME NOT NULL,
+		FOREIGN KEY (coupon_id) REFERENCES coupons(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	`
+	for _, stmt := range []string{couponsTableSQL, couponRedemptionsTableSQL} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateCoupon checks whether a coupon can be used on a product type on a date,
+// given how many times it has been redeemed in total and by the member
+func ValidateCoupon(coupon models.Coupon, productType string, now time.Time, totalRedemptions, userRedemptions int) error {
+	today := now.Format("2006-01-02")
+	switch {
+	case !coupon.Active:
+		return fmt.Errorf("rabattkoden er ikke lenger gyldig")
+	case coupon.ValidFrom != nil && today < coupon.ValidFrom.Format("2006-01-02"):
+		return fmt.Errorf("rabattkoden er ikke gyldig før %s", coupon.ValidFrom.Format("02.01.2006"))
+	case coupon.ValidUntil != nil && today > coupon.ValidUntil.Format("2006-01-02"):
+		return fmt.Errorf("rabattkoden gikk ut %s", coupon.ValidUntil.Format("02.01.2006"))
+	case !coupon.AppliesTo(productType):
+		return fmt.Errorf("rabattkoden gjelder ikke for %s", productType)
+	case coupon.MaxRedemptions > 0 && totalRedemptions >= coupon.MaxRedemptions:
+		return fmt.Errorf("rabattkoden er brukt opp")
+	case coupon.PerUserLimit > 0 && userRedemptions >= coupon.PerUserLimit:
+		return fmt.Errorf("du har allerede brukt denne rabattkoden")
+	}
+	return nil
+}
+
+const couponColumns = `
+	SELECT c.id, c.code, c.description, c.discount_type, c.discount_value, c.duration_months, c.product_types,
+	       c.max_redemptions, c.per_user_limit, c.valid_from, c.valid_until, c.active, c.created_at,
+	       COUNT(r.id), COUNT(DISTINCT r.user_id), COALESCE(SUM(r.discount_amount), 0)
+	FROM coupons c LEFT JOIN coupon_redemptions r ON r.coupon_id = c.id`
+
+func scanCoupon(scanner interface{ Scan(...interface{}) error }) (*models.Coupon, error) {
+	var c models.Coupon
+	var productTypes string
+	var validFrom, validUntil sql.NullTime
+	err := scanner.Scan(&c.ID, &c.Code, &c.Description, &c.DiscountType, &c.DiscountValue, &c.DurationMonths, &productTypes,
+		&c.MaxRedemptions, &c.PerUserLimit, &validFrom, &validUntil, &c.Active, &c.CreatedAt,
+		&c.Redemptions, &c.UniqueUsers, &c.TotalDiscount)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range strings.Split(productTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			c.ProductTypes = append(c.ProductTypes, t)
+		}
+	}
+	if validFrom.Valid {
+		c.ValidFrom = &validFrom.Time
+	}
+	if validUntil.Valid {
+		c.ValidUntil = &validUntil.Time
+	}
+	return &c, nil
+}
+
+// GetCoupons returns all coupons with their redemption statistics, newest first
+func (db *Database) GetCoupons() ([]models.Coupon, error) {
+	rows, err := db.Conn.Query(couponColumns + " GROUP BY c.id ORDER BY c.created_at DESC, c.id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var coupons []models.Coupon
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, err
+		}
+		coupons = append(coupons, *c)
+	}
+	return coupons, rows.Err()
+}
+
+// GetCouponByCode looks up a coupon, ignoring case
+func (db *Database) GetCouponByCode(code string) (*models.Coupon, error) {
+	c, err := scanCoupon(db.Conn.QueryRow(couponColumns+" WHERE c.code = ? GROUP BY c.id", strings.TrimSpace(code)))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("ugyldig rabattkode")
+	}
+	return c, err
+}
+
+// SaveCoupon creates a coupon, or updates it when the ID is set
+func (db *Database) SaveCoupon(coupon models.Coupon) (int64, error) {
+	coupon.Code = strings.ToUpper(strings.TrimSpace(coupon.Code))
+	if coupon.Code == "" {
+		return 0, fmt.Errorf("rabattkoden må ha en kode")
+	}
+	switch coupon.DiscountType {
+	case models.CouponPercent:
+		if coupon.DiscountValue <= 0 || coupon.DiscountValue > 100 {
+			return 0, fmt.Errorf("prosentrabatt må være mellom 1 og 100")
+		}
+	case models.CouponFixed:
+		if coupon.DiscountValue <= 0 {
+			return 0, fmt.Errorf("rabattbeløpet må være større enn 0")
+		}
+	default:
+		return 0, fmt.Errorf("ugyldig rabatttype: %s", coupon.DiscountType)
+	}
+	if len(coupon.ProductTypes) == 0 {
+		return 0, fmt.Errorf("rabattkoden må gjelde minst én produkttype")
+	}
+	for _, t := range coupon.ProductTypes {
+		if t != models.CouponProductMembership && t != models.CouponProductKlippekort {
+			return 0, fmt.Errorf("ugyldig produkttype: %s", t)
+		}
+	}
+	if coupon.DurationMonths < 1 {
+		coupon.DurationMonths = 1
+	}
+	if coupon.MaxRedemptions < 0 || coupon.PerUserLimit < 0 {
+		return 0, fmt.Errorf("grenser kan ikke være negative")
+	}
+	if coupon.ValidFrom != nil && coupon.ValidUntil != nil && coupon.ValidUntil.Before(*coupon.ValidFrom) {
+		return 0, fmt.Errorf("sluttdato kan ikke være før startdato")
+	}
+
+	var validFrom, validUntil interface{}
+	if coupon.ValidFrom != nil {
+		validFrom = coupon.ValidFrom.Format("2006-01-02")
+	}
+	if coupon.ValidUntil != nil {
+		validUntil = coupon.ValidUntil.Format("2006-01-02")
+	}
+	args := []interface{}{coupon.Code, coupon.Description, coupon.DiscountType, coupon.DiscountValue, coupon.DurationMonths,
+		strings.Join(coupon.ProductTypes, ","), coupon.MaxRedemptions, coupon.PerUserLimit, validFrom, validUntil, coupon.Active}
+
+	if coupon.ID == 0 {
+		result, err := db.Conn.Exec(`INSERT INTO coupons (code, description, discount_type, discount_value, duration_months,
+			product_types, max_redemptions, per_user_limit, valid_from, valid_until, active) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, args...)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE") {
+				return 0, fmt.Errorf("rabattkoden %s finnes allerede", coupon.Code)
+			}
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+
+	_, err := db.Conn.Exec(`UPDATE coupons SET code = ?, description = ?, discount_type = ?, discount_value = ?, duration_months = ?,
+		product_types = ?, max_redemptions = ?, per_user_limit = ?, valid_from = ?, valid_until = ?, active = ?
+		WHERE id = ?`, append(args, coupon.ID)...)
+	return int64(coupon.ID), err
+}
+
+// DeleteCoupon removes an unused coupon. Coupons that have been redeemed are deactivated instead
+// so their redemption history is kept. Returns whether the coupon was deleted.
+func (db *Database) DeleteCoupon(id int64) (bool, error) {
+	var redemptions int
+	if err := db.Conn.QueryRow("SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ?", id).Scan(&redemptions); err != nil {
+		return false, err
+	}
+	if redemptions > 0 {
+		_, err := db.Conn.Exec("UPDATE coupons SET active = FALSE WHERE id = ?", id)
+		return false, err
+	}
+	_, err := db.Conn.Exec("DELETE FROM coupons WHERE id = ?", id)
+	return true, err
+}
+
+// GetCouponRedemptions returns who redeemed a coupon, newest first
+func (db *Database) GetCouponRedemptions(couponID int64) ([]models.CouponRedemption, error) {
+	rows, err := db.Conn.Query(`
+		SELECT r.id, r.coupon_id, c.code, r.user_id, u.name, r.product_type, r.product_name, r.reference_id,
+		       r.original_amount, r.discount_amount, r.payments_discounted, r.redeemed_at
+		FROM coupon_redemptions r
+		JOIN coupons c ON r.coupon_id = c.id
+		JOIN users u ON r.user_id = u.id
+		WHERE r.coupon_id = ?
+		ORDER BY r.redeemed_at DESC, r.id DESC`, couponID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var redemptions []models.CouponRedemption
+	for rows.Next() {
+		var r models.CouponRedemption
+		if err := rows.Scan(&r.ID, &r.CouponID, &r.Code, &r.UserID, &r.UserName, &r.ProductType, &r.ProductName, &r.ReferenceID,
+			&r.OriginalAmount, &r.DiscountAmount, &r.PaymentsDiscounted, &r.RedeemedAt); err != nil {
+			return nil, err
+		}
+		redemptions = append(redemptions, r)
+	}
+	return redemptions, rows.Err()
+}
+
+// resolveCoupon looks up a coupon code and checks that the member can use it on a product type
+func (db *Database) resolveCoupon(userID int64, code, productType string, now time.Time) (*models.Coupon, error) {
+	coupon, err := db.GetCouponByCode(code)
+	if err != nil {
+		return nil, err
+	}
+	var userRedemptions int
+	if err := db.Conn.QueryRow("SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND user_id = ?",
+		coupon.ID, userID).Scan(&userRedemptions); err != nil {
+		return nil, err
+	}
+	if err := ValidateCoupon(*coupon, productType, now, coupon.Redemptions, userRedemptions); err != nil {
+		return nil, err
+	}
+	return coupon, nil
+}
+
+// QuoteCoupon shows what a coupon takes off a price before the member buys
+func (db *Database) QuoteCoupon(userID int64, code, productType string, amount int, now time.Time) (*models.CouponQuote, error) {
+	coupon, err := db.resolveCoupon(userID, code, productType, now)
+	if err != nil {
+		return nil, err
+	}
+	discount := coupon.Discount(amount)
+	durationMonths := 1
+	if productType == models.CouponProductMembership {
+		durationMonths = coupon.DurationMonths
+	}
+	return &models.CouponQuote{
+		Code:           coupon.Code,
+		Description:    coupon.Description,
+		OriginalAmount: amount,
+		DiscountAmount: discount,
+		FinalAmount:    amount - discount,
+		DurationMonths: durationMonths,
+	}, nil
+}
+
+// recordCouponRedemption stores a coupon used on a purchase
+func (db *Database) recordCouponRedemption(coupon *models.Coupon, userID int64, productType, productName string, referenceID int64, originalAmount, discount int, now time.Time) error {
+	_, err := db.Conn.Exec(`INSERT INTO coupon_redemptions (coupon_id, user_id, product_type, product_name, reference_id,
+		original_amount, discount_amount, redeemed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		coupon.ID, userID, productType, productName, referenceID, originalAmount, discount, now)
+	return err
+}
+
+// membershipCouponDiscount is a coupon discount on a membership renewal, added to the redemption
+// once the renewal is billed
+type membershipCouponDiscount struct {
+	redemptionID int64
+	amount       int
+}
+
+// applyMembershipCoupon reduces a renewal of a user membership by a coupon that still covers the date.
+// The discount is nil when no coupon applies, and is only recorded with recordMembershipCouponDiscount.
+func (db *Database) applyMembershipCoupon(userMembershipID int64, date time.Time, price int) (int, *membershipCouponDiscount, error) {
+	var redemptionID int64
+	var redeemedAt time.Time
+	var durationMonths int
+	var coupon models.Coupon
+	err := db.Conn.QueryRow(`
+		SELECT r.id, r.redeemed_at, c.duration_months, c.discount_type, c.discount_value
+		FROM coupon_redemptions r JOIN coupons c ON r.coupon_id = c.id
+		WHERE r.product_type = ? AND r.reference_id = ? AND r.payments_discounted < c.duration_months
+		ORDER BY r.id DESC LIMIT 1`, models.CouponProductMembership, userMembershipID,
+	).Scan(&redemptionID, &redeemedAt, &durationMonths, &coupon.DiscountType, &coupon.DiscountValue)
+	if err == sql.ErrNoRows {
+		return price, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// The coupon covers the months after the first payment, whatever happens to the payment count
+	if !date.Before(redeemedAt.AddDate(0, durationMonths, 0)) {
+		return price, nil, nil
+	}
+
+	discount := coupon.Discount(price)
+	return price - discount, &membershipCouponDiscount{redemptionID: redemptionID, amount: discount}, nil
+}
+
+// recordMembershipCouponDiscount adds a billed renewal discount to its redemption
+func (db *Database) recordMembershipCouponDiscount(discount *membershipCouponDiscount) error {
+	if discount == nil {
+		return nil
+	}
+	_, err := db.Conn.Exec(`UPDATE coupon_redemptions SET discount_amount = discount_amount + ?, payments_discounted = payments_discounted + 1
+		WHERE id = ?`, discount.amount, discount.redemptionID)
+	return err
+}
+
+// applyKlippekortCoupon records a coupon used on a klippekort purchase and returns the price and charge description to bill
+func (db *Database) applyKlippekortCoupon(coupon *models.Coupon, userID int64, pkg *models.KlippekortPackage, klippekortID int64, description string, now time.Time) (int, string, error) {
+	if coupon == nil {
+		return pkg.Price, description, nil
+	}
+	discount := coupon.Discount(pkg.Price)
+	if err := db.recordCouponRedemption(coupon, userID, models.CouponProductKlippekort, pkg.Name, klippekortID, pkg.Price, discount, now); err != nil {
+		return 0, "", err
+	}
+	return pkg.Price - discount, fmt.Sprintf("%s, rabattkode %s", description, coupon.Code), nil
+}
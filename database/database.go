@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"kjernekraft/models"
 	"log"
@@ -13,7 +14,16 @@ import (
 )
 
 type Database struct {
-	Conn *sql.DB
+	Conn     *sql.DB
+	Payments PaymentProvider // Simulated when nil
+	Vipps    VippsClient     // In-process mock when nil
+}
+
+// dbWriter is the connection or the transaction a change is written with, so the helpers that
+// write it can be part of a larger transaction
+type dbWriter interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
 func Connect() (*sql.DB, error) {
@@ -233,6 +243,92 @@ func Migrate(db *sql.DB) error {
 		
 		log.Println("Added last_billed column to user_memberships table")
 	}
+
+	if err := migrateNotifications(db); err != nil {
+		return err
+	}
+	if err := migrateClosures(db); err != nil {
+		return err
+	}
+	if err := migrateLivestream(db); err != nil {
+		return err
+	}
+	if err := migrateQualifications(db); err != nil {
+		return err
+	}
+	if err := migrateCharges(db); err != nil {
+		return err
+	}
+	if err := migrateMembershipPrices(db); err != nil {
+		return err
+	}
+	if err := migrateProration(db); err != nil {
+		return err
+	}
+	if err := migrateDiscountVerifications(db); err != nil {
+		return err
+	}
+	if err := migrateMembershipHistory(db); err != nil {
+		return err
+	}
+	if err := migrateCampaigns(db); err != nil {
+		return err
+	}
+	if err := migrateCoupons(db); err != nil {
+		return err
+	}
+	if err := migrateTrials(db); err != nil {
+		return err
+	}
+	// Recommendation rules can ask for trial plans, so they come after the trial columns
+	if err := migrateRecommendations(db); err != nil {
+		return err
+	}
+	if err := migrateHouseholds(db); err != nil {
+		return err
+	}
+	if err := migrateCompanies(db); err != nil {
+		return err
+	}
+	if err := migrateEntitlements(db); err != nil {
+		return err
+	}
+	if err := migrateAdminOverrides(db); err != nil {
+		return err
+	}
+	if err := migrateKlippekortLedger(db); err != nil {
+		return err
+	}
+	if err := migrateKlippekortExpiry(db); err != nil {
+		return err
+	}
+	if err := migrateKlippekortPackages(db); err != nil {
+		return err
+	}
+	if err := migrateKlippekortShares(db); err != nil {
+		return err
+	}
+	if err := migrateRefunds(db); err != nil {
+		return err
+	}
+	if err := migrateReceipts(db); err != nil {
+		return err
+	}
+	if err := migrateVipps(db); err != nil {
+		return err
+	}
+	if err := migrateAccounting(db); err != nil {
+		return err
+	}
+	if err := migrateWebhooks(db); err != nil {
+		return err
+	}
+	if err := migrateAccountCredit(db); err != nil {
+		return err
+	}
+	if err := migrateReports(db); err != nil {
+		return err
+	}
 	
 	return nil
 }
@@ -359,8 +455,8 @@ func (db *Database) CreateUser(u models.User) (int64, error) {
 // CreateDefaultPaymentMethods creates two default payment cards for a new user
 func (db *Database) CreateDefaultPaymentMethods(userID int64) error {
 	// Create first default card (Visa simulation)
-	card1Query := `INSERT INTO payment_methods (user_id, provider, provider_id) 
-	               VALUES (?, 'stripe', ?)`
+	card1Query := `INSERT INTO payment_methods (user_id, provider, provider_id, type, brand, last4) 
+	               VALUES (?, 'stripe', ?, 'card', 'visa', '4242')`
 	
 	_, err := db.Conn.Exec(card1Query, userID, fmt.Sprintf("pm_default_visa_%d", userID))
 	if err != nil {
@@ -368,30 +464,99 @@ func (db *Database) CreateDefaultPaymentMethods(userID int64) error {
 	}
 	
 	// Create second default card (Mastercard simulation)
-	card2Query := `INSERT INTO payment_methods (user_id, provider, provider_id) 
-	               VALUES (?, 'stripe', ?)`
+	card2Query := `INSERT INTO payment_methods (user_id, provider, provider_id, type, brand, last4) 
+	               VALUES (?, 'stripe', ?, 'card', 'mastercard', '5555')`
 	
 	_, err = db.Conn.Exec(card2Query, userID, fmt.Sprintf("pm_default_mastercard_%d", userID))
-	return err
+	if err != nil {
+		return err
+	}
+	return db.ensureDefaultPaymentMethod(userID)
 }
 
-// SimulateBilling creates a simulated charge entry for a user's default payment method
+// SimulateBilling creates a simulated charge entry for a user's default payment method.
+// Members linked to a household are billed to the household payer. Account credit pays for
+// memberships and klippekort before the payment method is charged.
 func (db *Database) SimulateBilling(userID int64, amount int, description, chargeType string) error {
-	// Get user's first payment method as default
-	var paymentMethodID int
-	err := db.Conn.QueryRow("SELECT id FROM payment_methods WHERE user_id = ? LIMIT 1", userID).Scan(&paymentMethodID)
+	_, err := db.simulateCharge(userID, amount, description, chargeType)
+	return err
+}
+
+// simulateCharge is SimulateBilling returning the ID of the charge
+func (db *Database) simulateCharge(userID int64, amount int, description, chargeType string) (int64, error) {
+	payerID, err := db.billingUserFor(userID)
 	if err != nil {
-		return fmt.Errorf("ingen betalingsmetode funnet for bruker")
+		return 0, err
 	}
 
-	// Create a simulated charge (assuming it succeeds)
-	chargeQuery := `INSERT INTO charges (user_id, payment_method_id, amount, currency, status, description, type, charge_date, created_at)
-	                VALUES (?, ?, ?, 'NOK', 'succeeded', ?, ?, ?, ?)`
+	// Account credit pays first, the payment method is not needed when it pays it all
+	credit, err := db.creditFor(payerID, amount, chargeType)
+	if err != nil {
+		return 0, err
+	}
+
+	paidByCredit := amount > 0 && credit == amount
+
+	// Charge the payer's default payment method
+	paymentMethod, err := db.defaultPaymentMethod(payerID)
+	if err != nil && !paidByCredit {
+		if payerID != userID {
+			return 0, fmt.Errorf("husstandens betaler har ingen betalingsmetode")
+		}
+		return 0, fmt.Errorf("ingen betalingsmetode funnet for bruker")
+	}
+	var paymentMethodID interface{}
+	paymentMethodType := models.PaymentMethodTypeCredit
+	if !paidByCredit {
+		paymentMethodID, paymentMethodType = paymentMethod.ID, paymentMethod.Type
+	}
+
+	var beneficiaryID interface{}
+	if payerID != userID {
+		beneficiaryID = userID
+	}
+
+	// Vipps takes the money through the Vipps API, cards are simulated (assuming they succeed).
+	// A declined Vipps payment is kept in the ledger as failed, without using the credit.
+	status, reference := models.ChargeStatusSucceeded, ""
+	var failureReason interface{}
+	var chargeErr error
+	if paymentMethodType == models.PaymentMethodTypeVipps && amount > 0 {
+		reference, chargeErr = db.chargeVipps(paymentMethod, amount-credit, description, chargeType)
+		if chargeErr != nil {
+			status, failureReason, credit = models.ChargeStatusFailed, chargeErr.Error(), 0
+		}
+	}
+
+	chargeQuery := `INSERT INTO charges (user_id, payment_method_id, amount, currency, status, description, type, charge_date, created_at,
+	                beneficiary_user_id, failure_reason, payment_method_type, provider_reference, credit_applied)
+	                VALUES (?, ?, ?, 'NOK', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
 	now := time.Now()
 	
-	_, err = db.Conn.Exec(chargeQuery, userID, paymentMethodID, amount, description, chargeType, now, now)
-	return err
+	result, err := db.Conn.Exec(chargeQuery, payerID, paymentMethodID, amount, status, description, chargeType, now, now, beneficiaryID,
+		failureReason, paymentMethodType, reference, credit)
+	if err != nil {
+		return 0, err
+	}
+	chargeID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if chargeErr != nil {
+		return chargeID, fmt.Errorf("%w: %v", errPaymentDeclined, chargeErr)
+	}
+	if credit > 0 {
+		if _, err := db.addCreditMovement(payerID, models.CreditApplied, -credit, chargeID, description, now); err != nil {
+			return 0, err
+		}
+	}
+	if amount > 0 {
+		if err := issueReceipt(db.Conn, chargeID, now); err != nil {
+			return 0, err
+		}
+	}
+	return chargeID, nil
 }
 
 func (db *Database) GetOrCreateRole(name string) (int64, error) {
@@ -474,8 +639,8 @@ func (db *Database) GetFilteredEvents(startDate, endDate, location string) ([]mo
 // CreateEvent creates a new event in the database
 func (db *Database) CreateEvent(event models.Event) (int64, error) {
 	res, err := db.Conn.Exec(
-		"INSERT INTO events (title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		event.Title, event.Description, event.StartTime, event.EndTime, event.Location, event.Organizer, event.ClassType, event.TeacherName, event.Capacity, event.CurrentEnrolment, event.Color,
+		"INSERT INTO events (title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color, delivery_mode, online_capacity, stream_url, role_requirements, klippekort_category) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.Title, event.Description, event.StartTime, event.EndTime, event.Location, event.Organizer, event.ClassType, event.TeacherName, event.Capacity, event.CurrentEnrolment, event.Color, deliveryModeOrDefault(event.DeliveryMode), event.OnlineCapacity, event.StreamURL, formatRoleRequirements(event.RoleRequirements), klippekortCategoryOrDefault(event.KlippekortCategory),
 	)
 	if err != nil {
 		return 0, err
@@ -494,7 +659,7 @@ func (db *Database) UpdateEventTime(eventID int64, startTime, endTime string) er
 
 // GetAllEvents fetches all events from the database
 func (db *Database) GetAllEvents() ([]models.Event, error) {
-	rows, err := db.Conn.Query("SELECT id, title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color FROM events")
+	rows, err := db.Conn.Query("SELECT id, title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color, cancelled, cancellation_reason, delivery_mode, online_capacity, online_enrolment, (SELECT COUNT(*) FROM event_signups es WHERE es.event_id = events.id AND es.online_joined_at IS NOT NULL), role_requirements FROM events")
 	if err != nil {
 		return nil, err
 	}
@@ -503,9 +668,11 @@ func (db *Database) GetAllEvents() ([]models.Event, error) {
 	var events []models.Event
 	for rows.Next() {
 		var event models.Event
-		if err := rows.Scan(&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime, &event.Location, &event.Organizer, &event.ClassType, &event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.Color); err != nil {
+		var roleRequirements sql.NullString
+		if err := rows.Scan(&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime, &event.Location, &event.Organizer, &event.ClassType, &event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.Color, &event.IsCancelled, &event.CancellationReason, &event.DeliveryMode, &event.OnlineCapacity, &event.OnlineEnrolment, &event.OnlineAttendance, &roleRequirements); err != nil {
 			return nil, err
 		}
+		event.RoleRequirements = parseRoleRequirements(roleRequirements)
 		events = append(events, event)
 	}
 	return events, nil
@@ -514,7 +681,7 @@ func (db *Database) GetAllEvents() ([]models.Event, error) {
 // GetTodaysEvents fetches events for today
 func (db *Database) GetTodaysEvents() ([]models.Event, error) {
 	query := `
-		SELECT id, title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color 
+		SELECT id, title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color, cancelled, cancellation_reason, delivery_mode, online_capacity, online_enrolment 
 		FROM events 
 		WHERE DATE(start_time) = DATE('now', 'localtime')
 		ORDER BY start_time ASC
@@ -528,7 +695,7 @@ func (db *Database) GetTodaysEvents() ([]models.Event, error) {
 	var events []models.Event
 	for rows.Next() {
 		var event models.Event
-		if err := rows.Scan(&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime, &event.Location, &event.Organizer, &event.ClassType, &event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.Color); err != nil {
+		if err := rows.Scan(&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime, &event.Location, &event.Organizer, &event.ClassType, &event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.Color, &event.IsCancelled, &event.CancellationReason, &event.DeliveryMode, &event.OnlineCapacity, &event.OnlineEnrolment); err != nil {
 			return nil, err
 		}
 		events = append(events, event)
@@ -539,7 +706,7 @@ func (db *Database) GetTodaysEvents() ([]models.Event, error) {
 // GetThisWeeksEvents fetches events for the current week
 func (db *Database) GetThisWeeksEvents() ([]models.Event, error) {
 	query := `
-		SELECT id, title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color 
+		SELECT id, title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color, cancelled, cancellation_reason, delivery_mode, online_capacity, online_enrolment 
 		FROM events 
 		WHERE DATE(start_time) >= DATE('now', 'weekday 0', '-6 days', 'localtime') 
 		AND DATE(start_time) <= DATE('now', 'weekday 0', 'localtime')
@@ -554,7 +721,7 @@ func (db *Database) GetThisWeeksEvents() ([]models.Event, error) {
 	var events []models.Event
 	for rows.Next() {
 		var event models.Event
-		if err := rows.Scan(&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime, &event.Location, &event.Organizer, &event.ClassType, &event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.Color); err != nil {
+		if err := rows.Scan(&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime, &event.Location, &event.Organizer, &event.ClassType, &event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.Color, &event.IsCancelled, &event.CancellationReason, &event.DeliveryMode, &event.OnlineCapacity, &event.OnlineEnrolment); err != nil {
 			return nil, err
 		}
 		events = append(events, event)
@@ -568,7 +735,7 @@ func (db *Database) GetEventsForWeek(mondayDate time.Time) ([]models.Event, erro
 	sundayDate := mondayDate.AddDate(0, 0, 6)
 	
 	query := `
-		SELECT id, title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color 
+		SELECT id, title, description, start_time, end_time, location, organizer, class_type, teacher_name, capacity, current_enrolment, color, cancelled, cancellation_reason, delivery_mode, online_capacity, online_enrolment, role_requirements 
 		FROM events 
 		WHERE DATE(start_time) >= DATE(?) 
 		AND DATE(start_time) <= DATE(?)
@@ -583,9 +750,11 @@ func (db *Database) GetEventsForWeek(mondayDate time.Time) ([]models.Event, erro
 	var events []models.Event
 	for rows.Next() {
 		var event models.Event
-		if err := rows.Scan(&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime, &event.Location, &event.Organizer, &event.ClassType, &event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.Color); err != nil {
+		var roleRequirements sql.NullString
+		if err := rows.Scan(&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime, &event.Location, &event.Organizer, &event.ClassType, &event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.Color, &event.IsCancelled, &event.CancellationReason, &event.DeliveryMode, &event.OnlineCapacity, &event.OnlineEnrolment, &roleRequirements); err != nil {
 			return nil, err
 		}
+		event.RoleRequirements = parseRoleRequirements(roleRequirements)
 		events = append(events, event)
 	}
 	return events, nil
@@ -635,7 +804,7 @@ func (db *Database) GetDistinctClassTypes() ([]string, error) {
 
 // GetAllMemberships fetches all active memberships
 func (db *Database) GetAllMemberships() ([]models.Membership, error) {
-	rows, err := db.Conn.Query("SELECT id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active FROM memberships WHERE active = TRUE")
+	rows, err := db.Conn.Query("SELECT id, name, price, commitment_months, duration_days, is_trial, is_student_senior, is_special_offer, description, entitlements, active FROM memberships WHERE active = TRUE")
 	if err != nil {
 		return nil, err
 	}
@@ -644,9 +813,11 @@ func (db *Database) GetAllMemberships() ([]models.Membership, error) {
 	var memberships []models.Membership
 	for rows.Next() {
 		var m models.Membership
-		if err := rows.Scan(&m.ID, &m.Name, &m.Price, &m.CommitmentMonths, &m.IsStudentSenior, &m.IsSpecialOffer, &m.Description, &m.Features, &m.Active); err != nil {
+		var entitlements sql.NullString
+		if err := rows.Scan(&m.ID, &m.Name, &m.Price, &m.CommitmentMonths, &m.DurationDays, &m.IsTrial, &m.IsStudentSenior, &m.IsSpecialOffer, &m.Description, &entitlements, &m.Active); err != nil {
 			return nil, err
 		}
+		m.Entitlements = parseEntitlements(entitlements)
 		memberships = append(memberships, m)
 	}
 	return memberships, nil
@@ -655,8 +826,8 @@ func (db *Database) GetAllMemberships() ([]models.Membership, error) {
 // GetUserMembership fetches a user's current membership
 func (db *Database) GetUserMembership(userID int64) (*models.MembershipWithDetails, error) {
 	query := `
-		SELECT um.id, um.user_id, um.membership_id, um.status, um.start_date, um.renewal_date, um.end_date, um.binding_end, um.last_billed, um.created_at,
-		       m.name, m.price, m.commitment_months, m.is_student_senior, m.is_special_offer, m.description, m.features, m.active
+		SELECT um.id, um.user_id, um.membership_id, um.status, um.start_date, um.plan_since, um.renewal_date, um.end_date, um.binding_end, um.last_billed, um.created_at, um.custom_price,
+		       m.name, m.price, m.commitment_months, m.duration_days, m.is_trial, m.is_student_senior, m.is_special_offer, m.description, m.entitlements, m.active
 		FROM user_memberships um
 		JOIN memberships m ON um.membership_id = m.id
 		WHERE um.user_id = ? AND (um.status = 'active' OR um.status = 'paused' OR um.status = 'freeze_requested')
@@ -665,13 +836,15 @@ func (db *Database) GetUserMembership(userID int64) (*models.MembershipWithDetai
 	`
 	
 	var membership models.MembershipWithDetails
+	var entitlements sql.NullString
+	var planSince sql.NullTime
 	err := db.Conn.QueryRow(query, userID).Scan(
 		&membership.UserMembership.ID, &membership.UserMembership.UserID, &membership.UserMembership.MembershipID,
-		&membership.UserMembership.Status, &membership.UserMembership.StartDate, &membership.UserMembership.RenewalDate,
-		&membership.UserMembership.EndDate, &membership.UserMembership.BindingEnd, &membership.UserMembership.LastBilled, &membership.UserMembership.CreatedAt,
+		&membership.UserMembership.Status, &membership.UserMembership.StartDate, &planSince, &membership.UserMembership.RenewalDate,
+		&membership.UserMembership.EndDate, &membership.UserMembership.BindingEnd, &membership.UserMembership.LastBilled, &membership.UserMembership.CreatedAt, &membership.UserMembership.CustomPrice,
 		&membership.Membership.Name, &membership.Membership.Price, &membership.Membership.CommitmentMonths,
-		&membership.Membership.IsStudentSenior, &membership.Membership.IsSpecialOffer, &membership.Membership.Description,
-		&membership.Membership.Features, &membership.Membership.Active,
+		&membership.Membership.DurationDays, &membership.Membership.IsTrial, &membership.Membership.IsStudentSenior, &membership.Membership.IsSpecialOffer, &membership.Membership.Description,
+		&entitlements, &membership.Membership.Active,
 	)
 	
 	if err != nil {
@@ -680,36 +853,53 @@ func (db *Database) GetUserMembership(userID int64) (*models.MembershipWithDetai
 		}
 		return nil, err
 	}
+	membership.Membership.Entitlements = parseEntitlements(entitlements)
+	membership.UserMembership.PlanSince = planSinceOrStart(membership.UserMembership.StartDate, planSince)
+	
+	// Show the price version this member pays, which may be grandfathered below the list price, reduced by a campaign or set by an admin
+	membership.Membership.Price, err = db.memberPlanPrice(userID, int64(membership.UserMembership.ID), int64(membership.UserMembership.MembershipID), membership.UserMembership.PlanSince, time.Now())
+	if err != nil {
+		return nil, err
+	}
 	
 	return &membership, nil
 }
 
+// memberPlanPrice returns what a member pays for a plan on a date with their membership, after
+// grandfathering from planSince, campaigns, an admin's custom price and the household discount
+func (db *Database) memberPlanPrice(userID, userMembershipID, membershipID int64, planSince, date time.Time) (int, error) {
+	price, err := db.memberPriceFor(userMembershipID, membershipID, planSince, date)
+	if err != nil {
+		return 0, err
+	}
+	return db.householdPrice(userID, membershipID, price)
+}
+
 // UpdateMembershipStatus updates the status of a user's membership
 func (db *Database) UpdateMembershipStatus(userID int64, status string) error {
 	query := `UPDATE user_memberships SET status = ? WHERE user_id = ? AND (status = 'active' OR status = 'paused' OR status = 'freeze_requested')`
 	_, err := db.Conn.Exec(query, status, userID)
-	return err
+	if err != nil {
+		return err
+	}
+	
+	// Count the freeze days used until now, see FreezeDaysUsed
+	if status == "active" {
+		return db.endFreeze(userID, time.Now())
+	}
+	return nil
 }
 
 // Klippekort-related database methods
 
 // GetAllKlippekortPackages fetches all active klippekort packages grouped by category
 func (db *Database) GetAllKlippekortPackages() ([]models.KlippekortPackage, error) {
-	rows, err := db.Conn.Query("SELECT id, name, category, klipp_count, price, price_per_session, description, valid_days, active, is_popular FROM klippekort_packages WHERE active = TRUE ORDER BY category, price")
+	rows, err := db.Conn.Query(`SELECT id, name, category, klipp_count, price, price_per_session, description, valid_days, active, is_popular, sort_order
+		FROM klippekort_packages WHERE active = TRUE ORDER BY category, sort_order, price`)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var packages []models.KlippekortPackage
-	for rows.Next() {
-		var p models.KlippekortPackage
-		if err := rows.Scan(&p.ID, &p.Name, &p.Category, &p.KlippCount, &p.Price, &p.PricePerSession, &p.Description, &p.ValidDays, &p.Active, &p.IsPopular); err != nil {
-			return nil, err
-		}
-		packages = append(packages, p)
-	}
-	return packages, nil
+	return scanKlippekortPackages(rows)
 }
 
 // GetUserKlippekort fetches all active klippekort for a user
@@ -855,7 +1045,10 @@ func (db *Database) GetPendingFreezeRequests() ([]models.FreezeRequest, error) {
 func (db *Database) ApproveFreezeRequest(userID int64) error {
 	query := `UPDATE user_memberships SET status = 'paused' WHERE user_id = ? AND status = 'freeze_requested'`
 	_, err := db.Conn.Exec(query, userID)
-	return err
+	if err != nil {
+		return err
+	}
+	return db.startFreeze(userID, time.Now())
 }
 
 // RejectFreezeRequest rejects a freeze request by setting status back to 'active'
@@ -875,6 +1068,11 @@ func (db *Database) UpdateUser(user *models.User) error {
 
 // AddUserMembership creates a new user membership
 func (db *Database) AddUserMembership(userID int64, membershipID int64) error {
+	return db.CheckoutMembership(userID, membershipID, 0, "")
+}
+
+// CheckoutMembership creates a new user membership, redeeming a campaign and a coupon code when given
+func (db *Database) CheckoutMembership(userID int64, membershipID int64, campaignID int64, couponCode string) error {
 	// First, check if user already has an active membership
 	existingMembership, _ := db.GetUserMembership(userID)
 	if existingMembership != nil {
@@ -888,22 +1086,105 @@ func (db *Database) AddUserMembership(userID int64, membershipID int64) error {
 	}
 
 	now := time.Now()
+
+	// Discounted plans require an approved student or senior verification
+	if err := db.checkDiscountEligibility(userID, membership, now); err != nil {
+		return err
+	}
+
+	// Each member gets one trial
+	if membership.IsTrial {
+		usedTrial, err := db.HasUsedTrial(userID)
+		if err != nil {
+			return err
+		}
+		if usedTrial {
+			return fmt.Errorf("du har allerede brukt prøvemedlemskapet")
+		}
+	}
+
+	// Campaigns can only be redeemed on their own plan by eligible members
+	price := membership.Price
+	renewal := now.AddDate(0, 1, 0) // Next month
+	var campaign *models.Campaign
+	if campaignID != 0 {
+		eligible, err := db.GetEligibleCampaigns(userID, now)
+		if err != nil {
+			return err
+		}
+		for i := range eligible {
+			if int64(eligible[i].ID) == campaignID && int64(eligible[i].MembershipID) == membershipID {
+				campaign = &eligible[i]
+			}
+		}
+		if campaign == nil {
+			return fmt.Errorf("kampanjen er ikke tilgjengelig for deg")
+		}
+		if campaign.DiscountPrice != nil && *campaign.DiscountPrice < price {
+			price = *campaign.DiscountPrice
+		}
+		renewal = renewal.AddDate(0, 0, 7*campaign.FreeWeeks)
+	}
+	price, err = db.householdPrice(userID, membershipID, price)
+	if err != nil {
+		return err
+	}
+
+	// Coupons are checked before anything is created so an invalid code leaves no membership behind
+	var coupon *models.Coupon
+	if couponCode != "" {
+		coupon, err = db.resolveCoupon(userID, couponCode, models.CouponProductMembership, now)
+		if err != nil {
+			return err
+		}
+	}
+
 	startDate := now.Format("2006-01-02")
-	renewalDate := now.AddDate(0, 1, 0).Format("2006-01-02") // Next month
+	renewalDate := renewal.Format("2006-01-02")
 	endDate := now.AddDate(0, membership.CommitmentMonths, 0).Format("2006-01-02")
 	bindingEnd := endDate // Binding period same as commitment
 
+	// Fixed-term plans such as trials end by themselves instead of renewing
+	if termEnd, ok := MembershipTermEnd(*membership, now); ok {
+		renewalDate = termEnd.Format("2006-01-02")
+		endDate = renewalDate
+	}
+
 	query := `INSERT INTO user_memberships (user_id, membership_id, status, start_date, renewal_date, end_date, binding_end, last_billed, created_at)
 	          VALUES (?, ?, 'active', ?, ?, ?, ?, ?, ?)`
 	
-	_, err = db.Conn.Exec(query, userID, membershipID, startDate, renewalDate, endDate, bindingEnd, startDate, now)
+	result, err := db.Conn.Exec(query, userID, membershipID, startDate, renewalDate, endDate, bindingEnd, startDate, now)
+	if err != nil {
+		return err
+	}
+	userMembershipID, err := result.LastInsertId()
 	if err != nil {
 		return err
 	}
+	if err := db.recordMembershipPeriod(userID, membershipID, now); err != nil {
+		return err
+	}
 
-	// Simulate billing for the membership
 	description := fmt.Sprintf("Medlemskap: %s", membership.Name)
-	err = db.SimulateBilling(userID, membership.Price, description, "medlemskap")
+	if campaign != nil {
+		_, err = db.Conn.Exec("INSERT INTO campaign_redemptions (campaign_id, user_id, user_membership_id, redeemed_at) VALUES (?, ?, ?, ?)",
+			campaign.ID, userID, userMembershipID, startDate)
+		if err != nil {
+			return err
+		}
+		description = fmt.Sprintf("Medlemskap: %s (%s)", membership.Name, campaign.Name)
+	}
+	if coupon != nil {
+		discount := coupon.Discount(price)
+		if err := db.recordCouponRedemption(coupon, userID, models.CouponProductMembership, membership.Name, userMembershipID, price, discount, now); err != nil {
+			return err
+		}
+		price -= discount
+		description = fmt.Sprintf("%s, rabattkode %s", description, coupon.Code)
+	}
+
+	// Simulate billing for the membership, with any company share put on the company's invoice
+	err = db.billMembership(userID, membershipID, price, description)
 	if err != nil {
 		log.Printf("Warning: Could not simulate billing for membership purchase: %v", err)
 	}
@@ -911,7 +1192,8 @@ func (db *Database) AddUserMembership(userID int64, membershipID int64) error {
 	return nil
 }
 
-// ChangeUserMembership changes a user's membership to a different type
+// ChangeUserMembership changes a user's membership to a different type right away.
+// The billing period is kept and the price difference for the rest of it is charged or credited.
 func (db *Database) ChangeUserMembership(userID int64, newMembershipID int64) error {
 	// Get membership rules
 	rules, err := db.GetMembershipRules()
@@ -935,18 +1217,42 @@ func (db *Database) ChangeUserMembership(userID int64, newMembershipID int64) er
 	}
 
 	now := time.Now()
-	renewalDate := now.AddDate(0, 1, 0).Format("2006-01-02")
+
+	// The difference for the rest of the current billing period is worked out on the current plan
+	quote, err := db.QuoteMembershipChange(userID, newMembershipID, now)
+	if err != nil {
+		return err
+	}
+
+	newBindingEnd := bindingEndAfterChange(rules, currentMembership, newMembership, now).Format("2006-01-02")
+
+	// Grandfathered and custom prices stay with the old plan, the new one is priced from today
+	query := `UPDATE user_memberships 
+	          SET membership_id = ?, binding_end = ?, scheduled_membership_id = NULL, plan_since = ?, custom_price = NULL 
+	          WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')`
 	
-	// Calculate new binding end date
-	var newBindingEnd string
-	isUpgrade := newMembership.Price > currentMembership.Price
+	_, err = db.Conn.Exec(query, newMembershipID, newBindingEnd, now.Format("2006-01-02"), userID)
+	if err != nil {
+		return err
+	}
+	if err := db.recordMembershipPeriod(userID, newMembershipID, now); err != nil {
+		return err
+	}
+
+	// Charge or credit the difference only once the member is on the new plan
+	return db.billProration(userID, quote, now)
+}
+
+// bindingEndAfterChange calculates the binding end date when a member changes plan on a date
+func bindingEndAfterChange(rules *models.MembershipRules, currentMembership *models.MembershipWithDetails, newMembership *models.Membership, from time.Time) time.Time {
+	isUpgrade := newMembership.Price > currentMembership.Membership.Price
 	
 	if isUpgrade && rules.CombineBindingPeriods && currentMembership.BindingEnd != nil {
 		// For upgrades, combine remaining binding time with new commitment
 		remainingMonths := 0
-		if now.Before(*currentMembership.BindingEnd) {
+		if from.Before(*currentMembership.BindingEnd) {
 			// Calculate remaining months in current binding
-			remainingMonths = int(currentMembership.BindingEnd.Sub(now).Hours() / (24 * 30))
+			remainingMonths = int(currentMembership.BindingEnd.Sub(from).Hours() / (24 * 30))
 			if remainingMonths < 0 {
 				remainingMonths = 0
 			}
@@ -954,44 +1260,41 @@ func (db *Database) ChangeUserMembership(userID int64, newMembershipID int64) er
 		
 		// Add new commitment months to remaining months
 		totalMonths := remainingMonths + newMembership.CommitmentMonths
-		newBindingEndTime := now.AddDate(0, totalMonths, 0)
-		newBindingEnd = newBindingEndTime.Format("2006-01-02")
-	} else {
-		// For downgrades or if not combining, use standard new commitment
-		newEndDate := now.AddDate(0, newMembership.CommitmentMonths, 0)
-		newBindingEnd = newEndDate.Format("2006-01-02")
+		return from.AddDate(0, totalMonths, 0)
 	}
 
-	query := `UPDATE user_memberships 
-	          SET membership_id = ?, renewal_date = ?, binding_end = ? 
-	          WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')`
-	
-	_, err = db.Conn.Exec(query, newMembershipID, renewalDate, newBindingEnd, userID)
-	return err
+	// For downgrades or if not combining, use standard new commitment
+	return from.AddDate(0, newMembership.CommitmentMonths, 0)
 }
 
 // RemoveUserMembership deactivates a user's membership
 func (db *Database) RemoveUserMembership(userID int64) error {
-	query := `UPDATE user_memberships SET status = 'cancelled' WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')`
-	_, err := db.Conn.Exec(query, userID)
-	return err
+	now := time.Now()
+	query := `UPDATE user_memberships SET status = 'cancelled', end_date = ? WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')`
+	_, err := db.Conn.Exec(query, now.Format("2006-01-02"), userID)
+	if err != nil {
+		return err
+	}
+	return db.endMembershipPeriod(userID, now)
 }
 
 // GetMembershipByID gets a membership by its ID
 func (db *Database) GetMembershipByID(membershipID int64) (*models.Membership, error) {
-	query := `SELECT id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active 
+	query := `SELECT id, name, price, commitment_months, duration_days, is_trial, is_student_senior, is_special_offer, description, entitlements, active 
 	          FROM memberships WHERE id = ?`
 	
 	var membership models.Membership
+	var entitlements sql.NullString
 	err := db.Conn.QueryRow(query, membershipID).Scan(
 		&membership.ID, &membership.Name, &membership.Price, &membership.CommitmentMonths,
-		&membership.IsStudentSenior, &membership.IsSpecialOffer, &membership.Description,
-		&membership.Features, &membership.Active,
+		&membership.DurationDays, &membership.IsTrial, &membership.IsStudentSenior, &membership.IsSpecialOffer,
+		&membership.Description, &entitlements, &membership.Active,
 	)
 	
 	if err != nil {
 		return nil, err
 	}
+	membership.Entitlements = parseEntitlements(entitlements)
 	
 	return &membership, nil
 }
@@ -1016,6 +1319,11 @@ func (db *Database) CanChangeMembership(userID int64, newMembershipID int64) (bo
 		return false, "Ugyldig nytt medlemskap"
 	}
 
+	// Trials are only for new members
+	if newMembership.IsTrial {
+		return false, "Kan ikke bytte til et prøvemedlemskap"
+	}
+
 	// Check if current membership allows changes (must be active or frozen)
 	if currentMembership.Status != "active" && currentMembership.Status != "paused" {
 		return false, "Medlemskap må være aktivt eller fryst for å bytte"
@@ -1041,10 +1349,9 @@ func (db *Database) CanChangeMembership(userID int64, newMembershipID int64) (bo
 		return false, "Nedgraderinger er ikke tillatt ifølge gjeldende regler"
 	}
 
-	// Check if switching involves adding a discount (would require admin approval)
-	if newMembership.IsStudentSenior && !currentMembership.IsStudentSenior {
-		// This would require admin approval - for now we block it
-		return false, "Bytte til student/senior-rabatt krever godkjenning fra admin"
+	// Discounted plans require an approved student or senior verification
+	if err := db.checkDiscountEligibility(userID, newMembership, now); err != nil {
+		return false, "Bytte til student/senior-rabatt krever godkjent student- eller seniorbekreftelse"
 	}
 
 	return true, ""
@@ -1052,6 +1359,11 @@ func (db *Database) CanChangeMembership(userID int64, newMembershipID int64) (bo
 
 // PurchaseKlippekort creates a new klippekort for a user or adds to existing one
 func (db *Database) PurchaseKlippekort(userID int64, packageID int64) error {
+	return db.CheckoutKlippekort(userID, packageID, "")
+}
+
+// CheckoutKlippekort purchases a klippekort package, redeeming a coupon code when given
+func (db *Database) CheckoutKlippekort(userID int64, packageID int64, couponCode string) error {
 	// Get package details
 	var pkg models.KlippekortPackage
 	query := `SELECT id, name, category, klipp_count, price, price_per_session, description, valid_days, active, is_popular 
@@ -1081,24 +1393,60 @@ func (db *Database) PurchaseKlippekort(userID int64, packageID int64) error {
 	var totalKlipp, remainingKlipp int
 	var expiryDate time.Time
 	
-	err = db.Conn.QueryRow(existingQuery, userID, pkg.Category).Scan(&existingID, &totalKlipp, &remainingKlipp, &expiryDate)
+	policy, err := db.GetKlippekortCategoryPolicy(pkg.Category)
+	if err != nil {
+		return err
+	}
+	if policy.TopUpMode == models.KlippekortTopUpNewCard {
+		// Every purchase in the category is a card of its own
+		err = sql.ErrNoRows
+	} else {
+		err = db.Conn.QueryRow(existingQuery, userID, pkg.Category).Scan(&existingID, &totalKlipp, &remainingKlipp, &expiryDate)
+	}
 	
 	now := time.Now()
 	newExpiryDate := now.AddDate(0, 0, pkg.ValidDays)
+
+	// Coupons are checked before anything is created so an invalid code leaves no klippekort behind
+	var coupon *models.Coupon
+	if couponCode != "" {
+		var couponErr error
+		coupon, couponErr = db.resolveCoupon(userID, couponCode, models.CouponProductKlippekort, now)
+		if couponErr != nil {
+			return couponErr
+		}
+	}
 	
 	if err == sql.ErrNoRows {
 		// No existing klippekort, create new one
 		insertQuery := `INSERT INTO user_klippekort (user_id, package_id, total_klipp, remaining_klipp, expiry_date, purchase_date, is_active)
 		                VALUES (?, ?, ?, ?, ?, ?, TRUE)`
 		
-		_, err = db.Conn.Exec(insertQuery, userID, packageID, pkg.KlippCount, pkg.KlippCount, newExpiryDate, now)
+		result, err := db.Conn.Exec(insertQuery, userID, packageID, pkg.KlippCount, pkg.KlippCount, newExpiryDate, now)
 		if err != nil {
 			return err
 		}
+		klippekortID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if _, err := db.addKlippMovement(klippekortID, models.KlippPurchase, pkg.KlippCount, 0, packageID, "", now); err != nil {
+			return err
+		}
+		if err := db.rolloverExpiredKlipp(userID, klippekortID, pkg.Category, now); err != nil {
+			return err
+		}
 
 		// Simulate billing for the klippekort
 		description := fmt.Sprintf("Klippekort: %s", pkg.Name)
-		err = db.SimulateBilling(userID, pkg.Price, description, "klippekort")
+		price, description, err := db.applyKlippekortCoupon(coupon, userID, &pkg, klippekortID, description, now)
+		if err != nil {
+			return err
+		}
+		err = db.billKlippekort(userID, price, description, klippekortID, pkg.KlippCount)
+		if errors.Is(err, errPaymentDeclined) {
+			return err
+		}
 		if err != nil {
 			log.Printf("Warning: Could not simulate billing for klippekort purchase: %v", err)
 		}
@@ -1108,35 +1456,47 @@ func (db *Database) PurchaseKlippekort(userID int64, packageID int64) error {
 		return err
 	}
 	
-	// Existing klippekort found - add to it
-	// Check if adding would exceed maximum allowed (20 by default)
-	maxKlipp := 20 // TODO: Make this configurable in admin settings
+	// Existing klippekort found - add to it, up to the category's maximum
 	newTotal := totalKlipp + pkg.KlippCount
-	newRemaining := remainingKlipp + pkg.KlippCount
 	
-	if newTotal > maxKlipp {
-		return fmt.Errorf("kan ikke kjøpe flere klipp. Maksimum %d klipp per kort (du har %d)", maxKlipp, totalKlipp)
+	if policy.MaxKlipp > 0 && newTotal > policy.MaxKlipp {
+		return fmt.Errorf("kan ikke kjøpe flere klipp. Maksimum %d klipp per kort (du har %d)", policy.MaxKlipp, totalKlipp)
 	}
 	
-	// Use the longer expiry date (existing or new)
+	// The category's policy decides how the expiry dates are combined
 	finalExpiryDate := expiryDate
-	if newExpiryDate.After(expiryDate) {
-		finalExpiryDate = newExpiryDate
+	switch policy.ExpiryMode {
+	case models.KlippekortExpiryExtend:
+		finalExpiryDate = expiryDate.AddDate(0, 0, pkg.ValidDays)
+	case models.KlippekortExpiryLongest:
+		if newExpiryDate.After(expiryDate) {
+			finalExpiryDate = newExpiryDate
+		}
 	}
 	
-	// Update existing klippekort
+	// Update existing klippekort, keeping the package it was bought as. The top-up's package is in the ledger.
 	updateQuery := `UPDATE user_klippekort 
-	                SET total_klipp = ?, remaining_klipp = ?, expiry_date = ?, package_id = ?
+	                SET total_klipp = ?, expiry_date = ?
 	                WHERE id = ?`
 	
-	_, err = db.Conn.Exec(updateQuery, newTotal, newRemaining, finalExpiryDate, packageID, existingID)
+	_, err = db.Conn.Exec(updateQuery, newTotal, finalExpiryDate, existingID)
 	if err != nil {
 		return err
 	}
+	if _, err := db.addKlippMovement(int64(existingID), models.KlippTopUp, pkg.KlippCount, 0, packageID, "", now); err != nil {
+		return err
+	}
 
 	// Simulate billing for the additional klippekort
 	description := fmt.Sprintf("Klippekort tillegg: %s", pkg.Name)
-	err = db.SimulateBilling(userID, pkg.Price, description, "klippekort")
+	price, description, err := db.applyKlippekortCoupon(coupon, userID, &pkg, int64(existingID), description, now)
+	if err != nil {
+		return err
+	}
+	err = db.billKlippekort(userID, price, description, int64(existingID), pkg.KlippCount)
+	if errors.Is(err, errPaymentDeclined) {
+		return err
+	}
 	if err != nil {
 		log.Printf("Warning: Could not simulate billing for klippekort purchase: %v", err)
 	}
@@ -1149,23 +1509,33 @@ func (db *Database) PurchaseKlippekort(userID int64, packageID int64) error {
 // GetEventByID fetches a single event by ID
 func (db *Database) GetEventByID(eventID int64) (*models.Event, error) {
 	var event models.Event
-	query := `SELECT id, title, description, start_time, end_time, teacher_name, capacity, current_enrolment, class_type
+	var roleRequirements sql.NullString
+	query := `SELECT id, title, description, start_time, end_time, teacher_name, capacity, current_enrolment, class_type, cancelled, cancellation_reason, delivery_mode, online_capacity, online_enrolment, role_requirements
 	          FROM events WHERE id = ?`
 	
 	err := db.Conn.QueryRow(query, eventID).Scan(
 		&event.ID, &event.Title, &event.Description, &event.StartTime, &event.EndTime,
 		&event.TeacherName, &event.Capacity, &event.CurrentEnrolment, &event.ClassType,
+		&event.IsCancelled, &event.CancellationReason,
+		&event.DeliveryMode, &event.OnlineCapacity, &event.OnlineEnrolment, &roleRequirements,
 	)
 	
 	if err != nil {
 		return nil, err
 	}
+	event.RoleRequirements = parseRoleRequirements(roleRequirements)
 	
 	return &event, nil
 }
 
-// SignupUserForEvent signs up a user for an event
+// SignupUserForEvent signs up a user for an event using the event's default attendance mode
 func (db *Database) SignupUserForEvent(userID, eventID int64) error {
+	return db.SignupUserForEventWithMode(userID, eventID, "")
+}
+
+// SignupUserForEventWithMode signs up a user for an event, either in the studio or online.
+// An empty attendance mode means online for online-only classes and in the studio otherwise.
+func (db *Database) SignupUserForEventWithMode(userID, eventID int64, attendanceMode string) error {
 	// Check if user is already signed up
 	var exists int
 	checkQuery := `SELECT COUNT(*) FROM event_signups WHERE user_id = ? AND event_id = ?`
@@ -1179,61 +1549,139 @@ func (db *Database) SignupUserForEvent(userID, eventID int64) error {
 	}
 	
 	// Check if event has capacity
-	var currentEnrolment, capacity int
-	capacityQuery := `SELECT current_enrolment, capacity FROM events WHERE id = ?`
-	err = db.Conn.QueryRow(capacityQuery, eventID).Scan(&currentEnrolment, &capacity)
+	var currentEnrolment, capacity, onlineEnrolment, onlineCapacity int
+	var cancelled bool
+	var deliveryMode string
+	var roleRequirements sql.NullString
+	var event models.Event
+	capacityQuery := `SELECT current_enrolment, capacity, cancelled, delivery_mode, online_enrolment, online_capacity, role_requirements, start_time, COALESCE(location, ''), COALESCE(class_type, ''), COALESCE(klippekort_category, '') FROM events WHERE id = ?`
+	err = db.Conn.QueryRow(capacityQuery, eventID).Scan(&currentEnrolment, &capacity, &cancelled, &deliveryMode, &onlineEnrolment, &onlineCapacity, &roleRequirements, &event.StartTime, &event.Location, &event.ClassType, &event.KlippekortCategory)
+	if err != nil {
+		return err
+	}
+	
+	if cancelled {
+		return fmt.Errorf("event is cancelled")
+	}
+	
+	// Check prerequisites, e.g. Reformer requires completing Reformer Intro
+	if err := db.checkEligibility(userID, models.Event{RoleRequirements: parseRoleRequirements(roleRequirements)}); err != nil {
+		return err
+	}
+	
+	attendanceMode, err = resolveAttendanceMode(deliveryMode, attendanceMode)
 	if err != nil {
 		return err
 	}
 	
-	if currentEnrolment >= capacity {
+	updateQuery := `UPDATE events SET current_enrolment = current_enrolment + 1 WHERE id = ?`
+	if attendanceMode == models.DeliveryOnline {
+		// Online capacity 0 means the stream has no limit
+		if onlineCapacity > 0 && onlineEnrolment >= onlineCapacity {
+			return fmt.Errorf("event is full online")
+		}
+		updateQuery = `UPDATE events SET online_enrolment = online_enrolment + 1 WHERE id = ?`
+	} else if currentEnrolment >= capacity {
 		return fmt.Errorf("event is full")
 	}
 	
+	// Classes the member's plan does not include are paid with a klipp of the class's category
+	klippekortID, err := db.klippekortForSignup(userID, event, attendanceMode)
+	if err != nil {
+		return err
+	}
+	var klippekort interface{}
+	if klippekortID != 0 {
+		klippekort = klippekortID
+	}
+	
+	// The signup, the enrolment and the klipp are saved together or not at all
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
 	// Create signup record
-	insertQuery := `INSERT INTO event_signups (user_id, event_id, signup_date) VALUES (?, ?, ?)`
-	_, err = db.Conn.Exec(insertQuery, userID, eventID, time.Now())
+	now := time.Now()
+	insertQuery := `INSERT INTO event_signups (user_id, event_id, signup_date, attendance_mode, klippekort_id) VALUES (?, ?, ?, ?, ?)`
+	_, err = tx.Exec(insertQuery, userID, eventID, now, attendanceMode, klippekort)
 	if err != nil {
 		return err
 	}
 	
 	// Update event enrolment count
-	updateQuery := `UPDATE events SET current_enrolment = current_enrolment + 1 WHERE id = ?`
-	_, err = db.Conn.Exec(updateQuery, eventID)
-	return err
+	_, err = tx.Exec(updateQuery, eventID)
+	if err != nil {
+		return err
+	}
+	
+	if klippekortID != 0 {
+		if _, err := writeKlippMovement(tx, klippekortID, userID, models.KlippSignup, -1, eventID, 0, "", now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 // CancelUserSignupForEvent cancels a user's signup for an event
 func (db *Database) CancelUserSignupForEvent(userID, eventID int64) error {
-	// Check if user is signed up
-	var exists int
-	checkQuery := `SELECT COUNT(*) FROM event_signups WHERE user_id = ? AND event_id = ?`
-	err := db.Conn.QueryRow(checkQuery, userID, eventID).Scan(&exists)
+	// Check if user is signed up, and whether in the studio or online
+	attendanceMode, err := db.GetUserAttendanceMode(userID, eventID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("user is not signed up for this event")
+	}
 	if err != nil {
 		return err
 	}
 	
-	if exists == 0 {
-		return fmt.Errorf("user is not signed up for this event")
+	klippekortID, err := db.signupKlippekort(userID, eventID)
+	if err != nil {
+		return err
 	}
 	
+	// The signup, the enrolment and the klipp are removed together or not at all
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
 	// Remove signup record
 	deleteQuery := `DELETE FROM event_signups WHERE user_id = ? AND event_id = ?`
-	_, err = db.Conn.Exec(deleteQuery, userID, eventID)
+	_, err = tx.Exec(deleteQuery, userID, eventID)
 	if err != nil {
 		return err
 	}
 	
+	// Give back the klipp the signup used
+	if klippekortID != 0 {
+		if _, err := writeKlippMovement(tx, klippekortID, userID, models.KlippRefund, 1, eventID, 0, "", time.Now()); err != nil {
+			return err
+		}
+	}
+	
 	// Update event enrolment count
 	updateQuery := `UPDATE events SET current_enrolment = current_enrolment - 1 WHERE id = ?`
-	_, err = db.Conn.Exec(updateQuery, eventID)
-	return err
+	if attendanceMode == models.DeliveryOnline {
+		updateQuery = `UPDATE events SET online_enrolment = online_enrolment - 1 WHERE id = ?`
+	}
+	if _, err := tx.Exec(updateQuery, eventID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	
+	// Guests can only come along with the member
+	return db.cancelGuests(userID, eventID)
 }
 
-// GetUserSignupsForEvents returns a map of event IDs that the user is signed up for
-func (db *Database) GetUserSignupsForEvents(userID int64, eventIDs []int64) (map[int64]bool, error) {
+// GetUserSignupsForEvents returns the user's attendance mode (in_person or online) keyed by
+// the IDs of the events the user is signed up for
+func (db *Database) GetUserSignupsForEvents(userID int64, eventIDs []int64) (map[int64]string, error) {
 	if len(eventIDs) == 0 {
-		return make(map[int64]bool), nil
+		return make(map[int64]string), nil
 	}
 	
 	// Build query with placeholders for event IDs
@@ -1247,7 +1695,7 @@ func (db *Database) GetUserSignupsForEvents(userID int64, eventIDs []int64) (map
 	}
 	
 	query := fmt.Sprintf(
-		`SELECT event_id FROM event_signups WHERE user_id = ? AND event_id IN (%s)`,
+		`SELECT event_id, attendance_mode FROM event_signups WHERE user_id = ? AND event_id IN (%s)`,
 		strings.Join(placeholders, ","),
 	)
 	
@@ -1257,13 +1705,14 @@ func (db *Database) GetUserSignupsForEvents(userID int64, eventIDs []int64) (map
 	}
 	defer rows.Close()
 	
-	signups := make(map[int64]bool)
+	signups := make(map[int64]string)
 	for rows.Next() {
 		var eventID int64
-		if err := rows.Scan(&eventID); err != nil {
+		var attendanceMode string
+		if err := rows.Scan(&eventID, &attendanceMode); err != nil {
 			return nil, err
 		}
-		signups[eventID] = true
+		signups[eventID] = attendanceMode
 	}
 	
 	return signups, rows.Err()
@@ -1272,9 +1721,10 @@ func (db *Database) GetUserSignupsForEvents(userID int64, eventIDs []int64) (map
 // GetUserUpcomingSignups returns all upcoming events that the user is signed up for
 func (db *Database) GetUserUpcomingSignups(userID int64) ([]models.Event, error) {
 	query := `
-		SELECT e.id, e.title, e.description, e.role_requirements, e.start_time, e.end_time, 
-		       e.location, e.organizer, e.attendees, e.class_type, e.teacher_name, 
-		       e.capacity, e.current_enrolment, e.color
+		SELECT e.id, e.title, e.description, e.start_time, e.end_time, 
+		       e.location, e.organizer, e.class_type, e.teacher_name, 
+		       e.capacity, e.current_enrolment, e.color, e.cancelled, e.cancellation_reason,
+		       e.delivery_mode, e.online_capacity, e.online_enrolment, es.attendance_mode
 		FROM events e
 		INNER JOIN event_signups es ON e.id = es.event_id
 		WHERE es.user_id = ? AND e.start_time > ?
@@ -1292,10 +1742,12 @@ func (db *Database) GetUserUpcomingSignups(userID int64) ([]models.Event, error)
 	for rows.Next() {
 		var event models.Event
 		err := rows.Scan(
-			&event.ID, &event.Title, &event.Description, &event.RoleRequirements,
+			&event.ID, &event.Title, &event.Description,
 			&event.StartTime, &event.EndTime, &event.Location, &event.Organizer,
-			&event.Attendees, &event.ClassType, &event.TeacherName,
+			&event.ClassType, &event.TeacherName,
 			&event.Capacity, &event.CurrentEnrolment, &event.Color,
+			&event.IsCancelled, &event.CancellationReason,
+			&event.DeliveryMode, &event.OnlineCapacity, &event.OnlineEnrolment, &event.UserAttendanceMode,
 		)
 		if err != nil {
 			return nil, err
@@ -1363,40 +1815,48 @@ func (db *Database) SaveMembershipRules(rules *models.MembershipRules) error {
 	return err
 }
 
-// UpdateMembershipPrice updates the price of a membership
+// UpdateMembershipPrice changes the price of a membership from today. Existing members keep
+// their price until the notice period has passed, see CreateMembershipPriceVersion.
 func (db *Database) UpdateMembershipPrice(membershipID int64, newPrice int) error {
-	query := `UPDATE memberships SET price = ? WHERE id = ?`
-	_, err := db.Conn.Exec(query, newPrice, membershipID)
+	now := time.Now()
+	_, err := db.CreateMembershipPriceVersion(membershipID, newPrice, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
 	return err
 }
 
 // CreateMembership creates a new membership
 func (db *Database) CreateMembership(membership models.Membership) (int64, error) {
 	query := `INSERT INTO memberships 
-		(name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-	
-	// Convert features to JSON if it's not already
-	features := membership.Features
-	if features == "" {
-		features = "[]"
-	}
+		(name, price, commitment_months, duration_days, is_trial, is_student_senior, is_special_offer, description, entitlements, active) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
 	result, err := db.Conn.Exec(query, 
 		membership.Name, 
 		membership.Price, 
 		membership.CommitmentMonths,
+		membership.DurationDays,
+		membership.IsTrial,
 		membership.IsStudentSenior,
 		membership.IsSpecialOffer,
 		membership.Description,
-		features,
+		formatEntitlements(membership.Entitlements),
 		membership.Active)
 	
 	if err != nil {
 		return 0, err
 	}
 	
-	return result.LastInsertId()
+	membershipID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	
+	// Start the price history of the new plan
+	today := time.Now().Format("2006-01-02")
+	_, err = db.Conn.Exec(
+		"INSERT INTO membership_prices (membership_id, price, effective_date, existing_members_date) VALUES (?, ?, ?, ?)",
+		membershipID, membership.Price, today, today,
+	)
+	return membershipID, err
 }
 
 // DeactivateMembership deactivates a membership (soft delete)
@@ -1409,18 +1869,20 @@ func (db *Database) DeactivateMembership(membershipID int64) error {
 // UpdateMembershipDetails updates full membership details
 func (db *Database) UpdateMembershipDetails(membership models.Membership) error {
 	query := `UPDATE memberships SET 
-		name = ?, price = ?, commitment_months = ?, is_student_senior = ?, 
-		is_special_offer = ?, description = ?, features = ?
+		name = ?, price = ?, commitment_months = ?, duration_days = ?, is_trial = ?, 
+		is_student_senior = ?, is_special_offer = ?, description = ?, entitlements = ?
 		WHERE id = ?`
 	
 	_, err := db.Conn.Exec(query,
 		membership.Name,
 		membership.Price,
 		membership.CommitmentMonths,
+		membership.DurationDays,
+		membership.IsTrial,
 		membership.IsStudentSenior,
 		membership.IsSpecialOffer,
 		membership.Description,
-		membership.Features,
+		formatEntitlements(membership.Entitlements),
 		membership.ID)
 	
 	return err
@@ -1436,12 +1898,15 @@ func (db *Database) DeleteEvent(eventID int64) error {
 func (db *Database) UpdateEvent(event models.Event) error {
 	query := `UPDATE events SET 
 		title = ?, description = ?, start_time = ?, end_time = ?, location = ?, 
-		class_type = ?, teacher_name = ?, capacity = ?, color = ?
+		class_type = ?, teacher_name = ?, capacity = ?, color = ?,
+		delivery_mode = ?, online_capacity = ?, stream_url = ?, role_requirements = ?
 		WHERE id = ?`
 	
 	_, err := db.Conn.Exec(query,
 		event.Title, event.Description, event.StartTime, event.EndTime, event.Location,
-		event.ClassType, event.TeacherName, event.Capacity, event.Color, event.ID)
+		event.ClassType, event.TeacherName, event.Capacity, event.Color,
+		deliveryModeOrDefault(event.DeliveryMode), event.OnlineCapacity, event.StreamURL,
+		formatRoleRequirements(event.RoleRequirements), event.ID)
 	
 	return err
 }
\ No newline at end of file
@@ -0,0 +1,391 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// SeniorAge is the age from which members qualify for senior plans
+const SeniorAge = 67
+
+// StudentVerificationMonths is how long an approved student verification is valid before it must be renewed
+const StudentVerificationMonths = 12
+
+// VerificationReminderDays is how long before expiry members are asked to renew their student verification
+const VerificationReminderDays = 30
+
+// migrateDiscountVerifications creates the verification table and links discounted plans to their regular plan
+func migrateDiscountVerifications(db *sql.DB) error {
+	verificationsTableSQL := `
+	CREATE TABLE IF NOT EXISTS discount_verifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		proof TEXT DEFAULT '',
+		status TEXT DEFAULT 'pending',
+		expires_at DATE,
+		review_note TEXT DEFAULT '',
+		reviewed_at DATETIME,
+		reminder_sent_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	`
+	if _, err := db.Exec(verificationsTableSQL); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("ALTER TABLE memberships ADD COLUMN regular_membership_id INTEGER")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+
+	// Discounted plans fall back to the oldest regular plan with the same commitment
+	_, err = db.Exec(`
+		UPDATE memberships SET regular_membership_id = (
+			SELECT r.id FROM memberships r
+			WHERE r.is_student_senior = FALSE AND r.is_special_offer = FALSE AND r.active = TRUE
+			AND r.commitment_months = memberships.commitment_months
+			ORDER BY r.id LIMIT 1
+		)
+		WHERE is_student_senior = TRUE AND regular_membership_id IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	// Members already on discounted plans get a year to verify before they are moved
+	_, err = db.Exec(`
+		INSERT INTO discount_verifications (user_id, type, status, expires_at, review_note, reviewed_at)
+		SELECT DISTINCT um.user_id, 'student', 'approved', date('now', '+12 months'), 'Eksisterende medlem', CURRENT_TIMESTAMP
+		FROM user_memberships um JOIN memberships m ON um.membership_id = m.id
+		WHERE m.is_student_senior = TRUE AND um.status IN ('active', 'paused', 'freeze_requested')
+		AND um.user_id NOT IN (SELECT user_id FROM discount_verifications)`)
+	return err
+}
+
+// IsSeniorEligible checks from a birthdate in "2006-01-02" format whether a member has reached SeniorAge
+func IsSeniorEligible(birthdate string, now time.Time) bool {
+	born, err := time.Parse("2006-01-02", strings.TrimSpace(birthdate))
+	if err != nil {
+		return false
+	}
+	seniorFrom := born.AddDate(SeniorAge, 0, 0)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return !seniorFrom.After(today)
+}
+
+// RequestDiscountVerification registers a member's proof of student or senior status.
+// Seniors are approved right away from their birthdate, students wait for an admin.
+func (db *Database) RequestDiscountVerification(userID int64, verificationType, proof string, now time.Time) (*models.DiscountVerification, error) {
+	proof = strings.TrimSpace(proof)
+
+	switch verificationType {
+	case models.VerificationSenior:
+		var birthdate string
+		if err := db.Conn.QueryRow("SELECT birthdate FROM users WHERE id = ?", userID).Scan(&birthdate); err != nil {
+			return nil, fmt.Errorf("fant ikke brukeren")
+		}
+		if !IsSeniorEligible(birthdate, now) {
+			return nil, fmt.Errorf("seniorrabatt gjelder fra fylte %d år", SeniorAge)
+		}
+
+		res, err := db.Conn.Exec(`INSERT INTO discount_verifications (user_id, type, proof, status, reviewed_at, review_note, created_at)
+			VALUES (?, ?, ?, 'approved', ?, 'Godkjent automatisk ut fra fødselsdato', ?)`,
+			userID, verificationType, birthdate, now, now)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		return db.getDiscountVerification(id)
+
+	case models.VerificationStudent:
+		if proof == "" {
+			return nil, fmt.Errorf("oppgi studentnummer og lærested")
+		}
+
+		var pending int
+		err := db.Conn.QueryRow("SELECT COUNT(*) FROM discount_verifications WHERE user_id = ? AND status = 'pending'", userID).Scan(&pending)
+		if err != nil {
+			return nil, err
+		}
+		if pending > 0 {
+			return nil, fmt.Errorf("du har allerede en forespørsel som venter på godkjenning")
+		}
+
+		res, err := db.Conn.Exec("INSERT INTO discount_verifications (user_id, type, proof, status, created_at) VALUES (?, ?, ?, 'pending', ?)",
+			userID, verificationType, proof, now)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		return db.getDiscountVerification(id)
+	}
+
+	return nil, fmt.Errorf("ugyldig type bekreftelse")
+}
+
+const discountVerificationColumns = `
+	dv.id, dv.user_id, u.name, u.email, dv.type, dv.proof, dv.status, dv.expires_at, dv.review_note, dv.reviewed_at, dv.created_at`
+
+// scanDiscountVerification scans a row selected with discountVerificationColumns
+func scanDiscountVerification(scanner interface{ Scan(...interface{}) error }) (*models.DiscountVerification, error) {
+	var v models.DiscountVerification
+	var expiresAt, reviewedAt sql.NullTime
+	err := scanner.Scan(&v.ID, &v.UserID, &v.UserName, &v.UserEmail, &v.Type, &v.Proof, &v.Status,
+		&expiresAt, &v.ReviewNote, &reviewedAt, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		v.ExpiresAt = &expiresAt.Time
+	}
+	if reviewedAt.Valid {
+		v.ReviewedAt = &reviewedAt.Time
+	}
+	return &v, nil
+}
+
+// getDiscountVerification fetches a single verification
+func (db *Database) getDiscountVerification(id int64) (*models.DiscountVerification, error) {
+	row := db.Conn.QueryRow(`SELECT `+discountVerificationColumns+`
+		FROM discount_verifications dv JOIN users u ON dv.user_id = u.id
+		WHERE dv.id = ?`, id)
+	return scanDiscountVerification(row)
+}
+
+// GetUserDiscountVerification returns a member's latest verification, or nil if they have none
+func (db *Database) GetUserDiscountVerification(userID int64) (*models.DiscountVerification, error) {
+	row := db.Conn.QueryRow(`SELECT `+discountVerificationColumns+`
+		FROM discount_verifications dv JOIN users u ON dv.user_id = u.id
+		WHERE dv.user_id = ?
+		ORDER BY dv.created_at DESC, dv.id DESC LIMIT 1`, userID)
+	verification, err := scanDiscountVerification(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return verification, err
+}
+
+// GetPendingDiscountVerifications returns the student verifications waiting for an admin, oldest first
+func (db *Database) GetPendingDiscountVerifications() ([]models.DiscountVerification, error) {
+	rows, err := db.Conn.Query(`SELECT ` + discountVerificationColumns + `
+		FROM discount_verifications dv JOIN users u ON dv.user_id = u.id
+		WHERE dv.status = 'pending'
+		ORDER BY dv.created_at, dv.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var verifications []models.DiscountVerification
+	for rows.Next() {
+		v, err := scanDiscountVerification(rows)
+		if err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, *v)
+	}
+	return verifications, rows.Err()
+}
+
+// HasValidDiscountVerification checks whether a member may use student or senior plans on a date
+func (db *Database) HasValidDiscountVerification(userID int64, now time.Time) (bool, error) {
+	var count int
+	err := db.Conn.QueryRow(`
+		SELECT COUNT(*) FROM discount_verifications
+		WHERE user_id = ? AND status = 'approved' AND (expires_at IS NULL OR expires_at >= ?)`,
+		userID, now.Format("2006-01-02"),
+	).Scan(&count)
+	return count > 0, err
+}
+
+// ReviewDiscountVerification approves or rejects a pending verification and notifies the member.
+// Approved student verifications are valid for StudentVerificationMonths.
+func (db *Database) ReviewDiscountVerification(id int64, approve bool, note string, now time.Time) error {
+	verification, err := db.getDiscountVerification(id)
+	if err != nil {
+		return fmt.Errorf("fant ikke forespørselen")
+	}
+	if verification.Status != "pending" {
+		return fmt.Errorf("forespørselen er allerede behandlet")
+	}
+
+	status := "rejected"
+	var expiresAt interface{}
+	title := "Student-/seniorrabatt ikke godkjent"
+	message := "Vi kunne ikke godkjenne forespørselen din om rabatt."
+	if approve {
+		status = "approved"
+		expires := now.AddDate(0, StudentVerificationMonths, 0)
+		expiresAt = expires.Format("2006-01-02")
+		title = "Student-/seniorrabatt godkjent"
+		message = fmt.Sprintf("Du kan nå velge student-/seniormedlemskap. Bekreftelsen gjelder til %s.", expires.Format("02.01.2006"))
+	}
+	if note = strings.TrimSpace(note); note != "" {
+		message += " " + note
+	}
+
+	_, err = db.Conn.Exec("UPDATE discount_verifications SET status = ?, expires_at = ?, review_note = ?, reviewed_at = ? WHERE id = ?",
+		status, expiresAt, note, now, id)
+	if err != nil {
+		return err
+	}
+
+	if err := db.CreateNotification(int64(verification.UserID), "discount_verification", title, message); err != nil {
+		log.Printf("Could not notify user %d about verification review: %v", verification.UserID, err)
+	}
+	return nil
+}
+
+// checkDiscountEligibility returns an error if a member may not use a discounted plan
+func (db *Database) checkDiscountEligibility(userID int64, membership *models.Membership, now time.Time) error {
+	if !membership.IsStudentSenior {
+		return nil
+	}
+	verified, err := db.HasValidDiscountVerification(userID, now)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return fmt.Errorf("student/senior-rabatt krever godkjent student- eller seniorbekreftelse")
+	}
+	return nil
+}
+
+// regularMembershipID returns the plan a discounted plan falls back to, or the default plan from the rules
+func (db *Database) regularMembershipID(membershipID int64) (int64, error) {
+	var regularID sql.NullInt64
+	if err := db.Conn.QueryRow("SELECT regular_membership_id FROM memberships WHERE id = ?", membershipID).Scan(&regularID); err != nil {
+		return 0, err
+	}
+	if regularID.Valid {
+		return regularID.Int64, nil
+	}
+
+	rules, err := db.GetMembershipRules()
+	if err != nil {
+		return 0, err
+	}
+	if rules.DefaultMembershipID == nil {
+		return 0, fmt.Errorf("no regular plan for membership %d", membershipID)
+	}
+	return int64(*rules.DefaultMembershipID), nil
+}
+
+// ExpireDiscountVerifications reminds students to renew their verification before it expires, expires
+// lapsed verifications and moves members without a valid verification to the regular plan at their next renewal.
+// Returns the number of verifications expired.
+func (db *Database) ExpireDiscountVerifications(now time.Time) (int, error) {
+	today := now.Format("2006-01-02")
+
+	// Remind students once, VerificationReminderDays before expiry
+	rows, err := db.Conn.Query(`
+		SELECT id, user_id, expires_at FROM discount_verifications
+		WHERE status = 'approved' AND expires_at IS NOT NULL AND expires_at >= ? AND expires_at <= ?
+		AND reminder_sent_at IS NULL`, today, now.AddDate(0, 0, VerificationReminderDays).Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	type expiringVerification struct {
+		id        int64
+		userID    int64
+		expiresAt time.Time
+	}
+	var expiring []expiringVerification
+	for rows.Next() {
+		var v expiringVerification
+		if err := rows.Scan(&v.id, &v.userID, &v.expiresAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expiring = append(expiring, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, v := range expiring {
+		message := fmt.Sprintf("Studentbekreftelsen din utløper %s. Send inn ny bekreftelse for å beholde studentprisen.", v.expiresAt.Format("02.01.2006"))
+		if err := db.CreateNotification(v.userID, "discount_verification", "Forny studentbekreftelsen", message); err != nil {
+			log.Printf("Could not remind user %d about verification expiry: %v", v.userID, err)
+			continue
+		}
+		if _, err := db.Conn.Exec("UPDATE discount_verifications SET reminder_sent_at = ? WHERE id = ?", now, v.id); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := db.Conn.Exec("UPDATE discount_verifications SET status = 'expired' WHERE status = 'approved' AND expires_at < ?", today)
+	if err != nil {
+		return 0, err
+	}
+	expired, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// Members renewing onto a discounted plan without a valid verification move to the regular plan
+	// at the next renewal instead, also when they have scheduled a change to another discounted plan
+	rows, err = db.Conn.Query(`
+		SELECT um.user_id, m.id, m.name FROM user_memberships um
+		JOIN memberships m ON m.id = COALESCE(um.scheduled_membership_id, um.membership_id)
+		WHERE m.is_student_senior = TRUE AND um.status IN ('active', 'paused', 'freeze_requested')
+		AND NOT EXISTS (
+			SELECT 1 FROM discount_verifications dv
+			WHERE dv.user_id = um.user_id AND dv.status = 'approved' AND (dv.expires_at IS NULL OR dv.expires_at >= ?)
+		)`, today)
+	if err != nil {
+		return int(expired), err
+	}
+	type lapsedMember struct {
+		userID         int64
+		membershipID   int64
+		membershipName string
+	}
+	var lapsed []lapsedMember
+	for rows.Next() {
+		var m lapsedMember
+		if err := rows.Scan(&m.userID, &m.membershipID, &m.membershipName); err != nil {
+			rows.Close()
+			return int(expired), err
+		}
+		lapsed = append(lapsed, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return int(expired), err
+	}
+
+	for _, m := range lapsed {
+		regularID, err := db.regularMembershipID(m.membershipID)
+		if err != nil {
+			log.Printf("Could not find regular plan for user %d: %v", m.userID, err)
+			continue
+		}
+		if err := db.ScheduleMembershipChange(m.userID, regularID); err != nil {
+			return int(expired), err
+		}
+
+		regular, err := db.GetMembershipByID(regularID)
+		if err != nil {
+			return int(expired), err
+		}
+		message := fmt.Sprintf("Du har ikke lenger en gyldig student- eller seniorbekreftelse. %s byttes til %s ved neste fornyelse.", m.membershipName, regular.Name)
+		if err := db.CreateNotification(m.userID, "discount_verification", "Rabatten din er utløpt", message); err != nil {
+			log.Printf("Could not notify user %d about lapsed verification: %v", m.userID, err)
+		}
+	}
+
+	return int(expired), nil
+}
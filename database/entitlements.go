@@ -0,0 +1,454 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// migrateEntitlements replaces the free-text features of each plan with structured entitlements,
+// and tracks freeze periods and guests so the entitlements can be enforced
+func migrateEntitlements(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE memberships ADD COLUMN entitlements TEXT")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	if err := backfillEntitlements(db); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS membership_freezes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		user_membership_id INTEGER NOT NULL,
+		started_at DATETIME NOT NULL,
+		ended_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (user_membership_id) REFERENCES user_memberships(id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS event_guests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		guest_name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (event_id) REFERENCES events(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	)`)
+	return err
+}
+
+// backfillEntitlements derives entitlements from the feature bullets of plans that have none yet
+func backfillEntitlements(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, COALESCE(features, '[]') FROM memberships WHERE entitlements IS NULL")
+	if err != nil {
+		return err
+	}
+	updates := make(map[int64]models.MembershipEntitlements)
+	for rows.Next() {
+		var id int64
+		var features string
+		if err := rows.Scan(&id, &features); err != nil {
+			rows.Close()
+			return err
+		}
+		var bullets []string
+		if err := json.Unmarshal([]byte(features), &bullets); err != nil {
+			log.Printf("Could not read features of membership %d: %v", id, err)
+		}
+		updates[id] = entitlementsFromFeatures(bullets)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, entitlements := range updates {
+		if _, err := db.Exec("UPDATE memberships SET entitlements = ? WHERE id = ?", formatEntitlements(entitlements), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entitlementsFromFeatures maps the old marketing bullets to entitlements. Every plan could
+// book all classes, stream online classes and request freezing before, so only "Kan fryses"
+// decides freezing and the bullets not describing access are kept as perks.
+func entitlementsFromFeatures(features []string) models.MembershipEntitlements {
+	entitlements := models.MembershipEntitlements{OnlineAccess: true}
+	for _, feature := range features {
+		switch strings.ToLower(strings.TrimSpace(feature)) {
+		case "kan fryses":
+			entitlements.FreezeAllowed = true
+		case "ubegrenset gruppeklasser", "alle gruppeklasser", "tilgang til alle lokasjoner", "online videobibliotek",
+			"ubegrenset tilgang", "2 ukers ubegrenset tilgang", "1 måned ubegrenset tilgang":
+			// Covered by the entitlements above
+		default:
+			entitlements.Perks = append(entitlements.Perks, feature)
+		}
+	}
+	return entitlements
+}
+
+// parseEntitlements reads entitlements stored as JSON. Plans without any can book every class and nothing else.
+func parseEntitlements(value sql.NullString) models.MembershipEntitlements {
+	var entitlements models.MembershipEntitlements
+	if !value.Valid || value.String == "" {
+		return entitlements
+	}
+	if err := json.Unmarshal([]byte(value.String), &entitlements); err != nil {
+		log.Printf("Could not read membership entitlements: %v", err)
+	}
+	return entitlements
+}
+
+// formatEntitlements writes entitlements as JSON for storing
+func formatEntitlements(entitlements models.MembershipEntitlements) string {
+	data, err := json.Marshal(entitlements)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// SetMembershipEntitlements changes what a plan gives access to
+func (db *Database) SetMembershipEntitlements(membershipID int64, entitlements models.MembershipEntitlements) error {
+	if entitlements.WeeklyClassLimit < 0 || entitlements.FreezeDaysPerYear < 0 || entitlements.GuestPassesPerMonth < 0 {
+		return fmt.Errorf("grensene kan ikke være negative")
+	}
+	entitlements.ClassTypes = trimEntitlementValues(entitlements.ClassTypes)
+	entitlements.Locations = trimEntitlementValues(entitlements.Locations)
+	entitlements.Perks = trimEntitlementValues(entitlements.Perks)
+
+	result, err := db.Conn.Exec("UPDATE memberships SET entitlements = ? WHERE id = ?", formatEntitlements(entitlements), membershipID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("fant ikke medlemskapet")
+	}
+	return nil
+}
+
+func trimEntitlementValues(values []string) []string {
+	var trimmed []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return trimmed
+}
+
+// getMemberEntitlements returns the entitlements of a member's current plan, or nil without a membership
+func (db *Database) getMemberEntitlements(userID int64) (*models.MembershipEntitlements, error) {
+	var value sql.NullString
+	err := db.Conn.QueryRow(`
+		SELECT m.entitlements FROM user_memberships um
+		JOIN memberships m ON um.membership_id = m.id
+		WHERE um.user_id = ? AND um.status IN ('active', 'paused', 'freeze_requested')
+		ORDER BY um.created_at DESC
+		LIMIT 1`, userID).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entitlements := parseEntitlements(value)
+	return &entitlements, nil
+}
+
+// weekBounds returns the Monday starting the week of a time and the Monday after, in UTC
+func weekBounds(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	start := time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// bookingEntitlements returns the entitlements of a member's plan when it can book classes, or
+// nil without a membership or while it is frozen
+func (db *Database) bookingEntitlements(userID int64) (*models.MembershipEntitlements, error) {
+	var value sql.NullString
+	err := db.Conn.QueryRow(`
+		SELECT m.entitlements FROM user_memberships um
+		JOIN memberships m ON um.membership_id = m.id
+		WHERE um.user_id = ? AND um.status IN ('active', 'freeze_requested')
+		ORDER BY um.created_at DESC
+		LIMIT 1`, userID).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entitlements := parseEntitlements(value)
+	return &entitlements, nil
+}
+
+// checkEntitlements checks a class signup against what the member's plan includes, e.g. class
+// types, locations and classes per week. Classes paid with klipp do not count towards the week.
+func (db *Database) checkEntitlements(userID int64, entitlements models.MembershipEntitlements, event models.Event, attendanceMode string) error {
+	if !entitlements.AllowsClassType(event.ClassType) {
+		return fmt.Errorf("your membership does not include %s classes", event.ClassType)
+	}
+	if attendanceMode == models.DeliveryOnline {
+		if !entitlements.OnlineAccess {
+			return fmt.Errorf("your membership does not include online classes")
+		}
+	} else if !entitlements.AllowsLocation(event.Location) {
+		return fmt.Errorf("your membership does not include classes at %s", event.Location)
+	}
+
+	if entitlements.WeeklyClassLimit > 0 {
+		weekStart, weekEnd := weekBounds(event.StartTime)
+		var booked int
+		err := db.Conn.QueryRow(`
+			SELECT COUNT(*) FROM event_signups es
+			JOIN events e ON es.event_id = e.id
+			WHERE es.user_id = ? AND es.klippekort_id IS NULL
+			AND datetime(e.start_time) >= datetime(?) AND datetime(e.start_time) < datetime(?)`,
+			userID, weekStart.Format("2006-01-02 15:04:05"), weekEnd.Format("2006-01-02 15:04:05")).Scan(&booked)
+		if err != nil {
+			return err
+		}
+		if booked >= entitlements.WeeklyClassLimit {
+			return fmt.Errorf("you have reached the weekly limit of %d classes for your membership", entitlements.WeeklyClassLimit)
+		}
+	}
+	return nil
+}
+
+// FreezeDaysUsed counts the days a member's memberships have been frozen in a calendar year
+func (db *Database) FreezeDaysUsed(userID int64, year int, now time.Time) (int, error) {
+	rows, err := db.Conn.Query("SELECT started_at, ended_at FROM membership_freezes WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	yearStart := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	yearEnd := yearStart.AddDate(1, 0, 0)
+	days := 0
+	for rows.Next() {
+		var startedAt time.Time
+		var endedAt sql.NullTime
+		if err := rows.Scan(&startedAt, &endedAt); err != nil {
+			return 0, err
+		}
+		end := now
+		if endedAt.Valid {
+			end = endedAt.Time
+		}
+		from, to := startedAt.UTC(), end.UTC()
+		if from.Before(yearStart) {
+			from = yearStart
+		}
+		if to.After(yearEnd) {
+			to = yearEnd
+		}
+		if to.After(from) {
+			days += int(to.Sub(from).Hours() / 24)
+		}
+	}
+	return days, rows.Err()
+}
+
+// RequestMembershipFreeze asks for the member's membership to be frozen, if the plan allows it
+// and the member has freeze days left this year
+func (db *Database) RequestMembershipFreeze(userID int64, now time.Time) error {
+	entitlements, err := db.getMemberEntitlements(userID)
+	if err != nil {
+		return err
+	}
+	if entitlements == nil {
+		return fmt.Errorf("du har ikke et aktivt medlemskap")
+	}
+	if !entitlements.FreezeAllowed {
+		return fmt.Errorf("medlemskapet ditt kan ikke fryses")
+	}
+	if entitlements.FreezeDaysPerYear > 0 {
+		used, err := db.FreezeDaysUsed(userID, now.Year(), now)
+		if err != nil {
+			return err
+		}
+		if used >= entitlements.FreezeDaysPerYear {
+			return fmt.Errorf("du har brukt frysedagene dine i år (%d per år)", entitlements.FreezeDaysPerYear)
+		}
+	}
+
+	result, err := db.Conn.Exec("UPDATE user_memberships SET status = 'freeze_requested' WHERE user_id = ? AND status = 'active'", userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("bare aktive medlemskap kan fryses")
+	}
+	return nil
+}
+
+// startFreeze records that a member's membership was frozen
+func (db *Database) startFreeze(userID int64, now time.Time) error {
+	return writeFreezeStart(db.Conn, userID, now)
+}
+
+// writeFreezeStart is startFreeze with the connection or a transaction
+func writeFreezeStart(w dbWriter, userID int64, now time.Time) error {
+	_, err := w.Exec(`
+		INSERT INTO membership_freezes (user_id, user_membership_id, started_at)
+		SELECT user_id, id, ? FROM user_memberships WHERE user_id = ? AND status = 'paused'
+		AND NOT EXISTS (SELECT 1 FROM membership_freezes WHERE user_id = ? AND ended_at IS NULL)`, now, userID, userID)
+	return err
+}
+
+// endFreeze records that a member's frozen membership is active again
+func (db *Database) endFreeze(userID int64, now time.Time) error {
+	return writeFreezeEnd(db.Conn, userID, now)
+}
+
+// writeFreezeEnd is endFreeze with the connection or a transaction
+func writeFreezeEnd(w dbWriter, userID int64, now time.Time) error {
+	_, err := w.Exec("UPDATE membership_freezes SET ended_at = ? WHERE user_id = ? AND ended_at IS NULL", now, userID)
+	return err
+}
+
+// EndExhaustedFreezes reactivates frozen memberships that have used up the freeze days of their plan this year
+func (db *Database) EndExhaustedFreezes(now time.Time) (int, error) {
+	rows, err := db.Conn.Query(`
+		SELECT um.user_id, m.entitlements FROM user_memberships um
+		JOIN memberships m ON um.membership_id = m.id
+		WHERE um.status = 'paused'`)
+	if err != nil {
+		return 0, err
+	}
+	type frozen struct {
+		userID  int64
+		maxDays int
+	}
+	var candidates []frozen
+	for rows.Next() {
+		var f frozen
+		var value sql.NullString
+		if err := rows.Scan(&f.userID, &value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if f.maxDays = parseEntitlements(value).FreezeDaysPerYear; f.maxDays > 0 {
+			candidates = append(candidates, f)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	ended := 0
+	for _, f := range candidates {
+		used, err := db.FreezeDaysUsed(f.userID, now.Year(), now)
+		if err != nil {
+			return ended, err
+		}
+		if used < f.maxDays {
+			continue
+		}
+		if err := db.UpdateMembershipStatus(f.userID, "active"); err != nil {
+			return ended, err
+		}
+		ended++
+
+		message := fmt.Sprintf("Du har brukt frysedagene dine i år (%d per år), og medlemskapet ditt er aktivt igjen.", f.maxDays)
+		if err := db.CreateNotification(f.userID, "freeze_ended", "Frysingen er avsluttet", message); err != nil {
+			log.Printf("Could not notify user %d about ended freeze: %v", f.userID, err)
+		}
+	}
+	return ended, nil
+}
+
+// GuestPassesUsed counts the guests a member has brought to classes in the month of a time
+func (db *Database) GuestPassesUsed(userID int64, month time.Time) (int, error) {
+	month = month.UTC()
+	monthStart := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var used int
+	err := db.Conn.QueryRow(`
+		SELECT COUNT(*) FROM event_guests g
+		JOIN events e ON g.event_id = e.id
+		WHERE g.user_id = ? AND datetime(e.start_time) >= datetime(?) AND datetime(e.start_time) < datetime(?)`,
+		userID, monthStart.Format("2006-01-02 15:04:05"), monthStart.AddDate(0, 1, 0).Format("2006-01-02 15:04:05")).Scan(&used)
+	return used, err
+}
+
+// BookGuestForEvent brings a guest to a class the member attends in the studio, using one of the plan's guest passes
+func (db *Database) BookGuestForEvent(userID, eventID int64, guestName string) error {
+	guestName = strings.TrimSpace(guestName)
+	if guestName == "" {
+		return fmt.Errorf("oppgi navnet til gjesten")
+	}
+
+	attendanceMode, err := db.GetUserAttendanceMode(userID, eventID)
+	if err == sql.ErrNoRows || (err == nil && attendanceMode == models.DeliveryOnline) {
+		return fmt.Errorf("du må være påmeldt klassen i salen for å ta med en gjest")
+	}
+	if err != nil {
+		return err
+	}
+
+	entitlements, err := db.getMemberEntitlements(userID)
+	if err != nil {
+		return err
+	}
+	if entitlements == nil || entitlements.GuestPassesPerMonth == 0 {
+		return fmt.Errorf("medlemskapet ditt inkluderer ikke gjestepass")
+	}
+
+	var startTime time.Time
+	var currentEnrolment, capacity int
+	var cancelled bool
+	err = db.Conn.QueryRow("SELECT start_time, current_enrolment, capacity, cancelled FROM events WHERE id = ?", eventID).
+		Scan(&startTime, &currentEnrolment, &capacity, &cancelled)
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		return fmt.Errorf("klassen er avlyst")
+	}
+	if currentEnrolment >= capacity {
+		return fmt.Errorf("klassen er full")
+	}
+
+	used, err := db.GuestPassesUsed(userID, startTime)
+	if err != nil {
+		return err
+	}
+	if used >= entitlements.GuestPassesPerMonth {
+		return fmt.Errorf("du har brukt gjestepassene dine denne måneden (%d per måned)", entitlements.GuestPassesPerMonth)
+	}
+
+	if _, err := db.Conn.Exec("INSERT INTO event_guests (event_id, user_id, guest_name) VALUES (?, ?, ?)", eventID, userID, guestName); err != nil {
+		return err
+	}
+	_, err = db.Conn.Exec("UPDATE events SET current_enrolment = current_enrolment + 1 WHERE id = ?", eventID)
+	return err
+}
+
+// cancelGuests removes the guests a member brought to a class and frees their places
+func (db *Database) cancelGuests(userID, eventID int64) error {
+	result, err := db.Conn.Exec("DELETE FROM event_guests WHERE user_id = ? AND event_id = ?", userID, eventID)
+	if err != nil {
+		return err
+	}
+	guests, err := result.RowsAffected()
+	if err != nil || guests == 0 {
+		return err
+	}
+	_, err = db.Conn.Exec("UPDATE events SET current_enrolment = current_enrolment - ? WHERE id = ?", guests, eventID)
+	return err
+}
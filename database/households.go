@@ -0,0 +1,391 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// migrateHouseholds creates households with a single payer, the per-plan household discount
+// and the link from a charge to the member it was paid for
+func migrateHouseholds(db *sql.DB) error {
+	householdsTableSQL := `
+	CREATE TABLE IF NOT EXISTS households (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		payer_user_id INTEGER NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (payer_user_id) REFERENCES users(id)
+	);
+	`
+	if _, err := db.Exec(householdsTableSQL); err != nil {
+		return err
+	}
+
+	householdMembersTableSQL := `
+	CREATE TABLE IF NOT EXISTS household_members (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		household_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		joined_at DATETIME NOT NULL,
+		left_at DATETIME,
+		entitlement_ends_at DATE,
+		entitlement_ended BOOLEAN DEFAULT FALSE,
+		FOREIGN KEY (household_id) REFERENCES households(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	`
+	if _, err := db.Exec(householdMembersTableSQL); err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_household_members_user ON household_members(user_id)"); err != nil {
+		return err
+	}
+
+	householdDiscountsTableSQL := `
+	CREATE TABLE IF NOT EXISTS household_discounts (
+		membership_id INTEGER PRIMARY KEY,
+		percent INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY (membership_id) REFERENCES memberships(id)
+	);
+	`
+	if _, err := db.Exec(householdDiscountsTableSQL); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("ALTER TABLE charges ADD COLUMN beneficiary_user_id INTEGER REFERENCES users(id)")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	return nil
+}
+
+// GetHouseholdForUser returns the household a user pays for or is linked to, or nil
+func (db *Database) GetHouseholdForUser(userID int64) (*models.Household, error) {
+	var householdID int64
+	err := db.Conn.QueryRow(`
+		SELECT id FROM households WHERE payer_user_id = ?
+		UNION
+		SELECT household_id FROM household_members WHERE user_id = ? AND left_at IS NULL
+		LIMIT 1`, userID, userID).Scan(&householdID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.GetHousehold(householdID)
+}
+
+// GetHousehold returns a household with its current members
+func (db *Database) GetHousehold(householdID int64) (*models.Household, error) {
+	var h models.Household
+	err := db.Conn.QueryRow(`
+		SELECT h.id, h.name, h.payer_user_id, u.name, h.created_at
+		FROM households h JOIN users u ON h.payer_user_id = u.id
+		WHERE h.id = ?`, householdID).Scan(&h.ID, &h.Name, &h.PayerUserID, &h.PayerName, &h.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Conn.Query(`
+		SELECT hm.id, hm.household_id, hm.user_id, u.name, u.email, COALESCE(m.name, ''), hm.joined_at, hm.left_at, hm.entitlement_ends_at
+		FROM household_members hm
+		JOIN users u ON hm.user_id = u.id
+		LEFT JOIN user_memberships um ON um.user_id = hm.user_id AND um.status IN ('active', 'paused', 'freeze_requested')
+		LEFT JOIN memberships m ON um.membership_id = m.id
+		WHERE hm.household_id = ? AND (hm.left_at IS NULL OR hm.entitlement_ended = FALSE)
+		ORDER BY hm.left_at IS NOT NULL, hm.joined_at`, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.HouseholdMember
+		var leftAt, entitlementEndsAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.HouseholdID, &m.UserID, &m.Name, &m.Email, &m.MembershipName, &m.JoinedAt, &leftAt, &entitlementEndsAt); err != nil {
+			return nil, err
+		}
+		if leftAt.Valid {
+			m.LeftAt = &leftAt.Time
+		}
+		if entitlementEndsAt.Valid {
+			m.EntitlementEndsAt = &entitlementEndsAt.Time
+		}
+		h.Members = append(h.Members, m)
+	}
+	return &h, rows.Err()
+}
+
+// GetHouseholds returns every household with its members for the admin overview
+func (db *Database) GetHouseholds() ([]models.Household, error) {
+	rows, err := db.Conn.Query("SELECT id FROM households ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var households []models.Household
+	for _, id := range ids {
+		household, err := db.GetHousehold(id)
+		if err != nil {
+			return nil, err
+		}
+		households = append(households, *household)
+	}
+	return households, nil
+}
+
+// CreateHousehold makes a member the payer of a new household
+func (db *Database) CreateHousehold(payerUserID int64, name string) (int64, error) {
+	existing, err := db.GetHouseholdForUser(payerUserID)
+	if err != nil {
+		return 0, err
+	}
+	if existing != nil {
+		return 0, fmt.Errorf("du er allerede med i en husstand")
+	}
+	if strings.TrimSpace(name) == "" {
+		return 0, fmt.Errorf("husstanden må ha et navn")
+	}
+
+	result, err := db.Conn.Exec("INSERT INTO households (name, payer_user_id, created_at) VALUES (?, ?, ?)",
+		strings.TrimSpace(name), payerUserID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// AddHouseholdMember links an existing member to the payer's household by email.
+// From now on the payer is billed for the member's membership and purchases.
+func (db *Database) AddHouseholdMember(payerUserID int64, email string, now time.Time) error {
+	household, err := db.GetHouseholdForUser(payerUserID)
+	if err != nil {
+		return err
+	}
+	if household == nil || !household.IsPayer(int(payerUserID)) {
+		return fmt.Errorf("bare betaleren kan legge til medlemmer i husstanden")
+	}
+
+	linked := 0
+	for _, m := range household.Members {
+		if m.LeftAt == nil {
+			linked++
+		}
+	}
+	if linked >= models.MaxHouseholdMembers {
+		return fmt.Errorf("husstanden kan ha maks %d medlemmer i tillegg til betaleren", models.MaxHouseholdMembers)
+	}
+
+	var userID int64
+	var name string
+	err = db.Conn.QueryRow("SELECT id, name FROM users WHERE email = ? COLLATE NOCASE", strings.TrimSpace(email)).Scan(&userID, &name)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("fant ingen bruker med denne e-postadressen")
+	}
+	if err != nil {
+		return err
+	}
+	if userID == payerUserID {
+		return fmt.Errorf("du er allerede betaler for husstanden")
+	}
+	other, err := db.GetHouseholdForUser(userID)
+	if err != nil {
+		return err
+	}
+	if other != nil {
+		return fmt.Errorf("%s er allerede med i en husstand", name)
+	}
+
+	_, err = db.Conn.Exec("INSERT INTO household_members (household_id, user_id, joined_at) VALUES (?, ?, ?)",
+		household.ID, userID, now)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Du er lagt til i husstanden %s. %s betaler for medlemskapet og kjøpene dine fra nå av.", household.Name, household.PayerName)
+	if err := db.CreateNotification(userID, "household", "Lagt til i husstand", message); err != nil {
+		log.Printf("Could not notify user %d about household: %v", userID, err)
+	}
+	return nil
+}
+
+// RemoveHouseholdMember unlinks a member from a household. The payer has paid for the current
+// period, so the member keeps their membership until the renewal date and it ends there,
+// see EndHouseholdEntitlements. Either the payer or the member themselves can do this.
+func (db *Database) RemoveHouseholdMember(requestedBy, userID int64, now time.Time) (time.Time, error) {
+	household, err := db.GetHouseholdForUser(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if household == nil || household.IsPayer(int(userID)) {
+		return time.Time{}, fmt.Errorf("brukeren er ikke medlem av en husstand")
+	}
+	if requestedBy != userID && !household.IsPayer(int(requestedBy)) {
+		return time.Time{}, fmt.Errorf("bare betaleren kan fjerne medlemmer fra husstanden")
+	}
+
+	entitlementEnds := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	membership, err := db.GetUserMembership(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if membership != nil && membership.RenewalDate.After(entitlementEnds) {
+		entitlementEnds = membership.RenewalDate
+	}
+
+	_, err = db.Conn.Exec(`UPDATE household_members SET left_at = ?, entitlement_ends_at = ?
+		WHERE household_id = ? AND user_id = ? AND left_at IS NULL`,
+		now, entitlementEnds.Format("2006-01-02"), household.ID, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	notify := []int64{userID, int64(household.PayerUserID)}
+	for _, id := range notify {
+		message := fmt.Sprintf("Medlemskapet som betales av husstanden %s varer til %s.", household.Name, entitlementEnds.Format("02.01.2006"))
+		if err := db.CreateNotification(id, "household", "Fjernet fra husstand", message); err != nil {
+			log.Printf("Could not notify user %d about household: %v", id, err)
+		}
+	}
+
+	if !entitlementEnds.After(now) {
+		_, err = db.EndHouseholdEntitlements(now)
+	}
+	return entitlementEnds, err
+}
+
+// EndHouseholdEntitlements ends the memberships of members who left a household once the
+// period their payer paid for is over. Returns the number of memberships ended.
+func (db *Database) EndHouseholdEntitlements(now time.Time) (int, error) {
+	rows, err := db.Conn.Query(`
+		SELECT id, user_id, entitlement_ends_at FROM household_members
+		WHERE left_at IS NOT NULL AND entitlement_ended = FALSE AND entitlement_ends_at <= ?`,
+		now.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+
+	type departure struct {
+		id     int64
+		userID int64
+		endsAt time.Time
+	}
+	var due []departure
+	for rows.Next() {
+		var d departure
+		if err := rows.Scan(&d.id, &d.userID, &d.endsAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	ended := 0
+	for _, d := range due {
+		result, err := db.Conn.Exec(`UPDATE user_memberships SET status = 'expired', end_date = ?
+			WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')`,
+			d.endsAt.Format("2006-01-02"), d.userID)
+		if err != nil {
+			return ended, err
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			if err := db.endMembershipPeriod(d.userID, d.endsAt); err != nil {
+				return ended, err
+			}
+			message := "Medlemskapet som ble betalt av husstanden er avsluttet. Du kan velge et nytt medlemskap når som helst."
+			if err := db.CreateNotification(d.userID, "household", "Medlemskapet er avsluttet", message); err != nil {
+				log.Printf("Could not notify user %d about household: %v", d.userID, err)
+			}
+			ended++
+		}
+		if _, err := db.Conn.Exec("UPDATE household_members SET entitlement_ended = TRUE WHERE id = ?", d.id); err != nil {
+			return ended, err
+		}
+	}
+	return ended, nil
+}
+
+// billingUserFor returns who pays for a member's purchases: the household payer while the
+// member is linked to a household, otherwise the member themselves
+func (db *Database) billingUserFor(userID int64) (int64, error) {
+	var payerID int64
+	err := db.Conn.QueryRow(`
+		SELECT h.payer_user_id FROM household_members hm JOIN households h ON hm.household_id = h.id
+		WHERE hm.user_id = ? AND hm.left_at IS NULL`, userID).Scan(&payerID)
+	if err == sql.ErrNoRows {
+		return userID, nil
+	}
+	return payerID, err
+}
+
+// householdPrice applies the plan's household discount when a linked member's membership is paid by the household
+func (db *Database) householdPrice(userID, membershipID int64, price int) (int, error) {
+	payerID, err := db.billingUserFor(userID)
+	if err != nil || payerID == userID {
+		return price, err
+	}
+
+	var percent int
+	err = db.Conn.QueryRow("SELECT percent FROM household_discounts WHERE membership_id = ?", membershipID).Scan(&percent)
+	if err == sql.ErrNoRows {
+		return price, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return models.HouseholdDiscount(price, percent), nil
+}
+
+// GetHouseholdDiscountRules returns the household discount of every active plan
+func (db *Database) GetHouseholdDiscountRules() ([]models.HouseholdDiscountRule, error) {
+	rows, err := db.Conn.Query(`
+		SELECT m.id, m.name, COALESCE(d.percent, 0)
+		FROM memberships m LEFT JOIN household_discounts d ON d.membership_id = m.id
+		WHERE m.active = TRUE ORDER BY m.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.HouseholdDiscountRule
+	for rows.Next() {
+		var rule models.HouseholdDiscountRule
+		if err := rows.Scan(&rule.MembershipID, &rule.MembershipName, &rule.Percent); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// SetHouseholdDiscount sets the discount linked members get on a plan from their next payment
+func (db *Database) SetHouseholdDiscount(membershipID int64, percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("husstandsrabatten må være mellom 0 og 100 prosent")
+	}
+	_, err := db.Conn.Exec(`INSERT INTO household_discounts (membership_id, percent) VALUES (?, ?)
+		ON CONFLICT(membership_id) DO UPDATE SET percent = excluded.percent`, membershipID, percent)
+	return err
+}
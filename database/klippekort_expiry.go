@@ -0,0 +1,309 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrateKlippekortExpiry creates the klippekort rules and the reminders sent before a card
+// expires, and lets a card remember the klipp it lost when it expired for a later rollover
+func migrateKlippekortExpiry(db *sql.DB) error {
+	tables := []string{
+		`CREATE TABLE IF NOT EXISTS klippekort_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reminder_days TEXT DEFAULT '30,7',
+			rollover_enabled BOOLEAN DEFAULT FALSE,
+			rollover_days INTEGER DEFAULT 30,
+			rollover_max_klipp INTEGER DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS klippekort_reminders (
+			user_klippekort_id INTEGER NOT NULL,
+			days_before INTEGER NOT NULL,
+			sent_at DATETIME NOT NULL,
+			PRIMARY KEY (user_klippekort_id, days_before),
+			FOREIGN KEY (user_klippekort_id) REFERENCES user_klippekort(id)
+		)`,
+	}
+	for _, table := range tables {
+		if _, err := db.Exec(table); err != nil {
+			return err
+		}
+	}
+
+	columns := []string{
+		"ALTER TABLE user_klippekort ADD COLUMN expired_at DATETIME",
+		"ALTER TABLE user_klippekort ADD COLUMN expired_klipp INTEGER DEFAULT 0",
+		"ALTER TABLE user_klippekort ADD COLUMN rolled_over_at DATETIME",
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseReminderDays reads reminder thresholds such as "30,7", largest first
+func parseReminderDays(value string) []int {
+	var days []int
+	for _, part := range strings.Split(value, ",") {
+		if d, err := strconv.Atoi(strings.TrimSpace(part)); err == nil && d > 0 {
+			days = append(days, d)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+	return days
+}
+
+// formatReminderDays stores reminder thresholds as a comma separated list
+func formatReminderDays(days []int) string {
+	parts := make([]string, 0, len(days))
+	for _, d := range days {
+		parts = append(parts, strconv.Itoa(d))
+	}
+	return strings.Join(parts, ",")
+}
+
+// GetKlippekortRules returns the klippekort rules configuration
+func (db *Database) GetKlippekortRules() (*models.KlippekortRules, error) {
+	var rules models.KlippekortRules
+	var reminderDays string
+	err := db.Conn.QueryRow(`SELECT id, reminder_days, rollover_enabled, rollover_days, rollover_max_klipp, updated_at
+		FROM klippekort_rules ORDER BY id DESC LIMIT 1`).Scan(
+		&rules.ID, &reminderDays, &rules.RolloverEnabled, &rules.RolloverDays, &rules.RolloverMaxKlipp, &rules.UpdatedAt)
+	if err == sql.ErrNoRows {
+		// Return default rules if none exist
+		return &models.KlippekortRules{
+			ReminderDays: []int{30, 7},
+			RolloverDays: 30,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rules.ReminderDays = parseReminderDays(reminderDays)
+	return &rules, nil
+}
+
+// SaveKlippekortRules saves or updates the klippekort rules configuration
+func (db *Database) SaveKlippekortRules(rules *models.KlippekortRules) error {
+	if rules.RolloverDays < 0 || rules.RolloverMaxKlipp < 0 {
+		return fmt.Errorf("verdiene kan ikke være negative")
+	}
+	for _, d := range rules.ReminderDays {
+		if d < 1 {
+			return fmt.Errorf("påminnelser må sendes minst én dag før utløp")
+		}
+	}
+
+	existingRules, err := db.GetKlippekortRules()
+	if err != nil {
+		return err
+	}
+
+	reminderDays := formatReminderDays(rules.ReminderDays)
+	if existingRules.ID > 0 {
+		_, err = db.Conn.Exec(`UPDATE klippekort_rules SET
+			reminder_days = ?, rollover_enabled = ?, rollover_days = ?, rollover_max_klipp = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, reminderDays, rules.RolloverEnabled, rules.RolloverDays, rules.RolloverMaxKlipp, existingRules.ID)
+	} else {
+		_, err = db.Conn.Exec(`INSERT INTO klippekort_rules (reminder_days, rollover_enabled, rollover_days, rollover_max_klipp)
+			VALUES (?, ?, ?, ?)`, reminderDays, rules.RolloverEnabled, rules.RolloverDays, rules.RolloverMaxKlipp)
+	}
+	return err
+}
+
+// activeKlippekort is a card still in use, as seen by the expiry jobs
+type activeKlippekort struct {
+	id, userID  int64
+	remaining   int
+	expiryDate  time.Time
+	packageName string
+}
+
+// getActiveKlippekort returns every card that has not been deactivated
+func (db *Database) getActiveKlippekort() ([]activeKlippekort, error) {
+	rows, err := db.Conn.Query(`SELECT uk.id, uk.user_id, uk.remaining_klipp, uk.expiry_date, kp.name
+		FROM user_klippekort uk JOIN klippekort_packages kp ON uk.package_id = kp.id
+		WHERE uk.is_active = TRUE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cards []activeKlippekort
+	for rows.Next() {
+		var c activeKlippekort
+		if err := rows.Scan(&c.id, &c.userID, &c.remaining, &c.expiryDate, &c.packageName); err != nil {
+			return nil, err
+		}
+		cards = append(cards, c)
+	}
+	return cards, rows.Err()
+}
+
+// ExpireKlippekort deactivates klippekort past their expiry date, writes the unused klipp off
+// in the ledger and tells the member. Returns the number of cards expired.
+func (db *Database) ExpireKlippekort(now time.Time) (int, error) {
+	rules, err := db.GetKlippekortRules()
+	if err != nil {
+		return 0, err
+	}
+	cards, err := db.getActiveKlippekort()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, c := range cards {
+		if c.expiryDate.After(now) {
+			continue
+		}
+
+		if c.remaining > 0 {
+			if _, err := db.addKlippMovement(c.id, models.KlippExpired, -c.remaining, 0, 0, "", now); err != nil {
+				return expired, err
+			}
+		}
+		_, err := db.Conn.Exec("UPDATE user_klippekort SET is_active = FALSE, expired_at = ?, expired_klipp = ? WHERE id = ?",
+			now, c.remaining, c.id)
+		if err != nil {
+			return expired, err
+		}
+		expired++
+
+		if c.remaining == 0 {
+			continue
+		}
+		message := fmt.Sprintf("%s utløp %s med %d ubrukte klipp.", c.packageName, c.expiryDate.Format("02.01.2006"), c.remaining)
+		if rules.RolloverEnabled {
+			message += fmt.Sprintf(" Kjøper du et nytt klippekort av samme type innen %d dager, blir de overført.", rules.RolloverDays)
+		}
+		if err := db.CreateNotification(c.userID, "klippekort_expired", "Klippekortet ditt er utløpt", message); err != nil {
+			log.Printf("Could not notify user %d about expired klippekort: %v", c.userID, err)
+		}
+	}
+	return expired, nil
+}
+
+// SendKlippekortReminders reminds members with klipp left that their card expires soon, once
+// for each threshold in the klippekort rules. Returns the number of reminders sent.
+func (db *Database) SendKlippekortReminders(now time.Time) (int, error) {
+	rules, err := db.GetKlippekortRules()
+	if err != nil {
+		return 0, err
+	}
+	if len(rules.ReminderDays) == 0 {
+		return 0, nil
+	}
+	cards, err := db.getActiveKlippekort()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, c := range cards {
+		daysLeft := int(math.Ceil(c.expiryDate.Sub(now).Hours() / 24))
+		if c.remaining == 0 || daysLeft <= 0 {
+			continue
+		}
+
+		// Thresholds passed while the job was not running are covered by one reminder
+		var due []int
+		for _, d := range rules.ReminderDays {
+			if daysLeft > d {
+				continue
+			}
+			var alreadySent int
+			err := db.Conn.QueryRow("SELECT COUNT(*) FROM klippekort_reminders WHERE user_klippekort_id = ? AND days_before = ?",
+				c.id, d).Scan(&alreadySent)
+			if err != nil {
+				return sent, err
+			}
+			if alreadySent == 0 {
+				due = append(due, d)
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		for _, d := range due {
+			_, err := db.Conn.Exec("INSERT INTO klippekort_reminders (user_klippekort_id, days_before, sent_at) VALUES (?, ?, ?)", c.id, d, now)
+			if err != nil {
+				return sent, err
+			}
+		}
+		message := fmt.Sprintf("%s utløper %s. Du har %d klipp igjen.", c.packageName, c.expiryDate.Format("02.01.2006"), c.remaining)
+		if err := db.CreateNotification(c.userID, "klippekort_expiry", "Klippekortet ditt utløper snart", message); err != nil {
+			log.Printf("Could not remind user %d about klippekort expiry: %v", c.userID, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// rolloverExpiredKlipp moves the klipp lost on the member's recently expired cards of a
+// category to a newly bought card, when the klippekort rules allow it
+func (db *Database) rolloverExpiredKlipp(userID, klippekortID int64, category string, now time.Time) error {
+	rules, err := db.GetKlippekortRules()
+	if err != nil || !rules.RolloverEnabled {
+		return err
+	}
+
+	rows, err := db.Conn.Query(`SELECT uk.id, uk.expired_klipp, uk.expired_at
+		FROM user_klippekort uk JOIN klippekort_packages kp ON uk.package_id = kp.id
+		WHERE uk.user_id = ? AND kp.category = ? AND uk.id != ? AND uk.expired_at IS NOT NULL
+		AND uk.rolled_over_at IS NULL AND uk.expired_klipp > 0`, userID, category, klippekortID)
+	if err != nil {
+		return err
+	}
+	var cardIDs []int64
+	klipp := 0
+	for rows.Next() {
+		var id int64
+		var lost int
+		var expiredAt time.Time
+		if err := rows.Scan(&id, &lost, &expiredAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if now.Sub(expiredAt) > time.Duration(rules.RolloverDays)*24*time.Hour {
+			continue
+		}
+		cardIDs = append(cardIDs, id)
+		klipp += lost
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if klipp == 0 {
+		return nil
+	}
+	if rules.RolloverMaxKlipp > 0 && klipp > rules.RolloverMaxKlipp {
+		klipp = rules.RolloverMaxKlipp
+	}
+
+	if _, err := db.addKlippMovement(klippekortID, models.KlippRollover, klipp, 0, 0, "Overført fra utløpt klippekort", now); err != nil {
+		return err
+	}
+	if _, err := db.Conn.Exec("UPDATE user_klippekort SET total_klipp = total_klipp + ? WHERE id = ?", klipp, klippekortID); err != nil {
+		return err
+	}
+	for _, id := range cardIDs {
+		if _, err := db.Conn.Exec("UPDATE user_klippekort SET rolled_over_at = ? WHERE id = ?", now, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"time"
+)
+
+// migrateKlippekortLedger creates the append-only ledger of klipp movements. A card's
+// remaining_klipp is kept equal to the sum of its movements. Signups remember which card
+// they used so a cancellation can give the klipp back.
+func migrateKlippekortLedger(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS klippekort_ledger (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_klippekort_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		klipp INTEGER NOT NULL,
+		balance INTEGER NOT NULL,
+		event_id INTEGER,
+		package_id INTEGER,
+		description TEXT DEFAULT '',
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (user_klippekort_id) REFERENCES user_klippekort(id),
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (event_id) REFERENCES events(id),
+		FOREIGN KEY (package_id) REFERENCES klippekort_packages(id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE event_signups ADD COLUMN klippekort_id INTEGER REFERENCES user_klippekort(id)")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+
+	// Classes take klipp from cards of their category. Classes in the Reformer Studio take
+	// Reformer/Apparatus klipp, the rest group class klipp.
+	_, err = db.Exec("ALTER TABLE events ADD COLUMN klippekort_category TEXT DEFAULT '" + defaultKlippekortCategory + "'")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	if err == nil {
+		_, err = db.Exec("UPDATE events SET klippekort_category = 'Reformer/Apparatus' WHERE location = 'Reformer Studio'")
+		if err != nil {
+			return err
+		}
+	}
+	return openKlippekortLedgers(db)
+}
+
+// defaultKlippekortCategory is the klippekort category of classes created without one
+const defaultKlippekortCategory = "Gruppetimer Sal"
+
+// klippekortCategoryOrDefault treats an empty klippekort category as a group class
+func klippekortCategoryOrDefault(category string) string {
+	if category == "" {
+		return defaultKlippekortCategory
+	}
+	return category
+}
+
+// openKlippekortLedgers starts the ledger of klippekort that have none: the klipp the card
+// was bought with, and the klipp used before the ledger existed as one adjustment
+func openKlippekortLedgers(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, user_id, package_id, total_klipp, remaining_klipp, purchase_date FROM user_klippekort uk
+		WHERE NOT EXISTS (SELECT 1 FROM klippekort_ledger l WHERE l.user_klippekort_id = uk.id)`)
+	if err != nil {
+		return err
+	}
+	type unopened struct {
+		id, userID, packageID int64
+		total, remaining      int
+		purchased             time.Time
+	}
+	var cards []unopened
+	for rows.Next() {
+		var c unopened
+		if err := rows.Scan(&c.id, &c.userID, &c.packageID, &c.total, &c.remaining, &c.purchased); err != nil {
+			rows.Close()
+			return err
+		}
+		cards = append(cards, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	insert := `INSERT INTO klippekort_ledger (user_klippekort_id, user_id, kind, klipp, balance, package_id, description, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, c := range cards {
+		if _, err := db.Exec(insert, c.id, c.userID, models.KlippPurchase, c.total, c.total, c.packageID, "", c.purchased); err != nil {
+			return err
+		}
+		if c.remaining != c.total {
+			_, err := db.Exec(insert, c.id, c.userID, models.KlippAdjustment, c.remaining-c.total, c.remaining, nil,
+				"Brukt før klippeloggen ble innført", time.Now())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OpenKlippekortLedgers starts the ledger of klippekort created outside the ledger, e.g. by test data
+func (db *Database) OpenKlippekortLedgers() error {
+	return openKlippekortLedgers(db.Conn)
+}
+
+// addKlippMovement appends a movement to a klippekort's ledger and sets the card's remaining
+// klipp to the new balance. Event and package IDs of 0 are left empty. Returns the balance.
+func (db *Database) addKlippMovement(klippekortID int64, kind string, klipp int, eventID, packageID int64, description string, now time.Time) (int, error) {
+	return db.addKlippMovementFor(klippekortID, 0, kind, klipp, eventID, packageID, description, now)
+}
+
+// addKlippMovementFor is addKlippMovement for a klipp used or given back by a member. When the
+// member is not the card's owner, e.g. on a shared card, they are recorded with the movement.
+func (db *Database) addKlippMovementFor(klippekortID, memberID int64, kind string, klipp int, eventID, packageID int64, description string, now time.Time) (int, error) {
+	return writeKlippMovement(db.Conn, klippekortID, memberID, kind, klipp, eventID, packageID, description, now)
+}
+
+// writeKlippMovement writes a movement with addKlippMovementFor's rules, so it can be part of
+// a transaction such as a class signup
+func writeKlippMovement(w dbWriter, klippekortID, memberID int64, kind string, klipp int, eventID, packageID int64, description string, now time.Time) (int, error) {
+	var userID int64
+	var balance int
+	err := w.QueryRow(`SELECT uk.user_id, COALESCE(SUM(l.klipp), 0) FROM user_klippekort uk
+		LEFT JOIN klippekort_ledger l ON l.user_klippekort_id = uk.id
+		WHERE uk.id = ? GROUP BY uk.id`, klippekortID).Scan(&userID, &balance)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("klippekort ikke funnet")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	balance += klipp
+	if balance < 0 {
+		return 0, fmt.Errorf("ikke nok klipp igjen på klippekortet")
+	}
+
+	var event, pkg, usedBy interface{}
+	if eventID != 0 {
+		event = eventID
+	}
+	if packageID != 0 {
+		pkg = packageID
+	}
+	if memberID != 0 && memberID != userID {
+		usedBy = memberID
+	}
+	_, err = w.Exec(`INSERT INTO klippekort_ledger (user_klippekort_id, user_id, kind, klipp, balance, event_id, package_id, description, created_at, used_by_user_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, klippekortID, userID, kind, klipp, balance, event, pkg, description, now, usedBy)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.Exec("UPDATE user_klippekort SET remaining_klipp = ? WHERE id = ?", balance, klippekortID)
+	return balance, err
+}
+
+// SetKlippekortBalance moves a klippekort to the given balance with an adjustment in its ledger
+func (db *Database) SetKlippekortBalance(klippekortID int64, remaining int, description string, now time.Time) error {
+	return writeKlippekortBalance(db.Conn, klippekortID, remaining, description, now)
+}
+
+// writeKlippekortBalance is SetKlippekortBalance with the connection or a transaction
+func writeKlippekortBalance(w dbWriter, klippekortID int64, remaining int, description string, now time.Time) error {
+	var current int
+	err := w.QueryRow("SELECT COALESCE(SUM(klipp), 0) FROM klippekort_ledger WHERE user_klippekort_id = ?", klippekortID).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if remaining == current {
+		return nil
+	}
+	_, err = writeKlippMovement(w, klippekortID, 0, models.KlippAdjustment, remaining-current, 0, 0, description, now)
+	return err
+}
+
+// klippekortForSignup returns the card a class signup draws its klipp from when the member's plan
+// does not cover the class, or they have no membership or it is frozen: their own card in the
+// class's category that expires first, or when they have none in that category a card of it
+// shared with them. Returns 0 when the plan covers the class, and an error when there is no klipp to use.
+func (db *Database) klippekortForSignup(userID int64, event models.Event, attendanceMode string) (int64, error) {
+	entitlements, err := db.bookingEntitlements(userID)
+	if err != nil {
+		return 0, err
+	}
+	var planErr error
+	if entitlements != nil {
+		if planErr = db.checkEntitlements(userID, *entitlements, event, attendanceMode); planErr == nil {
+			return 0, nil
+		}
+	}
+
+	category := klippekortCategoryOrDefault(event.KlippekortCategory)
+	var klippekortID int64
+	err = db.Conn.QueryRow(`SELECT uk.id FROM user_klippekort uk
+		JOIN klippekort_packages kp ON uk.package_id = kp.id
+		WHERE uk.user_id = ? AND kp.category = ? AND uk.is_active = TRUE AND uk.remaining_klipp > 0 AND uk.expiry_date > datetime('now')
+		ORDER BY uk.expiry_date ASC LIMIT 1`, userID, category).Scan(&klippekortID)
+	if err == sql.ErrNoRows {
+		klippekortID, err = db.sharedKlippekortFor(userID, category)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if klippekortID == 0 && planErr != nil {
+		return 0, planErr
+	}
+	if klippekortID == 0 {
+		return 0, fmt.Errorf("you need a membership or klipp left on a %s klippekort to sign up", category)
+	}
+	return klippekortID, nil
+}
+
+// signupKlippekort returns the klippekort a signup drew its klipp from, or 0
+func (db *Database) signupKlippekort(userID, eventID int64) (int64, error) {
+	var klippekortID sql.NullInt64
+	err := db.Conn.QueryRow("SELECT klippekort_id FROM event_signups WHERE user_id = ? AND event_id = ?", userID, eventID).Scan(&klippekortID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return klippekortID.Int64, err
+}
+
+// refundEventKlipp gives back the klipp used for signups to a cancelled event
+func (db *Database) refundEventKlipp(eventID int64, now time.Time) error {
+	rows, err := db.Conn.Query("SELECT user_id, klippekort_id FROM event_signups WHERE event_id = ? AND klippekort_id IS NOT NULL", eventID)
+	if err != nil {
+		return err
+	}
+	type used struct{ userID, klippekortID int64 }
+	var signups []used
+	for rows.Next() {
+		var u used
+		if err := rows.Scan(&u.userID, &u.klippekortID); err != nil {
+			rows.Close()
+			return err
+		}
+		signups = append(signups, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, u := range signups {
+		if _, err := db.addKlippMovementFor(u.klippekortID, u.userID, models.KlippRefund, 1, eventID, 0, "Timen ble avlyst", now); err != nil {
+			return err
+		}
+		_, err := db.Conn.Exec("UPDATE event_signups SET klippekort_id = NULL WHERE user_id = ? AND event_id = ?", u.userID, eventID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetKlippHistory returns the movements on all of a member's klippekort, and the klipp they
+// used from cards shared with them, newest first
+func (db *Database) GetKlippHistory(userID int64) ([]models.KlippMovement, error) {
+	rows, err := db.Conn.Query(`
+		SELECT l.id, l.user_klippekort_id, l.user_id, l.kind, l.klipp, l.balance, l.event_id, e.title,
+		       COALESCE(l.description, ''), COALESCE(p.name, cp.name), l.created_at, l.used_by_user_id, u.name
+		FROM klippekort_ledger l
+		JOIN user_klippekort uk ON l.user_klippekort_id = uk.id
+		JOIN klippekort_packages cp ON uk.package_id = cp.id
+		LEFT JOIN klippekort_packages p ON l.package_id = p.id
+		LEFT JOIN events e ON l.event_id = e.id
+		LEFT JOIN users u ON l.used_by_user_id = u.id
+		WHERE l.user_id = ? OR l.used_by_user_id = ?
+		ORDER BY l.created_at DESC, l.id DESC`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.KlippMovement
+	for rows.Next() {
+		var m models.KlippMovement
+		var eventID, usedBy sql.NullInt64
+		var eventTitle, usedByName sql.NullString
+		if err := rows.Scan(&m.ID, &m.KlippekortID, &m.UserID, &m.Kind, &m.Klipp, &m.Balance, &eventID, &eventTitle,
+			&m.Description, &m.PackageName, &m.CreatedAt, &usedBy, &usedByName); err != nil {
+			return nil, err
+		}
+		if usedBy.Valid {
+			id := int(usedBy.Int64)
+			m.UsedByUserID = &id
+			m.UsedByName = &usedByName.String
+		}
+		if eventID.Valid {
+			id := int(eventID.Int64)
+			m.EventID = &id
+		}
+		if eventTitle.Valid {
+			m.EventTitle = &eventTitle.String
+		}
+		history = append(history, m)
+	}
+	return history, rows.Err()
+}
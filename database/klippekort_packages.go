@@ -0,0 +1,217 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"strings"
+)
+
+// migrateKlippekortPackages lets packages be ordered within their category and adds the
+// per-category policy for topping up klippekort
+func migrateKlippekortPackages(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE klippekort_packages ADD COLUMN sort_order INTEGER DEFAULT 0")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS klippekort_category_policies (
+		category TEXT PRIMARY KEY,
+		max_klipp INTEGER DEFAULT 20,
+		top_up_mode TEXT DEFAULT 'merge',
+		expiry_mode TEXT DEFAULT 'longest',
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// scanKlippekortPackages reads packages selected with all their columns
+func scanKlippekortPackages(rows *sql.Rows) ([]models.KlippekortPackage, error) {
+	defer rows.Close()
+
+	var packages []models.KlippekortPackage
+	for rows.Next() {
+		var p models.KlippekortPackage
+		var description sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &p.Category, &p.KlippCount, &p.Price, &p.PricePerSession, &description,
+			&p.ValidDays, &p.Active, &p.IsPopular, &p.SortOrder); err != nil {
+			return nil, err
+		}
+		p.Description = description.String
+		packages = append(packages, p)
+	}
+	return packages, rows.Err()
+}
+
+// GetKlippekortPackagesForAdmin returns every klippekort package, including retired ones,
+// in the order they are shown on the purchase page
+func (db *Database) GetKlippekortPackagesForAdmin() ([]models.KlippekortPackage, error) {
+	rows, err := db.Conn.Query(`SELECT id, name, category, klipp_count, price, price_per_session, description, valid_days, active, is_popular, sort_order
+		FROM klippekort_packages ORDER BY category, sort_order, price`)
+	if err != nil {
+		return nil, err
+	}
+	return scanKlippekortPackages(rows)
+}
+
+// SaveKlippekortPackage creates a klippekort package, or updates it when it has an ID. The
+// price per session follows from the price and number of klipp. New packages are put last
+// in their category.
+func (db *Database) SaveKlippekortPackage(pkg models.KlippekortPackage) (int64, error) {
+	pkg.Name = strings.TrimSpace(pkg.Name)
+	pkg.Category = strings.TrimSpace(pkg.Category)
+	if pkg.Name == "" || pkg.Category == "" {
+		return 0, fmt.Errorf("pakken må ha et navn og en kategori")
+	}
+	if pkg.KlippCount <= 0 {
+		return 0, fmt.Errorf("pakken må ha minst ett klipp")
+	}
+	if pkg.Price < 0 {
+		return 0, fmt.Errorf("prisen kan ikke være negativ")
+	}
+	if pkg.ValidDays <= 0 {
+		return 0, fmt.Errorf("pakken må være gyldig i minst én dag")
+	}
+	pkg.PricePerSession = pkg.Price / pkg.KlippCount
+
+	if pkg.ID > 0 {
+		result, err := db.Conn.Exec(`UPDATE klippekort_packages SET name = ?, category = ?, klipp_count = ?, price = ?,
+			price_per_session = ?, description = ?, valid_days = ?, active = ?, is_popular = ? WHERE id = ?`,
+			pkg.Name, pkg.Category, pkg.KlippCount, pkg.Price, pkg.PricePerSession, pkg.Description, pkg.ValidDays,
+			pkg.Active, pkg.IsPopular, pkg.ID)
+		if err != nil {
+			return 0, err
+		}
+		if updated, _ := result.RowsAffected(); updated == 0 {
+			return 0, fmt.Errorf("klippekort-pakke ikke funnet")
+		}
+		return int64(pkg.ID), nil
+	}
+
+	result, err := db.Conn.Exec(`INSERT INTO klippekort_packages
+		(name, category, klipp_count, price, price_per_session, description, valid_days, active, is_popular, sort_order)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(sort_order), 0) + 1 FROM klippekort_packages WHERE category = ?))`,
+		pkg.Name, pkg.Category, pkg.KlippCount, pkg.Price, pkg.PricePerSession, pkg.Description, pkg.ValidDays,
+		pkg.Active, pkg.IsPopular, pkg.Category)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// RetireKlippekortPackage takes a package off sale. Klippekort already bought from it keep working.
+func (db *Database) RetireKlippekortPackage(packageID int64) error {
+	result, err := db.Conn.Exec("UPDATE klippekort_packages SET active = FALSE WHERE id = ?", packageID)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return fmt.Errorf("klippekort-pakke ikke funnet")
+	}
+	return nil
+}
+
+// ReorderKlippekortPackages sets the order of a category's packages on the purchase page
+func (db *Database) ReorderKlippekortPackages(category string, packageIDs []int64) error {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range packageIDs {
+		result, err := tx.Exec("UPDATE klippekort_packages SET sort_order = ? WHERE id = ? AND category = ?", i+1, id, category)
+		if err != nil {
+			return err
+		}
+		if updated, _ := result.RowsAffected(); updated == 0 {
+			return fmt.Errorf("pakke %d finnes ikke i kategorien %s", id, category)
+		}
+	}
+	return tx.Commit()
+}
+
+// defaultKlippekortCategoryPolicy is used for categories without a saved policy, and matches
+// how top-ups worked before the policy could be changed
+func defaultKlippekortCategoryPolicy(category string) *models.KlippekortCategoryPolicy {
+	return &models.KlippekortCategoryPolicy{
+		Category:   category,
+		MaxKlipp:   20,
+		TopUpMode:  models.KlippekortTopUpMerge,
+		ExpiryMode: models.KlippekortExpiryLongest,
+	}
+}
+
+// GetKlippekortCategoryPolicy returns the top-up policy of a klippekort category
+func (db *Database) GetKlippekortCategoryPolicy(category string) (*models.KlippekortCategoryPolicy, error) {
+	policy := models.KlippekortCategoryPolicy{Category: category}
+	err := db.Conn.QueryRow(`SELECT max_klipp, top_up_mode, expiry_mode, updated_at
+		FROM klippekort_category_policies WHERE category = ?`, category).Scan(
+		&policy.MaxKlipp, &policy.TopUpMode, &policy.ExpiryMode, &policy.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return defaultKlippekortCategoryPolicy(category), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// GetKlippekortCategoryPolicies returns the top-up policy of every category that has packages
+func (db *Database) GetKlippekortCategoryPolicies() ([]models.KlippekortCategoryPolicy, error) {
+	rows, err := db.Conn.Query("SELECT DISTINCT category FROM klippekort_packages ORDER BY category")
+	if err != nil {
+		return nil, err
+	}
+	var categories []string
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var policies []models.KlippekortCategoryPolicy
+	for _, category := range categories {
+		policy, err := db.GetKlippekortCategoryPolicy(category)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *policy)
+	}
+	return policies, nil
+}
+
+// SaveKlippekortCategoryPolicy saves the top-up policy of a klippekort category
+func (db *Database) SaveKlippekortCategoryPolicy(policy models.KlippekortCategoryPolicy) error {
+	policy.Category = strings.TrimSpace(policy.Category)
+	if policy.Category == "" {
+		return fmt.Errorf("kategori mangler")
+	}
+	if policy.MaxKlipp < 0 {
+		return fmt.Errorf("maks antall klipp kan ikke være negativt")
+	}
+	switch policy.TopUpMode {
+	case models.KlippekortTopUpMerge, models.KlippekortTopUpNewCard:
+	default:
+		return fmt.Errorf("ugyldig påfyllingsvalg: %s", policy.TopUpMode)
+	}
+	switch policy.ExpiryMode {
+	case models.KlippekortExpiryLongest, models.KlippekortExpiryExtend, models.KlippekortExpiryKeep:
+	default:
+		return fmt.Errorf("ugyldig valg for utløpsdato: %s", policy.ExpiryMode)
+	}
+
+	_, err := db.Conn.Exec(`INSERT INTO klippekort_category_policies (category, max_klipp, top_up_mode, expiry_mode, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(category) DO UPDATE SET max_klipp = excluded.max_klipp, top_up_mode = excluded.top_up_mode,
+			expiry_mode = excluded.expiry_mode, updated_at = excluded.updated_at`,
+		policy.Category, policy.MaxKlipp, policy.TopUpMode, policy.ExpiryMode)
+	return err
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// migrateKlippekortShares lets klippekort owners share a card with other members, and records
+// in the ledger which member used a klipp from a shared card
+func migrateKlippekortShares(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS klippekort_shares (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_klippekort_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		FOREIGN KEY (user_klippekort_id) REFERENCES user_klippekort(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE klippekort_ledger ADD COLUMN used_by_user_id INTEGER REFERENCES users(id)")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	return nil
+}
+
+// ShareKlippekort lets the member with the given email draw klipp from one of the owner's cards
+func (db *Database) ShareKlippekort(ownerID, klippekortID int64, email string, now time.Time) error {
+	var packageName string
+	err := db.Conn.QueryRow(`SELECT kp.name FROM user_klippekort uk JOIN klippekort_packages kp ON uk.package_id = kp.id
+		WHERE uk.id = ? AND uk.user_id = ? AND uk.is_active = TRUE`, klippekortID, ownerID).Scan(&packageName)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("klippekort ikke funnet")
+	}
+	if err != nil {
+		return err
+	}
+
+	var userID int64
+	var name string
+	err = db.Conn.QueryRow("SELECT id, name FROM users WHERE email = ? COLLATE NOCASE", strings.TrimSpace(email)).Scan(&userID, &name)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("fant ingen bruker med denne e-postadressen")
+	}
+	if err != nil {
+		return err
+	}
+	if userID == ownerID {
+		return fmt.Errorf("du kan ikke dele klippekortet med deg selv")
+	}
+
+	var shared int
+	err = db.Conn.QueryRow("SELECT COUNT(*) FROM klippekort_shares WHERE user_klippekort_id = ? AND user_id = ? AND revoked_at IS NULL",
+		klippekortID, userID).Scan(&shared)
+	if err != nil {
+		return err
+	}
+	if shared > 0 {
+		return fmt.Errorf("klippekortet er allerede delt med %s", name)
+	}
+
+	_, err = db.Conn.Exec("INSERT INTO klippekort_shares (user_klippekort_id, user_id, created_at) VALUES (?, ?, ?)",
+		klippekortID, userID, now)
+	if err != nil {
+		return err
+	}
+
+	var ownerName string
+	if err := db.Conn.QueryRow("SELECT name FROM users WHERE id = ?", ownerID).Scan(&ownerName); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("%s har delt klippekortet %s med deg. Du bruker klipp fra det når du ikke har egne klipp igjen.", ownerName, packageName)
+	if err := db.CreateNotification(userID, "klippekort_shared", "Et klippekort er delt med deg", message); err != nil {
+		log.Printf("Could not notify user %d about shared klippekort: %v", userID, err)
+	}
+	return nil
+}
+
+// RevokeKlippekortShare stops a member from drawing klipp from one of the owner's cards.
+// Classes they already booked keep their klipp.
+func (db *Database) RevokeKlippekortShare(ownerID, shareID int64, now time.Time) error {
+	result, err := db.Conn.Exec(`UPDATE klippekort_shares SET revoked_at = ?
+		WHERE id = ? AND revoked_at IS NULL
+		AND user_klippekort_id IN (SELECT id FROM user_klippekort WHERE user_id = ?)`, now, shareID, ownerID)
+	if err != nil {
+		return err
+	}
+	if revoked, _ := result.RowsAffected(); revoked == 0 {
+		return fmt.Errorf("delingen finnes ikke")
+	}
+	return nil
+}
+
+// GetKlippekortShares returns who the owner's klippekort are shared with
+func (db *Database) GetKlippekortShares(ownerID int64) ([]models.KlippekortShare, error) {
+	rows, err := db.Conn.Query(`SELECT s.id, s.user_klippekort_id, s.user_id, u.name, u.email, s.created_at
+		FROM klippekort_shares s
+		JOIN user_klippekort uk ON s.user_klippekort_id = uk.id
+		JOIN users u ON s.user_id = u.id
+		WHERE uk.user_id = ? AND s.revoked_at IS NULL
+		ORDER BY s.created_at`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []models.KlippekortShare
+	for rows.Next() {
+		var s models.KlippekortShare
+		if err := rows.Scan(&s.ID, &s.KlippekortID, &s.UserID, &s.Name, &s.Email, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// GetSharedKlippekort returns the active klippekort other members have shared with the user
+func (db *Database) GetSharedKlippekort(userID int64) ([]models.SharedKlippekort, error) {
+	rows, err := db.Conn.Query(`
+		SELECT uk.id, uk.user_id, uk.package_id, uk.total_klipp, uk.remaining_klipp, uk.expiry_date, uk.purchase_date, uk.is_active,
+		       kp.name, kp.category, owner.name
+		FROM klippekort_shares s
+		JOIN user_klippekort uk ON s.user_klippekort_id = uk.id
+		JOIN klippekort_packages kp ON uk.package_id = kp.id
+		JOIN users owner ON uk.user_id = owner.id
+		WHERE s.user_id = ? AND s.revoked_at IS NULL AND uk.is_active = TRUE AND uk.expiry_date > datetime('now')
+		ORDER BY uk.expiry_date ASC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cards []models.SharedKlippekort
+	for rows.Next() {
+		var k models.SharedKlippekort
+		if err := rows.Scan(&k.UserKlippekort.ID, &k.UserKlippekort.UserID, &k.UserKlippekort.PackageID,
+			&k.TotalKlipp, &k.RemainingKlipp, &k.ExpiryDate, &k.PurchaseDate, &k.UserKlippekort.IsActive,
+			&k.Name, &k.Category, &k.OwnerName); err != nil {
+			return nil, err
+		}
+		cards = append(cards, k)
+	}
+	return cards, rows.Err()
+}
+
+// sharedKlippekortFor returns the card of a category shared with the member with klipp left
+// that expires first, or 0
+func (db *Database) sharedKlippekortFor(userID int64, category string) (int64, error) {
+	var klippekortID int64
+	err := db.Conn.QueryRow(`SELECT uk.id FROM klippekort_shares s
+		JOIN user_klippekort uk ON s.user_klippekort_id = uk.id
+		JOIN klippekort_packages kp ON uk.package_id = kp.id
+		WHERE s.user_id = ? AND s.revoked_at IS NULL AND kp.category = ?
+		AND uk.is_active = TRUE AND uk.remaining_klipp > 0 AND uk.expiry_date > datetime('now')
+		ORDER BY uk.expiry_date ASC LIMIT 1`, userID, category).Scan(&klippekortID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return klippekortID, err
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"time"
+)
+
+// migrateLivestream adds delivery mode, online capacity and stream columns to events and signups
+func migrateLivestream(db *sql.DB) error {
+	columns := []string{
+		"ALTER TABLE events ADD COLUMN delivery_mode TEXT DEFAULT 'in_person'",
+		"ALTER TABLE events ADD COLUMN online_capacity INTEGER DEFAULT 0",
+		"ALTER TABLE events ADD COLUMN online_enrolment INTEGER DEFAULT 0",
+		"ALTER TABLE events ADD COLUMN stream_url TEXT DEFAULT ''",
+		"ALTER TABLE event_signups ADD COLUMN attendance_mode TEXT DEFAULT 'in_person'",
+		"ALTER TABLE event_signups ADD COLUMN online_joined_at DATETIME",
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliveryModeOrDefault treats an empty delivery mode as an in-person class
+func deliveryModeOrDefault(deliveryMode string) string {
+	if deliveryMode == "" {
+		return models.DeliveryInPerson
+	}
+	return deliveryMode
+}
+
+// resolveAttendanceMode checks that the requested attendance mode is offered by the class.
+// An empty attendance mode picks online for online-only classes and in the studio otherwise.
+func resolveAttendanceMode(deliveryMode, attendanceMode string) (string, error) {
+	deliveryMode = deliveryModeOrDefault(deliveryMode)
+
+	if attendanceMode == "" {
+		if deliveryMode == models.DeliveryOnline {
+			return models.DeliveryOnline, nil
+		}
+		return models.DeliveryInPerson, nil
+	}
+
+	switch attendanceMode {
+	case models.DeliveryInPerson:
+		if deliveryMode == models.DeliveryOnline {
+			return "", fmt.Errorf("event is online only")
+		}
+	case models.DeliveryOnline:
+		if deliveryMode == models.DeliveryInPerson {
+			return "", fmt.Errorf("event is not streamed")
+		}
+	default:
+		return "", fmt.Errorf("invalid attendance mode")
+	}
+	return attendanceMode, nil
+}
+
+// GetUserAttendanceMode returns how the user attends an event (in_person or online).
+// Returns sql.ErrNoRows if the user is not signed up.
+func (db *Database) GetUserAttendanceMode(userID, eventID int64) (string, error) {
+	var attendanceMode string
+	err := db.Conn.QueryRow(
+		"SELECT attendance_mode FROM event_signups WHERE user_id = ? AND event_id = ?",
+		userID, eventID,
+	).Scan(&attendanceMode)
+	return attendanceMode, err
+}
+
+// GetEventStreamURL returns the stream URL of an event. Only reveal it to signed-up online members.
+func (db *Database) GetEventStreamURL(eventID int64) (string, error) {
+	var streamURL string
+	err := db.Conn.QueryRow("SELECT stream_url FROM events WHERE id = ?", eventID).Scan(&streamURL)
+	return streamURL, err
+}
+
+// RecordOnlineAttendance marks an online signup as attended the first time the member joins the stream
+func (db *Database) RecordOnlineAttendance(userID, eventID int64) error {
+	_, err := db.Conn.Exec(
+		"UPDATE event_signups SET online_joined_at = ? WHERE user_id = ? AND event_id = ? AND online_joined_at IS NULL",
+		time.Now(), userID, eventID,
+	)
+	return err
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"database/sql"
+	"kjernekraft/models"
+	"time"
+)
+
+// migrateMembershipHistory creates the membership history table and backfills it from user memberships
+func migrateMembershipHistory(db *sql.DB) error {
+	membershipHistoryTableSQL := `
+	CREATE TABLE IF NOT EXISTS membership_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		user_membership_id INTEGER NOT NULL,
+		membership_id INTEGER NOT NULL,
+		started_at DATE NOT NULL,
+		ended_at DATE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (user_membership_id) REFERENCES user_memberships(id),
+		FOREIGN KEY (membership_id) REFERENCES memberships(id)
+	);
+	`
+	if _, err := db.Exec(membershipHistoryTableSQL); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO membership_history (user_id, user_membership_id, membership_id, started_at, ended_at)
+		SELECT user_id, id, membership_id, date(start_date), ` + endedAtFromUserMembership + `
+		FROM user_memberships
+		WHERE id NOT IN (SELECT user_membership_id FROM membership_history)`)
+	return err
+}
+
+// endedAtFromUserMembership derives when a user membership without history ended
+// Without a recorded end, the membership is taken to have ended when its last paid period ran out.
+const endedAtFromUserMembership = `CASE WHEN status IN ('active', 'paused', 'freeze_requested') THEN NULL ELSE date(COALESCE(renewal_date, end_date)) END`
+
+// GetMembershipHistory returns every plan a member has been on, oldest first.
+// User memberships created outside the app without history are included as a single period.
+func (db *Database) GetMembershipHistory(userID int64) ([]models.MembershipPeriod, error) {
+	rows, err := db.Conn.Query(`
+		SELECT h.user_id, h.membership_id, m.name, h.started_at, h.ended_at
+		FROM membership_history h JOIN memberships m ON h.membership_id = m.id
+		WHERE h.user_id = ?
+		UNION ALL
+		SELECT um.user_id, um.membership_id, m.name, date(um.start_date), `+endedAtFromUserMembership+`
+		FROM user_memberships um JOIN memberships m ON um.membership_id = m.id
+		WHERE um.user_id = ? AND um.id NOT IN (SELECT user_membership_id FROM membership_history)
+		ORDER BY 4`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.MembershipPeriod
+	for rows.Next() {
+		var period models.MembershipPeriod
+		var startedAt string
+		var endedAt sql.NullString
+		if err := rows.Scan(&period.UserID, &period.MembershipID, &period.MembershipName, &startedAt, &endedAt); err != nil {
+			return nil, err
+		}
+		period.StartedAt, _ = parseHistoryDate(startedAt)
+		if endedAt.Valid {
+			if ended, ok := parseHistoryDate(endedAt.String); ok {
+				period.EndedAt = &ended
+			}
+		}
+		history = append(history, period)
+	}
+	return history, rows.Err()
+}
+
+// parseHistoryDate parses the date part of a history date, which the driver may return with a time
+func parseHistoryDate(value string) (time.Time, bool) {
+	if len(value) < 10 {
+		return time.Time{}, false
+	}
+	date, err := time.Parse("2006-01-02", value[:10])
+	return date, err == nil
+}
+
+// recordMembershipPeriod ends the member's ongoing period and starts one on their current plan
+func (db *Database) recordMembershipPeriod(userID, membershipID int64, at time.Time) error {
+	return writeMembershipPeriod(db.Conn, userID, membershipID, at)
+}
+
+// writeMembershipPeriod is recordMembershipPeriod with the connection or a transaction
+func writeMembershipPeriod(w dbWriter, userID, membershipID int64, at time.Time) error {
+	if err := writeMembershipPeriodEnd(w, userID, at); err != nil {
+		return err
+	}
+
+	var userMembershipID int64
+	err := w.QueryRow(`SELECT id FROM user_memberships
+		WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')
+		ORDER BY created_at DESC LIMIT 1`, userID).Scan(&userMembershipID)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Exec("INSERT INTO membership_history (user_id, user_membership_id, membership_id, started_at) VALUES (?, ?, ?, ?)",
+		userID, userMembershipID, membershipID, at.Format("2006-01-02"))
+	return err
+}
+
+// endMembershipPeriod ends the member's ongoing period
+func (db *Database) endMembershipPeriod(userID int64, at time.Time) error {
+	return writeMembershipPeriodEnd(db.Conn, userID, at)
+}
+
+// writeMembershipPeriodEnd is endMembershipPeriod with the connection or a transaction
+func writeMembershipPeriodEnd(w dbWriter, userID int64, at time.Time) error {
+	_, err := w.Exec("UPDATE membership_history SET ended_at = ? WHERE user_id = ? AND ended_at IS NULL",
+		at.Format("2006-01-02"), userID)
+	return err
+}
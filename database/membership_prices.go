@@ -0,0 +1,288 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"time"
+)
+
+// PriceNoticeDays is how long existing members keep their price after a price increase is announced.
+// Norwegian consumer law requires members to be notified at least a month before a price increase.
+const PriceNoticeDays = 30
+
+// migrateMembershipPrices creates the price version table and seeds it with the current prices
+func migrateMembershipPrices(db *sql.DB) error {
+	membershipPricesTableSQL := `
+	CREATE TABLE IF NOT EXISTS membership_prices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		membership_id INTEGER NOT NULL,
+		price INTEGER NOT NULL,
+		effective_date DATE NOT NULL,
+		existing_members_date DATE NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (membership_id) REFERENCES memberships(id)
+	);
+	`
+	if _, err := db.Exec(membershipPricesTableSQL); err != nil {
+		return err
+	}
+
+	// Plans without price history get their current price as the first version
+	_, err := db.Exec(`
+		INSERT INTO membership_prices (membership_id, price, effective_date, existing_members_date)
+		SELECT id, price, '2000-01-01', '2000-01-01' FROM memberships
+		WHERE id NOT IN (SELECT membership_id FROM membership_prices)`)
+	return err
+}
+
+// ExistingMembersPriceDate returns when existing members start paying a new price.
+// Price decreases apply right away, increases only after PriceNoticeDays from the announcement.
+func ExistingMembersPriceDate(effectiveDate, announcedAt time.Time, currentPrice, newPrice int) time.Time {
+	if newPrice <= currentPrice {
+		return effectiveDate
+	}
+	noticeEnd := time.Date(announcedAt.Year(), announcedAt.Month(), announcedAt.Day(), 0, 0, 0, 0, effectiveDate.Location()).AddDate(0, 0, PriceNoticeDays)
+	if effectiveDate.Before(noticeEnd) {
+		return noticeEnd
+	}
+	return effectiveDate
+}
+
+// memberPriceAt returns the price a member who started on memberSince pays for a plan on a date.
+// Members pay the newest version that has reached them, or the version valid when they joined.
+func (db *Database) memberPriceAt(membershipID int64, memberSince, date time.Time) (int, error) {
+	var price int
+	err := db.Conn.QueryRow(`
+		SELECT price FROM membership_prices
+		WHERE membership_id = ? AND (existing_members_date <= ? OR effective_date <= ?)
+		ORDER BY effective_date DESC, id DESC LIMIT 1`,
+		membershipID, date.Format("2006-01-02"), memberSince.Format("2006-01-02"),
+	).Scan(&price)
+	if err == sql.ErrNoRows {
+		// No price history, fall back to the list price
+		err = db.Conn.QueryRow("SELECT price FROM memberships WHERE id = ?", membershipID).Scan(&price)
+	}
+	return price, err
+}
+
+// GetMembershipPriceVersions returns all price versions, newest first. A membership ID of 0 returns all plans.
+func (db *Database) GetMembershipPriceVersions(membershipID int64) ([]models.MembershipPrice, error) {
+	query := `
+		SELECT mp.id, mp.membership_id, m.name, mp.price, mp.effective_date, mp.existing_members_date, mp.created_at
+		FROM membership_prices mp
+		JOIN memberships m ON mp.membership_id = m.id
+		WHERE (? = 0 OR mp.membership_id = ?) AND m.active = TRUE
+		ORDER BY mp.effective_date DESC, mp.id DESC`
+	rows, err := db.Conn.Query(query, membershipID, membershipID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.MembershipPrice
+	for rows.Next() {
+		var v models.MembershipPrice
+		if err := rows.Scan(&v.ID, &v.MembershipID, &v.MembershipName, &v.Price, &v.EffectiveDate, &v.ExistingMembersDate, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// getMembershipPriceVersion fetches a single price version
+func (db *Database) getMembershipPriceVersion(versionID int64) (*models.MembershipPrice, error) {
+	var v models.MembershipPrice
+	err := db.Conn.QueryRow(`
+		SELECT mp.id, mp.membership_id, m.name, mp.price, mp.effective_date, mp.existing_members_date, mp.created_at
+		FROM membership_prices mp
+		JOIN memberships m ON mp.membership_id = m.id
+		WHERE mp.id = ?`, versionID,
+	).Scan(&v.ID, &v.MembershipID, &v.MembershipName, &v.Price, &v.EffectiveDate, &v.ExistingMembersDate, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// affectedMembers lists members on a plan whose price changes to newPrice from existingMembersDate.
+// currentPriceDate is the date their current price is looked up for.
+func (db *Database) affectedMembers(membershipID int64, newPrice int, existingMembersDate, currentPriceDate time.Time) ([]models.AffectedMember, error) {
+	rows, err := db.Conn.Query(`
+		SELECT u.id, u.name, u.email, u.phone, um.start_date, um.plan_since, um.renewal_date
+		FROM user_memberships um
+		JOIN users u ON um.user_id = u.id
+		WHERE um.membership_id = ? AND um.status IN ('active', 'paused', 'freeze_requested')
+		AND COALESCE(um.plan_since, um.start_date) < ?
+		ORDER BY u.name`, membershipID, existingMembersDate.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	type memberRow struct {
+		member      models.AffectedMember
+		planSince   time.Time
+		renewalDate time.Time
+	}
+	var members []memberRow
+	for rows.Next() {
+		var m memberRow
+		var startDate time.Time
+		var planSince sql.NullTime
+		if err := rows.Scan(&m.member.UserID, &m.member.Name, &m.member.Email, &m.member.Phone, &startDate, &planSince, &m.renewalDate); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.planSince = planSinceOrStart(startDate, planSince)
+		members = append(members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var affected []models.AffectedMember
+	for _, m := range members {
+		currentPrice, err := db.memberPriceAt(membershipID, m.planSince, currentPriceDate)
+		if err != nil {
+			return nil, err
+		}
+		if currentPrice == newPrice {
+			continue
+		}
+
+		// The new price is charged from the first renewal on or after the date it reaches existing members
+		newPriceFrom := m.renewalDate
+		for newPriceFrom.Before(existingMembersDate) {
+			newPriceFrom = newPriceFrom.AddDate(0, 1, 0)
+		}
+
+		m.member.CurrentPrice = currentPrice
+		m.member.NewPrice = newPrice
+		m.member.NewPriceFrom = newPriceFrom
+		affected = append(affected, m.member)
+	}
+	return affected, nil
+}
+
+// PreviewMembershipPriceChange shows which members a new price would affect and the revenue impact
+func (db *Database) PreviewMembershipPriceChange(membershipID int64, newPrice int, effectiveDate time.Time) (*models.PriceChangePreview, error) {
+	membership, err := db.GetMembershipByID(membershipID)
+	if err != nil {
+		return nil, fmt.Errorf("medlemskapet finnes ikke")
+	}
+
+	now := time.Now()
+	existingMembersDate := ExistingMembersPriceDate(effectiveDate, now, membership.Price, newPrice)
+
+	affected, err := db.affectedMembers(membershipID, newPrice, existingMembersDate, now)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &models.PriceChangePreview{
+		MembershipID:        int(membershipID),
+		NewPrice:            newPrice,
+		EffectiveDate:       effectiveDate,
+		ExistingMembersDate: existingMembersDate,
+		AffectedMembers:     affected,
+	}
+	for _, member := range affected {
+		preview.MonthlyRevenueImpact += member.NewPrice - member.CurrentPrice
+	}
+	preview.AnnualRevenueImpact = preview.MonthlyRevenueImpact * 12
+	return preview, nil
+}
+
+// CreateMembershipPriceVersion schedules a new price for a plan. New members pay it from the
+// effective date, existing members are grandfathered until the notice period has passed and notified.
+func (db *Database) CreateMembershipPriceVersion(membershipID int64, newPrice int, effectiveDate time.Time) (*models.MembershipPrice, error) {
+	if newPrice <= 0 {
+		return nil, fmt.Errorf("prisen må være større enn null")
+	}
+
+	preview, err := db.PreviewMembershipPriceChange(membershipID, newPrice, effectiveDate)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.Conn.Exec(
+		"INSERT INTO membership_prices (membership_id, price, effective_date, existing_members_date, created_at) VALUES (?, ?, ?, ?, ?)",
+		membershipID, newPrice, effectiveDate.Format("2006-01-02"), preview.ExistingMembersDate.Format("2006-01-02"), time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	versionID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	// Update the list price right away if the new price is already effective
+	if _, err := db.ApplyDueMembershipPrices(time.Now()); err != nil {
+		return nil, err
+	}
+
+	version, err := db.getMembershipPriceVersion(versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range preview.AffectedMembers {
+		message := fmt.Sprintf("Prisen på %s endres fra %s til %s per måned. Den nye prisen gjelder fra fornyelsen %s.",
+			version.MembershipName, formatKroner(member.CurrentPrice), formatKroner(member.NewPrice), member.NewPriceFrom.Format("02.01.2006"))
+		if err := db.CreateNotification(int64(member.UserID), "price_change", "Prisendring på medlemskapet ditt", message); err != nil {
+			log.Printf("Could not notify user %d about price change: %v", member.UserID, err)
+		}
+	}
+
+	return version, nil
+}
+
+// GetPriceNoticeList returns the members to notify about a price version, for letters or e-mail
+func (db *Database) GetPriceNoticeList(versionID int64) (*models.MembershipPrice, []models.AffectedMember, error) {
+	version, err := db.getMembershipPriceVersion(versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Compare with the price members paid the day before the new version reached them
+	dayBefore := version.ExistingMembersDate.AddDate(0, 0, -1)
+	affected, err := db.affectedMembers(int64(version.MembershipID), version.Price, version.ExistingMembersDate, dayBefore)
+	if err != nil {
+		return nil, nil, err
+	}
+	return version, affected, nil
+}
+
+// ApplyDueMembershipPrices sets the list price of each plan to its newest effective price version
+func (db *Database) ApplyDueMembershipPrices(now time.Time) (int, error) {
+	res, err := db.Conn.Exec(`
+		UPDATE memberships SET price = (
+			SELECT mp.price FROM membership_prices mp
+			WHERE mp.membership_id = memberships.id AND mp.effective_date <= ?
+			ORDER BY mp.effective_date DESC, mp.id DESC LIMIT 1
+		)
+		WHERE price != (
+			SELECT mp.price FROM membership_prices mp
+			WHERE mp.membership_id = memberships.id AND mp.effective_date <= ?
+			ORDER BY mp.effective_date DESC, mp.id DESC LIMIT 1
+		)`, now.Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	updated, err := res.RowsAffected()
+	return int(updated), err
+}
+
+// formatKroner formats an amount in øre as kroner, e.g. 59900 as "599,00 kr"
+func formatKroner(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d,%02d kr", sign, amount/100, amount%100)
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql"
+	"kjernekraft/models"
+	"time"
+)
+
+// migrateNotifications creates the notifications table
+func migrateNotifications(db *sql.DB) error {
+	notificationsTableSQL := `
+	CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		type TEXT NOT NULL,
+		title TEXT NOT NULL,
+		message TEXT NOT NULL,
+		is_read BOOLEAN DEFAULT FALSE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+	`
+	_, err := db.Exec(notificationsTableSQL)
+	return err
+}
+
+// CreateNotification stores a notification for a user
+func (db *Database) CreateNotification(userID int64, notificationType, title, message string) error {
+	query := `INSERT INTO notifications (user_id, type, title, message, is_read, created_at) VALUES (?, ?, ?, ?, FALSE, ?)`
+	_, err := db.Conn.Exec(query, userID, notificationType, title, message, time.Now())
+	return err
+}
+
+// GetUserNotifications fetches the most recent notifications for a user
+func (db *Database) GetUserNotifications(userID int64, limit int) ([]models.Notification, error) {
+	query := `SELECT id, user_id, type, title, message, is_read, created_at
+	          FROM notifications WHERE user_id = ?
+	          ORDER BY created_at DESC, id DESC
+	          LIMIT ?`
+
+	rows, err := db.Conn.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.Title, &n.Message, &n.IsRead, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationsRead marks all of a user's notifications as read
+func (db *Database) MarkNotificationsRead(userID int64) error {
+	_, err := db.Conn.Exec(`UPDATE notifications SET is_read = TRUE WHERE user_id = ? AND is_read = FALSE`, userID)
+	return err
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"fmt"
+	"kjernekraft/models"
+)
+
+// PaymentProvider moves money to and from a member's payment method. Charges are still
+// simulated, the provider is used to pay money back.
+type PaymentProvider interface {
+	// Refund pays back part or all of a charge and returns the provider's ID for the refund
+	Refund(charge models.Charge, amount int) (string, error)
+}
+
+// SimulatedPaymentProvider accepts every refund without moving any money, matching how
+// charges are simulated
+type SimulatedPaymentProvider struct{}
+
+// Refund pretends to pay the amount back and makes up a refund ID
+func (SimulatedPaymentProvider) Refund(charge models.Charge, amount int) (string, error) {
+	return fmt.Sprintf("re_sim_%d_%d", charge.ID, charge.RefundedAmount+amount), nil
+}
+
+// paymentProvider returns the provider set on the database, or the simulated one
+func (db *Database) paymentProvider() PaymentProvider {
+	if db.Payments != nil {
+		return db.Payments
+	}
+	return SimulatedPaymentProvider{}
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"math"
+	"time"
+)
+
+// migrateProration adds the membership a member has asked to switch to at the next renewal,
+// and when they moved onto the plan they are on
+func migrateProration(db *sql.DB) error {
+	columns := []string{
+		"ALTER TABLE user_memberships ADD COLUMN scheduled_membership_id INTEGER",
+		"ALTER TABLE user_memberships ADD COLUMN plan_since DATE",
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// planSinceOrStart returns when a member moved onto their current plan, which grandfathered
+// prices count from. Members who never changed plan have been on it since they started.
+func planSinceOrStart(startDate time.Time, planSince sql.NullTime) time.Time {
+	if planSince.Valid {
+		return planSince.Time
+	}
+	return startDate
+}
+
+// ProrateMembershipChange returns the price difference for the rest of a billing period when a member
+// changes plan on changeDate. Positive amounts are charged, negative amounts are credited.
+// Days are counted by calendar date, so a change on the first day of the period covers the whole period.
+func ProrateMembershipChange(currentPrice, newPrice int, periodStart, periodEnd, changeDate time.Time) (amount, daysRemaining, daysInPeriod int) {
+	daysInPeriod = daysBetween(periodStart, periodEnd)
+	if daysInPeriod <= 0 {
+		return 0, 0, 0
+	}
+
+	daysRemaining = daysBetween(changeDate, periodEnd)
+	if daysRemaining < 0 {
+		daysRemaining = 0
+	}
+	if daysRemaining > daysInPeriod {
+		daysRemaining = daysInPeriod
+	}
+
+	amount = int(math.Round(float64((newPrice-currentPrice)*daysRemaining) / float64(daysInPeriod)))
+	return amount, daysRemaining, daysInPeriod
+}
+
+// daysBetween counts calendar days from one date to another
+func daysBetween(from, to time.Time) int {
+	fromDate := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDate := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toDate.Sub(fromDate).Hours() / 24)
+}
+
+// QuoteMembershipChange calculates what a member is charged or credited for changing plan now.
+// Both plans are priced as the member would pay them. The current plan keeps its grandfathered
+// price, while the new plan is priced as of the change since the member joins it then. A custom
+// price set by an admin belongs to the current plan and is not carried over.
+func (db *Database) QuoteMembershipChange(userID, newMembershipID int64, now time.Time) (*models.ProrationQuote, error) {
+	current, err := db.GetUserMembership(userID)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("bruker har ingen aktivt medlemskap")
+	}
+
+	newMembership, err := db.GetMembershipByID(newMembershipID)
+	if err != nil {
+		return nil, fmt.Errorf("ugyldig nytt medlemskap")
+	}
+
+	userMembershipID := int64(current.UserMembership.ID)
+	currentPrice, err := db.memberPlanPrice(userID, userMembershipID, int64(current.MembershipID), current.PlanSince, now)
+	if err != nil {
+		return nil, err
+	}
+	newPrice, err := db.memberPlanPrice(userID, userMembershipID, newMembershipID, now, now)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, daysRemaining, daysInPeriod := ProrateMembershipChange(currentPrice, newPrice, current.LastBilled, current.RenewalDate, now)
+
+	return &models.ProrationQuote{
+		CurrentMembershipID:   current.MembershipID,
+		CurrentMembershipName: current.Name,
+		CurrentPrice:          currentPrice,
+		NewMembershipID:       newMembership.ID,
+		NewMembershipName:     newMembership.Name,
+		NewPrice:              newPrice,
+		PeriodStart:           current.LastBilled,
+		PeriodEnd:             current.RenewalDate,
+		DaysInPeriod:          daysInPeriod,
+		DaysRemaining:         daysRemaining,
+		Amount:                amount,
+		IsUpgrade:             newPrice > currentPrice,
+	}, nil
+}
+
+// billProration charges the amount in a quote through the charge ledger. What a member is owed
+// for moving to a cheaper plan is put on their account credit.
+func (db *Database) billProration(userID int64, quote *models.ProrationQuote, now time.Time) error {
+	if quote.Amount == 0 {
+		return nil
+	}
+
+	if quote.Amount < 0 {
+		description := fmt.Sprintf("Kreditering ved bytte til %s (%d dager)", quote.NewMembershipName, quote.DaysRemaining)
+		return db.creditCharge(userID, -quote.Amount, models.CreditDowngrade, description, "medlemskap", now)
+	}
+	description := fmt.Sprintf("Bytte til %s (%d dager)", quote.NewMembershipName, quote.DaysRemaining)
+	return db.SimulateBilling(userID, quote.Amount, description, "medlemskap")
+}
+
+// ScheduleMembershipChange switches a member to another plan at the next renewal instead of right away.
+// Nothing is charged or credited, the new price is billed in full from the renewal.
+func (db *Database) ScheduleMembershipChange(userID, newMembershipID int64) error {
+	if _, err := db.GetMembershipByID(newMembershipID); err != nil {
+		return fmt.Errorf("ugyldig nytt medlemskap")
+	}
+
+	res, err := db.Conn.Exec(`UPDATE user_memberships SET scheduled_membership_id = ?
+		WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')`, newMembershipID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("bruker har ingen aktivt medlemskap")
+	}
+	return nil
+}
+
+// CancelScheduledMembershipChange keeps the member on their current plan at the next renewal
+func (db *Database) CancelScheduledMembershipChange(userID int64) error {
+	_, err := db.Conn.Exec(`UPDATE user_memberships SET scheduled_membership_id = NULL
+		WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')`, userID)
+	return err
+}
+
+// GetScheduledMembershipChange returns the plan a member switches to at the next renewal, or nil if none
+func (db *Database) GetScheduledMembershipChange(userID int64) (*models.Membership, error) {
+	var scheduledID sql.NullInt64
+	err := db.Conn.QueryRow(`SELECT scheduled_membership_id FROM user_memberships
+		WHERE user_id = ? AND status IN ('active', 'paused', 'freeze_requested')
+		ORDER BY created_at DESC LIMIT 1`, userID).Scan(&scheduledID)
+	if err == sql.ErrNoRows || (err == nil && !scheduledID.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.GetMembershipByID(scheduledID.Int64)
+}
+
+// applyScheduledMembershipChange moves a member to their scheduled plan on the renewal date
+func (db *Database) applyScheduledMembershipChange(userID, newMembershipID int64, renewalDate time.Time) error {
+	rules, err := db.GetMembershipRules()
+	if err != nil {
+		return err
+	}
+	current, err := db.GetUserMembership(userID)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("bruker har ingen aktivt medlemskap")
+	}
+	newMembership, err := db.GetMembershipByID(newMembershipID)
+	if err != nil {
+		return err
+	}
+
+	bindingEnd := bindingEndAfterChange(rules, current, newMembership, renewalDate)
+	_, err = db.Conn.Exec(`UPDATE user_memberships SET membership_id = ?, binding_end = ?, scheduled_membership_id = NULL,
+		plan_since = ?, custom_price = NULL WHERE id = ?`,
+		newMembershipID, bindingEnd.Format("2006-01-02"), renewalDate.Format("2006-01-02"), current.UserMembership.ID)
+	if err != nil {
+		return err
+	}
+	// A trial that continues on a regular plan no longer ends by itself
+	if current.DurationDays > 0 {
+		_, err = db.Conn.Exec("UPDATE user_memberships SET end_date = ? WHERE id = ?",
+			renewalDate.AddDate(0, newMembership.CommitmentMonths, 0).Format("2006-01-02"), current.UserMembership.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return db.recordMembershipPeriod(userID, newMembershipID, renewalDate)
+}
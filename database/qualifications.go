@@ -0,0 +1,161 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"sort"
+	"strings"
+)
+
+// migrateQualifications lets roles double as qualifications that unlock classes
+func migrateQualifications(db *sql.DB) error {
+	if _, err := db.Exec("ALTER TABLE roles ADD COLUMN description TEXT DEFAULT ''"); err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	if _, err := db.Exec("ALTER TABLE roles ADD COLUMN is_qualification BOOLEAN DEFAULT FALSE"); err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	return nil
+}
+
+// parseRoleRequirements reads the comma-separated role_requirements column
+func parseRoleRequirements(value sql.NullString) map[string]struct{} {
+	requirements := make(map[string]struct{})
+	if !value.Valid {
+		return requirements
+	}
+	for _, role := range strings.Split(value.String, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			requirements[role] = struct{}{}
+		}
+	}
+	return requirements
+}
+
+// formatRoleRequirements writes role requirements as a sorted, comma-separated list
+func formatRoleRequirements(requirements map[string]struct{}) string {
+	roles := make([]string, 0, len(requirements))
+	for role := range requirements {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
+
+// GetQualifications returns all roles that admins can grant as class prerequisites
+func (db *Database) GetQualifications() ([]models.Qualification, error) {
+	rows, err := db.Conn.Query("SELECT id, name, description FROM roles WHERE is_qualification = TRUE ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var qualifications []models.Qualification
+	for rows.Next() {
+		var q models.Qualification
+		if err := rows.Scan(&q.ID, &q.Name, &q.Description); err != nil {
+			return nil, err
+		}
+		qualifications = append(qualifications, q)
+	}
+	return qualifications, rows.Err()
+}
+
+// CreateQualification creates a qualification, or marks an existing role as one
+func (db *Database) CreateQualification(name, description string) (int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.Contains(name, ",") {
+		return 0, fmt.Errorf("ugyldig navn på kvalifikasjon")
+	}
+
+	roleID, err := db.GetOrCreateRole(name)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = db.Conn.Exec("UPDATE roles SET description = ?, is_qualification = TRUE WHERE id = ?", description, roleID)
+	return roleID, err
+}
+
+// GrantQualification gives a user a qualification. Granting it twice is a no-op.
+func (db *Database) GrantQualification(userID int64, name string) error {
+	var roleID int64
+	err := db.Conn.QueryRow("SELECT id FROM roles WHERE name = ? AND is_qualification = TRUE", name).Scan(&roleID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("kvalifikasjonen finnes ikke")
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Conn.Exec("INSERT OR IGNORE INTO user_roles (user_id, role_id) VALUES (?, ?)", userID, roleID)
+	return err
+}
+
+// RevokeQualification removes a qualification from a user
+func (db *Database) RevokeQualification(userID int64, name string) error {
+	_, err := db.Conn.Exec(
+		"DELETE FROM user_roles WHERE user_id = ? AND role_id = (SELECT id FROM roles WHERE name = ? AND is_qualification = TRUE)",
+		userID, name,
+	)
+	return err
+}
+
+// DescribeRequirements turns role names into the descriptions members see, falling back to the role name
+func (db *Database) DescribeRequirements(roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	descriptions, err := db.getQualificationDescriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	described := make([]string, len(roles))
+	for i, role := range roles {
+		described[i] = role
+		if description := descriptions[role]; description != "" {
+			described[i] = description
+		}
+	}
+	return described, nil
+}
+
+// getQualificationDescriptions maps qualification names to their descriptions
+func (db *Database) getQualificationDescriptions() (map[string]string, error) {
+	qualifications, err := db.GetQualifications()
+	if err != nil {
+		return nil, err
+	}
+
+	descriptions := make(map[string]string, len(qualifications))
+	for _, q := range qualifications {
+		descriptions[q.Name] = q.Description
+	}
+	return descriptions, nil
+}
+
+// checkEligibility returns an error naming the qualifications the user lacks for an event
+func (db *Database) checkEligibility(userID int64, event models.Event) error {
+	if len(event.RoleRequirements) == 0 {
+		return nil
+	}
+
+	userRoles, err := db.GetUserRoles(userID)
+	if err != nil {
+		return err
+	}
+
+	missing := event.MissingRequirements(userRoles)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	described, err := db.DescribeRequirements(missing)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("class requires: %s", strings.Join(described, ", "))
+}
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"strings"
+	"time"
+)
+
+// defaultVATRate is the standard MVA rate, used for charge types without a rate of their own
+const defaultVATRate = 25
+
+// migrateReceipts creates the studio's business details and MVA rates printed on receipts,
+// and numbers a receipt for every charge paid before receipts existed
+func migrateReceipts(db *sql.DB) error {
+	tables := []string{
+		`CREATE TABLE IF NOT EXISTS business_details (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			org_number TEXT DEFAULT '',
+			address TEXT DEFAULT '',
+			postal_code TEXT DEFAULT '',
+			city TEXT DEFAULT '',
+			email TEXT DEFAULT '',
+			vat_registered BOOLEAN DEFAULT TRUE,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS vat_rates (
+			charge_type TEXT PRIMARY KEY,
+			rate INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS receipts (
+			number INTEGER PRIMARY KEY,
+			charge_id INTEGER NOT NULL UNIQUE,
+			issued_at DATETIME NOT NULL,
+			FOREIGN KEY (charge_id) REFERENCES charges(id)
+		)`,
+	}
+	for _, table := range tables {
+		if _, err := db.Exec(table); err != nil {
+			return err
+		}
+	}
+
+	// Access to training is sold at the low rate, education is exempt
+	_, err := db.Exec(`INSERT OR IGNORE INTO vat_rates (charge_type, rate) VALUES
+		('medlemskap', 12), ('klippekort', 12), ('utdanninger', 0)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT id, charge_date FROM charges c
+		WHERE ` + receiptableCharge + ` AND NOT EXISTS (SELECT 1 FROM receipts r WHERE r.charge_id = c.id)
+		ORDER BY charge_date, id`)
+	if err != nil {
+		return err
+	}
+	type unnumbered struct {
+		id   int64
+		date time.Time
+	}
+	var charges []unnumbered
+	for rows.Next() {
+		var c unnumbered
+		if err := rows.Scan(&c.id, &c.date); err != nil {
+			rows.Close()
+			return err
+		}
+		charges = append(charges, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, c := range charges {
+		if err := issueReceipt(db, c.id, c.date); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiptableCharge selects the charges a member paid themselves. Credits and the company's
+// share of a membership, which is on the company's invoice, get no receipt.
+const receiptableCharge = `c.amount > 0 AND c.company_id IS NULL AND c.status IN ('succeeded', 'refunded', 'partially_refunded', 'disputed')`
+
+// issueReceipt gives a charge the next receipt number, unless it already has one
+func issueReceipt(db *sql.DB, chargeID int64, issuedAt time.Time) error {
+	_, err := db.Exec(`INSERT INTO receipts (number, charge_id, issued_at)
+		VALUES ((SELECT COALESCE(MAX(number), 0) + 1 FROM receipts), ?, ?)
+		ON CONFLICT(charge_id) DO NOTHING`, chargeID, issuedAt)
+	return err
+}
+
+// GetBusinessDetails returns the studio's details printed on receipts
+func (db *Database) GetBusinessDetails() (*models.BusinessDetails, error) {
+	var details models.BusinessDetails
+	err := db.Conn.QueryRow(`SELECT name, org_number, address, postal_code, city, email, vat_registered, updated_at
+		FROM business_details ORDER BY id DESC LIMIT 1`).Scan(&details.Name, &details.OrgNumber, &details.Address,
+		&details.PostalCode, &details.City, &details.Email, &details.VATRegistered, &details.UpdatedAt)
+	if err == sql.ErrNoRows {
+		// Return default details if none are saved
+		return &models.BusinessDetails{Name: "Kjernekraft", VATRegistered: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// SaveBusinessDetails saves the studio's details printed on receipts
+func (db *Database) SaveBusinessDetails(details *models.BusinessDetails) error {
+	details.Name = strings.TrimSpace(details.Name)
+	if details.Name == "" {
+		return fmt.Errorf("navn mangler")
+	}
+	details.OrgNumber = strings.ReplaceAll(strings.TrimSpace(details.OrgNumber), " ", "")
+	if details.OrgNumber != "" {
+		if len(details.OrgNumber) != 9 || strings.Trim(details.OrgNumber, "0123456789") != "" {
+			return fmt.Errorf("organisasjonsnummeret må ha 9 siffer")
+		}
+	}
+
+	_, err := db.Conn.Exec("DELETE FROM business_details")
+	if err != nil {
+		return err
+	}
+	_, err = db.Conn.Exec(`INSERT INTO business_details (name, org_number, address, postal_code, city, email, vat_registered)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, details.Name, details.OrgNumber, strings.TrimSpace(details.Address),
+		strings.TrimSpace(details.PostalCode), strings.TrimSpace(details.City), strings.TrimSpace(details.Email), details.VATRegistered)
+	return err
+}
+
+// GetVATRates returns the MVA rate of each charge type
+func (db *Database) GetVATRates() ([]models.VATRate, error) {
+	rows, err := db.Conn.Query("SELECT charge_type, rate FROM vat_rates ORDER BY charge_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []models.VATRate
+	for rows.Next() {
+		var r models.VATRate
+		if err := rows.Scan(&r.ChargeType, &r.Rate); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+// SaveVATRate sets the MVA rate of a charge type to one of the Norwegian rates
+func (db *Database) SaveVATRate(rate models.VATRate) error {
+	rate.ChargeType = strings.TrimSpace(rate.ChargeType)
+	if rate.ChargeType == "" {
+		return fmt.Errorf("type mangler")
+	}
+	switch rate.Rate {
+	case 0, 12, 15, 25:
+	default:
+		return fmt.Errorf("ugyldig MVA-sats: %d %%", rate.Rate)
+	}
+
+	_, err := db.Conn.Exec(`INSERT INTO vat_rates (charge_type, rate) VALUES (?, ?)
+		ON CONFLICT(charge_type) DO UPDATE SET rate = excluded.rate`, rate.ChargeType, rate.Rate)
+	return err
+}
+
+// vatRateFor returns the MVA rate of a charge type
+func (db *Database) vatRateFor(chargeType string) (int, error) {
+	var rate int
+	err := db.Conn.QueryRow("SELECT rate FROM vat_rates WHERE charge_type = ?", chargeType).Scan(&rate)
+	if err == sql.ErrNoRows {
+		return defaultVATRate, nil
+	}
+	return rate, err
+}
+
+// receiptLine breaks the MVA out of an amount that includes it
+func receiptLine(description string, amount, rate int) models.ReceiptLine {
+	net := (amount*100 + (100+rate)/2) / (100 + rate)
+	return models.ReceiptLine{
+		Description: description,
+		NetAmount:   net,
+		VATRate:     rate,
+		VATAmount:   amount - net,
+		Amount:      amount,
+	}
+}
+
+// GetReceipt returns the receipt for one of the charges a member paid
+func (db *Database) GetReceipt(userID, chargeID int64) (*models.Receipt, error) {
+	var c models.Charge
+	err := db.Conn.QueryRow(`SELECT c.id, c.amount, c.currency, c.description, c.type, c.charge_date
+		FROM charges c WHERE c.id = ? AND c.user_id = ? AND `+receiptableCharge, chargeID, userID).Scan(
+		&c.ID, &c.Amount, &c.Currency, &c.Description, &c.Type, &c.ChargeDate)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("kvittering ikke funnet")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Charges made outside the billing code are numbered when their receipt is first needed
+	if err := issueReceipt(db.Conn, chargeID, c.ChargeDate); err != nil {
+		return nil, err
+	}
+	receipt := models.Receipt{ChargeID: c.ID, Currency: c.Currency}
+	err = db.Conn.QueryRow("SELECT number, issued_at FROM receipts WHERE charge_id = ?", chargeID).Scan(&receipt.Number, &receipt.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	seller, err := db.GetBusinessDetails()
+	if err != nil {
+		return nil, err
+	}
+	receipt.Seller = *seller
+	customer := &receipt.Customer
+	err = db.Conn.QueryRow(`SELECT id, name, email, COALESCE(phone, ''), COALESCE(address, ''), COALESCE(postal_code, ''),
+		COALESCE(city, ''), COALESCE(country, '') FROM users WHERE id = ?`, userID).Scan(
+		&customer.ID, &customer.Name, &customer.Email, &customer.Phone, &customer.Address, &customer.PostalCode,
+		&customer.City, &customer.Country)
+	if err != nil {
+		return nil, err
+	}
+
+	rate, err := db.vatRateFor(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	if !seller.VATRegistered {
+		rate = 0
+	}
+	line := receiptLine(c.Description, c.Amount, rate)
+	receipt.Lines = []models.ReceiptLine{line}
+	for _, l := range receipt.Lines {
+		receipt.NetAmount += l.NetAmount
+		receipt.VATAmount += l.VATAmount
+		receipt.Total += l.Amount
+	}
+
+	receipt.CreditNotes, err = db.getChargeCreditNotes(chargeID)
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// getChargeCreditNotes returns the credit notes written for a charge, oldest first
+func (db *Database) getChargeCreditNotes(chargeID int64) ([]models.CreditNote, error) {
+	rows, err := db.Conn.Query(`SELECT id, number, charge_id, user_id, amount, reason, provider_refund_id, klipp_removed, created_at,
+		COALESCE(credited_amount, 0)
+		FROM credit_notes WHERE charge_id = ? ORDER BY created_at, id`, chargeID)
+	if err != nil {
+		return nil, err
+	}
+	return scanCreditNotes(rows)
+}
@@ -0,0 +1,278 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"sort"
+	"strings"
+)
+
+// migrateRecommendations creates the recommendation rules table and seeds the questionnaire defaults
+func migrateRecommendations(db *sql.DB) error {
+	recommendationRulesTableSQL := `
+	CREATE TABLE IF NOT EXISTS recommendation_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		question TEXT NOT NULL,
+		answer TEXT NOT NULL,
+		membership_id INTEGER,
+		commitment_months INTEGER,
+		is_student_senior BOOLEAN,
+		is_special_offer BOOLEAN,
+		is_trial BOOLEAN,
+		required BOOLEAN DEFAULT FALSE,
+		weight INTEGER DEFAULT 0,
+		highlight TEXT DEFAULT '',
+		active BOOLEAN DEFAULT TRUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (membership_id) REFERENCES memberships(id)
+	);
+	`
+	if _, err := db.Exec(recommendationRulesTableSQL); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("ALTER TABLE recommendation_rules ADD COLUMN is_trial BOOLEAN")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	if err == nil {
+		// The trial answer was seeded with the plan IDs 7 and 8 of the seed data, which were not seeded yet or may be
+		// other plans. Match the trial and the monthly pass by what they are instead.
+		seeds := []string{
+			`UPDATE recommendation_rules SET membership_id = NULL, is_trial = TRUE
+			 WHERE question = 'commitment' AND answer = 'trial' AND (
+				membership_id IN (SELECT id FROM memberships WHERE is_trial = TRUE)
+				OR (membership_id = 7 AND membership_id NOT IN (SELECT id FROM memberships)))`,
+			`UPDATE recommendation_rules SET membership_id = NULL, commitment_months = 1
+			 WHERE question = 'commitment' AND answer = 'trial' AND (
+				membership_id IN (SELECT id FROM memberships WHERE commitment_months = 1 AND is_trial = FALSE)
+				OR (membership_id = 8 AND membership_id NOT IN (SELECT id FROM memberships)))`,
+		}
+		for _, seed := range seeds {
+			if _, err := db.Exec(seed); err != nil {
+				return err
+			}
+		}
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM recommendation_rules").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	seeds := []string{
+		// Students and seniors only see discounted plans, everyone else only regular plans
+		`INSERT INTO recommendation_rules (question, answer, is_student_senior, required) VALUES ('is_student_senior', 'true', TRUE, TRUE)`,
+		`INSERT INTO recommendation_rules (question, answer, is_student_senior, required) VALUES ('is_student_senior', 'false', FALSE, TRUE)`,
+		`INSERT INTO recommendation_rules (question, answer, commitment_months, weight) VALUES ('commitment', '12', 12, 10)`,
+		`INSERT INTO recommendation_rules (question, answer, commitment_months, weight) VALUES ('commitment', '6', 6, 10)`,
+		`INSERT INTO recommendation_rules (question, answer, commitment_months, weight) VALUES ('commitment', '0', 0, 10)`,
+		// Trial plans and the monthly pass
+		`INSERT INTO recommendation_rules (question, answer, is_trial, weight) VALUES ('commitment', 'trial', TRUE, 10)`,
+		`INSERT INTO recommendation_rules (question, answer, commitment_months, weight) VALUES ('commitment', 'trial', 1, 10)`,
+		`INSERT INTO recommendation_rules (question, answer, is_special_offer, weight, highlight)
+		 VALUES ('commitment', 'autumn_special', TRUE, 10, '🍂 Spesielt Høsttilbud! Få 12-måneders pris med kun 4 måneders binding')`,
+	}
+	for _, seed := range seeds {
+		if _, err := db.Exec(seed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ruleApplies checks whether a rule reacts to the member's answers
+func ruleApplies(rule models.RecommendationRule, answers map[string]string) bool {
+	if !rule.Active {
+		return false
+	}
+	if rule.Answer == models.RecommendationAnyAnswer {
+		return true
+	}
+	return answers[rule.Question] == rule.Answer
+}
+
+// ruleMatchesPlan checks whether a plan has every attribute the rule asks for
+func ruleMatchesPlan(rule models.RecommendationRule, membership models.Membership) bool {
+	if rule.MembershipID != nil && *rule.MembershipID != membership.ID {
+		return false
+	}
+	if rule.CommitmentMonths != nil && *rule.CommitmentMonths != membership.CommitmentMonths {
+		return false
+	}
+	if rule.IsStudentSenior != nil && *rule.IsStudentSenior != membership.IsStudentSenior {
+		return false
+	}
+	if rule.IsSpecialOffer != nil && *rule.IsSpecialOffer != membership.IsSpecialOffer {
+		return false
+	}
+	if rule.IsTrial != nil && *rule.IsTrial != membership.IsTrial {
+		return false
+	}
+	return true
+}
+
+// ScoreMemberships ranks plans for a member's questionnaire answers, highest score first and cheapest first
+// on ties. Only plans that a rule scored are recommended. If the answers triggered rules but no plan
+// scored, all plans the required rules allow are suggested instead, except special offers.
+// Also returns the highlights of the rules that matched a recommended plan.
+func ScoreMemberships(memberships []models.Membership, rules []models.RecommendationRule, answers map[string]string) ([]models.Recommendation, []string) {
+	var allowed []models.Recommendation
+	triggered := false
+
+	for _, membership := range memberships {
+		if !membership.Active {
+			continue
+		}
+
+		recommendation := models.Recommendation{Membership: membership}
+		excluded := false
+		for _, rule := range rules {
+			if !ruleApplies(rule, answers) {
+				continue
+			}
+			if !rule.Required && rule.Answer != models.RecommendationAnyAnswer {
+				triggered = true
+			}
+
+			if !ruleMatchesPlan(rule, membership) {
+				if rule.Required {
+					excluded = true
+					break
+				}
+				continue
+			}
+			recommendation.Score += rule.Weight
+			if rule.Highlight != "" {
+				recommendation.Highlighted = true
+			}
+		}
+		if !excluded {
+			allowed = append(allowed, recommendation)
+		}
+	}
+
+	var recommendations []models.Recommendation
+	for _, recommendation := range allowed {
+		if recommendation.Score > 0 {
+			recommendations = append(recommendations, recommendation)
+		}
+	}
+	if len(recommendations) == 0 && triggered {
+		for _, recommendation := range allowed {
+			if !recommendation.IsSpecialOffer {
+				recommendations = append(recommendations, recommendation)
+			}
+		}
+	}
+
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		if recommendations[i].Score != recommendations[j].Score {
+			return recommendations[i].Score > recommendations[j].Score
+		}
+		if recommendations[i].Price != recommendations[j].Price {
+			return recommendations[i].Price < recommendations[j].Price
+		}
+		return recommendations[i].ID < recommendations[j].ID
+	})
+
+	// Show each highlight once, in rule order, if it matched a recommended plan
+	var highlights []string
+	for _, rule := range rules {
+		if rule.Highlight == "" || !ruleApplies(rule, answers) {
+			continue
+		}
+		for _, recommendation := range recommendations {
+			if ruleMatchesPlan(rule, recommendation.Membership) {
+				highlights = append(highlights, rule.Highlight)
+				break
+			}
+		}
+	}
+
+	return recommendations, highlights
+}
+
+// GetRecommendationRules returns all recommendation rules in the order they were added
+func (db *Database) GetRecommendationRules() ([]models.RecommendationRule, error) {
+	rows, err := db.Conn.Query(`
+		SELECT id, question, answer, membership_id, commitment_months, is_student_senior, is_special_offer, is_trial, required, weight, highlight, active
+		FROM recommendation_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.RecommendationRule
+	for rows.Next() {
+		var rule models.RecommendationRule
+		var membershipID, commitmentMonths sql.NullInt64
+		var isStudentSenior, isSpecialOffer, isTrial sql.NullBool
+		if err := rows.Scan(&rule.ID, &rule.Question, &rule.Answer, &membershipID, &commitmentMonths, &isStudentSenior, &isSpecialOffer, &isTrial,
+			&rule.Required, &rule.Weight, &rule.Highlight, &rule.Active); err != nil {
+			return nil, err
+		}
+		if membershipID.Valid {
+			id := int(membershipID.Int64)
+			rule.MembershipID = &id
+		}
+		if commitmentMonths.Valid {
+			months := int(commitmentMonths.Int64)
+			rule.CommitmentMonths = &months
+		}
+		if isStudentSenior.Valid {
+			rule.IsStudentSenior = &isStudentSenior.Bool
+		}
+		if isSpecialOffer.Valid {
+			rule.IsSpecialOffer = &isSpecialOffer.Bool
+		}
+		if isTrial.Valid {
+			rule.IsTrial = &isTrial.Bool
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// SaveRecommendationRule creates a rule, or updates it if it has an ID
+func (db *Database) SaveRecommendationRule(rule models.RecommendationRule) (int64, error) {
+	rule.Answer = strings.TrimSpace(rule.Answer)
+	switch rule.Question {
+	case models.QuestionCommitment, models.QuestionStudentSenior, models.QuestionStartTime:
+	default:
+		return 0, fmt.Errorf("ugyldig spørsmål")
+	}
+	if rule.Answer == "" {
+		return 0, fmt.Errorf("svar må fylles ut")
+	}
+
+	if rule.ID == 0 {
+		res, err := db.Conn.Exec(`
+			INSERT INTO recommendation_rules (question, answer, membership_id, commitment_months, is_student_senior, is_special_offer, is_trial, required, weight, highlight, active)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			rule.Question, rule.Answer, rule.MembershipID, rule.CommitmentMonths, rule.IsStudentSenior, rule.IsSpecialOffer, rule.IsTrial,
+			rule.Required, rule.Weight, rule.Highlight, rule.Active)
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+
+	_, err := db.Conn.Exec(`
+		UPDATE recommendation_rules SET question = ?, answer = ?, membership_id = ?, commitment_months = ?, is_student_senior = ?,
+		is_special_offer = ?, is_trial = ?, required = ?, weight = ?, highlight = ?, active = ?
+		WHERE id = ?`,
+		rule.Question, rule.Answer, rule.MembershipID, rule.CommitmentMonths, rule.IsStudentSenior, rule.IsSpecialOffer, rule.IsTrial,
+		rule.Required, rule.Weight, rule.Highlight, rule.Active, rule.ID)
+	return int64(rule.ID), err
+}
+
+// DeleteRecommendationRule removes a recommendation rule
+func (db *Database) DeleteRecommendationRule(id int64) error {
+	_, err := db.Conn.Exec("DELETE FROM recommendation_rules WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,287 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// migrateRefunds creates the credit notes for money paid back on a charge, and lets a
+// klippekort charge remember the card and klipp it paid for so a refund can take them back
+func migrateRefunds(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS credit_notes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		number TEXT NOT NULL UNIQUE,
+		charge_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		amount INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		provider_refund_id TEXT DEFAULT '',
+		klipp_removed INTEGER DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (charge_id) REFERENCES charges(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	columns := []string{
+		"ALTER TABLE charges ADD COLUMN refunded_amount INTEGER DEFAULT 0",
+		"ALTER TABLE charges ADD COLUMN user_klippekort_id INTEGER REFERENCES user_klippekort(id)",
+		"ALTER TABLE charges ADD COLUMN klipp INTEGER DEFAULT 0",
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// billKlippekort bills a klippekort purchase and links the charge to the card and the klipp
+// it bought. When the payment is declined the klipp are taken back off the card.
+func (db *Database) billKlippekort(userID int64, amount int, description string, klippekortID int64, klipp int) error {
+	chargeID, billErr := db.simulateCharge(userID, amount, description, "klippekort")
+	if chargeID == 0 {
+		return billErr
+	}
+	_, err := db.Conn.Exec("UPDATE charges SET user_klippekort_id = ?, klipp = ? WHERE id = ?", klippekortID, klipp, chargeID)
+	if err != nil {
+		return err
+	}
+	if errors.Is(billErr, errPaymentDeclined) {
+		if err := db.takeBackUnpaidKlipp(klippekortID, klipp, description, time.Now()); err != nil {
+			return err
+		}
+	}
+	return billErr
+}
+
+// takeBackUnpaidKlipp removes the klipp a failed payment was for, as far as they are unused
+func (db *Database) takeBackUnpaidKlipp(klippekortID int64, klipp int, description string, now time.Time) error {
+	var remaining int
+	if err := db.Conn.QueryRow("SELECT remaining_klipp FROM user_klippekort WHERE id = ?", klippekortID).Scan(&remaining); err != nil {
+		return err
+	}
+	if klipp > remaining {
+		klipp = remaining
+	}
+	if klipp > 0 {
+		if _, err := db.addKlippMovement(klippekortID, models.KlippPaymentFailed, -klipp, 0, 0, description, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refundableCharge is a charge as seen by a refund
+type refundableCharge struct {
+	models.Charge
+	companyID    sql.NullInt64
+	klippekortID sql.NullInt64
+	klipp        int
+}
+
+// getRefundableCharge returns one of a member's charges with what a refund needs to know
+func (db *Database) getRefundableCharge(userID, chargeID int64) (*refundableCharge, error) {
+	var c refundableCharge
+	var providerChargeID sql.NullString
+	err := db.Conn.QueryRow(`SELECT id, user_id, stripe_charge_id, amount, currency, status, description, type, charge_date,
+		COALESCE(refunded_amount, 0), company_id, user_klippekort_id, COALESCE(klipp, 0),
+		COALESCE(payment_method_type, ''), COALESCE(provider_reference, ''), COALESCE(credit_applied, 0)
+		FROM charges WHERE id = ? AND user_id = ?`, chargeID, userID).Scan(
+		&c.ID, &c.UserID, &providerChargeID, &c.Amount, &c.Currency, &c.Status, &c.Description, &c.Type, &c.ChargeDate,
+		&c.RefundedAmount, &c.companyID, &c.klippekortID, &c.klipp, &c.PaymentMethodType, &c.ProviderReference, &c.CreditApplied)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("betaling ikke funnet")
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.StripeChargeID = providerChargeID.String
+	return &c, nil
+}
+
+// klippToRemove returns how many unused klipp a refund takes off the card the charge paid
+// for. The klipp follow the share of the charge refunded so far, so a full refund takes back
+// every klipp that has not been used.
+func (db *Database) klippToRemove(c *refundableCharge, amount int) (int, error) {
+	if !c.klippekortID.Valid || c.klipp == 0 {
+		return 0, nil
+	}
+
+	var removed, remaining int
+	err := db.Conn.QueryRow("SELECT COALESCE(SUM(klipp_removed), 0) FROM credit_notes WHERE charge_id = ?", c.ID).Scan(&removed)
+	if err != nil {
+		return 0, err
+	}
+	err = db.Conn.QueryRow("SELECT remaining_klipp FROM user_klippekort WHERE id = ?", c.klippekortID.Int64).Scan(&remaining)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	klipp := c.klipp*(c.RefundedAmount+amount)/c.Amount - removed
+	if klipp > remaining {
+		klipp = remaining
+	}
+	if klipp < 0 {
+		klipp = 0
+	}
+	return klipp, nil
+}
+
+// nextCreditNoteNumber numbers credit notes in a series of their own per year
+func nextCreditNoteNumber(tx *sql.Tx, now time.Time) (string, error) {
+	prefix := fmt.Sprintf("KN-%d-", now.Year())
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM credit_notes WHERE number LIKE ?", prefix+"%").Scan(&count); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%04d", prefix, count+1), nil
+}
+
+// AdminRefundCharge pays back part or all of one of a member's charges through the payment
+// provider and writes a credit note for it. Unused klipp on a refunded klippekort are taken
+// off the card. Memberships are not changed, an admin ends them separately if needed.
+func (db *Database) AdminRefundCharge(userID, chargeID int64, amount int, reason string, now time.Time) error {
+	if err := checkAdminReason(reason); err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("beløpet må være større enn 0")
+	}
+
+	c, err := db.getRefundableCharge(userID, chargeID)
+	if err != nil {
+		return err
+	}
+	if c.companyID.Valid {
+		return fmt.Errorf("betalinger fakturert til en bedrift kan ikke refunderes her")
+	}
+	if !c.Refundable() {
+		return fmt.Errorf("betalingen kan ikke refunderes")
+	}
+	if amount > c.Amount-c.RefundedAmount {
+		return fmt.Errorf("kan ikke refundere mer enn %.2f kr", float64(c.Amount-c.RefundedAmount)/100)
+	}
+	klipp, err := db.klippToRemove(c, amount)
+	if err != nil {
+		return err
+	}
+
+	// What the payment method paid goes back to it, what account credit paid goes back on the credit
+	var credited int
+	err = db.Conn.QueryRow("SELECT COALESCE(SUM(credited_amount), 0) FROM credit_notes WHERE charge_id = ?", chargeID).Scan(&credited)
+	if err != nil {
+		return err
+	}
+	toPaymentMethod := c.Amount - c.CreditApplied - (c.RefundedAmount - credited)
+	if toPaymentMethod > amount {
+		toPaymentMethod = amount
+	}
+	if toPaymentMethod < 0 {
+		toPaymentMethod = 0
+	}
+	toCredit := amount - toPaymentMethod
+
+	// The money is paid back first, a failed refund leaves nothing recorded
+	refundID := ""
+	if toPaymentMethod > 0 {
+		refundID, err = db.refundProvider(c.Charge).Refund(c.Charge, toPaymentMethod)
+		if err != nil {
+			return fmt.Errorf("refusjonen feilet: %v", err)
+		}
+	}
+
+	refunded := c.RefundedAmount + amount
+	status := models.ChargeStatusPartiallyRefunded
+	if refunded == c.Amount {
+		status = models.ChargeStatusRefunded
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	number, err := nextCreditNoteNumber(tx, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO credit_notes (number, charge_id, user_id, amount, reason, provider_refund_id, klipp_removed, created_at, credited_amount)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, number, chargeID, userID, amount, strings.TrimSpace(reason), refundID, klipp, now, toCredit)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE charges SET refunded_amount = ?, status = ? WHERE id = ?", refunded, status, chargeID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if klipp > 0 {
+		if _, err := db.addKlippMovement(c.klippekortID.Int64, models.KlippChargeRefunded, -klipp, 0, 0, number, now); err != nil {
+			return err
+		}
+	}
+	if toCredit > 0 {
+		if _, err := db.addCreditMovement(userID, models.CreditRefund, toCredit, chargeID, number, now); err != nil {
+			return err
+		}
+	}
+
+	details := fmt.Sprintf("%s: %.2f kr av %s", number, float64(amount)/100, c.Description)
+	if klipp > 0 {
+		details += fmt.Sprintf(", %d klipp fjernet", klipp)
+	}
+	if err := db.recordAdminAction(userID, models.AdminActionRefundCharge, reason, details, now); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("%.2f kr for %s er betalt tilbake til betalingsmetoden din (kreditnota %s).", float64(amount)/100, c.Description, number)
+	if toCredit > 0 {
+		message = fmt.Sprintf("%.2f kr for %s er refundert (kreditnota %s), %.2f kr av dem er satt tilbake som tilgodehavende.",
+			float64(amount)/100, c.Description, number, float64(toCredit)/100)
+	}
+	if err := db.CreateNotification(userID, "charge_refunded", "Du har fått en refusjon", message); err != nil {
+		log.Printf("Could not notify user %d about refund: %v", userID, err)
+	}
+	return nil
+}
+
+// GetCreditNotes returns the credit notes written for a member's charges, newest first
+func (db *Database) GetCreditNotes(userID int64) ([]models.CreditNote, error) {
+	rows, err := db.Conn.Query(`SELECT id, number, charge_id, user_id, amount, reason, provider_refund_id, klipp_removed, created_at,
+		COALESCE(credited_amount, 0)
+		FROM credit_notes WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanCreditNotes(rows)
+}
+
+// scanCreditNotes reads credit notes selected with all their columns
+func scanCreditNotes(rows *sql.Rows) ([]models.CreditNote, error) {
+	defer rows.Close()
+
+	var notes []models.CreditNote
+	for rows.Next() {
+		var n models.CreditNote
+		if err := rows.Scan(&n.ID, &n.Number, &n.ChargeID, &n.UserID, &n.Amount, &n.Reason, &n.ProviderRefundID,
+			&n.KlippRemoved, &n.CreatedAt, &n.CreditedAmount); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
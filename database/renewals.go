@@ -0,0 +1,100 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// RunMembershipRenewals bills active memberships whose renewal date has passed, at the price
+// version valid for each member or their campaign price, less any coupon still running,
+// and moves the renewal date a month ahead. An employee's company share goes on the company's invoice.
+// Plan changes scheduled for the next renewal are applied before it is billed.
+// Fixed-term plans only renew when the member has chosen a plan to continue on.
+// Returns the number of renewals charged.
+func (db *Database) RunMembershipRenewals(now time.Time) (int, error) {
+	rows, err := db.Conn.Query(`
+		SELECT um.id, um.user_id, um.membership_id, um.start_date, um.plan_since, um.renewal_date, um.scheduled_membership_id, m.name
+		FROM user_memberships um
+		JOIN memberships m ON um.membership_id = m.id
+		WHERE um.status = 'active' AND um.renewal_date <= ?
+		AND (m.duration_days = 0 OR um.scheduled_membership_id IS NOT NULL)`, now.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+
+	type dueRenewal struct {
+		userMembershipID int64
+		userID           int64
+		membershipID     int64
+		planSince        time.Time
+		renewalDate      time.Time
+		scheduledID      sql.NullInt64
+		membershipName   string
+	}
+	var due []dueRenewal
+	for rows.Next() {
+		var r dueRenewal
+		var startDate time.Time
+		var planSince sql.NullTime
+		if err := rows.Scan(&r.userMembershipID, &r.userID, &r.membershipID, &startDate, &planSince, &r.renewalDate, &r.scheduledID, &r.membershipName); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		r.planSince = planSinceOrStart(startDate, planSince)
+		due = append(due, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	charged := 0
+	for _, r := range due {
+		if r.scheduledID.Valid {
+			if err := db.applyScheduledMembershipChange(r.userID, r.scheduledID.Int64, r.renewalDate); err != nil {
+				return charged, err
+			}
+			r.membershipID = r.scheduledID.Int64
+			r.planSince = r.renewalDate
+			if err := db.Conn.QueryRow("SELECT name FROM memberships WHERE id = ?", r.membershipID).Scan(&r.membershipName); err != nil {
+				return charged, err
+			}
+
+			message := fmt.Sprintf("Medlemskapet ditt er byttet til %s fra fornyelsen %s.", r.membershipName, r.renewalDate.Format("02.01.2006"))
+			if err := db.CreateNotification(r.userID, "membership_change", "Medlemskapet ditt er byttet", message); err != nil {
+				log.Printf("Could not notify user %d about membership change: %v", r.userID, err)
+			}
+		}
+
+		// Catch up on every missed period, each at the price valid on its renewal date
+		for renewalDate := r.renewalDate; !renewalDate.After(now); renewalDate = renewalDate.AddDate(0, 1, 0) {
+			price, err := db.memberPlanPrice(r.userID, r.userMembershipID, r.membershipID, r.planSince, renewalDate)
+			if err != nil {
+				return charged, err
+			}
+			price, discount, err := db.applyMembershipCoupon(r.userMembershipID, renewalDate, price)
+			if err != nil {
+				return charged, err
+			}
+
+			description := fmt.Sprintf("Medlemskap: %s (%s)", r.membershipName, renewalDate.Format("01.2006"))
+			if err := db.billMembership(r.userID, r.membershipID, price, description); err != nil {
+				log.Printf("Could not bill renewal for user %d: %v", r.userID, err)
+				break
+			}
+			if err := db.recordMembershipCouponDiscount(discount); err != nil {
+				return charged, err
+			}
+
+			_, err = db.Conn.Exec("UPDATE user_memberships SET last_billed = ?, renewal_date = ? WHERE id = ?",
+				renewalDate.Format("2006-01-02"), renewalDate.AddDate(0, 1, 0).Format("2006-01-02"), r.userMembershipID)
+			if err != nil {
+				return charged, err
+			}
+			charged++
+		}
+	}
+	return charged, nil
+}
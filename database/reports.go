@@ -0,0 +1,403 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportWeekdays are the weekday keys of time slots, as in the timetable translations
+var reportWeekdays = [...]string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// revenueStatuses are the charge statuses that were paid, less what was refunded
+const revenueStatuses = `('succeeded', 'refunded', 'partially_refunded', 'disputed')`
+
+// membershipPeriods is every period a member has been on a plan, from the membership history and
+// user memberships created outside the app without history, as in GetMembershipHistory
+const membershipPeriods = `
+	SELECT user_id, user_membership_id, membership_id, date(started_at) AS started_at, date(ended_at) AS ended_at
+	FROM membership_history
+	UNION ALL
+	SELECT user_id, id, membership_id, date(start_date), ` + endedAtFromUserMembership + `
+	FROM user_memberships WHERE id NOT IN (SELECT user_membership_id FROM membership_history)`
+
+// migrateReports registers when members check in to classes in the studio, and adds the
+// indexes the KPI report reads by
+func migrateReports(db *sql.DB) error {
+	if _, err := db.Exec("ALTER TABLE event_signups ADD COLUMN checked_in_at DATETIME"); err != nil && !isColumnExistsError(err) {
+		return err
+	}
+
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_events_start_time ON events(start_time)",
+		"CREATE INDEX IF NOT EXISTS idx_event_signups_event ON event_signups(event_id)",
+		"CREATE INDEX IF NOT EXISTS idx_charges_type_date ON charges(type, charge_date)",
+		"CREATE INDEX IF NOT EXISTS idx_membership_history_user ON membership_history(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_users_created_at ON users(created_at)",
+	}
+	for _, index := range indexes {
+		if _, err := db.Exec(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetCheckedIn registers whether a member who signed up for a class turned up in the studio.
+// Signups to a class count towards the no-show rate once anyone has checked in to it.
+func (db *Database) SetCheckedIn(userID, eventID int64, checkedIn bool, now time.Time) error {
+	var at interface{}
+	if checkedIn {
+		at = now
+	}
+	result, err := db.Conn.Exec("UPDATE event_signups SET checked_in_at = ? WHERE user_id = ? AND event_id = ?", at, userID, eventID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("medlemmet er ikke påmeldt denne timen")
+	}
+	return nil
+}
+
+// GetEventParticipants returns the members signed up for a class by name, with whether they have checked in
+func (db *Database) GetEventParticipants(eventID int64) ([]models.EventParticipant, error) {
+	rows, err := db.Conn.Query(`
+		SELECT u.id, u.name, u.email, COALESCE(s.attendance_mode, ''), s.checked_in_at
+		FROM event_signups s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.event_id = ?
+		ORDER BY u.name`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var participants []models.EventParticipant
+	for rows.Next() {
+		var participant models.EventParticipant
+		var checkedInAt sql.NullTime
+		if err := rows.Scan(&participant.UserID, &participant.Name, &participant.Email, &participant.AttendanceMode, &checkedInAt); err != nil {
+			return nil, err
+		}
+		if checkedInAt.Valid {
+			participant.CheckedInAt = &checkedInAt.Time
+		}
+		participants = append(participants, participant)
+	}
+	return participants, rows.Err()
+}
+
+// GetKPIReport returns the membership, revenue and class figures from one date up to another.
+// Members and MRR are counted at the end of the period, or now if it has not ended. Outstanding
+// klipp are always counted now, since used klipp are not kept per date.
+func (db *Database) GetKPIReport(from, to, now time.Time) (*models.KPIReport, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("til-datoen må være etter fra-datoen")
+	}
+	report := &models.KPIReport{From: from, To: to, AsOf: to.AddDate(0, 0, -1)}
+	if now.Before(report.AsOf) {
+		report.AsOf = now
+	}
+
+	if err := db.addPlanMembers(report); err != nil {
+		return nil, err
+	}
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location()); month.Before(to); month = month.AddDate(0, 1, 0) {
+		start, end := month, month.AddDate(0, 1, 0)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		kpi, err := db.monthlyKPI(start, end)
+		if err != nil {
+			return nil, err
+		}
+		kpi.Month = month
+		report.Months = append(report.Months, *kpi)
+	}
+	if err := db.addKlippekortSales(report, now); err != nil {
+		return nil, err
+	}
+	if err := db.addClassUtilisation(report, now); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// addPlanMembers counts the members on each plan at the end of the report and what they pay a
+// month, priced with memberPlanPrice as billing prices them. Trials are not recurring revenue.
+func (db *Database) addPlanMembers(report *models.KPIReport) error {
+	asOf := report.AsOf.Format("2006-01-02")
+	rows, err := db.Conn.Query(`
+		WITH periods AS (`+membershipPeriods+`)
+		SELECT p.user_id, COALESCE(p.user_membership_id, 0), m.id, m.name, COALESCE(m.is_trial, FALSE), p.started_at,
+		       um.membership_id, um.start_date, um.plan_since
+		FROM periods p
+		JOIN memberships m ON p.membership_id = m.id
+		LEFT JOIN user_memberships um ON um.id = p.user_membership_id
+		WHERE p.started_at <= ? AND (p.ended_at IS NULL OR p.ended_at > ?)`, asOf, asOf)
+	if err != nil {
+		return err
+	}
+
+	type memberPlan struct {
+		userID, userMembershipID, membershipID int64
+		isTrial                                bool
+		planSince                              time.Time
+	}
+	var running []memberPlan
+	plans := map[int64]*models.PlanMembers{}
+	for rows.Next() {
+		var member memberPlan
+		var name, startedAt string
+		var currentPlan sql.NullInt64
+		var startDate, planSince sql.NullTime
+		if err := rows.Scan(&member.userID, &member.userMembershipID, &member.membershipID, &name, &member.isTrial, &startedAt,
+			&currentPlan, &startDate, &planSince); err != nil {
+			rows.Close()
+			return err
+		}
+		// Prices are grandfathered from when the member got the plan, which the period only tells
+		// if the membership has since moved on to another plan
+		member.planSince, err = time.ParseInLocation("2006-01-02", startedAt, report.AsOf.Location())
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if currentPlan.Valid && currentPlan.Int64 == member.membershipID && startDate.Valid {
+			member.planSince = planSinceOrStart(startDate.Time, planSince)
+		}
+		running = append(running, member)
+		if plans[member.membershipID] == nil {
+			plans[member.membershipID] = &models.PlanMembers{MembershipID: int(member.membershipID), Name: name}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	counted := map[[2]int64]bool{}
+	for _, member := range running {
+		key := [2]int64{member.membershipID, member.userID}
+		if counted[key] {
+			continue
+		}
+		counted[key] = true
+
+		plan := plans[member.membershipID]
+		plan.Members++
+		if member.isTrial {
+			continue
+		}
+		price, err := db.memberPlanPrice(member.userID, member.userMembershipID, member.membershipID, member.planSince, report.AsOf)
+		if err != nil {
+			return err
+		}
+		plan.MRR += price
+	}
+
+	for _, plan := range plans {
+		report.MembersByPlan = append(report.MembersByPlan, *plan)
+		report.ActiveMembers += plan.Members
+		report.MRR += plan.MRR
+	}
+	sort.Slice(report.MembersByPlan, func(i, j int) bool {
+		if report.MembersByPlan[i].Members != report.MembersByPlan[j].Members {
+			return report.MembersByPlan[i].Members > report.MembersByPlan[j].Members
+		}
+		return report.MembersByPlan[i].Name < report.MembersByPlan[j].Name
+	})
+	return nil
+}
+
+// monthlyKPI counts the member movement and revenue from one date up to another. Members who
+// change plan are neither new nor churned.
+func (db *Database) monthlyKPI(start, end time.Time) (*models.MonthlyKPI, error) {
+	startDate, endDate := start.Format("2006-01-02"), end.Format("2006-01-02")
+	kpi := &models.MonthlyKPI{}
+	err := db.Conn.QueryRow(`
+		WITH periods AS (`+membershipPeriods+`)
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE created_at >= ? AND created_at < ?),
+			(SELECT COUNT(DISTINCT p.user_id) FROM periods p WHERE p.started_at >= ? AND p.started_at < ?
+			 AND NOT EXISTS (SELECT 1 FROM periods e WHERE e.user_id = p.user_id AND e.started_at < p.started_at)),
+			(SELECT COUNT(DISTINCT user_id) FROM periods WHERE started_at < ? AND (ended_at IS NULL OR ended_at >= ?)),
+			(SELECT COUNT(*) FROM user_memberships WHERE status = 'cancelled' AND date(end_date) >= ? AND date(end_date) < ?),
+			(SELECT COUNT(DISTINCT p.user_id) FROM periods p WHERE p.ended_at >= ? AND p.ended_at < ?
+			 AND NOT EXISTS (SELECT 1 FROM periods n WHERE n.user_id = p.user_id AND n.started_at < ? AND (n.ended_at IS NULL OR n.ended_at >= ?))),
+			(SELECT COALESCE(SUM(amount - COALESCE(refunded_amount, 0)), 0) FROM charges
+			 WHERE type = 'medlemskap' AND status IN `+revenueStatuses+` AND charge_date >= ? AND charge_date < ?),
+			(SELECT COALESCE(SUM(amount - COALESCE(refunded_amount, 0)), 0) FROM charges
+			 WHERE type = 'klippekort' AND status IN `+revenueStatuses+` AND charge_date >= ? AND charge_date < ?)`,
+		// Users are created with the UTC time of the database
+		start.UTC(), end.UTC(),
+		startDate, endDate,
+		startDate, startDate,
+		startDate, endDate,
+		startDate, endDate, endDate, endDate,
+		start, end,
+		start, end,
+	).Scan(&kpi.Signups, &kpi.NewMembers, &kpi.ActiveAtStart, &kpi.Cancellations, &kpi.Churned,
+		&kpi.MembershipRevenue, &kpi.KlippekortRevenue)
+	if err != nil {
+		return nil, err
+	}
+	return kpi, nil
+}
+
+// addKlippekortSales sums the klippekort sold in the report per package, and the klipp members
+// have left now with what they paid for them
+func (db *Database) addKlippekortSales(report *models.KPIReport, now time.Time) error {
+	rows, err := db.Conn.Query(`
+		SELECT COALESCE(kp.name, c.description),
+		       SUM(CASE WHEN c.status = 'refunded' THEN 0 ELSE 1 END),
+		       COALESCE(SUM(CASE WHEN c.status = 'refunded' THEN 0 ELSE c.klipp END), 0),
+		       SUM(c.amount - COALESCE(c.refunded_amount, 0))
+		FROM charges c
+		LEFT JOIN user_klippekort uk ON c.user_klippekort_id = uk.id
+		LEFT JOIN klippekort_packages kp ON uk.package_id = kp.id
+		WHERE c.type = 'klippekort' AND c.amount > 0 AND c.status IN `+revenueStatuses+`
+		AND c.charge_date >= ? AND c.charge_date < ?
+		GROUP BY 1
+		ORDER BY 4 DESC, 1`, report.From, report.To)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var sales models.KlippekortSales
+		if err := rows.Scan(&sales.Name, &sales.Sold, &sales.Klipp, &sales.Amount); err != nil {
+			rows.Close()
+			return err
+		}
+		report.KlippekortSales = append(report.KlippekortSales, sales)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	deferred, err := db.GetDeferredRevenue(now)
+	if err != nil {
+		return err
+	}
+	for _, line := range deferred.Lines {
+		if line.ChargeType == "klippekort" {
+			report.OutstandingKlipp += line.Units
+			report.KlippLiability += line.Amount
+		}
+	}
+	return nil
+}
+
+// addClassUtilisation sums how full the classes in the report were per class type, teacher and
+// weekly time slot. Only past classes where attendance was registered count towards no-shows:
+// online signups by joining the stream, studio signups once anyone has checked in to the class.
+func (db *Database) addClassUtilisation(report *models.KPIReport, now time.Time) error {
+	rows, err := db.Conn.Query(`
+		SELECT e.start_time, COALESCE(e.class_type, ''), COALESCE(e.teacher_name, ''), COALESCE(e.capacity, 0),
+		       COALESCE(SUM(CASE WHEN s.id IS NOT NULL AND COALESCE(s.attendance_mode, '') != 'online' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN COALESCE(s.attendance_mode, '') != 'online' AND s.checked_in_at IS NOT NULL THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN s.attendance_mode = 'online' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN s.attendance_mode = 'online' AND s.online_joined_at IS NOT NULL THEN 1 ELSE 0 END), 0)
+		FROM events e
+		LEFT JOIN event_signups s ON s.event_id = e.id
+		WHERE e.start_time >= ? AND e.start_time < ? AND COALESCE(e.cancelled, FALSE) = FALSE
+		GROUP BY e.id
+		ORDER BY e.start_time`, report.From, report.To)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	classTypes := map[string]*models.ClassUtilisation{}
+	teachers := map[string]*models.ClassUtilisation{}
+	timeSlots := map[string]*models.ClassUtilisation{}
+	loc := report.From.Location()
+	for rows.Next() {
+		var start time.Time
+		var classType, teacher string
+		var capacity, studio, checkedIn, online, joined int
+		if err := rows.Scan(&start, &classType, &teacher, &capacity, &studio, &checkedIn, &online, &joined); err != nil {
+			return err
+		}
+
+		class := models.ClassUtilisation{Classes: 1}
+		if capacity > 0 {
+			class.Capacity, class.Booked = capacity, studio
+		}
+		if start.Before(now) {
+			if checkedIn > 0 {
+				class.Tracked += studio
+				class.NoShows += studio - checkedIn
+			}
+			class.Tracked += online
+			class.NoShows += online - joined
+		}
+
+		local := start.In(loc)
+		weekday := reportWeekdays[local.Weekday()]
+		hour := fmt.Sprintf("%02d:00", local.Hour())
+		addUtilisation(classTypes, classType, "", class)
+		addUtilisation(teachers, teacher, "", class)
+		addUtilisation(timeSlots, weekday+" "+hour, weekday, class)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	report.ClassTypes = sortedUtilisation(classTypes)
+	report.Teachers = sortedUtilisation(teachers)
+	report.TimeSlots = sortedUtilisation(timeSlots)
+	// Time slots run through the week from Monday
+	sort.SliceStable(report.TimeSlots, func(i, j int) bool {
+		a, b := report.TimeSlots[i], report.TimeSlots[j]
+		if a.Weekday != b.Weekday {
+			return weekdayOrder(a.Weekday) < weekdayOrder(b.Weekday)
+		}
+		return a.Key < b.Key
+	})
+	return nil
+}
+
+// addUtilisation adds a class to the row with its key. Time slot keys are the hour after the weekday.
+func addUtilisation(rows map[string]*models.ClassUtilisation, key, weekday string, class models.ClassUtilisation) {
+	row, ok := rows[key]
+	if !ok {
+		row = &models.ClassUtilisation{Key: strings.TrimPrefix(key, weekday+" "), Weekday: weekday}
+		rows[key] = row
+	}
+	row.Classes += class.Classes
+	row.Capacity += class.Capacity
+	row.Booked += class.Booked
+	row.Tracked += class.Tracked
+	row.NoShows += class.NoShows
+}
+
+// sortedUtilisation returns the rows ordered by key
+func sortedUtilisation(rows map[string]*models.ClassUtilisation) []models.ClassUtilisation {
+	sorted := make([]models.ClassUtilisation, 0, len(rows))
+	for _, row := range rows {
+		sorted = append(sorted, *row)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+// weekdayOrder returns the position of a weekday key in a week starting on Monday
+func weekdayOrder(weekday string) int {
+	for i, name := range reportWeekdays {
+		if name == weekday {
+			return (i + 6) % 7
+		}
+	}
+	return 7
+}
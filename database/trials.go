@@ -0,0 +1,189 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"kjernekraft/models"
+	"log"
+	"time"
+)
+
+// TrialReminderDays is how long before a fixed-term membership ends the member is reminded
+const TrialReminderDays = 3
+
+// migrateTrials adds fixed-term plans, turns the two week trial into one and tracks end reminders
+func migrateTrials(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE memberships ADD COLUMN duration_days INTEGER DEFAULT 0")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	if err == nil {
+		// The trial was seeded as an ordinary plan, so its members renewed like everyone else
+		seeds := []string{
+			"ALTER TABLE memberships ADD COLUMN is_trial BOOLEAN DEFAULT FALSE",
+			"UPDATE memberships SET duration_days = 14, is_trial = TRUE WHERE name = '2-ukers prøve'",
+			`UPDATE user_memberships SET renewal_date = date(start_date, '+14 days'), end_date = date(start_date, '+14 days')
+			 WHERE status IN ('active', 'paused', 'freeze_requested')
+			 AND membership_id IN (SELECT id FROM memberships WHERE is_trial = TRUE)`,
+		}
+		for _, seed := range seeds {
+			if _, err := db.Exec(seed); err != nil && !isColumnExistsError(err) {
+				return err
+			}
+		}
+	}
+
+	_, err = db.Exec("ALTER TABLE user_memberships ADD COLUMN end_reminder_sent_at DATETIME")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	return nil
+}
+
+// MembershipTermEnd returns when a membership started on a date ends by itself.
+// Plans without a duration run until cancelled and return false.
+func MembershipTermEnd(membership models.Membership, start time.Time) (time.Time, bool) {
+	if membership.DurationDays <= 0 {
+		return time.Time{}, false
+	}
+	return start.AddDate(0, 0, membership.DurationDays), true
+}
+
+// HasUsedTrial checks whether a member has ever been on a trial plan
+func (db *Database) HasUsedTrial(userID int64) (bool, error) {
+	var count int
+	err := db.Conn.QueryRow(`
+		SELECT COUNT(*) FROM memberships m
+		WHERE m.is_trial = TRUE AND (
+			m.id IN (SELECT membership_id FROM membership_history WHERE user_id = ?)
+			OR m.id IN (SELECT membership_id FROM user_memberships WHERE user_id = ?)
+		)`, userID, userID).Scan(&count)
+	return count > 0, err
+}
+
+// GetTrialConversionOptions returns the plans a trial can continue on
+func (db *Database) GetTrialConversionOptions() ([]models.Membership, error) {
+	memberships, err := db.GetAllMemberships()
+	if err != nil {
+		return nil, err
+	}
+	var options []models.Membership
+	for _, m := range memberships {
+		if !m.IsTrial && !m.IsSpecialOffer && m.DurationDays == 0 {
+			options = append(options, m)
+		}
+	}
+	return options, nil
+}
+
+// SetTrialConversion lets a member on a fixed-term plan choose the plan to continue on when it ends.
+// The choice is stored as a scheduled change, so it is applied and billed by the renewal job.
+func (db *Database) SetTrialConversion(userID, membershipID int64, now time.Time) error {
+	current, err := db.GetUserMembership(userID)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.DurationDays == 0 {
+		return fmt.Errorf("bruker har ikke et prøvemedlemskap")
+	}
+
+	options, err := db.GetTrialConversionOptions()
+	if err != nil {
+		return err
+	}
+	var target *models.Membership
+	for i := range options {
+		if int64(options[i].ID) == membershipID {
+			target = &options[i]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("kan ikke fortsette på dette medlemskapet etter prøveperioden")
+	}
+	if err := db.checkDiscountEligibility(userID, target, now); err != nil {
+		return err
+	}
+	return db.ScheduleMembershipChange(userID, membershipID)
+}
+
+// EndFixedTermMemberships reminds members whose fixed-term plan ends soon and ends the plans that
+// have run out. Members who chose a plan to continue on are left for the renewal job, which moves
+// them over and bills the new plan. Returns the number of memberships reminded or ended.
+func (db *Database) EndFixedTermMemberships(now time.Time) (int, error) {
+	today := now.Format("2006-01-02")
+	rows, err := db.Conn.Query(`
+		SELECT um.id, um.user_id, um.renewal_date, um.scheduled_membership_id, um.end_reminder_sent_at IS NOT NULL, m.name
+		FROM user_memberships um
+		JOIN memberships m ON um.membership_id = m.id
+		WHERE m.duration_days > 0 AND um.status IN ('active', 'paused', 'freeze_requested')
+		AND um.renewal_date <= ?`, now.AddDate(0, 0, TrialReminderDays).Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+
+	type fixedTerm struct {
+		userMembershipID int64
+		userID           int64
+		endsAt           time.Time
+		convertTo        sql.NullInt64
+		reminded         bool
+		membershipName   string
+	}
+	var due []fixedTerm
+	for rows.Next() {
+		var f fixedTerm
+		if err := rows.Scan(&f.userMembershipID, &f.userID, &f.endsAt, &f.convertTo, &f.reminded, &f.membershipName); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, f := range due {
+		if f.endsAt.Format("2006-01-02") > today {
+			if f.reminded {
+				continue
+			}
+			message := fmt.Sprintf("%s slutter %s. Velg et medlemskap å fortsette på, ellers avsluttes medlemskapet.", f.membershipName, f.endsAt.Format("02.01.2006"))
+			if f.convertTo.Valid {
+				var nextName string
+				if err := db.Conn.QueryRow("SELECT name FROM memberships WHERE id = ?", f.convertTo.Int64).Scan(&nextName); err != nil {
+					return processed, err
+				}
+				message = fmt.Sprintf("%s slutter %s, og du fortsetter da på %s.", f.membershipName, f.endsAt.Format("02.01.2006"), nextName)
+			}
+			if err := db.CreateNotification(f.userID, "trial_ending", "Prøveperioden slutter snart", message); err != nil {
+				log.Printf("Could not remind user %d about trial ending: %v", f.userID, err)
+			}
+			if _, err := db.Conn.Exec("UPDATE user_memberships SET end_reminder_sent_at = ? WHERE id = ?", now, f.userMembershipID); err != nil {
+				return processed, err
+			}
+			processed++
+			continue
+		}
+
+		// Continuing members are moved over and billed by the renewal job
+		if f.convertTo.Valid {
+			continue
+		}
+
+		if _, err := db.Conn.Exec("UPDATE user_memberships SET status = 'expired', end_date = ? WHERE id = ?",
+			f.endsAt.Format("2006-01-02"), f.userMembershipID); err != nil {
+			return processed, err
+		}
+		if err := db.endMembershipPeriod(f.userID, f.endsAt); err != nil {
+			return processed, err
+		}
+		message := fmt.Sprintf("%s er avsluttet. Du kan når som helst velge et nytt medlemskap.", f.membershipName)
+		if err := db.CreateNotification(f.userID, "trial_ended", "Prøveperioden er over", message); err != nil {
+			log.Printf("Could not notify user %d about trial ending: %v", f.userID, err)
+		}
+		processed++
+	}
+	return processed, nil
+}
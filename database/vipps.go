@@ -0,0 +1,469 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"kjernekraft/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errPaymentDeclined marks a charge that was recorded as failed because the payment was declined
+var errPaymentDeclined = errors.New("betalingen med Vipps feilet")
+
+// vippsAgreementProduct is the name members see on the recurring agreement in the Vipps app
+const vippsAgreementProduct = "Kjernekraft medlemskap"
+
+// migrateVipps lets a payment method be Vipps as well as a card and marks the default one,
+// and lets the charge ledger record which type of payment method was charged
+func migrateVipps(db *sql.DB) error {
+	columns := []string{
+		"ALTER TABLE payment_methods ADD COLUMN type TEXT DEFAULT 'card'",
+		"ALTER TABLE payment_methods ADD COLUMN brand TEXT DEFAULT ''",
+		"ALTER TABLE payment_methods ADD COLUMN last4 TEXT DEFAULT ''",
+		"ALTER TABLE payment_methods ADD COLUMN phone_number TEXT DEFAULT ''",
+		"ALTER TABLE payment_methods ADD COLUMN agreement_id TEXT DEFAULT ''",
+		"ALTER TABLE payment_methods ADD COLUMN agreement_status TEXT DEFAULT ''",
+		"ALTER TABLE payment_methods ADD COLUMN is_default BOOLEAN DEFAULT FALSE",
+		"ALTER TABLE payment_methods ADD COLUMN created_at DATETIME",
+		"ALTER TABLE charges ADD COLUMN payment_method_type TEXT DEFAULT ''",
+		"ALTER TABLE charges ADD COLUMN provider_reference TEXT DEFAULT ''",
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+
+	backfill := []string{
+		// The simulated default cards get the card details they were shown with
+		`UPDATE payment_methods SET brand = 'visa', last4 = '4242'
+			WHERE provider = 'stripe' AND provider_id LIKE 'pm_default_visa_%' AND brand = ''`,
+		`UPDATE payment_methods SET brand = 'mastercard', last4 = '5555'
+			WHERE provider = 'stripe' AND provider_id LIKE 'pm_default_mastercard_%' AND brand = ''`,
+		// Charges used to go to a member's first payment method
+		`UPDATE payment_methods SET is_default = TRUE
+			WHERE id IN (SELECT MIN(id) FROM payment_methods GROUP BY user_id)
+			AND NOT EXISTS (SELECT 1 FROM payment_methods d WHERE d.user_id = payment_methods.user_id AND d.is_default)`,
+		`UPDATE charges SET payment_method_type = COALESCE((SELECT pm.type FROM payment_methods pm WHERE pm.id = charges.payment_method_id), '')
+			WHERE payment_method_type = '' AND payment_method_id IS NOT NULL`,
+	}
+	for _, query := range backfill {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VippsClient is the part of the Vipps ePayment and Recurring APIs the studio uses. Amounts
+// are in øre.
+type VippsClient interface {
+	// CreatePayment asks the member to pay a one-off amount and returns the payment's reference
+	CreatePayment(phoneNumber string, amount int, description string) (string, error)
+	// CapturePayment takes the money of a payment the member has approved
+	CapturePayment(reference string, amount int) error
+	// CreateAgreement asks the member to approve a recurring agreement and returns its ID and status
+	CreateAgreement(phoneNumber, productName string) (string, string, error)
+	// GetAgreement returns the status of an agreement
+	GetAgreement(agreementID string) (string, error)
+	// StopAgreement stops an agreement so it can not be charged again
+	StopAgreement(agreementID string) error
+	// CreateCharge charges an active agreement and returns the charge's reference
+	CreateCharge(agreementID string, amount int, description string) (string, error)
+	// Refund pays back part or all of a payment or agreement charge and returns the refund's ID
+	Refund(reference string, amount int) (string, error)
+}
+
+// mockVippsTransaction is a one-off payment or an agreement charge in the mock
+type mockVippsTransaction struct {
+	amount   int
+	captured int
+	refunded int
+}
+
+// MockVipps is an in-process stand-in for the Vipps APIs, used in development and tests. Payments
+// are approved at once unless the phone number is declined, and agreements are approved at once
+// unless ManualApproval is set.
+type MockVipps struct {
+	// ManualApproval leaves new agreements pending until ApproveAgreement is called
+	ManualApproval bool
+
+	mu           sync.Mutex
+	next         int
+	declined     map[string]bool
+	agreements   map[string]string
+	transactions map[string]*mockVippsTransaction
+}
+
+// NewMockVipps returns an empty mock
+func NewMockVipps() *MockVipps {
+	return &MockVipps{
+		declined:     map[string]bool{},
+		agreements:   map[string]string{},
+		transactions: map[string]*mockVippsTransaction{},
+	}
+}
+
+// Decline makes the mock reject every payment from a phone number, as when the member
+// rejects it in the app or the card behind it is declined
+func (m *MockVipps) Decline(phoneNumber string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.declined[phoneNumber] = true
+}
+
+// ApproveAgreement approves a pending agreement as the member would in the app
+func (m *MockVipps) ApproveAgreement(agreementID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.agreements[agreementID] != models.VippsAgreementPending {
+		return fmt.Errorf("avtalen venter ikke på godkjenning")
+	}
+	m.agreements[agreementID] = models.VippsAgreementActive
+	return nil
+}
+
+// Captured returns how much of a payment or agreement charge has been captured and refunded
+func (m *MockVipps) Captured(reference string) (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.transactions[reference]; ok {
+		return t.captured, t.refunded
+	}
+	return 0, 0
+}
+
+func (m *MockVipps) reference(prefix string) string {
+	m.next++
+	return fmt.Sprintf("%s-mock-%d", prefix, m.next)
+}
+
+// CreatePayment approves the payment at once unless the phone number is declined
+func (m *MockVipps) CreatePayment(phoneNumber string, amount int, description string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if amount <= 0 {
+		return "", fmt.Errorf("beløpet må være større enn 0")
+	}
+	if m.declined[phoneNumber] {
+		return "", fmt.Errorf("betalingen ble avvist i Vipps")
+	}
+	reference := m.reference("vipps-payment")
+	m.transactions[reference] = &mockVippsTransaction{amount: amount}
+	return reference, nil
+}
+
+// CapturePayment captures an approved payment
+func (m *MockVipps) CapturePayment(reference string, amount int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.transactions[reference]
+	if !ok {
+		return fmt.Errorf("Vipps-betaling %s finnes ikke", reference)
+	}
+	if t.captured+amount > t.amount {
+		return fmt.Errorf("kan ikke trekke mer enn det godkjente beløpet")
+	}
+	t.captured += amount
+	return nil
+}
+
+// CreateAgreement creates an agreement, active at once unless ManualApproval is set
+func (m *MockVipps) CreateAgreement(phoneNumber, productName string) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.declined[phoneNumber] {
+		return "", "", fmt.Errorf("avtalen ble avvist i Vipps")
+	}
+	agreementID := m.reference("agr")
+	status := models.VippsAgreementActive
+	if m.ManualApproval {
+		status = models.VippsAgreementPending
+	}
+	m.agreements[agreementID] = status
+	return agreementID, status, nil
+}
+
+// GetAgreement returns the status of an agreement
+func (m *MockVipps) GetAgreement(agreementID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.agreements[agreementID]
+	if !ok {
+		// The mock keeps nothing between restarts, so agreements made before are taken to be active
+		m.agreements[agreementID] = models.VippsAgreementActive
+		return models.VippsAgreementActive, nil
+	}
+	return status, nil
+}
+
+// StopAgreement stops an agreement
+func (m *MockVipps) StopAgreement(agreementID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.agreements[agreementID]; ok {
+		m.agreements[agreementID] = models.VippsAgreementStopped
+	}
+	return nil
+}
+
+// CreateCharge charges an active agreement and captures the charge at once
+func (m *MockVipps) CreateCharge(agreementID string, amount int, description string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.agreements[agreementID] != models.VippsAgreementActive {
+		return "", fmt.Errorf("Vipps-avtalen er ikke aktiv")
+	}
+	if amount <= 0 {
+		return "", fmt.Errorf("beløpet må være større enn 0")
+	}
+	reference := m.reference("vipps-charge")
+	m.transactions[reference] = &mockVippsTransaction{amount: amount, captured: amount}
+	return reference, nil
+}
+
+// Refund pays back captured money. Payments made before a restart are refunded as asked.
+func (m *MockVipps) Refund(reference string, amount int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if reference == "" {
+		return "", fmt.Errorf("Vipps-referanse mangler")
+	}
+	t, ok := m.transactions[reference]
+	if !ok {
+		return m.reference("vipps-refund"), nil
+	}
+	if t.refunded+amount > t.captured {
+		return "", fmt.Errorf("kan ikke refundere mer enn det som er trukket")
+	}
+	t.refunded += amount
+	return m.reference("vipps-refund"), nil
+}
+
+// defaultVipps is the mock used when no Vipps client is set. It is shared so payments made
+// through one Database can be refunded through another in the same process.
+var defaultVipps = NewMockVipps()
+
+// vipps returns the Vipps client set on the database, or the in-process mock
+func (db *Database) vipps() VippsClient {
+	if db.Vipps != nil {
+		return db.Vipps
+	}
+	return defaultVipps
+}
+
+// vippsRefunds pays money back through Vipps
+type vippsRefunds struct {
+	client VippsClient
+}
+
+// Refund pays back part or all of the Vipps payment or agreement charge behind a charge
+func (v vippsRefunds) Refund(charge models.Charge, amount int) (string, error) {
+	return v.client.Refund(charge.ProviderReference, amount)
+}
+
+// refundProvider returns who pays back a charge, Vipps for charges paid with Vipps
+func (db *Database) refundProvider(charge models.Charge) PaymentProvider {
+	if charge.PaymentMethodType == models.PaymentMethodTypeVipps {
+		return vippsRefunds{db.vipps()}
+	}
+	return db.paymentProvider()
+}
+
+// normalizeVippsPhoneNumber returns a Norwegian mobile number as its 8 digits
+func normalizeVippsPhoneNumber(phoneNumber string) (string, error) {
+	number := strings.NewReplacer(" ", "", "-", "", ".", "").Replace(strings.TrimSpace(phoneNumber))
+	number = strings.TrimPrefix(number, "+47")
+	if len(number) == 12 {
+		number = strings.TrimPrefix(number, "0047")
+	}
+	if len(number) != 8 || strings.Trim(number, "0123456789") != "" || (number[0] != '4' && number[0] != '9') {
+		return "", fmt.Errorf("ugyldig mobilnummer for Vipps")
+	}
+	return number, nil
+}
+
+// AddVippsPaymentMethod adds Vipps as a member's payment method and asks them to approve a
+// recurring agreement for their membership. Vipps becomes the default payment method, since
+// adding it is choosing to pay with it.
+func (db *Database) AddVippsPaymentMethod(userID int64, phoneNumber string, now time.Time) (int64, error) {
+	number, err := normalizeVippsPhoneNumber(phoneNumber)
+	if err != nil {
+		return 0, err
+	}
+	var exists int
+	err = db.Conn.QueryRow("SELECT COUNT(*) FROM payment_methods WHERE user_id = ? AND type = ? AND phone_number = ?",
+		userID, models.PaymentMethodTypeVipps, number).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if exists > 0 {
+		return 0, fmt.Errorf("Vipps med dette nummeret er allerede lagt til")
+	}
+
+	agreementID, status, err := db.vipps().CreateAgreement(number, vippsAgreementProduct)
+	if err != nil {
+		return 0, fmt.Errorf("kunne ikke opprette Vipps-avtale: %v", err)
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE payment_methods SET is_default = FALSE WHERE user_id = ?", userID); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(`INSERT INTO payment_methods (user_id, provider, provider_id, type, phone_number, agreement_id, agreement_status, is_default, created_at)
+		VALUES (?, 'vipps', ?, ?, ?, ?, ?, TRUE, ?)`, userID, agreementID, models.PaymentMethodTypeVipps, number, agreementID, status, now)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// GetPaymentMethods returns a member's payment methods, the default one first
+func (db *Database) GetPaymentMethods(userID int64) ([]models.PaymentMethod, error) {
+	rows, err := db.Conn.Query(`SELECT id, user_id, provider_id, COALESCE(type, 'card'), COALESCE(brand, ''), COALESCE(last4, ''),
+		COALESCE(expiry_month, 0), COALESCE(expiry_year, 0), COALESCE(phone_number, ''), COALESCE(agreement_id, ''),
+		COALESCE(agreement_status, ''), COALESCE(is_default, FALSE), COALESCE(active, TRUE)
+		FROM payment_methods WHERE user_id = ? ORDER BY is_default DESC, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var methods []models.PaymentMethod
+	for rows.Next() {
+		var pm models.PaymentMethod
+		if err := rows.Scan(&pm.ID, &pm.UserID, &pm.StripePaymentMethodID, &pm.Type, &pm.Brand, &pm.Last4, &pm.ExpiryMonth,
+			&pm.ExpiryYear, &pm.PhoneNumber, &pm.AgreementID, &pm.AgreementStatus, &pm.IsDefault, &pm.Active); err != nil {
+			return nil, err
+		}
+		methods = append(methods, pm)
+	}
+	return methods, rows.Err()
+}
+
+// getPaymentMethod returns one of a member's payment methods
+func (db *Database) getPaymentMethod(userID, paymentMethodID int64) (*models.PaymentMethod, error) {
+	methods, err := db.GetPaymentMethods(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pm := range methods {
+		if int64(pm.ID) == paymentMethodID {
+			return &pm, nil
+		}
+	}
+	return nil, fmt.Errorf("betalingsmetode ikke funnet")
+}
+
+// defaultPaymentMethod returns the payment method a member's charges go to. Expired cards are
+// never charged.
+func (db *Database) defaultPaymentMethod(userID int64) (*models.PaymentMethod, error) {
+	methods, err := db.GetPaymentMethods(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pm := range methods {
+		if pm.Active {
+			return &pm, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ensureDefaultPaymentMethod makes a member's oldest working payment method the default when
+// none is
+func (db *Database) ensureDefaultPaymentMethod(userID int64) error {
+	_, err := db.Conn.Exec(`UPDATE payment_methods SET is_default = TRUE
+		WHERE id = (SELECT id FROM payment_methods WHERE user_id = ? AND COALESCE(active, TRUE) ORDER BY id LIMIT 1)
+		AND NOT EXISTS (SELECT 1 FROM payment_methods WHERE user_id = ? AND is_default)`, userID, userID)
+	return err
+}
+
+// SetDefaultPaymentMethod makes one of a member's payment methods the one charges go to
+func (db *Database) SetDefaultPaymentMethod(userID, paymentMethodID int64) error {
+	pm, err := db.getPaymentMethod(userID, paymentMethodID)
+	if err != nil {
+		return err
+	}
+	if !pm.Active {
+		return fmt.Errorf("kortet er utløpt")
+	}
+	_, err = db.Conn.Exec("UPDATE payment_methods SET is_default = (id = ?) WHERE user_id = ?", paymentMethodID, userID)
+	return err
+}
+
+// RemovePaymentMethod removes one of a member's payment methods, stopping its Vipps
+// agreement. Charges made to it stay in the ledger without the payment method.
+func (db *Database) RemovePaymentMethod(userID, paymentMethodID int64) error {
+	pm, err := db.getPaymentMethod(userID, paymentMethodID)
+	if err != nil {
+		return err
+	}
+	if pm.Type == models.PaymentMethodTypeVipps && pm.AgreementID != "" && pm.AgreementStatus != models.VippsAgreementStopped {
+		if err := db.vipps().StopAgreement(pm.AgreementID); err != nil {
+			return fmt.Errorf("kunne ikke stoppe Vipps-avtalen: %v", err)
+		}
+	}
+
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"UPDATE charges SET payment_method_id = NULL WHERE payment_method_id = ?",
+		"DELETE FROM user_payment_methods WHERE payment_method_id = ?",
+		"DELETE FROM payment_methods WHERE id = ?",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, paymentMethodID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return db.ensureDefaultPaymentMethod(userID)
+}
+
+// chargeVipps takes an amount with Vipps and returns the Vipps reference. Memberships are
+// charged on the recurring agreement, everything else is a one-off payment.
+func (db *Database) chargeVipps(pm *models.PaymentMethod, amount int, description, chargeType string) (string, error) {
+	client := db.vipps()
+	if chargeType != "medlemskap" {
+		reference, err := client.CreatePayment(pm.PhoneNumber, amount, description)
+		if err != nil {
+			return "", err
+		}
+		return reference, client.CapturePayment(reference, amount)
+	}
+
+	// The member may have approved or stopped the agreement in the app since it was stored
+	status, err := client.GetAgreement(pm.AgreementID)
+	if err != nil {
+		return "", err
+	}
+	if status != pm.AgreementStatus {
+		if _, err := db.Conn.Exec("UPDATE payment_methods SET agreement_status = ? WHERE id = ?", status, pm.ID); err != nil {
+			return "", err
+		}
+		pm.AgreementStatus = status
+	}
+	if status != models.VippsAgreementActive {
+		return "", fmt.Errorf("Vipps-avtalen er ikke godkjent")
+	}
+	return client.CreateCharge(pm.AgreementID, amount, description)
+}
@@ -0,0 +1,364 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kjernekraft/models"
+	"time"
+)
+
+// errWebhookNothingToDo marks an event that needs nothing done, e.g. about a payment method
+// the member has removed
+var errWebhookNothingToDo = errors.New("ingenting å oppdatere")
+
+// WebhookProcessingTimeout is how long an event can be processing before it counts as stuck,
+// e.g. after a restart in the middle of it, and can be processed again
+const WebhookProcessingTimeout = 10 * time.Minute
+
+// migrateWebhooks creates the table payment provider webhook events are stored in, and the
+// card details the events keep up to date
+func migrateWebhooks(db *sql.DB) error {
+	columns := []string{
+		"ALTER TABLE payment_methods ADD COLUMN expiry_month INTEGER DEFAULT 0",
+		"ALTER TABLE payment_methods ADD COLUMN expiry_year INTEGER DEFAULT 0",
+		"ALTER TABLE payment_methods ADD COLUMN active BOOLEAN DEFAULT TRUE",
+	}
+	for _, column := range columns {
+		if _, err := db.Exec(column); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS webhook_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		provider TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'received',
+		error TEXT DEFAULT '',
+		attempts INTEGER DEFAULT 0,
+		received_at DATETIME NOT NULL,
+		processed_at DATETIME,
+		UNIQUE (provider, event_id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE webhook_events ADD COLUMN claimed_at DATETIME")
+	if err != nil && !isColumnExistsError(err) {
+		return err
+	}
+	return nil
+}
+
+// RecordWebhookEvent stores an event delivered by a payment provider and returns it. An event
+// delivered before is not stored again, the stored one is returned with duplicate set.
+func (db *Database) RecordWebhookEvent(event models.WebhookEventPayload, payload []byte, now time.Time) (*models.WebhookEvent, bool, error) {
+	result, err := db.Conn.Exec(`INSERT INTO webhook_events (provider, event_id, type, payload, received_at)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT(provider, event_id) DO NOTHING`,
+		event.Provider, event.ID, event.Type, string(payload), now)
+	if err != nil {
+		return nil, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var id int64
+	err = db.Conn.QueryRow("SELECT id FROM webhook_events WHERE provider = ? AND event_id = ?", event.Provider, event.ID).Scan(&id)
+	if err != nil {
+		return nil, false, err
+	}
+	stored, err := db.GetWebhookEvent(id)
+	return stored, inserted == 0, err
+}
+
+// webhookEventColumns are the columns scanned by scanWebhookEvent
+const webhookEventColumns = `id, provider, event_id, type, payload, status, COALESCE(error, ''), attempts, received_at, processed_at, claimed_at`
+
+// scanWebhookEvent reads a stored event
+func scanWebhookEvent(row interface{ Scan(...interface{}) error }) (*models.WebhookEvent, error) {
+	var e models.WebhookEvent
+	var processedAt, claimedAt sql.NullTime
+	if err := row.Scan(&e.ID, &e.Provider, &e.EventID, &e.Type, &e.Payload, &e.Status, &e.Error, &e.Attempts,
+		&e.ReceivedAt, &processedAt, &claimedAt); err != nil {
+		return nil, err
+	}
+	if processedAt.Valid {
+		e.ProcessedAt = &processedAt.Time
+	}
+	if claimedAt.Valid {
+		e.ClaimedAt = &claimedAt.Time
+	}
+	return &e, nil
+}
+
+// GetWebhookEvent returns a stored event
+func (db *Database) GetWebhookEvent(id int64) (*models.WebhookEvent, error) {
+	e, err := scanWebhookEvent(db.Conn.QueryRow("SELECT "+webhookEventColumns+" FROM webhook_events WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("hendelsen finnes ikke")
+	}
+	return e, err
+}
+
+// GetWebhookEvents returns the stored events with a status, newest first
+func (db *Database) GetWebhookEvents(status string, limit int) ([]models.WebhookEvent, error) {
+	rows, err := db.Conn.Query("SELECT "+webhookEventColumns+` FROM webhook_events
+		WHERE status = ? ORDER BY received_at DESC, id DESC LIMIT ?`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.WebhookEvent
+	for rows.Next() {
+		e, err := scanWebhookEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// ProcessWebhookEvent acts on a stored event that has not been processed yet. An event is only
+// processed once, a delivery of an event that is processed or being processed does nothing.
+// A failed event keeps the reason and can be replayed, and so can an event stuck processing
+// for longer than WebhookProcessingTimeout.
+func (db *Database) ProcessWebhookEvent(id int64, now time.Time) error {
+	claim, err := db.Conn.Exec(`UPDATE webhook_events SET status = ?, attempts = attempts + 1, claimed_at = ?
+		WHERE id = ? AND (status IN (?, ?) OR (status = ? AND (claimed_at IS NULL OR claimed_at < ?)))`,
+		models.WebhookEventProcessing, now, id, models.WebhookEventReceived, models.WebhookEventFailed,
+		models.WebhookEventProcessing, now.Add(-WebhookProcessingTimeout))
+	if err != nil {
+		return err
+	}
+	if claimed, err := claim.RowsAffected(); err != nil || claimed == 0 {
+		return err
+	}
+
+	stored, err := db.GetWebhookEvent(id)
+	if err != nil {
+		return err
+	}
+	var event models.WebhookEventPayload
+	processErr := json.Unmarshal([]byte(stored.Payload), &event)
+	if processErr == nil {
+		processErr = db.applyWebhookEvent(event, now)
+	}
+
+	status, reason := models.WebhookEventProcessed, ""
+	switch {
+	case errors.Is(processErr, errWebhookNothingToDo):
+		status, reason, processErr = models.WebhookEventIgnored, processErr.Error(), nil
+	case processErr != nil:
+		status, reason = models.WebhookEventFailed, processErr.Error()
+	}
+	_, err = db.Conn.Exec("UPDATE webhook_events SET status = ?, error = ?, processed_at = ? WHERE id = ?", status, reason, now, id)
+	if err != nil {
+		return err
+	}
+	return processErr
+}
+
+// ReplayWebhookEvent processes a failed event again, e.g. a charge event that arrived before
+// the charge was stored, or an event that got stuck processing
+func (db *Database) ReplayWebhookEvent(id int64, now time.Time) error {
+	stored, err := db.GetWebhookEvent(id)
+	if err != nil {
+		return err
+	}
+	switch stored.Status {
+	case models.WebhookEventFailed, models.WebhookEventReceived:
+	case models.WebhookEventProcessing:
+		if stored.ClaimedAt != nil && stored.ClaimedAt.After(now.Add(-WebhookProcessingTimeout)) {
+			return fmt.Errorf("hendelsen behandles nå, prøv igjen om litt")
+		}
+	default:
+		return fmt.Errorf("hendelsen er allerede behandlet")
+	}
+	return db.ProcessWebhookEvent(id, now)
+}
+
+// GetStuckWebhookEvents returns the events that have been processing for longer than
+// WebhookProcessingTimeout, oldest first
+func (db *Database) GetStuckWebhookEvents(now time.Time, limit int) ([]models.WebhookEvent, error) {
+	rows, err := db.Conn.Query("SELECT "+webhookEventColumns+` FROM webhook_events
+		WHERE status = ? AND (claimed_at IS NULL OR claimed_at < ?) ORDER BY received_at, id LIMIT ?`,
+		models.WebhookEventProcessing, now.Add(-WebhookProcessingTimeout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.WebhookEvent
+	for rows.Next() {
+		e, err := scanWebhookEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// applyWebhookEvent updates the charge or payment method an event is about
+func (db *Database) applyWebhookEvent(event models.WebhookEventPayload, now time.Time) error {
+	switch event.Type {
+	case models.WebhookChargeSucceeded, models.WebhookChargeFailed, models.WebhookDisputeCreated, models.WebhookDisputeClosed:
+		return db.applyChargeEvent(event, now)
+	case models.WebhookPaymentMethodUpdated, models.WebhookPaymentMethodExpired:
+		return db.applyCardEvent(event)
+	case models.WebhookAgreementActivated, models.WebhookAgreementStopped, models.WebhookAgreementExpired:
+		return db.applyAgreementEvent(event)
+	}
+	return fmt.Errorf("%w: %s", errWebhookNothingToDo, event.Type)
+}
+
+// webhookCharge returns the charge an event is about. The charge may not be stored yet when
+// the event arrives, so a missing charge fails the event for a replay.
+func (db *Database) webhookCharge(data models.WebhookEventData) (*refundableCharge, error) {
+	var id, userID int64
+	err := db.Conn.QueryRow(`SELECT id, user_id FROM charges
+		WHERE (? != 0 AND id = ?) OR (? != '' AND (stripe_charge_id = ? OR provider_reference = ?))
+		ORDER BY id LIMIT 1`, data.ChargeID, data.ChargeID, data.Charge, data.Charge, data.Charge).Scan(&id, &userID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("fant ikke betalingen %q", data.Charge)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.getRefundableCharge(userID, id)
+}
+
+// applyChargeEvent moves a charge to the status the provider reports. Each move only happens
+// from the statuses it makes sense from, so an event out of order changes nothing.
+func (db *Database) applyChargeEvent(event models.WebhookEventPayload, now time.Time) error {
+	charge, err := db.webhookCharge(event.Data)
+	if err != nil {
+		return err
+	}
+
+	var result sql.Result
+	switch event.Type {
+	case models.WebhookChargeSucceeded:
+		result, err = db.Conn.Exec("UPDATE charges SET status = ?, failure_reason = NULL WHERE id = ? AND status = ?",
+			models.ChargeStatusSucceeded, charge.ID, models.ChargeStatusPending)
+	case models.WebhookChargeFailed:
+		reason := event.Data.FailureReason
+		if reason == "" {
+			reason = "Betalingen ble avvist"
+		}
+		result, err = db.Conn.Exec("UPDATE charges SET status = ?, failure_reason = ? WHERE id = ? AND status IN (?, ?)",
+			models.ChargeStatusFailed, reason, charge.ID, models.ChargeStatusPending, models.ChargeStatusSucceeded)
+	case models.WebhookDisputeCreated:
+		result, err = db.Conn.Exec("UPDATE charges SET status = ? WHERE id = ? AND status IN (?, ?)",
+			models.ChargeStatusDisputed, charge.ID, models.ChargeStatusSucceeded, models.ChargeStatusPartiallyRefunded)
+	case models.WebhookDisputeClosed:
+		switch event.Data.Outcome {
+		case models.DisputeWon:
+			result, err = db.Conn.Exec(`UPDATE charges SET status = CASE WHEN COALESCE(refunded_amount, 0) > 0 THEN ? ELSE ? END
+				WHERE id = ? AND status = ?`, models.ChargeStatusPartiallyRefunded, models.ChargeStatusSucceeded,
+				charge.ID, models.ChargeStatusDisputed)
+		case models.DisputeLost:
+			result, err = db.Conn.Exec("UPDATE charges SET status = ?, failure_reason = ? WHERE id = ? AND status = ?",
+				models.ChargeStatusChargedBack, "Innsigelsen ble tapt", charge.ID, models.ChargeStatusDisputed)
+		default:
+			return fmt.Errorf("ukjent utfall av innsigelse %q", event.Data.Outcome)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if changed, err := result.RowsAffected(); err != nil || changed == 0 {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: betalingen er %s", errWebhookNothingToDo, charge.Status)
+	}
+
+	switch event.Type {
+	case models.WebhookChargeSucceeded:
+		if charge.Amount > 0 {
+			return issueReceipt(db.Conn, int64(charge.ID), now)
+		}
+	case models.WebhookChargeFailed:
+		// Credit used on the charge is given back, klipp handed out while the payment was being
+		// confirmed are taken back
+		if err := db.reverseCreditApplied(charge, now); err != nil {
+			return err
+		}
+		if charge.Status == models.ChargeStatusSucceeded && charge.klippekortID.Valid && charge.klipp > 0 {
+			return db.takeBackUnpaidKlipp(charge.klippekortID.Int64, charge.klipp, charge.Description, now)
+		}
+	}
+	return nil
+}
+
+// webhookPaymentMethod returns the ID and owner of the payment method an event is about
+func (db *Database) webhookPaymentMethod(event models.WebhookEventPayload) (int64, int64, error) {
+	var id, userID int64
+	err := db.Conn.QueryRow(`SELECT id, user_id FROM payment_methods
+		WHERE provider = ? AND (provider_id = ? OR (agreement_id != '' AND agreement_id = ?))`,
+		event.Provider, event.Data.PaymentMethod, event.Data.PaymentMethod).Scan(&id, &userID)
+	if err == sql.ErrNoRows {
+		return 0, 0, fmt.Errorf("%w: betalingsmetoden %q finnes ikke", errWebhookNothingToDo, event.Data.PaymentMethod)
+	}
+	return id, userID, err
+}
+
+// applyCardEvent keeps a card's expiry up to date. An expired card is no longer charged, the
+// member's next working payment method becomes the default.
+func (db *Database) applyCardEvent(event models.WebhookEventPayload) error {
+	id, userID, err := db.webhookPaymentMethod(event)
+	if err != nil {
+		return err
+	}
+
+	if event.Type == models.WebhookPaymentMethodExpired {
+		_, err = db.Conn.Exec(`UPDATE payment_methods SET active = FALSE, is_default = FALSE,
+			expiry_month = CASE WHEN ? > 0 THEN ? ELSE expiry_month END,
+			expiry_year = CASE WHEN ? > 0 THEN ? ELSE expiry_year END WHERE id = ?`,
+			event.Data.ExpiryMonth, event.Data.ExpiryMonth, event.Data.ExpiryYear, event.Data.ExpiryYear, id)
+		if err != nil {
+			return err
+		}
+		return db.ensureDefaultPaymentMethod(userID)
+	}
+
+	// The card network sent new card details, e.g. a renewed card
+	if event.Data.ExpiryMonth < 1 || event.Data.ExpiryMonth > 12 || event.Data.ExpiryYear == 0 {
+		return fmt.Errorf("ugyldig utløpsdato %d/%d", event.Data.ExpiryMonth, event.Data.ExpiryYear)
+	}
+	_, err = db.Conn.Exec("UPDATE payment_methods SET active = TRUE, expiry_month = ?, expiry_year = ? WHERE id = ?",
+		event.Data.ExpiryMonth, event.Data.ExpiryYear, id)
+	if err != nil {
+		return err
+	}
+	return db.ensureDefaultPaymentMethod(userID)
+}
+
+// applyAgreementEvent keeps a Vipps agreement's status up to date, e.g. when the member stops
+// it in the Vipps app
+func (db *Database) applyAgreementEvent(event models.WebhookEventPayload) error {
+	id, _, err := db.webhookPaymentMethod(event)
+	if err != nil {
+		return err
+	}
+
+	status := models.VippsAgreementActive
+	switch event.Type {
+	case models.WebhookAgreementStopped:
+		status = models.VippsAgreementStopped
+	case models.WebhookAgreementExpired:
+		status = models.VippsAgreementExpired
+	}
+	_, err = db.Conn.Exec("UPDATE payment_methods SET agreement_status = ? WHERE id = ?", status, id)
+	return err
+}
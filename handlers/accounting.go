@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kjernekraft/handlers/config"
+	"kjernekraft/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Accounting export formats
+const (
+	AccountingFormatFiken     = "fiken"
+	AccountingFormatTripletex = "tripletex"
+	AccountingFormatSAFT      = "saft"
+	AccountingFormatDeferred  = "deferred"
+)
+
+// formatAccountingAmount writes an amount in øre as kroner with two decimals and no
+// thousands separator, e.g. 1234,56
+func formatAccountingAmount(amount int, decimal string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d%s%02d", sign, amount/100, decimal, amount%100)
+}
+
+// accountingDate writes a date the way Norwegian accounting systems read it
+func accountingDate(t time.Time) string {
+	return t.In(config.GetInstance().GetLocation()).Format("02.01.2006")
+}
+
+// WriteFikenCSV writes one line per entry from the debit to the credit account, in the layout
+// Fiken imports journal entries from. Refunds and credits are booked the other way round.
+func WriteFikenCSV(w io.Writer, entries []models.AccountingEntry) error {
+	out := csv.NewWriter(w)
+	out.Comma = ';'
+	out.Write([]string{"Dato", "Bilagsnummer", "Beskrivelse", "Debetkonto", "Kreditkonto", "Beløp", "MVA-kode", "Kunde"})
+	for _, e := range entries {
+		debit, credit, amount := e.SettlementAccount, e.RevenueAccount, e.Amount
+		if amount < 0 {
+			debit, credit, amount = credit, debit, -amount
+		}
+		out.Write([]string{accountingDate(e.Date), e.Voucher, e.Description, debit, credit,
+			formatAccountingAmount(amount, ","), e.VATCode, e.CustomerName})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteTripletexCSV writes each entry as a voucher with a line per account, debits positive
+// and credits negative, in the layout Tripletex imports vouchers from. The revenue line holds
+// the amount with MVA and the MVA code, so the system books the MVA itself.
+func WriteTripletexCSV(w io.Writer, entries []models.AccountingEntry) error {
+	out := csv.NewWriter(w)
+	out.Comma = ';'
+	out.Write([]string{"Bilagsnummer", "Dato", "Beskrivelse", "Konto", "MVA-kode", "Beløp", "Kunde"})
+	for _, e := range entries {
+		date := accountingDate(e.Date)
+		out.Write([]string{e.Voucher, date, e.Description, e.SettlementAccount, "",
+			formatAccountingAmount(e.Amount, ","), e.CustomerName})
+		out.Write([]string{e.Voucher, date, e.Description, e.RevenueAccount, e.VATCode,
+			formatAccountingAmount(-e.Amount, ","), e.CustomerName})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteDeferredRevenueCSV writes the revenue paid for but not yet used, one line per klippekort
+// or membership
+func WriteDeferredRevenueCSV(w io.Writer, deferred *models.DeferredRevenue) error {
+	out := csv.NewWriter(w)
+	out.Comma = ';'
+	out.Write([]string{"Dato", "Type", "Medlem", "Beskrivelse", "Ubrukt", "Totalt", "Beløp inkl. MVA", "Beløp eks. MVA"})
+	date := accountingDate(deferred.AsOf)
+	for _, line := range deferred.Lines {
+		out.Write([]string{date, line.ChargeType, line.MemberName, line.Description, strconv.Itoa(line.Units),
+			strconv.Itoa(line.TotalUnits), formatAccountingAmount(line.Amount, ","), formatAccountingAmount(line.NetAmount, ",")})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// parseAccountingPeriod reads the from and to dates of an export, both included. The default
+// is last month.
+func parseAccountingPeriod(r *http.Request) (time.Time, time.Time, error) {
+	loc := config.GetInstance().GetLocation()
+	now := config.GetInstance().GetCurrentTime().In(loc)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	return parsePeriod(r, "from", "to", monthStart.AddDate(0, -1, 0), monthStart)
+}
+
+// parsePeriod reads the dates of a period, both included, from the named query parameters.
+// Dates not given keep the defaults, where to is excluded.
+func parsePeriod(r *http.Request, fromKey, toKey string, from, to time.Time) (time.Time, time.Time, error) {
+	loc := config.GetInstance().GetLocation()
+	if value := r.URL.Query().Get(fromKey); value != "" {
+		date, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			return from, to, fmt.Errorf("ugyldig fra-dato")
+		}
+		from = date
+	}
+	if value := r.URL.Query().Get(toKey); value != "" {
+		date, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			return from, to, fmt.Errorf("ugyldig til-dato")
+		}
+		to = date.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		return from, to, fmt.Errorf("til-datoen må være etter fra-datoen")
+	}
+	return from, to, nil
+}
+
+// AccountingExportHandler downloads the sales, refunds and credits of a period for the
+// accountant, as CSV for Fiken or Tripletex or as a SAF-T Financial file, or the deferred
+// revenue on the last day of the period
+func AccountingExportHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	from, to, err := parseAccountingPeriod(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	period := from.Format("2006-01-02") + "_" + to.AddDate(0, 0, -1).Format("2006-01-02")
+	format := r.URL.Query().Get("format")
+
+	if format == AccountingFormatDeferred {
+		asOf := to
+		if now := config.GetInstance().GetCurrentTime(); now.Before(asOf) {
+			asOf = now
+		}
+		deferred, err := AdminDB.GetDeferredRevenue(asOf)
+		if err != nil {
+			log.Printf("Error computing deferred revenue: %v", err)
+			http.Error(w, "Kunne ikke beregne forskuddsbetalte inntekter", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "forskudd_"+asOf.Format("2006-01-02")+".csv"))
+		if err := WriteDeferredRevenueCSV(w, deferred); err != nil {
+			log.Printf("Error writing deferred revenue: %v", err)
+		}
+		return
+	}
+
+	entries, err := AdminDB.GetAccountingEntries(from, to)
+	if err != nil {
+		log.Printf("Error fetching accounting entries: %v", err)
+		http.Error(w, "Kunne ikke hente posteringer", http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case AccountingFormatFiken, AccountingFormatTripletex:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", format+"_"+period+".csv"))
+		write := WriteFikenCSV
+		if format == AccountingFormatTripletex {
+			write = WriteTripletexCSV
+		}
+		err = write(w, entries)
+	case AccountingFormatSAFT:
+		seller, sellerErr := AdminDB.GetBusinessDetails()
+		if sellerErr != nil {
+			http.Error(w, "Kunne ikke hente firmaopplysninger", http.StatusInternalServerError)
+			return
+		}
+		now := config.GetInstance().GetCurrentTime()
+		filename := fmt.Sprintf("SAF-T Financial_%s_%s_1_1.xml", seller.OrgNumber, now.Format("20060102150405"))
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		err = WriteSAFT(w, entries, *seller, from, to.AddDate(0, 0, -1), now)
+	default:
+		http.Error(w, "Ukjent format", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error writing %s export: %v", format, err)
+	}
+}
+
+// SaveAccountMappingHandler sets the account a charge type or payment method is booked on
+func SaveAccountMappingHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var mapping models.AccountMapping
+	if err := json.NewDecoder(r.Body).Decode(&mapping); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.SaveAccountMapping(mapping); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Kontoen er lagret",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
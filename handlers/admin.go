@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"kjernekraft/database"
+	"kjernekraft/handlers/config"
 	"kjernekraft/handlers/modules"
+	"kjernekraft/models"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 var AdminDB *database.Database
@@ -37,6 +41,141 @@ func AdminPageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Closures for the current year, including public holidays
+	now := config.GetInstance().GetCurrentTime()
+	closures, err := AdminDB.GetClosures(now, time.Date(now.Year(), time.December, 31, 0, 0, 0, 0, now.Location()))
+	if err != nil {
+		http.Error(w, "Kunne ikke hente stengte dager", http.StatusInternalServerError)
+		return
+	}
+
+	qualifications, err := AdminDB.GetQualifications()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente kvalifikasjoner", http.StatusInternalServerError)
+		return
+	}
+
+	priceVersions, err := AdminDB.GetMembershipPriceVersions(0)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente prishistorikk", http.StatusInternalServerError)
+		return
+	}
+
+	discountVerifications, err := AdminDB.GetPendingDiscountVerifications()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente rabattbekreftelser", http.StatusInternalServerError)
+		return
+	}
+
+	recommendationRules, err := AdminDB.GetRecommendationRules()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente anbefalingsregler", http.StatusInternalServerError)
+		return
+	}
+
+	campaigns, err := AdminDB.GetCampaigns()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente kampanjer", http.StatusInternalServerError)
+		return
+	}
+
+	coupons, err := AdminDB.GetCoupons()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente rabattkoder", http.StatusInternalServerError)
+		return
+	}
+
+	households, err := AdminDB.GetHouseholds()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente husstander", http.StatusInternalServerError)
+		return
+	}
+
+	householdDiscounts, err := AdminDB.GetHouseholdDiscountRules()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente husstandsrabatter", http.StatusInternalServerError)
+		return
+	}
+
+	companies, err := AdminDB.GetCompanies()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente bedriftsavtaler", http.StatusInternalServerError)
+		return
+	}
+
+	companyInvoices, err := AdminDB.GetCompanyInvoices(0)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente bedriftsfakturaer", http.StatusInternalServerError)
+		return
+	}
+
+	klippekortRules, err := AdminDB.GetKlippekortRules()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente klippekortregler", http.StatusInternalServerError)
+		return
+	}
+
+	klippekortPackages, err := AdminDB.GetKlippekortPackagesForAdmin()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente klippekort-pakker", http.StatusInternalServerError)
+		return
+	}
+
+	klippekortPolicies, err := AdminDB.GetKlippekortCategoryPolicies()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente påfyllingsregler", http.StatusInternalServerError)
+		return
+	}
+
+	businessDetails, err := AdminDB.GetBusinessDetails()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente firmaopplysninger", http.StatusInternalServerError)
+		return
+	}
+
+	vatRates, err := AdminDB.GetVATRates()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente MVA-satser", http.StatusInternalServerError)
+		return
+	}
+
+	accountMappings, err := AdminDB.GetAccountMappings()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente kontoplan", http.StatusInternalServerError)
+		return
+	}
+
+	deferredRevenue, err := AdminDB.GetDeferredRevenue(now)
+	if err != nil {
+		http.Error(w, "Kunne ikke beregne forskuddsbetalte inntekter", http.StatusInternalServerError)
+		return
+	}
+	failedWebhookEvents, err := AdminDB.GetWebhookEvents(models.WebhookEventFailed, 50)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente betalingshendelser", http.StatusInternalServerError)
+		return
+	}
+	stuckWebhookEvents, err := AdminDB.GetStuckWebhookEvents(now, 50)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente betalingshendelser", http.StatusInternalServerError)
+		return
+	}
+
+	reportFrom, reportTo, err := parseReportPeriod(r, "report_from", "report_to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := AdminDB.GetKPIReport(reportFrom, reportTo, now)
+	if err != nil {
+		log.Printf("Error computing KPI report: %v", err)
+		http.Error(w, "Kunne ikke lage rapporten", http.StatusInternalServerError)
+		return
+	}
+
+	// The export defaults to last month
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, config.GetInstance().GetLocation())
+
 	// Get language from request (default to Norwegian bokmål)
 	lang := r.URL.Query().Get("lang")
 	if lang == "" {
@@ -52,15 +191,39 @@ func AdminPageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
-		"Title":          "Admin Dashboard",
-		"Users":          users,
-		"Events":         events,
-		"FreezeRequests": freezeRequests,
-		"Memberships":    memberships,
-		"Stats":          statsModule,
-		"Lang":           lang,
-		"CurrentPage":    "admin",
-		"ExternalCSS":    []string{},
+		"Title":                 "Admin Dashboard",
+		"Users":                 users,
+		"Events":                events,
+		"FreezeRequests":        freezeRequests,
+		"Memberships":           memberships,
+		"Closures":              closures,
+		"Qualifications":        qualifications,
+		"PriceVersions":         priceVersions,
+		"DiscountVerifications": discountVerifications,
+		"RecommendationRules":   recommendationRules,
+		"Campaigns":             campaigns,
+		"Coupons":               coupons,
+		"Households":            households,
+		"HouseholdDiscounts":    householdDiscounts,
+		"Companies":             companies,
+		"CompanyInvoices":       companyInvoices,
+		"KlippekortRules":       klippekortRules,
+		"KlippekortPackages":    klippekortPackages,
+		"KlippekortPolicies":    klippekortPolicies,
+		"BusinessDetails":       businessDetails,
+		"VATRates":              vatRates,
+		"AccountMappings":       accountMappings,
+		"DeferredRevenue":       deferredRevenue,
+		"AccountingFrom":        monthStart.AddDate(0, -1, 0).Format("2006-01-02"),
+		"AccountingTo":          monthStart.AddDate(0, 0, -1).Format("2006-01-02"),
+		"FailedWebhookEvents":   append(stuckWebhookEvents, failedWebhookEvents...),
+		"Report":                report,
+		"ReportFrom":            reportFrom.Format("2006-01-02"),
+		"ReportTo":              reportTo.AddDate(0, 0, -1).Format("2006-01-02"),
+		"Stats":                 statsModule,
+		"Lang":                  lang,
+		"CurrentPage":           "admin",
+		"ExternalCSS":           []string{},
 	}
 
 	// Use template manager instead of inline template
@@ -89,10 +252,48 @@ func GetUsersAPIHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Not implemented", http.StatusNotImplemented)
 }
 
+// ApproveFreezeRequestHandler freezes a membership. The freeze days count from now, see FreezeDaysUsed.
 func ApproveFreezeRequestHandler(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "Not implemented", http.StatusNotImplemented)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.ApproveFreezeRequest(userID); err != nil {
+		http.Error(w, "Could not approve freeze request", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
+// RejectFreezeRequestHandler keeps a membership active
 func RejectFreezeRequestHandler(w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "Not implemented", http.StatusNotImplemented)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.RejectFreezeRequest(userID); err != nil {
+		http.Error(w, "Could not reject freeze request", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"kjernekraft/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetCampaignsHandler returns all campaigns with their redemption counts
+func GetCampaignsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	campaigns, err := AdminDB.GetCampaigns()
+	if err != nil {
+		http.Error(w, "Could not fetch campaigns", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(campaigns)
+}
+
+// SaveCampaignHandler creates or updates a campaign
+func SaveCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var campaignData struct {
+		ID             int    `json:"id"`
+		Name           string `json:"name"`
+		Description    string `json:"description"`
+		MembershipID   int    `json:"membership_id"`
+		StartDate      string `json:"start_date"`
+		EndDate        string `json:"end_date"`
+		Eligibility    string `json:"eligibility"`
+		LapsedMonths   int    `json:"lapsed_months"`
+		DiscountPrice  *int   `json:"discount_price"`
+		DiscountMonths int    `json:"discount_months"`
+		FreeWeeks      int    `json:"free_weeks"`
+		Active         bool   `json:"active"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&campaignData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	startDate, err := time.Parse("2006-01-02", campaignData.StartDate)
+	if err != nil {
+		http.Error(w, "Invalid start date format", http.StatusBadRequest)
+		return
+	}
+
+	endDate, err := time.Parse("2006-01-02", campaignData.EndDate)
+	if err != nil {
+		http.Error(w, "Invalid end date format", http.StatusBadRequest)
+		return
+	}
+
+	campaignID, err := AdminDB.SaveCampaign(models.Campaign{
+		ID:             campaignData.ID,
+		Name:           campaignData.Name,
+		Description:    campaignData.Description,
+		MembershipID:   campaignData.MembershipID,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		Eligibility:    campaignData.Eligibility,
+		LapsedMonths:   campaignData.LapsedMonths,
+		DiscountPrice:  campaignData.DiscountPrice,
+		DiscountMonths: campaignData.DiscountMonths,
+		FreeWeeks:      campaignData.FreeWeeks,
+		Active:         campaignData.Active,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":     true,
+		"message":     "Campaign saved successfully",
+		"campaign_id": campaignID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeactivateCampaignHandler ends a campaign early
+func DeactivateCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	campaignID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid campaign ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.DeactivateCampaign(campaignID); err != nil {
+		http.Error(w, "Could not deactivate campaign", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Campaign deactivated successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"kjernekraft/handlers/config"
 	"kjernekraft/models"
 	"net/http"
 	"strconv"
@@ -18,18 +19,23 @@ func CreateClassHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO: Add admin authentication check here
 
 	var classData struct {
-		Title          string `json:"title"`
-		ClassType      string `json:"class_type"`
-		TeacherName    string `json:"teacher_name"`
-		Location       string `json:"location"`
-		Date           string `json:"date"`
-		StartTime      string `json:"start_time"`
-		EndTime        string `json:"end_time"`
-		Capacity       int    `json:"capacity"`
-		Color          string `json:"color"`
-		Description    string `json:"description"`
-		IsRecurring    bool   `json:"is_recurring"`
-		RecurringWeeks int    `json:"recurring_weeks"`
+		Title          string   `json:"title"`
+		ClassType      string   `json:"class_type"`
+		TeacherName    string   `json:"teacher_name"`
+		Location       string   `json:"location"`
+		Date           string   `json:"date"`
+		StartTime      string   `json:"start_time"`
+		EndTime        string   `json:"end_time"`
+		Capacity       int      `json:"capacity"`
+		Color          string   `json:"color"`
+		Description    string   `json:"description"`
+		IsRecurring    bool     `json:"is_recurring"`
+		RecurringWeeks int      `json:"recurring_weeks"`
+		DeliveryMode   string   `json:"delivery_mode"`
+		OnlineCapacity int      `json:"online_capacity"`
+		StreamURL      string   `json:"stream_url"`
+		RequiredRoles  []string `json:"role_requirements"`
+		KlippekortCategory string `json:"klippekort_category"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&classData); err != nil {
@@ -37,6 +43,11 @@ func CreateClassHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isValidDeliveryMode(classData.DeliveryMode) {
+		http.Error(w, "Invalid delivery mode", http.StatusBadRequest)
+		return
+	}
+
 	// Parse date and times
 	classDate, err := time.Parse("2006-01-02", classData.Date)
 	if err != nil {
@@ -69,12 +80,24 @@ func CreateClassHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var createdEventIDs []int64
+	var skippedDates []string
 
 	for week := 0; week < weeksToCreate; week++ {
 		weekOffset := time.Duration(week) * 7 * 24 * time.Hour
 		weekStartTime := startDateTime.Add(weekOffset)
 		weekEndTime := endDateTime.Add(weekOffset)
 
+		// Skip dates when the studio is closed (public holidays, summer break etc.)
+		closure, err := AdminDB.GetClosureForDate(weekStartTime)
+		if err != nil {
+			http.Error(w, "Could not check closures", http.StatusInternalServerError)
+			return
+		}
+		if closure != nil {
+			skippedDates = append(skippedDates, weekStartTime.Format("2006-01-02")+" ("+closure.Name+")")
+			continue
+		}
+
 		event := models.Event{
 			Title:            classData.Title,
 			Description:      classData.Description,
@@ -87,6 +110,11 @@ func CreateClassHandler(w http.ResponseWriter, r *http.Request) {
 			Capacity:         classData.Capacity,
 			CurrentEnrolment: 0,
 			Color:            classData.Color,
+			DeliveryMode:     classData.DeliveryMode,
+			OnlineCapacity:   classData.OnlineCapacity,
+			StreamURL:        classData.StreamURL,
+			RoleRequirements: roleRequirementsFromList(classData.RequiredRoles),
+			KlippekortCategory: classData.KlippekortCategory,
 		}
 
 		eventID, err := AdminDB.CreateEvent(event)
@@ -97,11 +125,17 @@ func CreateClassHandler(w http.ResponseWriter, r *http.Request) {
 		createdEventIDs = append(createdEventIDs, eventID)
 	}
 
+	if len(createdEventIDs) == 0 && len(skippedDates) > 0 {
+		http.Error(w, "Studio is closed on the selected date(s)", http.StatusConflict)
+		return
+	}
+
 	response := map[string]interface{}{
 		"success":    true,
 		"message":    "Class(es) created successfully",
 		"event_ids":  createdEventIDs,
 		"events_created": len(createdEventIDs),
+		"skipped_dates":  skippedDates,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -162,16 +196,20 @@ func UpdateClassHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var updateData struct {
-		Title       string `json:"title"`
-		ClassType   string `json:"class_type"`
-		TeacherName string `json:"teacher_name"`
-		Location    string `json:"location"`
-		Date        string `json:"date"`
-		StartTime   string `json:"start_time"`
-		EndTime     string `json:"end_time"`
-		Capacity    int    `json:"capacity"`
-		Color       string `json:"color"`
-		Description string `json:"description"`
+		Title          string   `json:"title"`
+		ClassType      string   `json:"class_type"`
+		TeacherName    string   `json:"teacher_name"`
+		Location       string   `json:"location"`
+		Date           string   `json:"date"`
+		StartTime      string   `json:"start_time"`
+		EndTime        string   `json:"end_time"`
+		Capacity       int      `json:"capacity"`
+		Color          string   `json:"color"`
+		Description    string   `json:"description"`
+		DeliveryMode   string   `json:"delivery_mode"`
+		OnlineCapacity int      `json:"online_capacity"`
+		StreamURL      string   `json:"stream_url"`
+		RequiredRoles  []string `json:"role_requirements"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
@@ -179,6 +217,11 @@ func UpdateClassHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isValidDeliveryMode(updateData.DeliveryMode) {
+		http.Error(w, "Invalid delivery mode", http.StatusBadRequest)
+		return
+	}
+
 	// Parse date and times (similar to create)
 	classDate, err := time.Parse("2006-01-02", updateData.Date)
 	if err != nil {
@@ -214,6 +257,10 @@ func UpdateClassHandler(w http.ResponseWriter, r *http.Request) {
 		TeacherName:      updateData.TeacherName,
 		Capacity:         updateData.Capacity,
 		Color:            updateData.Color,
+		DeliveryMode:     updateData.DeliveryMode,
+		OnlineCapacity:   updateData.OnlineCapacity,
+		StreamURL:        updateData.StreamURL,
+		RoleRequirements: roleRequirementsFromList(updateData.RequiredRoles),
 	}
 
 	if err := AdminDB.UpdateEvent(event); err != nil {
@@ -228,4 +275,53 @@ func UpdateClassHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// GetEventParticipantsHandler lists the members signed up for a class, for checking them in
+func GetEventParticipantsHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	eventID, err := strconv.ParseInt(r.URL.Query().Get("event_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	participants, err := AdminDB.GetEventParticipants(eventID)
+	if err != nil {
+		http.Error(w, "Could not fetch participants", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(participants)
+}
+
+// CheckInHandler registers that a member who signed up for a class turned up in the studio,
+// or undoes it. Check-ins are what the no-show rate in the KPI report counts.
+func CheckInHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var req struct {
+		UserID    int64 `json:"user_id"`
+		EventID   int64 `json:"event_id"`
+		CheckedIn bool  `json:"checked_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.SetCheckedIn(req.UserID, req.EventID, req.CheckedIn, config.GetInstance().GetCurrentTime()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Oppmøtet er registrert",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
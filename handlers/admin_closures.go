@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"kjernekraft/handlers/config"
+	"kjernekraft/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetClosuresHandler returns all closures (custom and public holidays) for a year
+func GetClosuresHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	year := config.GetInstance().GetCurrentTime().Year()
+	if yearParam := r.URL.Query().Get("year"); yearParam != "" {
+		parsedYear, err := strconv.Atoi(yearParam)
+		if err != nil {
+			http.Error(w, "Invalid year", http.StatusBadRequest)
+			return
+		}
+		year = parsedYear
+	}
+
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC)
+	closures, err := AdminDB.GetClosures(from, to)
+	if err != nil {
+		http.Error(w, "Could not fetch closures", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(closures)
+}
+
+// CreateClosureHandler creates a custom closure and cancels the classes within it
+func CreateClosureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var closureData struct {
+		Name      string `json:"name"`
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&closureData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if closureData.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	startDate, err := time.Parse("2006-01-02", closureData.StartDate)
+	if err != nil {
+		http.Error(w, "Invalid start date format", http.StatusBadRequest)
+		return
+	}
+
+	endDate := startDate
+	if closureData.EndDate != "" {
+		endDate, err = time.Parse("2006-01-02", closureData.EndDate)
+		if err != nil {
+			http.Error(w, "Invalid end date format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	closureID, err := AdminDB.CreateClosure(models.Closure{
+		Name:      closureData.Name,
+		StartDate: startDate,
+		EndDate:   endDate,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cancelledCount, err := AdminDB.CancelEventsInClosures(startDate, endDate)
+	if err != nil {
+		http.Error(w, "Closure created, but could not cancel classes", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":          true,
+		"message":          "Closure created successfully",
+		"closure_id":       closureID,
+		"events_cancelled": cancelledCount,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteClosureHandler removes a custom closure
+func DeleteClosureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	closureID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid closure ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.DeleteClosure(closureID); err != nil {
+		http.Error(w, "Could not delete closure", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Closure deleted successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ApplyClosuresHandler cancels all existing classes that fall on a closed day in the coming year
+func ApplyClosuresHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	now := config.GetInstance().GetCurrentTime()
+	cancelledCount, err := AdminDB.CancelEventsInClosures(now, now.AddDate(1, 0, 0))
+	if err != nil {
+		http.Error(w, "Could not cancel classes", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":          true,
+		"message":          "Closures applied successfully",
+		"events_cancelled": cancelledCount,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"kjernekraft/models"
+	"net/http"
+	"strconv"
+)
+
+// GetCompaniesHandler returns all company accounts
+func GetCompaniesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	companies, err := AdminDB.GetCompanies()
+	if err != nil {
+		http.Error(w, "Could not fetch companies", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(companies)
+}
+
+// SaveCompanyHandler creates or updates a company account
+func SaveCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var company models.Company
+	if err := json.NewDecoder(r.Body).Decode(&company); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	companyID, err := AdminDB.SaveCompany(company)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":    true,
+		"message":    "Bedriftsavtalen er lagret",
+		"company_id": companyID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteCompanyHandler deactivates a company account
+func DeleteCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	companyID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid company ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.DeactivateCompany(companyID); err != nil {
+		http.Error(w, "Could not deactivate company", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Bedriftsavtalen er deaktivert",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetCompanyInvoicesHandler returns company invoices, or a single invoice with its lines when an ID is given
+func GetCompanyInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	if r.URL.Query().Get("id") != "" {
+		invoiceID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+			return
+		}
+		invoice, err := AdminDB.GetCompanyInvoice(invoiceID)
+		if err != nil {
+			http.Error(w, "Invoice not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invoice)
+		return
+	}
+
+	companyID, _ := strconv.ParseInt(r.URL.Query().Get("company_id"), 10, 64)
+	invoices, err := AdminDB.GetCompanyInvoices(companyID)
+	if err != nil {
+		http.Error(w, "Could not fetch invoices", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invoices)
+}
+
+// MarkCompanyInvoicePaidHandler records a company's payment of an invoice
+func MarkCompanyInvoicePaidHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	invoiceID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.MarkCompanyInvoicePaid(invoiceID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Fakturaen er markert som betalt",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
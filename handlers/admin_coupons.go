@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"kjernekraft/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetCouponsHandler returns all coupons with their redemption statistics
+func GetCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	coupons, err := AdminDB.GetCoupons()
+	if err != nil {
+		http.Error(w, "Could not fetch coupons", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(coupons)
+}
+
+// GetCouponRedemptionsHandler returns who redeemed a coupon
+func GetCouponRedemptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	couponID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid coupon ID", http.StatusBadRequest)
+		return
+	}
+
+	redemptions, err := AdminDB.GetCouponRedemptions(couponID)
+	if err != nil {
+		http.Error(w, "Could not fetch coupon redemptions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redemptions)
+}
+
+// SaveCouponHandler creates or updates a coupon
+func SaveCouponHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var couponData struct {
+		ID             int      `json:"id"`
+		Code           string   `json:"code"`
+		Description    string   `json:"description"`
+		DiscountType   string   `json:"discount_type"`
+		DiscountValue  int      `json:"discount_value"`
+		DurationMonths int      `json:"duration_months"`
+		ProductTypes   []string `json:"product_types"`
+		MaxRedemptions int      `json:"max_redemptions"`
+		PerUserLimit   int      `json:"per_user_limit"`
+		ValidFrom      string   `json:"valid_from"`
+		ValidUntil     string   `json:"valid_until"`
+		Active         bool     `json:"active"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&couponData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	coupon := models.Coupon{
+		ID:             couponData.ID,
+		Code:           couponData.Code,
+		Description:    couponData.Description,
+		DiscountType:   couponData.DiscountType,
+		DiscountValue:  couponData.DiscountValue,
+		DurationMonths: couponData.DurationMonths,
+		ProductTypes:   couponData.ProductTypes,
+		MaxRedemptions: couponData.MaxRedemptions,
+		PerUserLimit:   couponData.PerUserLimit,
+		Active:         couponData.Active,
+	}
+
+	// The validity window is optional at both ends
+	if couponData.ValidFrom != "" {
+		validFrom, err := time.Parse("2006-01-02", couponData.ValidFrom)
+		if err != nil {
+			http.Error(w, "Invalid start date format", http.StatusBadRequest)
+			return
+		}
+		coupon.ValidFrom = &validFrom
+	}
+	if couponData.ValidUntil != "" {
+		validUntil, err := time.Parse("2006-01-02", couponData.ValidUntil)
+		if err != nil {
+			http.Error(w, "Invalid end date format", http.StatusBadRequest)
+			return
+		}
+		coupon.ValidUntil = &validUntil
+	}
+
+	couponID, err := AdminDB.SaveCoupon(coupon)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"message":   "Coupon saved successfully",
+		"coupon_id": couponID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteCouponHandler deletes an unused coupon, or deactivates one that has been redeemed
+func DeleteCouponHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	couponID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid coupon ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := AdminDB.DeleteCoupon(couponID)
+	if err != nil {
+		http.Error(w, "Could not delete coupon", http.StatusInternalServerError)
+		return
+	}
+
+	message := "Coupon deleted successfully"
+	if !deleted {
+		message = "Coupon has been redeemed and was deactivated instead"
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": message,
+		"deleted": deleted,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
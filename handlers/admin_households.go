@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GetHouseholdsHandler returns all households with their members
+func GetHouseholdsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	households, err := AdminDB.GetHouseholds()
+	if err != nil {
+		http.Error(w, "Could not fetch households", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(households)
+}
+
+// SaveHouseholdDiscountHandler sets the household discount for a plan
+func SaveHouseholdDiscountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var discountData struct {
+		MembershipID int64 `json:"membership_id"`
+		Percent      int   `json:"percent"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&discountData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.SetHouseholdDiscount(discountData.MembershipID, discountData.Percent); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Husstandsrabatten er lagret",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
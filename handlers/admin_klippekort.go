@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"kjernekraft/models"
+	"net/http"
+	"strconv"
+)
+
+// GetKlippekortRulesHandler returns the klippekort expiry and rollover rules
+func GetKlippekortRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := AdminDB.GetKlippekortRules()
+	if err != nil {
+		http.Error(w, "Could not retrieve klippekort rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// SaveKlippekortRulesHandler saves the klippekort expiry and rollover rules
+func SaveKlippekortRulesHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var rules models.KlippekortRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.SaveKlippekortRules(&rules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Klippekort rules saved successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetKlippekortPackagesAdminHandler returns every klippekort package, including retired ones
+func GetKlippekortPackagesAdminHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	packages, err := AdminDB.GetKlippekortPackagesForAdmin()
+	if err != nil {
+		http.Error(w, "Could not fetch klippekort packages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(packages)
+}
+
+// SaveKlippekortPackageHandler creates or updates a klippekort package
+func SaveKlippekortPackageHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var pkg models.KlippekortPackage
+	if err := json.NewDecoder(r.Body).Decode(&pkg); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	packageID, err := AdminDB.SaveKlippekortPackage(pkg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":    true,
+		"message":    "Klippekort-pakken er lagret",
+		"package_id": packageID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RetireKlippekortPackageHandler takes a klippekort package off sale
+func RetireKlippekortPackageHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	packageID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid package ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.RetireKlippekortPackage(packageID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Klippekort-pakken er tatt ut av salg",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ReorderKlippekortPackagesHandler sets the order of a category's packages on the purchase page
+func ReorderKlippekortPackagesHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var request struct {
+		Category   string  `json:"category"`
+		PackageIDs []int64 `json:"package_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.ReorderKlippekortPackages(request.Category, request.PackageIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Rekkefølgen er lagret",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SaveKlippekortPolicyHandler saves how klippekort in a category are topped up
+func SaveKlippekortPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var policy models.KlippekortCategoryPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.SaveKlippekortCategoryPolicy(policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Påfyllingsreglene er lagret",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"kjernekraft/handlers/config"
+	"kjernekraft/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// memberOverrideRequest is an admin override on a member's account. Which fields are used
+// depends on the action, see the models.AdminAction constants.
+type memberOverrideRequest struct {
+	UserID       int64  `json:"user_id"`
+	Action       string `json:"action"`
+	Reason       string `json:"reason"`
+	MembershipID int64  `json:"membership_id"`
+	Status       string `json:"status"`
+	Date         string `json:"date"`
+	Months       int    `json:"months"`
+	CustomPrice  *int   `json:"custom_price"` // In øre, null goes back to the plan's price
+	PackageID    int64  `json:"package_id"`
+	KlippekortID int64  `json:"klippekort_id"`
+	Klipp        int    `json:"klipp"`
+	ChargeID     int64  `json:"charge_id"`
+	Amount       int    `json:"amount"` // In øre
+}
+
+// MemberDetailPageHandler shows a member's membership, klippekort, charges, account credit and
+// the overrides admins have made, with forms for making new ones
+func MemberDetailPageHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	userID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	member, err := AdminDB.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Bruker ikke funnet", http.StatusNotFound)
+		return
+	}
+	membership, err := AdminDB.GetUserMembership(userID)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente medlemskap", http.StatusInternalServerError)
+		return
+	}
+	history, err := AdminDB.GetMembershipHistory(userID)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente medlemskapshistorikk", http.StatusInternalServerError)
+		return
+	}
+	klippekort, err := AdminDB.GetAllUserKlippekort(userID)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente klippekort", http.StatusInternalServerError)
+		return
+	}
+	packages, err := AdminDB.GetAllKlippekortPackages()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente klippekort-pakker", http.StatusInternalServerError)
+		return
+	}
+	charges, err := AdminDB.GetUserCharges(userID, "")
+	if err != nil {
+		http.Error(w, "Kunne ikke hente betalinger", http.StatusInternalServerError)
+		return
+	}
+	creditNotes, err := AdminDB.GetCreditNotes(userID)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente kreditnotaer", http.StatusInternalServerError)
+		return
+	}
+	creditBalance, err := AdminDB.GetCreditBalance(userID)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente tilgodehavende", http.StatusInternalServerError)
+		return
+	}
+	creditHistory, err := AdminDB.GetCreditHistory(userID)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente tilgodehavende", http.StatusInternalServerError)
+		return
+	}
+	actions, err := AdminDB.GetAdminActions(userID)
+	if err != nil {
+		http.Error(w, "Kunne ikke hente endringslogg", http.StatusInternalServerError)
+		return
+	}
+	memberships, err := AdminDB.GetAllMemberships()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente medlemskap", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":              member.Name,
+		"Member":             member,
+		"Membership":         membership,
+		"MembershipHistory":  history,
+		"Klippekort":         klippekort,
+		"KlippekortPackages": packages,
+		"Charges":            charges,
+		"CreditNotes":        creditNotes,
+		"CreditBalance":      creditBalance,
+		"CreditHistory":      creditHistory,
+		"AdminActions":       actions,
+		"Memberships":        memberships,
+		"Lang":               GetLanguageFromRequest(r),
+		"CurrentPage":        "admin",
+		"ExternalCSS":        []string{},
+	}
+
+	tm := GetTemplateManager()
+	if tmpl, exists := tm.GetTemplate("pages/admin-member"); exists {
+		w.Header().Set("Content-Type", "text/html")
+		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+			log.Printf("Error executing member detail template: %v", err)
+			http.Error(w, "Template execution error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Error(w, "Template not found", http.StatusInternalServerError)
+}
+
+// MemberOverrideHandler applies an admin override to a member's membership or klippekort.
+// Every override needs a reason, which is stored with it.
+func MemberOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var req memberOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	now := config.GetInstance().GetCurrentTime()
+	date := now
+	if req.Date != "" {
+		parsed, err := time.Parse("2006-01-02", req.Date)
+		if err != nil {
+			http.Error(w, "Ugyldig dato", http.StatusBadRequest)
+			return
+		}
+		date = parsed
+	}
+
+	var err error
+	switch req.Action {
+	case models.AdminActionAssignMembership:
+		err = AdminDB.AdminAssignMembership(req.UserID, req.MembershipID, req.Reason, now)
+	case models.AdminActionChangeMembership:
+		err = AdminDB.AdminChangeMembership(req.UserID, req.MembershipID, req.Reason, now)
+	case models.AdminActionEndMembership:
+		err = AdminDB.AdminEndMembership(req.UserID, date, req.Reason, now)
+	case models.AdminActionSetStatus:
+		err = AdminDB.AdminSetMembershipStatus(req.UserID, req.Status, req.Reason, now)
+	case models.AdminActionSetBindingEnd:
+		err = AdminDB.AdminSetBindingEnd(req.UserID, date, req.Reason, now)
+	case models.AdminActionGrantFreeMonths:
+		err = AdminDB.AdminGrantFreeMonths(req.UserID, req.Months, req.Reason, now)
+	case models.AdminActionSetCustomPrice:
+		err = AdminDB.AdminSetCustomPrice(req.UserID, req.CustomPrice, req.Reason, now)
+	case models.AdminActionGrantKlippekort:
+		err = AdminDB.AdminGrantKlippekort(req.UserID, req.PackageID, req.Klipp, req.Reason, now)
+	case models.AdminActionAdjustKlippekort:
+		err = AdminDB.AdminAdjustKlippekort(req.UserID, req.KlippekortID, req.Klipp, date, req.Reason, now)
+	case models.AdminActionExtendKlippekort:
+		err = AdminDB.AdminExtendKlippekort(req.UserID, req.KlippekortID, date, req.Reason, now)
+	case models.AdminActionRefundCharge:
+		err = AdminDB.AdminRefundCharge(req.UserID, req.ChargeID, req.Amount, req.Reason, now)
+	case models.AdminActionAdjustCredit:
+		err = AdminDB.AdminAdjustCredit(req.UserID, req.Amount, req.Reason, now)
+	default:
+		http.Error(w, "Ukjent handling", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Endringen er lagret",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetMemberActionsHandler returns the overrides admins have made on a member's account
+func GetMemberActionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	actions, err := AdminDB.GetAdminActions(userID)
+	if err != nil {
+		http.Error(w, "Could not fetch admin actions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(actions)
+}
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"kjernekraft/models"
 	"net/http"
 	"strconv"
+	"time"
 )
 
-// UpdateMembershipPriceHandler updates the price of a membership
+// UpdateMembershipPriceHandler schedules a new price version for a membership.
+// Existing members keep their current price until the notice period has passed.
 func UpdateMembershipPriceHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -17,8 +21,9 @@ func UpdateMembershipPriceHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO: Add admin authentication check here
 
 	var requestData struct {
-		MembershipID int `json:"membership_id"`
-		Price        int `json:"price"`
+		MembershipID  int    `json:"membership_id"`
+		Price         int    `json:"price"`
+		EffectiveDate string `json:"effective_date"` // Optional, defaults to today
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
@@ -26,20 +31,141 @@ func UpdateMembershipPriceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := AdminDB.UpdateMembershipPrice(int64(requestData.MembershipID), requestData.Price); err != nil {
-		http.Error(w, "Could not update membership price", http.StatusInternalServerError)
+	effectiveDate, err := parseEffectiveDate(requestData.EffectiveDate)
+	if err != nil {
+		http.Error(w, "Invalid effective date format", http.StatusBadRequest)
+		return
+	}
+
+	version, err := AdminDB.CreateMembershipPriceVersion(int64(requestData.MembershipID), requestData.Price, effectiveDate)
+	if err != nil {
+		http.Error(w, "Could not update membership price: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	response := map[string]interface{}{
-		"success": true,
-		"message": "Membership price updated successfully",
+		"success":       true,
+		"message":       "Membership price updated successfully",
+		"price_version": version,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// PreviewMembershipPriceHandler shows affected members and revenue impact of a price change before saving it
+func PreviewMembershipPriceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	membershipID, err := strconv.ParseInt(r.URL.Query().Get("membership_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid membership ID", http.StatusBadRequest)
+		return
+	}
+
+	price, err := strconv.Atoi(r.URL.Query().Get("price"))
+	if err != nil {
+		http.Error(w, "Invalid price", http.StatusBadRequest)
+		return
+	}
+
+	effectiveDate, err := parseEffectiveDate(r.URL.Query().Get("effective_date"))
+	if err != nil {
+		http.Error(w, "Invalid effective date format", http.StatusBadRequest)
+		return
+	}
+
+	preview, err := AdminDB.PreviewMembershipPriceChange(membershipID, price, effectiveDate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// GetMembershipPriceVersionsHandler returns the price history of a membership, or of all memberships
+func GetMembershipPriceVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var membershipID int64
+	if membershipIDStr := r.URL.Query().Get("membership_id"); membershipIDStr != "" {
+		var err error
+		membershipID, err = strconv.ParseInt(membershipIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid membership ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	versions, err := AdminDB.GetMembershipPriceVersions(membershipID)
+	if err != nil {
+		http.Error(w, "Could not fetch price versions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// PriceNoticeListHandler exports the members to notify about a price version as CSV
+func PriceNoticeListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	versionID, err := strconv.ParseInt(r.URL.Query().Get("version_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid version ID", http.StatusBadRequest)
+		return
+	}
+
+	version, members, err := AdminDB.GetPriceNoticeList(versionID)
+	if err != nil {
+		http.Error(w, "Could not generate notice list", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=prisvarsel-%d-%s.csv",
+		version.ID, version.EffectiveDate.Format("2006-01-02")))
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"Navn", "E-post", "Telefon", "Nåværende pris (øre)", "Ny pris (øre)", "Ny pris fra"})
+	for _, member := range members {
+		writer.Write([]string{
+			member.Name,
+			member.Email,
+			member.Phone,
+			strconv.Itoa(member.CurrentPrice),
+			strconv.Itoa(member.NewPrice),
+			member.NewPriceFrom.Format("2006-01-02"),
+		})
+	}
+	writer.Flush()
+}
+
+// parseEffectiveDate parses a YYYY-MM-DD date, where an empty string means today
+func parseEffectiveDate(value string) (time.Time, error) {
+	if value == "" {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
 // CreateMembershipHandler creates a new membership
 func CreateMembershipHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -55,6 +181,12 @@ func CreateMembershipHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A trial has to end by itself
+	if membership.IsTrial && membership.DurationDays <= 0 {
+		http.Error(w, "Trial memberships need a duration", http.StatusBadRequest)
+		return
+	}
+
 	// Set default values
 	membership.Active = true
 
@@ -74,6 +206,38 @@ func CreateMembershipHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// SaveMembershipEntitlementsHandler changes what a membership plan gives access to
+func SaveMembershipEntitlementsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var request struct {
+		MembershipID int64                         `json:"membership_id"`
+		Entitlements models.MembershipEntitlements `json:"entitlements"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.SetMembershipEntitlements(request.MembershipID, request.Entitlements); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Rettighetene er lagret",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // DeleteMembershipHandler deactivates a membership
 func DeleteMembershipHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// roleRequirementsFromList converts the qualifications picked in the class form to an event's role requirements
+func roleRequirementsFromList(roles []string) map[string]struct{} {
+	requirements := make(map[string]struct{})
+	for _, role := range roles {
+		if role = strings.TrimSpace(role); role != "" {
+			requirements[role] = struct{}{}
+		}
+	}
+	return requirements
+}
+
+// GetQualificationsHandler returns all qualifications that can be required by classes
+func GetQualificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	qualifications, err := AdminDB.GetQualifications()
+	if err != nil {
+		http.Error(w, "Could not fetch qualifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(qualifications)
+}
+
+// CreateQualificationHandler creates a new qualification, e.g. "reformer" for completing Reformer Intro
+func CreateQualificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var qualificationData struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&qualificationData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	qualificationID, err := AdminDB.CreateQualification(qualificationData.Name, qualificationData.Description)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":          true,
+		"message":          "Qualification created successfully",
+		"qualification_id": qualificationID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GrantQualificationHandler grants or revokes a qualification for a user
+func GrantQualificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var grantData struct {
+		UserID        int64  `json:"user_id"`
+		Qualification string `json:"qualification"`
+		Revoke        bool   `json:"revoke"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&grantData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if grantData.UserID == 0 || grantData.Qualification == "" {
+		http.Error(w, "User and qualification are required", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	message := "Qualification granted successfully"
+	if grantData.Revoke {
+		err = AdminDB.RevokeQualification(grantData.UserID, grantData.Qualification)
+		message = "Qualification revoked successfully"
+	} else {
+		err = AdminDB.GrantQualification(grantData.UserID, grantData.Qualification)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
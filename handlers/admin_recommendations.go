@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"kjernekraft/models"
+	"net/http"
+	"strconv"
+)
+
+// GetRecommendationRulesHandler returns the rules the membership questionnaire is scored with
+func GetRecommendationRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	rules, err := AdminDB.GetRecommendationRules()
+	if err != nil {
+		http.Error(w, "Could not fetch recommendation rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// SaveRecommendationRuleHandler creates or updates a recommendation rule
+func SaveRecommendationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var rule models.RecommendationRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	ruleID, err := AdminDB.SaveRecommendationRule(rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Recommendation rule saved successfully",
+		"rule_id": ruleID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteRecommendationRuleHandler removes a recommendation rule
+func DeleteRecommendationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	ruleID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.DeleteRecommendationRule(ruleID); err != nil {
+		http.Error(w, "Could not delete recommendation rule", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Recommendation rule deleted successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"kjernekraft/handlers/config"
+	"kjernekraft/models"
 	"net/http"
 )
 
@@ -55,4 +56,52 @@ func updateSettings(w http.ResponseWriter, r *http.Request) {
 		"status": "success",
 		"message": "Settings updated successfully",
 	})
-}
\ No newline at end of file
+}
+
+// SaveBusinessDetailsHandler saves the studio's details printed on receipts
+func SaveBusinessDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var details models.BusinessDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.SaveBusinessDetails(&details); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Firmaopplysningene er lagret",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SaveVATRateHandler sets the MVA rate used on receipts for a type of charge
+func SaveVATRateHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var rate models.VATRate
+	if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.SaveVATRate(rate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "MVA-satsen er lagret",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -16,12 +16,51 @@ func BetalingHandler(w http.ResponseWriter, r *http.Request) {
 	// Get language from cookies/request (using new system)
 	lang := GetLanguageFromRequest(r)
 
+	household, err := DB.GetHouseholdForUser(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch household", http.StatusInternalServerError)
+		return
+	}
+
+	company, err := DB.GetCompanyForEmployee(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch company", http.StatusInternalServerError)
+		return
+	}
+	pendingCompany, err := DB.GetPendingCompanyForEmployee(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch company", http.StatusInternalServerError)
+		return
+	}
+	managedCompany, err := DB.GetCompanyForAdmin(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch company", http.StatusInternalServerError)
+		return
+	}
+
+	creditBalance, err := DB.GetCreditBalance(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch account credit", http.StatusInternalServerError)
+		return
+	}
+	creditHistory, err := DB.GetCreditHistory(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch account credit", http.StatusInternalServerError)
+		return
+	}
+
 	data := map[string]interface{}{
-		"Title":       "Betaling",
-		"CurrentPage": "betaling",
-		"UserName":    user.Name,
-		"User":        user,
-		"Lang":        lang,
+		"Title":          "Betaling",
+		"CurrentPage":    "betaling",
+		"UserName":       user.Name,
+		"User":           user,
+		"Lang":           lang,
+		"Household":      household,
+		"Company":        company,
+		"PendingCompany": pendingCompany,
+		"ManagedCompany": managedCompany,
+		"CreditBalance":  creditBalance,
+		"CreditHistory":  creditHistory,
 	}
 
 	// Use the new template system
@@ -36,4 +75,4 @@ func BetalingHandler(w http.ResponseWriter, r *http.Request) {
 
 	// If template doesn't exist, return error
 	http.Error(w, "Template not found", http.StatusInternalServerError)
-}
\ No newline at end of file
+}
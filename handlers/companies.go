@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// EnrollInCompanyHandler enrolls the member in their employer's company account
+func EnrollInCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	company, pending, err := DB.EnrollInCompany(int64(user.ID), r.FormValue("invite_code"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message := fmt.Sprintf("Du er med i bedriftsavtalen til %s!", company.Name)
+	if pending {
+		message = fmt.Sprintf("Forespørselen er sendt. Du er med i bedriftsavtalen når %s har godkjent deg.", company.Name)
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"message": message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// LeaveCompanyHandler ends an enrollment. Members can leave themselves, company admins can remove employees.
+func LeaveCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID := int64(user.ID)
+	if r.FormValue("user_id") != "" {
+		var err error
+		userID, err = strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := DB.LeaveCompany(int64(user.ID), userID, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Bedriften betaler ikke lenger for medlemskapet fra neste betaling.",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ApproveCompanyEmployeeHandler lets a company admin approve a member who asked to join by email domain
+func ApproveCompanyEmployeeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := DB.ApproveCompanyEmployee(int64(user.ID), userID, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Den ansatte er godkjent i bedriftsavtalen.",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CompanyInvoiceHandler returns an invoice with its lines to the company's admins
+func CompanyInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invoiceID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := DB.GetCompanyInvoice(invoiceID)
+	if err != nil {
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+		return
+	}
+	company, err := DB.GetCompany(int64(invoice.CompanyID))
+	if err != nil || !company.IsAdmin(user.ID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invoice)
+}
+
+// CompanyPageHandler shows company admins which employees are enrolled and the company's invoices
+func CompanyPageHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/innlogging", http.StatusSeeOther)
+		return
+	}
+
+	company, err := DB.GetCompanyForAdmin(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch company", http.StatusInternalServerError)
+		return
+	}
+	if company == nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	employees, err := DB.GetCompanyEmployees(int64(company.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch employees", http.StatusInternalServerError)
+		return
+	}
+	invoices, err := DB.GetCompanyInvoices(int64(company.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch invoices", http.StatusInternalServerError)
+		return
+	}
+	memberships, err := DB.GetAllMemberships()
+	if err != nil {
+		http.Error(w, "Could not fetch memberships", http.StatusInternalServerError)
+		return
+	}
+
+	lang := GetLanguageFromRequest(r)
+
+	data := map[string]interface{}{
+		"Title":       company.Name,
+		"CurrentPage": "bedrift",
+		"UserName":    user.Name,
+		"User":        user,
+		"Lang":        lang,
+		"Company":     company,
+		"Employees":   employees,
+		"Invoices":    invoices,
+		"Memberships": memberships,
+	}
+
+	tm := GetTemplateManager()
+	if tmpl, exists := tm.GetTemplate("pages/bedrift"); exists {
+		w.Header().Set("Content-Type", "text/html")
+		if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+			log.Printf("Error executing company template: %v", err)
+			http.Error(w, "Template execution error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Error(w, "Template not found", http.StatusInternalServerError)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"kjernekraft/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// QuoteCouponHandler shows what a coupon code takes off a membership or klippekort package before checkout
+func QuoteCouponHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	productID, err := strconv.ParseInt(r.URL.Query().Get("product_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	// Look up the price of the product the code is used on
+	productType := r.URL.Query().Get("product_type")
+	var amount int
+	switch productType {
+	case models.CouponProductMembership:
+		membership, err := DB.GetMembershipByID(productID)
+		if err != nil {
+			http.Error(w, "Invalid membership ID", http.StatusBadRequest)
+			return
+		}
+		amount = membership.Price
+	case models.CouponProductKlippekort:
+		packages, err := DB.GetAllKlippekortPackages()
+		if err != nil {
+			http.Error(w, "Could not fetch klippekort packages", http.StatusInternalServerError)
+			return
+		}
+		found := false
+		for _, pkg := range packages {
+			if int64(pkg.ID) == productID {
+				amount = pkg.Price
+				found = true
+			}
+		}
+		if !found {
+			http.Error(w, "Invalid package ID", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Invalid product type", http.StatusBadRequest)
+		return
+	}
+
+	quote, err := DB.QuoteCoupon(int64(user.ID), r.URL.Query().Get("code"), productType, amount, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"quote":   quote,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -68,6 +68,47 @@ func UserKlippekortHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UserKlippHistoryHandler provides HTMX endpoint for the movements on the user's klippekort
+func UserKlippHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	history, err := DB.GetKlippHistory(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch klipp history", http.StatusInternalServerError)
+		log.Printf("Error fetching klipp history for user %d: %v", user.ID, err)
+		return
+	}
+
+	// Get language from request (default to Norwegian bokmål)
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = "nb"
+	}
+
+	tm := GetTemplateManager()
+	tmpl, exists := tm.GetTemplate("modules/membership/klipp-history")
+	if !exists {
+		http.Error(w, "Template not found", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Movements": history,
+		"Lang":      lang,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := tmpl.ExecuteTemplate(w, "klipp_history_module", data); err != nil {
+		log.Printf("Error executing klipp history template: %v", err)
+		http.Error(w, "Template execution error", http.StatusInternalServerError)
+	}
+}
+
 // UserMembershipHandler provides HTMX endpoint for user's membership display
 func UserMembershipHandler(w http.ResponseWriter, r *http.Request) {
 	// Get user from session
@@ -108,8 +149,22 @@ func UserMembershipHandler(w http.ResponseWriter, r *http.Request) {
 			membership.MonthsUntilBindingEnd = totalMonths
 		}
 
+		// Show a plan change that waits for the next renewal
+		membership.ScheduledMembership, err = DB.GetScheduledMembershipChange(userID)
+		if err != nil {
+			log.Printf("Error fetching scheduled membership change for user %d: %v", userID, err)
+		}
+
+		// A trial ends by itself unless the member picks a plan to continue on
+		if membership.DurationDays > 0 {
+			membership.TrialConversionOptions, err = DB.GetTrialConversionOptions()
+			if err != nil {
+				log.Printf("Error fetching trial conversion options for user %d: %v", userID, err)
+			}
+		}
+
 		// Business logic for what actions are available
-		membership.CanPause = membership.Status == "active"
+		membership.CanPause = membership.Status == "active" && membership.Entitlements.FreezeAllowed
 
 		// Can cancel if no binding period OR if binding period has ended
 		if membership.BindingEnd == nil {
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// RequestDiscountVerificationHandler lets members send proof of student or senior status
+func RequestDiscountVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	verification, err := DB.RequestDiscountVerification(int64(user.ID), r.FormValue("type"), r.FormValue("proof"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message := "Forespørselen er sendt og behandles av oss så snart som mulig!"
+	if verification.Status == "approved" {
+		message = "Seniorrabatt er godkjent!"
+	}
+
+	response := map[string]interface{}{
+		"success":      true,
+		"message":      message,
+		"verification": verification,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetPendingDiscountVerificationsHandler returns the student verifications waiting for approval
+func GetPendingDiscountVerificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	verifications, err := AdminDB.GetPendingDiscountVerifications()
+	if err != nil {
+		http.Error(w, "Could not fetch verifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verifications)
+}
+
+// ReviewDiscountVerificationHandler approves or rejects a student verification
+func ReviewDiscountVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	var reviewData struct {
+		ID      int64  `json:"id"`
+		Approve bool   `json:"approve"`
+		Note    string `json:"note"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reviewData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.ReviewDiscountVerification(reviewData.ID, reviewData.Approve, reviewData.Note, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message := "Verification rejected"
+	if reviewData.Approve {
+		message = "Verification approved"
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
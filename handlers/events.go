@@ -128,8 +128,8 @@ func EventSignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Sign up user for event
-	err = DB.SignupUserForEvent(int64(user.ID), eventID)
+	// Sign up user for event, in the studio or online for hybrid classes
+	err = DB.SignupUserForEventWithMode(int64(user.ID), eventID, r.FormValue("attendance_mode"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -139,6 +139,31 @@ func EventSignupHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Successfully signed up for event"))
 }
 
+// EventGuestHandler lets a member bring a guest to a class, using a guest pass from their plan
+func EventGuestHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	eventIDStr := r.FormValue("event_id")
+	eventID, err := strconv.ParseInt(eventIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	err = DB.BookGuestForEvent(int64(user.ID), eventID, r.FormValue("guest_name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Guest signed up for event"))
+}
+
 // EventCancelSignupHandler handles user cancellation of event signup
 func EventCancelSignupHandler(w http.ResponseWriter, r *http.Request) {
 	user := GetUserFromSession(r)
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jobsMu keeps the ticker and manual runs from billing the same renewal twice
+var jobsMu sync.Mutex
+
+// backgroundJob is a periodic task. run returns how many items it processed.
+type backgroundJob struct {
+	name string
+	run  func(now time.Time) (int, error)
+}
+
+// backgroundJobs are run in order by StartBackgroundJobs and RunBackgroundJobsHandler
+func backgroundJobs() []backgroundJob {
+	return []backgroundJob{
+		{name: "membership_prices", run: AdminDB.ApplyDueMembershipPrices},
+		{name: "discount_verifications", run: AdminDB.ExpireDiscountVerifications},
+		{name: "membership_freezes", run: AdminDB.EndExhaustedFreezes},
+		{name: "membership_trials", run: AdminDB.EndFixedTermMemberships},
+		{name: "household_entitlements", run: AdminDB.EndHouseholdEntitlements},
+		{name: "membership_renewals", run: AdminDB.RunMembershipRenewals},
+		{name: "company_invoices", run: AdminDB.GenerateCompanyInvoices},
+		{name: "klippekort_reminders", run: AdminDB.SendKlippekortReminders},
+		{name: "klippekort_expiry", run: AdminDB.ExpireKlippekort},
+	}
+}
+
+// runBackgroundJobs runs every job once and returns the number of items each processed
+func runBackgroundJobs(now time.Time) map[string]int {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	results := make(map[string]int)
+	for _, job := range backgroundJobs() {
+		count, err := job.run(now)
+		if err != nil {
+			log.Printf("Background job %s failed: %v", job.name, err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("Background job %s processed %d item(s)", job.name, count)
+		}
+		results[job.name] = count
+	}
+	return results
+}
+
+// StartBackgroundJobs runs the background jobs now and then at every interval
+func StartBackgroundJobs(interval time.Duration) {
+	go func() {
+		runBackgroundJobs(time.Now())
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runBackgroundJobs(now)
+		}
+	}()
+}
+
+// RunBackgroundJobsHandler lets admins run the background jobs right away
+func RunBackgroundJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// TODO: Add admin authentication check here
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Background jobs completed",
+		"results": runBackgroundJobs(time.Now()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
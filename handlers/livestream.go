@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"database/sql"
+	"kjernekraft/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// streamRevealWindow is how long before start the stream link is handed out to signed-up members
+const streamRevealWindow = 15 * time.Minute
+
+// isValidDeliveryMode checks a delivery mode from the admin forms, empty means in the studio
+func isValidDeliveryMode(deliveryMode string) bool {
+	switch deliveryMode {
+	case "", models.DeliveryInPerson, models.DeliveryOnline, models.DeliveryHybrid:
+		return true
+	}
+	return false
+}
+
+// EventStreamHandler sends a member signed up to attend online on to the class stream.
+// The stream URL never leaves the server unless the member is signed up online and the
+// class starts within streamRevealWindow. Joining counts as online attendance.
+func EventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	eventID, err := strconv.ParseInt(r.URL.Query().Get("event_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	attendanceMode, err := DB.GetUserAttendanceMode(int64(user.ID), eventID)
+	if err == sql.ErrNoRows || (err == nil && attendanceMode != models.DeliveryOnline) {
+		http.Error(w, "You are not signed up to attend this class online", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not check signup", http.StatusInternalServerError)
+		return
+	}
+
+	event, err := DB.GetEventByID(eventID)
+	if err != nil {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	if event.IsCancelled {
+		http.Error(w, "Class is cancelled", http.StatusGone)
+		return
+	}
+
+	now := time.Now()
+	if now.Before(event.StartTime.Add(-streamRevealWindow)) {
+		http.Error(w, "The stream opens 15 minutes before the class starts", http.StatusForbidden)
+		return
+	}
+	if now.After(event.EndTime) {
+		http.Error(w, "Class has ended", http.StatusGone)
+		return
+	}
+
+	streamURL, err := DB.GetEventStreamURL(eventID)
+	if err != nil || streamURL == "" {
+		http.Error(w, "Stream link has not been published yet", http.StatusNotFound)
+		return
+	}
+
+	if err := DB.RecordOnlineAttendance(int64(user.ID), eventID); err != nil {
+		// Don't keep the member out of class because attendance could not be recorded
+		log.Printf("Error recording online attendance for user %d, event %d: %v", user.ID, eventID, err)
+	}
+
+	http.Redirect(w, r, streamURL, http.StatusFound)
+}
@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"encoding/json"
 	"html/template"
+	"kjernekraft/database"
 	"kjernekraft/models"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // KlippekortPageHandler serves the klippekort two-step selection page
@@ -18,13 +23,38 @@ func KlippekortPageHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get language from cookies/request (using new system)
 	lang := GetLanguageFromRequest(r)
+
+	packages, err := DB.GetAllKlippekortPackages()
+	if err != nil {
+		http.Error(w, "Kunne ikke hente klippekort-pakker", http.StatusInternalServerError)
+		return
+	}
+	ownedKlippekort, err := DB.GetUserKlippekort(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Kunne ikke hente klippekort", http.StatusInternalServerError)
+		return
+	}
+	shares, err := DB.GetKlippekortShares(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Kunne ikke hente delte klippekort", http.StatusInternalServerError)
+		return
+	}
+	sharedKlippekort, err := DB.GetSharedKlippekort(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Kunne ikke hente delte klippekort", http.StatusInternalServerError)
+		return
+	}
 	
 	data := map[string]interface{}{
-		"Title":       "Klippekort",
-		"CurrentPage": "klippekort",
-		"UserName":    user.Name,
-		"User":        user,
-		"Lang":        lang,
+		"Title":             "Klippekort",
+		"CurrentPage":       "klippekort",
+		"UserName":          user.Name,
+		"User":              user,
+		"Lang":              lang,
+		"PackageCategories": groupKlippekortPackages(packages),
+		"OwnedKlippekort":   ownedKlippekort,
+		"KlippekortShares":  shares,
+		"SharedKlippekort":  sharedKlippekort,
 	}
 
 	// Use the new template system
@@ -41,6 +71,51 @@ func KlippekortPageHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Template not found", http.StatusInternalServerError)
 }
 
+// klippekortPackageView is a package on the purchase page with what it saves compared to
+// the most expensive package per session in its category
+type klippekortPackageView struct {
+	models.KlippekortPackage
+	Savings int
+}
+
+// klippekortCategoryView is a category on the purchase page with its packages in order
+type klippekortCategoryView struct {
+	Name                  string
+	LowestPricePerSession int
+	Packages              []klippekortPackageView
+}
+
+// groupKlippekortPackages groups packages sorted by category into the categories of the purchase page
+func groupKlippekortPackages(packages []models.KlippekortPackage) []klippekortCategoryView {
+	var categories []klippekortCategoryView
+	for _, pkg := range packages {
+		if len(categories) == 0 || categories[len(categories)-1].Name != pkg.Category {
+			categories = append(categories, klippekortCategoryView{Name: pkg.Category, LowestPricePerSession: pkg.PricePerSession})
+		}
+		category := &categories[len(categories)-1]
+		if pkg.PricePerSession < category.LowestPricePerSession {
+			category.LowestPricePerSession = pkg.PricePerSession
+		}
+		category.Packages = append(category.Packages, klippekortPackageView{KlippekortPackage: pkg})
+	}
+
+	for c := range categories {
+		highest := 0
+		for _, pkg := range categories[c].Packages {
+			if pkg.PricePerSession > highest {
+				highest = pkg.PricePerSession
+			}
+		}
+		for p := range categories[c].Packages {
+			pkg := &categories[c].Packages[p]
+			if savings := highest*pkg.KlippCount - pkg.Price; savings > 0 {
+				pkg.Savings = savings
+			}
+		}
+	}
+	return categories
+}
+
 // MembershipSelectorHandler serves the interactive membership selector page
 func MembershipSelectorHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if user is logged in
@@ -54,21 +129,46 @@ func MembershipSelectorHandler(w http.ResponseWriter, r *http.Request) {
 	membership, err := DB.GetUserMembership(int64(user.ID))
 	hasCurrentMembership := membership != nil && err == nil
 	
-	// Check if user has ever had a membership (for hiding offers)
-	// For now, we'll just use the current membership check
-	hasHadMembership := hasCurrentMembership
+	// Check if user has ever had a membership, including ended ones
+	history, err := DB.GetMembershipHistory(int64(user.ID))
+	if err != nil {
+		log.Printf("Error fetching membership history for user %d: %v", user.ID, err)
+	}
+	hasHadMembership := hasCurrentMembership || len(history) > 0
 	
-	// Determine page title and show special offer
+	// Determine page title
 	pageTitle := "Finn ditt perfekte medlemskap"
-	showSpecialOffer := true
 	
 	if hasCurrentMembership {
 		pageTitle = "Bytt medlemskapet mitt"
 	}
-	
-	if hasHadMembership {
-		showSpecialOffer = false
+
+	// Offers are only shown while a campaign the member is eligible for is running
+	now := time.Now()
+	campaigns, err := DB.GetEligibleCampaigns(int64(user.ID), now)
+	if err != nil {
+		log.Printf("Error fetching campaigns for user %d: %v", user.ID, err)
+	}
+	var specialOfferCampaign *models.Campaign
+	for i := range campaigns {
+		if campaigns[i].SpecialOfferPlan {
+			specialOfferCampaign = &campaigns[i]
+			break
+		}
 	}
+	showSpecialOffer := specialOfferCampaign != nil
+
+	// Student and senior plans need a valid verification, students renew theirs yearly
+	discountVerification, err := DB.GetUserDiscountVerification(int64(user.ID))
+	if err != nil {
+		log.Printf("Error fetching discount verification for user %d: %v", user.ID, err)
+	}
+	discountVerified, err := DB.HasValidDiscountVerification(int64(user.ID), now)
+	if err != nil {
+		log.Printf("Error checking discount verification for user %d: %v", user.ID, err)
+	}
+	discountRenewalDue := discountVerified && discountVerification != nil && discountVerification.ExpiresAt != nil &&
+		discountVerification.ExpiresAt.Before(now.AddDate(0, 0, database.VerificationReminderDays))
 
 	// Get language from cookies/request (using new system)
 	lang := GetLanguageFromRequest(r)
@@ -80,7 +180,12 @@ func MembershipSelectorHandler(w http.ResponseWriter, r *http.Request) {
 		"HasCurrentMembership": hasCurrentMembership,
 		"HasHadMembership":     hasHadMembership,
 		"ShowSpecialOffer":     showSpecialOffer,
+		"SpecialOfferCampaign": specialOfferCampaign,
+		"Campaigns":            campaigns,
 		"UserMembership":       membership,
+		"DiscountVerification": discountVerification,
+		"DiscountVerified":     discountVerified,
+		"DiscountRenewalDue":   discountRenewalDue,
 		"UserName":             user.Name,
 		"User":                 user,
 		"Lang":                 lang,
@@ -117,6 +222,12 @@ func MembershipRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
 	commitment := r.FormValue("commitment")
 	startTime := r.FormValue("start_time")
 
+	answers := map[string]string{
+		models.QuestionCommitment:    commitment,
+		models.QuestionStudentSenior: strconv.FormatBool(isStudentSenior),
+		models.QuestionStartTime:     startTime,
+	}
+
 	// Get all memberships
 	allMemberships, err := DB.GetAllMemberships()
 	if err != nil {
@@ -124,40 +235,44 @@ func MembershipRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Filter memberships based on criteria
-	var recommendations []models.Membership
+	rules, err := DB.GetRecommendationRules()
+	if err != nil {
+		http.Error(w, "Could not fetch recommendation rules", http.StatusInternalServerError)
+		return
+	}
+
+	// Special offer plans can only be signed up for while a campaign for them is running
+	liveCampaigns, err := DB.GetLiveCampaigns(time.Now())
+	if err != nil {
+		http.Error(w, "Could not fetch campaigns", http.StatusInternalServerError)
+		return
+	}
+	var memberships []models.Membership
 	for _, membership := range allMemberships {
-		// Check student/senior eligibility
-		if isStudentSenior != membership.IsStudentSenior {
+		if !membership.IsSpecialOffer {
+			memberships = append(memberships, membership)
 			continue
 		}
-
-		// Check commitment preferences
-		if commitment == "trial" {
-			// Show trial options (2-week trial, monthly pass)
-			if membership.ID == 7 || membership.ID == 8 {
-				recommendations = append(recommendations, membership)
+		for _, campaign := range liveCampaigns {
+			if campaign.MembershipID == membership.ID {
+				memberships = append(memberships, membership)
+				break
 			}
-		} else if commitment != "" {
-			commitmentMonths, _ := strconv.Atoi(commitment)
-			if membership.CommitmentMonths == commitmentMonths {
-				recommendations = append(recommendations, membership)
-			}
-		}
-
-		// Special handling for autumn offer
-		if startTime == "august" && membership.IsSpecialOffer {
-			recommendations = append(recommendations, membership)
 		}
 	}
 
-	// If no specific matches, show some default options
-	if len(recommendations) == 0 && commitment != "" {
-		for _, membership := range allMemberships {
-			if membership.IsStudentSenior == isStudentSenior && !membership.IsSpecialOffer {
-				recommendations = append(recommendations, membership)
-			}
+	recommendations, highlights := database.ScoreMemberships(memberships, rules, answers)
+
+	// JSON variant for clients other than the questionnaire
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		response := map[string]interface{}{
+			"recommendations": recommendations,
+			"highlights":      highlights,
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
 	}
 
 	// Check if this is an HTMX request
@@ -166,27 +281,26 @@ func MembershipRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
 	if isHTMX {
 		// Return HTML fragment for HTMX
 		data := struct {
-			Recommendations []models.Membership
-			ShowAutumnOffer bool
+			Recommendations []models.Recommendation
+			Highlights      []string
 		}{
 			Recommendations: recommendations,
-			ShowAutumnOffer: startTime == "august",
+			Highlights:      highlights,
 		}
 
 		tmpl := `{{if .Recommendations}}
 <div style="background: white; border-radius: 12px; padding: 1.5rem; box-shadow: 0 4px 12px rgba(0,0,0,0.1);">
     <h3 style="margin-bottom: 1.5rem; color: #333; font-size: 1.25rem;">Våre anbefalinger for deg:</h3>
     
-    {{if .ShowAutumnOffer}}
+    {{range .Highlights}}
     <div style="background: linear-gradient(135deg, #ff6b35, #f7931e); color: white; padding: 1rem; border-radius: 8px; margin-bottom: 1.5rem; text-align: center;">
-        <strong>🍂 Spesielt Høsttilbud!</strong><br>
-        Få 12-måneders pris med kun 4 måneders binding
+        <strong>{{.}}</strong>
     </div>
     {{end}}
     
     <div style="display: grid; gap: 1rem;">
         {{range .Recommendations}}
-        <div style="border: 2px solid {{if .IsSpecialOffer}}#ff6b35{{else}}#e0e0e0{{end}}; border-radius: 8px; padding: 1.5rem; {{if .IsSpecialOffer}}background-color: #fff5f0;{{end}}">
+        <div style="border: 2px solid {{if or .IsSpecialOffer .Highlighted}}#ff6b35{{else}}#e0e0e0{{end}}; border-radius: 8px; padding: 1.5rem; {{if or .IsSpecialOffer .Highlighted}}background-color: #fff5f0;{{end}}">
             {{if .IsSpecialOffer}}
             <div style="background: #ff6b35; color: white; padding: 0.25rem 0.75rem; border-radius: 12px; font-size: 0.8rem; font-weight: 600; display: inline-block; margin-bottom: 0.5rem;">
                 Spesialtilbud
@@ -262,14 +376,14 @@ func MembershipRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Return full page for regular form submission
 		data := struct {
-			Recommendations []models.Membership
-			ShowAutumnOffer bool
+			Recommendations []models.Recommendation
+			Highlights      []string
 			IsStudentSenior bool
 			Commitment      string
 			StartTime       string
 		}{
 			Recommendations: recommendations,
-			ShowAutumnOffer: startTime == "august",
+			Highlights:      highlights,
 			IsStudentSenior: isStudentSenior,
 			Commitment:      commitment,
 			StartTime:       startTime,
@@ -321,16 +435,15 @@ func MembershipRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
     <main class="main">
         <h1 class="page-title">Våre anbefalinger for deg</h1>
         
-        {{if .ShowAutumnOffer}}
+        {{range .Highlights}}
         <div style="background: linear-gradient(135deg, #ff6b35, #f7931e); color: white; padding: 1.5rem; border-radius: 12px; margin-bottom: 2rem; text-align: center;">
-            <strong style="font-size: 1.2rem;">🍂 Spesielt Høsttilbud!</strong><br>
-            Få 12-måneders pris med kun 4 måneders binding
+            <strong style="font-size: 1.2rem;">{{.}}</strong>
         </div>
         {{end}}
         
         <div class="recommendations">
             {{range .Recommendations}}
-            <div class="recommendation-card {{if .IsSpecialOffer}}special{{end}}">
+            <div class="recommendation-card {{if or .IsSpecialOffer .Highlighted}}special{{end}}">
                 {{if .IsSpecialOffer}}
                 <div class="special-badge">Spesialtilbud</div>
                 {{end}}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
 // FreezeMembershipHandler handles membership freeze requests
@@ -19,10 +20,11 @@ func FreezeMembershipHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The plan decides whether the membership can be frozen and for how many days a year
 	userID := int64(user.ID)
-	err := DB.UpdateMembershipStatus(userID, "freeze_requested")
+	err := DB.RequestMembershipFreeze(userID, time.Now())
 	if err != nil {
-		http.Error(w, "Could not freeze membership", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
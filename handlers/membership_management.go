@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 // AddMembershipHandler handles adding a membership to a user
@@ -28,8 +29,18 @@ func AddMembershipHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Campaign is optional
+	var campaignID int64
+	if campaignIDStr := r.FormValue("campaign_id"); campaignIDStr != "" {
+		campaignID, err = strconv.ParseInt(campaignIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid campaign ID", http.StatusBadRequest)
+			return
+		}
+	}
+
 	userID := int64(user.ID)
-	err = DB.AddUserMembership(userID, membershipID)
+	err = DB.CheckoutMembership(userID, membershipID, campaignID, r.FormValue("coupon_code"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
@@ -75,6 +86,29 @@ func ChangeMembershipHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Downgrades can wait until the next renewal instead of being credited now
+	if r.FormValue("when") == "next_renewal" {
+		if err := DB.ScheduleMembershipChange(userID, membershipID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response := map[string]interface{}{
+			"success": true,
+			"message": "Medlemskapet byttes ved neste fornyelse!",
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	quote, err := DB.QuoteMembershipChange(userID, membershipID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	err = DB.ChangeUserMembership(userID, membershipID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -84,6 +118,7 @@ func ChangeMembershipHandler(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"success": true,
 		"message": "Medlemskap endret!",
+		"quote":   quote,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -149,8 +184,82 @@ func CanChangeMembershipHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	// Quote the prorated amount so the member sees what they pay before confirming
+	quote, err := DB.QuoteMembershipChange(userID, membershipID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"quote":   quote,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CancelScheduledMembershipChangeHandler keeps the member on their current plan at the next renewal
+func CancelScheduledMembershipChangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := DB.CancelScheduledMembershipChange(int64(user.ID)); err != nil {
+		http.Error(w, "Could not cancel scheduled membership change", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Planlagt bytte av medlemskap er avbrutt!",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// TrialConversionHandler lets a member on a trial choose the plan to continue on when the trial ends
+func TrialConversionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	membershipID, err := strconv.ParseInt(r.FormValue("membership_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid membership ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := DB.SetTrialConversion(int64(user.ID), membershipID, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Du fortsetter på det valgte medlemskapet når prøveperioden er over!",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 // PurchaseKlippekortHandler handles purchasing klippekort packages
@@ -176,7 +285,7 @@ func PurchaseKlippekortHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := int64(user.ID)
-	err = DB.PurchaseKlippekort(userID, packageID)
+	err = DB.CheckoutKlippekort(userID, packageID, r.FormValue("coupon_code"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -189,4 +298,72 @@ func PurchaseKlippekortHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// ShareKlippekortHandler lets the owner of a klippekort share it with another member by email
+func ShareKlippekortHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	klippekortID, err := strconv.ParseInt(r.FormValue("klippekort_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid klippekort ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := DB.ShareKlippekort(int64(user.ID), klippekortID, r.FormValue("email"), time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Klippekortet er delt!",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeKlippekortShareHandler lets the owner of a klippekort stop sharing it with a member
+func RevokeKlippekortShareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	shareID, err := strconv.ParseInt(r.FormValue("share_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid share ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := DB.RevokeKlippekortShare(int64(user.ID), shareID, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Delingen er stoppet",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -1,22 +1,24 @@
 package modules
 
 import (
+	"html/template"
 	"io/ioutil"
+	"kjernekraft/models"
 	"path/filepath"
 )
 
 // ChargesModuleData represents the data needed for the charges module
 type ChargesModuleData struct {
 	HasCharges bool
-	Charges    interface{} // This will be []models.Charge in practice
+	Charges    interface{} // This will be []models.ChargeWithDetails in practice
 	Lang       string
-	ChargesCSS string
+	ChargesCSS template.CSS
 }
 
 // NewChargesModule creates a new charges module with the given data
 func NewChargesModule(charges interface{}, lang string) (*ChargesModuleData, error) {
 	// Load CSS content
-	cssPath := filepath.Join("handlers", "templates", "modules", "charges.css")
+	cssPath := filepath.Join("handlers", "templates", "modules", "membership", "charges.css")
 	cssContent, err := ioutil.ReadFile(cssPath)
 	if err != nil {
 		cssContent = []byte("/* CSS loading failed */")
@@ -28,6 +30,8 @@ func NewChargesModule(charges interface{}, lang string) (*ChargesModuleData, err
 		switch v := charges.(type) {
 		case []interface{}:
 			hasCharges = len(v) > 0
+		case []models.ChargeWithDetails:
+			hasCharges = len(v) > 0
 		default:
 			hasCharges = true // Assume true if not a slice
 		}
@@ -37,7 +41,7 @@ func NewChargesModule(charges interface{}, lang string) (*ChargesModuleData, err
 		HasCharges: hasCharges,
 		Charges:    charges,
 		Lang:       lang,
-		ChargesCSS: string(cssContent),
+		ChargesCSS: template.CSS(cssContent),
 	}, nil
 }
 
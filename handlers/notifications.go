@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// UserNotificationsHandler returns the logged-in user's latest notifications as JSON
+func UserNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	notifications, err := DB.GetUserNotifications(int64(user.ID), 20)
+	if err != nil {
+		log.Printf("Error fetching notifications for user %d: %v", user.ID, err)
+		http.Error(w, "Could not fetch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// MarkNotificationsReadHandler marks all of the logged-in user's notifications as read
+func MarkNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from session
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := DB.MarkNotificationsRead(int64(user.ID)); err != nil {
+		http.Error(w, "Could not update notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
@@ -1,14 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
 	"html/template"
+	"kjernekraft/handlers/config"
+	"kjernekraft/handlers/modules"
 	"kjernekraft/models"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
-	"strings"
-	"time"
 )
 
 // PaymentMethodsHandler provides HTMX endpoint for user's payment methods
@@ -20,30 +20,11 @@ func PaymentMethodsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// For now, return mock data since we don't have Stripe integration yet
-	paymentMethods := []models.PaymentMethod{
-		{
-			ID:          1,
-			UserID:      user.ID,
-			Type:        "card",
-			Last4:       "4242",
-			Brand:       "visa",
-			ExpiryMonth: 12,
-			ExpiryYear:  2025,
-			IsDefault:   true,
-			Active:      true,
-		},
-		{
-			ID:          2,
-			UserID:      user.ID,
-			Type:        "card",
-			Last4:       "5555",
-			Brand:       "mastercard",
-			ExpiryMonth: 8,
-			ExpiryYear:  2026,
-			IsDefault:   false,
-			Active:      true,
-		},
+	paymentMethods, err := DB.GetPaymentMethods(int64(user.ID))
+	if err != nil {
+		http.Error(w, "Could not fetch payment methods", http.StatusInternalServerError)
+		log.Printf("Error fetching payment methods for user %d: %v", user.ID, err)
+		return
 	}
 
 	data := struct {
@@ -60,22 +41,34 @@ func PaymentMethodsHandler(w http.ResponseWriter, r *http.Request) {
     <div class="payment-method-card {{if .IsDefault}}default{{end}}">
         <div class="payment-method-info">
             <div class="payment-method-icon">
-                {{if eq .Brand "visa"}}VISA
+                {{if eq .Type "vipps"}}VIPPS
+                {{else if eq .Brand "visa"}}VISA
                 {{else if eq .Brand "mastercard"}}MC
                 {{else if eq .Brand "amex"}}AMEX
                 {{else}}CARD
                 {{end}}
             </div>
             <div class="payment-method-details">
+                {{if eq .Type "vipps"}}
+                <div class="payment-method-brand">Vipps</div>
+                <div class="payment-method-last4">{{.MaskedPhoneNumber}}</div>
+                {{if eq .AgreementStatus "PENDING"}}
+                <div class="payment-method-expiry">Godkjenn avtalen i Vipps-appen for å betale medlemskapet</div>
+                {{else if or (eq .AgreementStatus "STOPPED") (eq .AgreementStatus "EXPIRED")}}
+                <div class="payment-method-expiry">Vipps-avtalen er stoppet, medlemskapet kan ikke trekkes</div>
+                {{end}}
+                {{else}}
                 <div class="payment-method-brand">{{.Brand}}</div>
                 <div class="payment-method-last4">•••• •••• •••• {{.Last4}}</div>
-                <div class="payment-method-expiry">Utløper {{.ExpiryMonth}}/{{.ExpiryYear}}</div>
+                {{if not .Active}}<div class="payment-method-expiry">Kortet er utløpt og kan ikke belastes</div>
+                {{else if .ExpiryYear}}<div class="payment-method-expiry">Utløper {{.ExpiryMonth}}/{{.ExpiryYear}}</div>{{end}}
+                {{end}}
             </div>
         </div>
         <div class="payment-method-actions">
             {{if .IsDefault}}
             <span class="default-badge">Standard</span>
-            {{else}}
+            {{else if .Active}}
             <button class="payment-method-btn set-default-btn" onclick="setDefaultPaymentMethod({{.ID}})">
                 Sett som standard
             </button>
@@ -117,151 +110,30 @@ func ChargesHandler(w http.ResponseWriter, r *http.Request) {
 	// Get filter type from query parameter
 	filterType := r.URL.Query().Get("type")
 
-	// For now, return mock data
-	allCharges := []models.ChargeWithDetails{
-		{
-			Charge: models.Charge{
-				ID:            1,
-				UserID:        user.ID,
-				Amount:        104000, // 1040 kr
-				Currency:      "NOK",
-				Status:        "succeeded",
-				Description:   "12-måneder medlemskap",
-				ChargeDate:    time.Now().AddDate(0, 0, -15), // 15 days ago
-				FailureReason: nil,
-				Type:          "medlemskap",
-			},
-			PaymentMethodLast4: stringPtr("4242"),
-			PaymentMethodBrand: stringPtr("visa"),
-		},
-		{
-			Charge: models.Charge{
-				ID:            2,
-				UserID:        user.ID,
-				Amount:        104000, // 1040 kr
-				Currency:      "NOK",
-				Status:        "succeeded",
-				Description:   "12-måneder medlemskap",
-				ChargeDate:    time.Now().AddDate(0, -1, -15), // 1 month and 15 days ago
-				FailureReason: nil,
-				Type:          "medlemskap",
-			},
-			PaymentMethodLast4: stringPtr("4242"),
-			PaymentMethodBrand: stringPtr("visa"),
-		},
-		{
-			Charge: models.Charge{
-				ID:            3,
-				UserID:        user.ID,
-				Amount:        104000, // 1040 kr
-				Currency:      "NOK",
-				Status:        "failed",
-				Description:   "12-måneder medlemskap",
-				ChargeDate:    time.Now().AddDate(0, -2, -10), // 2 months and 10 days ago
-				FailureReason: stringPtr("Insufficient funds"),
-				Type:          "medlemskap",
-			},
-			PaymentMethodLast4: stringPtr("5555"),
-			PaymentMethodBrand: stringPtr("mastercard"),
-		},
-		{
-			Charge: models.Charge{
-				ID:            4,
-				UserID:        user.ID,
-				Amount:        220000, // 2200 kr
-				Currency:      "NOK",
-				Status:        "succeeded",
-				Description:   "10 klipp Gruppetimer Sal",
-				ChargeDate:    time.Now().AddDate(0, 0, -5), // 5 days ago
-				FailureReason: nil,
-				Type:          "klippekort",
-			},
-			PaymentMethodLast4: stringPtr("4242"),
-			PaymentMethodBrand: stringPtr("visa"),
-		},
-		{
-			Charge: models.Charge{
-				ID:            5,
-				UserID:        user.ID,
-				Amount:        280000, // 2800 kr
-				Currency:      "NOK",
-				Status:        "succeeded",
-				Description:   "10 klipp Reformer/Apparatus",
-				ChargeDate:    time.Now().AddDate(0, 0, -20), // 20 days ago
-				FailureReason: nil,
-				Type:          "klippekort",
-			},
-			PaymentMethodLast4: stringPtr("4242"),
-			PaymentMethodBrand: stringPtr("visa"),
-		},
-	}
-
-	// Filter charges by type if specified
-	var charges []models.ChargeWithDetails
-	if filterType != "" {
-		for _, charge := range allCharges {
-			if charge.Type == filterType {
-				charges = append(charges, charge)
-			}
-		}
-	} else {
-		charges = allCharges
-	}
-
-	data := struct {
-		Charges    []models.ChargeWithDetails
-		HasCharges bool
-	}{
-		Charges:    charges,
-		HasCharges: len(charges) > 0,
+	// A household payer sees the charges for the members they pay for in the same list
+	charges, err := DB.GetUserCharges(int64(user.ID), filterType)
+	if err != nil {
+		http.Error(w, "Could not fetch charges", http.StatusInternalServerError)
+		log.Printf("Error fetching charges for user %d: %v", user.ID, err)
+		return
 	}
 
-	// Load and parse the component template directly
-	tmplFuncs := template.FuncMap{
-		"divf": func(a, b interface{}) float64 {
-			var aFloat, bFloat float64
-
-			switch v := a.(type) {
-			case int:
-				aFloat = float64(v)
-			case float64:
-				aFloat = v
-			default:
-				return 0
-			}
-
-			switch v := b.(type) {
-			case int:
-				bFloat = float64(v)
-			case float64:
-				bFloat = v
-			default:
-				return 0
-			}
-
-			if bFloat == 0 {
-				return 0
-			}
-			return aFloat / bFloat
-		},
-		"title": func(s string) string {
-			if len(s) == 0 {
-				return s
-			}
-			return strings.ToUpper(s[:1]) + s[1:]
-		},
+	lang := GetLanguageFromRequest(r)
+	moduleData, err := modules.NewChargesModule(charges, lang)
+	if err != nil {
+		http.Error(w, "Error creating module", http.StatusInternalServerError)
+		return
 	}
 
-	templatePath := filepath.Join("handlers", "templates", "components", "charges.html")
-	t, err := template.New("charges_component").Funcs(tmplFuncs).ParseFiles(templatePath)
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		log.Printf("Error parsing charges template: %v", err)
+	tm := GetTemplateManager()
+	tmpl, exists := tm.GetTemplate("modules/membership/charges")
+	if !exists {
+		http.Error(w, "Template not found", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	if err := t.ExecuteTemplate(w, "charges_component", data); err != nil {
+	if err := tmpl.ExecuteTemplate(w, "charges_module", moduleData); err != nil {
 		http.Error(w, "Template execution error", http.StatusInternalServerError)
 		log.Printf("Error executing charges template: %v", err)
 	}
@@ -282,14 +154,16 @@ func SetDefaultPaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	paymentMethodIDStr := r.FormValue("payment_method_id")
-	_, err := strconv.Atoi(paymentMethodIDStr)
+	paymentMethodID, err := strconv.ParseInt(paymentMethodIDStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid payment method ID", http.StatusBadRequest)
 		return
 	}
 
-	// TODO: Implement actual database update
-	// For now, just return success
+	if err := DB.SetDefaultPaymentMethod(int64(user.ID), paymentMethodID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Payment method set as default"))
 }
@@ -309,19 +183,47 @@ func RemovePaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	paymentMethodIDStr := r.FormValue("payment_method_id")
-	_, err := strconv.Atoi(paymentMethodIDStr)
+	paymentMethodID, err := strconv.ParseInt(paymentMethodIDStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid payment method ID", http.StatusBadRequest)
 		return
 	}
 
-	// TODO: Implement actual database removal and Stripe detachment
-	// For now, just return success
+	// TODO: Detach cards from Stripe once cards are charged through it
+	if err := DB.RemovePaymentMethod(int64(user.ID), paymentMethodID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Payment method removed"))
 }
 
-// Helper function
-func stringPtr(s string) *string {
-	return &s
-}
\ No newline at end of file
+// AddVippsPaymentMethodHandler adds Vipps as the user's payment method, with a recurring
+// agreement for their membership that they approve in the Vipps app
+func AddVippsPaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		PhoneNumber string `json:"phone_number"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	now := config.GetInstance().GetCurrentTime()
+	if _, err := DB.AddVippsPaymentMethod(int64(user.ID), req.PhoneNumber, now); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Vipps er lagt til som betalingsmetode",
+	})
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"fmt"
+	"kjernekraft/handlers/config"
+	"kjernekraft/handlers/pdf"
+	"kjernekraft/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formatReceiptAmount formats an amount in øre the way the language writes money
+func formatReceiptAmount(amount int, lang string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	kroner := strconv.Itoa(amount / 100)
+	separator, decimal := " ", ","
+	if lang == "en" {
+		separator, decimal = ",", "."
+	}
+	for i := len(kroner) - 3; i > 0; i -= 3 {
+		kroner = kroner[:i] + separator + kroner[i:]
+	}
+	if lang == "en" {
+		return fmt.Sprintf("%sNOK %s%s%02d", sign, kroner, decimal, amount%100)
+	}
+	return fmt.Sprintf("%s%s%s%02d kr", sign, kroner, decimal, amount%100)
+}
+
+// formatOrgNumber writes an organisation number in groups of three, with "MVA" after it
+// when the business is registered for MVA
+func formatOrgNumber(details models.BusinessDetails) string {
+	number := details.OrgNumber
+	if len(number) == 9 {
+		number = number[:3] + " " + number[3:6] + " " + number[6:]
+	}
+	if details.VATRegistered {
+		number += " MVA"
+	}
+	return number
+}
+
+// RenderReceiptPDF lays a receipt out as a one page PDF in the given language
+func RenderReceiptPDF(receipt *models.Receipt, lang string) []byte {
+	loc := GetLocalization()
+	t := func(key string) string { return loc.T(lang, "receipt."+key) }
+	amount := func(a int) string { return formatReceiptAmount(a, lang) }
+	date := receipt.IssuedAt.In(config.GetInstance().GetLocation()).Format("02.01.2006")
+
+	const left, right = 50.0, 545.0
+	doc := pdf.New(fmt.Sprintf("%s %d", t("title"), receipt.Number))
+
+	// Seller and receipt number
+	seller := receipt.Seller
+	doc.Text(left, 70, 18, true, seller.Name)
+	doc.TextRight(right, 70, 18, true, t("title"))
+	y := 90.0
+	for _, line := range []string{seller.Address, strings.TrimSpace(seller.PostalCode + " " + seller.City), seller.Email} {
+		if line != "" {
+			doc.Text(left, y, 10, false, line)
+			y += 14
+		}
+	}
+	if seller.OrgNumber != "" {
+		doc.Text(left, y, 10, false, t("org_number")+" "+formatOrgNumber(seller))
+	}
+	doc.TextRight(right, 90, 10, false, fmt.Sprintf("%s %d", t("number"), receipt.Number))
+	doc.TextRight(right, 104, 10, false, t("date")+" "+date)
+
+	// Customer
+	customer := receipt.Customer
+	y = 170
+	doc.Text(left, y, 10, true, t("customer"))
+	for _, line := range []string{customer.Name, customer.Address, strings.TrimSpace(customer.PostalCode + " " + customer.City), customer.Email} {
+		if line != "" {
+			y += 14
+			doc.Text(left, y, 10, false, line)
+		}
+	}
+
+	// Lines with MVA broken out
+	y += 40
+	const netX, rateX, vatX = 360.0, 420.0, 480.0
+	doc.Rect(left-5, y-13, right-left+10, 19, 0.92)
+	doc.Text(left, y, 9, true, t("description"))
+	doc.TextRight(netX, y, 9, true, t("net"))
+	doc.TextRight(rateX, y, 9, true, t("vat_rate"))
+	doc.TextRight(vatX, y, 9, true, t("vat"))
+	doc.TextRight(right, y, 9, true, t("amount"))
+	y += 20
+	for _, line := range receipt.Lines {
+		doc.TextRight(netX, y, 9, false, amount(line.NetAmount))
+		doc.TextRight(rateX, y, 9, false, fmt.Sprintf("%d %%", line.VATRate))
+		doc.TextRight(vatX, y, 9, false, amount(line.VATAmount))
+		doc.TextRight(right, y, 9, false, amount(line.Amount))
+		for _, text := range pdf.Wrap(line.Description, 9, false, netX-left-70) {
+			doc.Text(left, y, 9, false, text)
+			y += 13
+		}
+		y += 4
+	}
+	doc.Line(left-5, y, right+5, y)
+
+	// Totals, with the MVA summed per rate
+	y += 18
+	doc.Text(netX-60, y, 10, false, t("net_total"))
+	doc.TextRight(right, y, 10, false, amount(receipt.NetAmount))
+	vatByRate := map[int]int{}
+	for _, line := range receipt.Lines {
+		vatByRate[line.VATRate] += line.VATAmount
+	}
+	rates := make([]int, 0, len(vatByRate))
+	for rate := range vatByRate {
+		rates = append(rates, rate)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(rates)))
+	for _, rate := range rates {
+		y += 15
+		doc.Text(netX-60, y, 10, false, fmt.Sprintf("%s %d %%", t("vat"), rate))
+		doc.TextRight(right, y, 10, false, amount(vatByRate[rate]))
+	}
+	y += 20
+	doc.Text(netX-60, y, 11, true, t("total"))
+	doc.TextRight(right, y, 11, true, amount(receipt.Total))
+
+	y += 35
+	doc.Text(left, y, 10, false, t("paid")+" "+date)
+	if !seller.VATRegistered {
+		y += 15
+		doc.Text(left, y, 10, false, t("not_vat_registered"))
+	}
+
+	// Refunds made after the charge was paid
+	if len(receipt.CreditNotes) > 0 {
+		y += 30
+		doc.Text(left, y, 10, true, t("refunds"))
+		for _, note := range receipt.CreditNotes {
+			y += 15
+			doc.Text(left, y, 10, false, fmt.Sprintf("%s %s", note.Number, note.CreatedAt.In(config.GetInstance().GetLocation()).Format("02.01.2006")))
+			doc.TextRight(right, y, 10, false, amount(-note.Amount))
+		}
+	}
+
+	footer := seller.Name
+	if seller.OrgNumber != "" {
+		footer += " • " + t("org_number") + " " + formatOrgNumber(seller)
+	}
+	if seller.Email != "" {
+		footer += " • " + seller.Email
+	}
+	doc.Line(left-5, 790, right+5, 790)
+	doc.Text(left, 805, 8, false, footer)
+	return doc.Bytes()
+}
+
+// ReceiptAttachment returns the file name and PDF of the receipt for one of a member's
+// charges, for downloading or attaching to an email
+func ReceiptAttachment(userID, chargeID int64, lang string) (string, []byte, error) {
+	receipt, err := DB.GetReceipt(userID, chargeID)
+	if err != nil {
+		return "", nil, err
+	}
+	filename := fmt.Sprintf("%s-%d.pdf", GetLocalization().T(lang, "receipt.filename"), receipt.Number)
+	return filename, RenderReceiptPDF(receipt, lang), nil
+}
+
+// ReceiptHandler downloads the PDF receipt for one of the user's charges
+func ReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromSession(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chargeID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid charge ID", http.StatusBadRequest)
+		return
+	}
+
+	filename, content, err := ReceiptAttachment(int64(user.ID), chargeID, GetLanguageFromRequest(r))
+	if err != nil {
+		log.Printf("Could not make receipt for charge %d: %v", chargeID, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(content)
+}
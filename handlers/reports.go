@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"kjernekraft/handlers/config"
+	"kjernekraft/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportWeekdayNames are the Norwegian weekday names of time slots in the CSV export
+var reportWeekdayNames = map[string]string{
+	"monday": "Mandag", "tuesday": "Tirsdag", "wednesday": "Onsdag", "thursday": "Torsdag",
+	"friday": "Fredag", "saturday": "Lørdag", "sunday": "Søndag",
+}
+
+// parseReportPeriod reads the from and to dates of the KPI report, both included. The default
+// is the last twelve months up to today.
+func parseReportPeriod(r *http.Request, fromKey, toKey string) (time.Time, time.Time, error) {
+	loc := config.GetInstance().GetLocation()
+	now := config.GetInstance().GetCurrentTime().In(loc)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+	return parsePeriod(r, fromKey, toKey, monthStart.AddDate(0, -11, 0), tomorrow)
+}
+
+// formatPercent writes a rate with one decimal and a decimal comma
+func formatPercent(rate float64) string {
+	return strings.Replace(strconv.FormatFloat(rate, 'f', 1, 64), ".", ",", 1)
+}
+
+// WriteKPIReportCSV writes the report with one figure per line, so it can be filtered and
+// pivoted in a spreadsheet. Amounts are in kroner with MVA.
+func WriteKPIReportCSV(w io.Writer, report *models.KPIReport) error {
+	out := csv.NewWriter(w)
+	out.Comma = ';'
+	out.Write([]string{"Del", "Gjelder", "Nøkkeltall", "Verdi"})
+
+	asOf := report.AsOf.Format("2006-01-02")
+	out.Write([]string{"Oversikt", asOf, "MRR", formatAccountingAmount(report.MRR, ",")})
+	out.Write([]string{"Oversikt", asOf, "Aktive medlemmer", strconv.Itoa(report.ActiveMembers)})
+	out.Write([]string{"Oversikt", "I dag", "Ubrukte klipp", strconv.Itoa(report.OutstandingKlipp)})
+	out.Write([]string{"Oversikt", "I dag", "Verdi ubrukte klipp", formatAccountingAmount(report.KlippLiability, ",")})
+
+	for _, plan := range report.MembersByPlan {
+		out.Write([]string{"Medlemmer per plan", plan.Name, "Medlemmer", strconv.Itoa(plan.Members)})
+		out.Write([]string{"Medlemmer per plan", plan.Name, "MRR", formatAccountingAmount(plan.MRR, ",")})
+	}
+
+	for _, month := range report.Months {
+		name := month.Month.Format("2006-01")
+		out.Write([]string{"Måned", name, "Nye brukere", strconv.Itoa(month.Signups)})
+		out.Write([]string{"Måned", name, "Nye medlemmer", strconv.Itoa(month.NewMembers)})
+		out.Write([]string{"Måned", name, "Medlemmer ved start", strconv.Itoa(month.ActiveAtStart)})
+		out.Write([]string{"Måned", name, "Oppsigelser", strconv.Itoa(month.Cancellations)})
+		out.Write([]string{"Måned", name, "Frafalte medlemmer", strconv.Itoa(month.Churned)})
+		out.Write([]string{"Måned", name, "Frafall %", formatPercent(month.ChurnRate())})
+		out.Write([]string{"Måned", name, "Inntekt medlemskap", formatAccountingAmount(month.MembershipRevenue, ",")})
+		out.Write([]string{"Måned", name, "Inntekt klippekort", formatAccountingAmount(month.KlippekortRevenue, ",")})
+	}
+
+	for _, sales := range report.KlippekortSales {
+		out.Write([]string{"Klippekortsalg", sales.Name, "Solgt", strconv.Itoa(sales.Sold)})
+		out.Write([]string{"Klippekortsalg", sales.Name, "Klipp", strconv.Itoa(sales.Klipp)})
+		out.Write([]string{"Klippekortsalg", sales.Name, "Beløp", formatAccountingAmount(sales.Amount, ",")})
+	}
+
+	sections := []struct {
+		name string
+		rows []models.ClassUtilisation
+	}{
+		{"Timetype", report.ClassTypes},
+		{"Instruktør", report.Teachers},
+		{"Tidspunkt", report.TimeSlots},
+	}
+	for _, section := range sections {
+		for _, row := range section.rows {
+			key := row.Key
+			if row.Weekday != "" {
+				key = reportWeekdayNames[row.Weekday] + " " + key
+			}
+			out.Write([]string{section.name, key, "Timer", strconv.Itoa(row.Classes)})
+			out.Write([]string{section.name, key, "Plasser", strconv.Itoa(row.Capacity)})
+			out.Write([]string{section.name, key, "Påmeldte", strconv.Itoa(row.Booked)})
+			out.Write([]string{section.name, key, "Utnyttelse %", formatPercent(row.UtilisationRate())})
+			out.Write([]string{section.name, key, "Uteblitt", strconv.Itoa(row.NoShows)})
+			out.Write([]string{section.name, key, "Uteblitt %", formatPercent(row.NoShowRate())})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// KPIReportExportHandler downloads the KPI report of a period as CSV
+func KPIReportExportHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	from, to, err := parseReportPeriod(r, "from", "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := AdminDB.GetKPIReport(from, to, config.GetInstance().GetCurrentTime())
+	if err != nil {
+		log.Printf("Error computing KPI report: %v", err)
+		http.Error(w, "Kunne ikke lage rapporten", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("nokkeltall_%s_%s.csv", from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := WriteKPIReportCSV(w, report); err != nil {
+		log.Printf("Error writing KPI report: %v", err)
+	}
+}
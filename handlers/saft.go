@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"io"
+	"kjernekraft/handlers/config"
+	"kjernekraft/models"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// saftNamespace is the namespace of the Norwegian SAF-T Financial format, version 1.30
+const saftNamespace = "urn:StandardAuditFile-Taxation-Financial:NO"
+
+// saftAccountNames describes the accounts from the standard chart of accounts the export uses
+var saftAccountNames = map[string]string{
+	"1500": "Kundefordringer",
+	"1920": "Bankinnskudd",
+	"2700": "Utgående merverdiavgift, høy sats",
+	"2701": "Utgående merverdiavgift, middels sats",
+	"2702": "Utgående merverdiavgift, lav sats",
+	"3000": "Salgsinntekt, avgiftspliktig",
+	"3010": "Salgsinntekt klippekort, avgiftspliktig",
+	"3100": "Salgsinntekt, avgiftsfri",
+}
+
+// saftVATCodeNames describes the SAF-T standard MVA codes the export uses
+var saftVATCodeNames = map[string]string{
+	"0":  "Ingen merverdiavgiftsbehandling",
+	"3":  "Utgående merverdiavgift, alminnelig sats",
+	"31": "Utgående merverdiavgift, middels sats",
+	"33": "Utgående merverdiavgift, lav sats",
+	"6":  "Omsetning utenfor merverdiavgiftsloven",
+}
+
+type saftAuditFile struct {
+	XMLName     xml.Name          `xml:"AuditFile"`
+	Namespace   string            `xml:"xmlns,attr"`
+	Header      saftHeader        `xml:"Header"`
+	MasterFiles saftMasterFiles   `xml:"MasterFiles"`
+	Entries     saftLedgerEntries `xml:"GeneralLedgerEntries"`
+}
+
+type saftHeader struct {
+	AuditFileVersion     string        `xml:"AuditFileVersion"`
+	AuditFileCountry     string        `xml:"AuditFileCountry"`
+	AuditFileDateCreated string        `xml:"AuditFileDateCreated"`
+	SoftwareCompanyName  string        `xml:"SoftwareCompanyName"`
+	SoftwareID           string        `xml:"SoftwareID"`
+	SoftwareVersion      string        `xml:"SoftwareVersion"`
+	Company              saftCompany   `xml:"Company"`
+	DefaultCurrencyCode  string        `xml:"DefaultCurrencyCode"`
+	SelectionCriteria    saftSelection `xml:"SelectionCriteria"`
+	TaxAccountingBasis   string        `xml:"TaxAccountingBasis"`
+}
+
+type saftCompany struct {
+	RegistrationNumber string      `xml:"RegistrationNumber"`
+	Name               string      `xml:"Name"`
+	Address            saftAddress `xml:"Address"`
+}
+
+type saftAddress struct {
+	StreetName string `xml:"StreetName,omitempty"`
+	City       string `xml:"City,omitempty"`
+	PostalCode string `xml:"PostalCode,omitempty"`
+	Country    string `xml:"Country"`
+}
+
+type saftSelection struct {
+	SelectionStartDate string `xml:"SelectionStartDate"`
+	SelectionEndDate   string `xml:"SelectionEndDate"`
+}
+
+type saftMasterFiles struct {
+	Accounts  []saftAccount  `xml:"GeneralLedgerAccounts>Account"`
+	Customers []saftCustomer `xml:"Customers>Customer"`
+	TaxTable  saftTaxTable   `xml:"TaxTable"`
+}
+
+type saftAccount struct {
+	AccountID            string `xml:"AccountID"`
+	AccountDescription   string `xml:"AccountDescription"`
+	StandardAccountID    string `xml:"StandardAccountID"`
+	AccountType          string `xml:"AccountType"`
+	OpeningDebitBalance  string `xml:"OpeningDebitBalance"`
+	ClosingDebitBalance  string `xml:"ClosingDebitBalance,omitempty"`
+	ClosingCreditBalance string `xml:"ClosingCreditBalance,omitempty"`
+}
+
+type saftCustomer struct {
+	Name       string      `xml:"Name"`
+	Address    saftAddress `xml:"Address"`
+	CustomerID string      `xml:"CustomerID"`
+	AccountID  string      `xml:"BalanceAccount>AccountID"`
+}
+
+type saftTaxTable struct {
+	TaxType     string        `xml:"TaxTableEntry>TaxType"`
+	Description string        `xml:"TaxTableEntry>Description"`
+	Codes       []saftTaxCode `xml:"TaxTableEntry>TaxCodeDetails"`
+}
+
+type saftTaxCode struct {
+	TaxCode         string `xml:"TaxCode"`
+	Description     string `xml:"Description"`
+	TaxPercentage   string `xml:"TaxPercentage"`
+	Country         string `xml:"Country"`
+	StandardTaxCode string `xml:"StandardTaxCode"`
+	BaseRate        string `xml:"BaseRate"`
+}
+
+type saftLedgerEntries struct {
+	NumberOfEntries int         `xml:"NumberOfEntries"`
+	TotalDebit      string      `xml:"TotalDebit"`
+	TotalCredit     string      `xml:"TotalCredit"`
+	Journal         saftJournal `xml:"Journal"`
+}
+
+type saftJournal struct {
+	JournalID    string            `xml:"JournalID"`
+	Description  string            `xml:"Description"`
+	Type         string            `xml:"Type"`
+	Transactions []saftTransaction `xml:"Transaction"`
+}
+
+type saftTransaction struct {
+	TransactionID   string     `xml:"TransactionID"`
+	Period          int        `xml:"Period"`
+	PeriodYear      int        `xml:"PeriodYear"`
+	TransactionDate string     `xml:"TransactionDate"`
+	Description     string     `xml:"Description"`
+	SystemEntryDate string     `xml:"SystemEntryDate"`
+	GLPostingDate   string     `xml:"GLPostingDate"`
+	CustomerID      string     `xml:"CustomerID,omitempty"`
+	Lines           []saftLine `xml:"Line"`
+}
+
+type saftLine struct {
+	RecordID     string       `xml:"RecordID"`
+	AccountID    string       `xml:"AccountID"`
+	CustomerID   string       `xml:"CustomerID,omitempty"`
+	Description  string       `xml:"Description"`
+	DebitAmount  *saftAmount  `xml:"DebitAmount,omitempty"`
+	CreditAmount *saftAmount  `xml:"CreditAmount,omitempty"`
+	Tax          *saftTaxInfo `xml:"TaxInformation,omitempty"`
+}
+
+type saftAmount struct {
+	Amount string `xml:"Amount"`
+}
+
+type saftTaxInfo struct {
+	TaxType       string     `xml:"TaxType"`
+	TaxCode       string     `xml:"TaxCode"`
+	TaxPercentage string     `xml:"TaxPercentage"`
+	TaxBase       string     `xml:"TaxBase"`
+	TaxAmount     saftAmount `xml:"TaxAmount"`
+}
+
+// saftLedger sums the balance of each account and the debits and credits of the file
+type saftLedger struct {
+	balances map[string]int
+	debit    int
+	credit   int
+}
+
+// post adds a line for an amount to an account, on the debit side when debit is set. Negative
+// amounts go on the other side.
+func (l *saftLedger) post(t *saftTransaction, account, customerID, description string, amount int, debit bool, tax *saftTaxInfo) {
+	if amount == 0 {
+		return
+	}
+	if amount < 0 {
+		amount, debit = -amount, !debit
+	}
+	line := saftLine{
+		RecordID:    t.TransactionID + "-" + strconv.Itoa(len(t.Lines)+1),
+		AccountID:   account,
+		CustomerID:  customerID,
+		Description: description,
+		Tax:         tax,
+	}
+	value := &saftAmount{formatAccountingAmount(amount, ".")}
+	if debit {
+		line.DebitAmount = value
+		l.balances[account] += amount
+		l.debit += amount
+	} else {
+		line.CreditAmount = value
+		l.balances[account] -= amount
+		l.credit += amount
+	}
+	t.Lines = append(t.Lines, line)
+}
+
+// WriteSAFT writes the entries of a period as a SAF-T Financial file. Each entry is a
+// transaction debiting the settlement account and crediting revenue and output MVA. The
+// account balances cover the entries of the period only.
+func WriteSAFT(w io.Writer, entries []models.AccountingEntry, seller models.BusinessDetails, from, to, now time.Time) error {
+	loc := config.GetInstance().GetLocation()
+	file := saftAuditFile{
+		Namespace: saftNamespace,
+		Header: saftHeader{
+			AuditFileVersion:     "1.30",
+			AuditFileCountry:     "NO",
+			AuditFileDateCreated: now.In(loc).Format("2006-01-02"),
+			SoftwareCompanyName:  seller.Name,
+			SoftwareID:           "Kjernekraft",
+			SoftwareVersion:      "1.0",
+			Company: saftCompany{
+				RegistrationNumber: seller.OrgNumber,
+				Name:               seller.Name,
+				Address:            saftAddress{StreetName: seller.Address, City: seller.City, PostalCode: seller.PostalCode, Country: "NO"},
+			},
+			DefaultCurrencyCode: "NOK",
+			SelectionCriteria:   saftSelection{from.Format("2006-01-02"), to.Format("2006-01-02")},
+			TaxAccountingBasis:  "A",
+		},
+	}
+
+	ledger := &saftLedger{balances: map[string]int{}}
+	customers := map[string]bool{}
+	taxCodes := map[string]int{}
+	journal := saftJournal{JournalID: "S", Description: "Salg", Type: "S"}
+	for _, e := range entries {
+		date := e.Date.In(loc)
+		t := saftTransaction{
+			TransactionID:   e.Voucher,
+			Period:          int(date.Month()),
+			PeriodYear:      date.Year(),
+			TransactionDate: date.Format("2006-01-02"),
+			Description:     e.Description,
+			SystemEntryDate: date.Format("2006-01-02T15:04:05"),
+			GLPostingDate:   date.Format("2006-01-02"),
+			CustomerID:      e.CustomerID,
+		}
+		tax := &saftTaxInfo{
+			TaxType:       "MVA",
+			TaxCode:       e.VATCode,
+			TaxPercentage: strconv.Itoa(e.VATRate),
+			TaxBase:       formatAccountingAmount(abs(e.NetAmount), "."),
+			TaxAmount:     saftAmount{formatAccountingAmount(abs(e.VATAmount), ".")},
+		}
+		ledger.post(&t, e.SettlementAccount, e.CustomerID, e.Description, e.Amount, true, nil)
+		ledger.post(&t, e.RevenueAccount, "", e.Description, e.NetAmount, false, tax)
+		if e.VATAccount != "" {
+			ledger.post(&t, e.VATAccount, "", e.Description, e.VATAmount, false, nil)
+		}
+		journal.Transactions = append(journal.Transactions, t)
+
+		if !customers[e.CustomerID] {
+			customers[e.CustomerID] = true
+			file.MasterFiles.Customers = append(file.MasterFiles.Customers, saftCustomer{
+				Name: e.CustomerName, Address: saftAddress{Country: "NO"}, CustomerID: e.CustomerID, AccountID: "1500",
+			})
+		}
+		taxCodes[e.VATCode] = e.VATRate
+	}
+
+	accounts := make([]string, 0, len(ledger.balances))
+	for account := range ledger.balances {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	for _, account := range accounts {
+		a := saftAccount{
+			AccountID:           account,
+			AccountDescription:  saftAccountNames[account],
+			StandardAccountID:   account[:2],
+			AccountType:         "GL",
+			OpeningDebitBalance: "0.00",
+		}
+		if a.AccountDescription == "" {
+			a.AccountDescription = "Konto " + account
+		}
+		if balance := ledger.balances[account]; balance >= 0 {
+			a.ClosingDebitBalance = formatAccountingAmount(balance, ".")
+		} else {
+			a.ClosingCreditBalance = formatAccountingAmount(-balance, ".")
+		}
+		file.MasterFiles.Accounts = append(file.MasterFiles.Accounts, a)
+	}
+
+	file.MasterFiles.TaxTable = saftTaxTable{TaxType: "MVA", Description: "Merverdiavgift"}
+	codes := make([]string, 0, len(taxCodes))
+	for code := range taxCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		file.MasterFiles.TaxTable.Codes = append(file.MasterFiles.TaxTable.Codes, saftTaxCode{
+			TaxCode:         code,
+			Description:     saftVATCodeNames[code],
+			TaxPercentage:   strconv.Itoa(taxCodes[code]),
+			Country:         "NO",
+			StandardTaxCode: code,
+			BaseRate:        "100",
+		})
+	}
+
+	file.Entries = saftLedgerEntries{
+		NumberOfEntries: len(journal.Transactions),
+		TotalDebit:      formatAccountingAmount(ledger.debit, "."),
+		TotalCredit:     formatAccountingAmount(ledger.credit, "."),
+		Journal:         journal,
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(file); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
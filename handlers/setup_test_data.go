@@ -67,10 +67,10 @@ func setupTestData() error {
 
 func setupMembershipAndKlippekortData(userID int64) error {
 	// Create membership types if they don't exist
-	_, err := DB.Conn.Exec(`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(1, 'Standard Medlemskap', 99900, 12, false, false, 'Vårt mest populære medlemskap', '["Ubegrenset tilgang", "Gruppetimer", "Rabatt på personlig trening"]', true),
-		(2, 'Premium Medlemskap', 149900, 6, false, false, 'All-inclusive pakke', '["Alt fra Standard", "Klippekort inkludert", "Gratis workshops"]', true),
-		(3, 'Student Medlemskap', 69900, 0, true, true, 'Spesialpris for studenter', '["Grunnleggende tilgang", "Utvalgte gruppetimer"]', true)
+	_, err := DB.Conn.Exec(`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(1, 'Standard Medlemskap', 99900, 12, false, false, 'Vårt mest populære medlemskap', '{"freeze_allowed": true, "freeze_days_per_year": 60, "guest_passes_per_month": 2, "online_access": true, "perks": ["Rabatt på personlig trening"]}', true),
+		(2, 'Premium Medlemskap', 149900, 6, false, false, 'All-inclusive pakke', '{"freeze_allowed": true, "guest_passes_per_month": 4, "online_access": true, "perks": ["Klippekort inkludert", "Gratis workshops"]}', true),
+		(3, 'Student Medlemskap', 69900, 0, true, true, 'Spesialpris for studenter', '{"class_types": ["yoga", "pilates"], "weekly_class_limit": 3}', true)
 	`)
 	if err != nil {
 		log.Printf("Warning: Could not create membership types: %v", err)
@@ -119,6 +119,9 @@ func setupMembershipAndKlippekortData(userID int64) error {
 		if err != nil {
 			log.Printf("Warning: Could not create user klippekort: %v", err)
 		}
+		if err := DB.OpenKlippekortLedgers(); err != nil {
+			log.Printf("Warning: Could not open klippekort ledgers: %v", err)
+		}
 	}
 
 	log.Printf("Setup test data completed for user ID: %d", userID)
@@ -9,6 +9,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -77,6 +78,16 @@ func getTemplateFuncs() template.FuncMap {
 			}
 			return aFloat / bFloat
 		},
+		"deref": func(v interface{}) interface{} {
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.Ptr {
+				return v
+			}
+			if rv.IsNil() {
+				return nil
+			}
+			return rv.Elem().Interface()
+		},
 		"formatTime": func(t time.Time, format string) string {
 			return t.In(settings.GetLocation()).Format(format)
 		},
@@ -186,11 +186,25 @@ func shuffleTestData() error {
 		}
 	}
 
-	// Insert events into database
+	// Reformer classes require completing Reformer Intro
+	if _, err := DB.CreateQualification("reformer", "Fullført Reformer Intro"); err != nil {
+		log.Printf("Error creating reformer qualification: %v", err)
+	}
+
+	// Insert events into database, skipping days when the studio is closed
 	successCount := 0
 	for _, event := range events {
-		_, err := DB.CreateEvent(event)
+		closure, err := DB.GetClosureForDate(event.StartTime)
 		if err != nil {
+			return err
+		}
+		if closure != nil {
+			continue
+		}
+		if event.Title == "Pilates Reformer" {
+			event.RoleRequirements = map[string]struct{}{"reformer": {}}
+		}
+		if _, err := DB.CreateEvent(event); err != nil {
 			log.Printf("Error creating event %s: %v", event.Title, err)
 		} else {
 			successCount++
@@ -350,8 +364,7 @@ func shuffleUserKlippekortData() error {
 
 	for _, klipp := range userKlippekort {
 		newRemaining := rand.Intn(klipp.TotalKlipp + 1)
-		_, err := DB.Conn.Exec(`UPDATE user_klippekort SET remaining_klipp = ? WHERE id = ?`, 
-			newRemaining, klipp.UserKlippekort.ID)
+		err := DB.SetKlippekortBalance(int64(klipp.UserKlippekort.ID), newRemaining, "Testdata", time.Now())
 		if err != nil {
 			log.Printf("Error updating user klippekort %d: %v", klipp.UserKlippekort.ID, err)
 		}
@@ -84,7 +84,21 @@ func ElevTimeplanHandler(w http.ResponseWriter, r *http.Request) {
 		} else {
 			// Update events with signup information
 			for i := range weekEvents {
-				weekEvents[i].IsUserSignedUp = userSignups[int64(weekEvents[i].ID)]
+				attendanceMode, signedUp := userSignups[int64(weekEvents[i].ID)]
+				weekEvents[i].IsUserSignedUp = signedUp
+				weekEvents[i].UserAttendanceMode = attendanceMode
+			}
+		}
+
+		// Explain why classes with prerequisites are locked for this user
+		userRoles, err := DB.GetUserRoles(int64(user.ID))
+		if err == nil {
+			for i := range weekEvents {
+				if weekEvents[i].IsUserSignedUp {
+					continue
+				}
+				missing := weekEvents[i].MissingRequirements(userRoles)
+				weekEvents[i].LockedReasons, _ = DB.DescribeRequirements(missing)
 			}
 		}
 	}
@@ -120,6 +134,21 @@ func ElevTimeplanHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Mark days when the studio is closed
+	closuresByDay := make(map[string]string)
+	closures, err := DB.GetClosures(weekDates[0], weekDates[6])
+	if err == nil {
+		for _, date := range weekDates {
+			dateKey := date.Format("2006-01-02")
+			for _, closure := range closures {
+				if dateKey >= closure.StartDate.Format("2006-01-02") && dateKey <= closure.EndDate.Format("2006-01-02") {
+					closuresByDay[dateKey] = closure.Name
+					break
+				}
+			}
+		}
+	}
+
 	// Calculate week title
 	var weekTitle string
 	_, targetWeek := targetMonday.ISOWeek()
@@ -151,6 +180,7 @@ func ElevTimeplanHandler(w http.ResponseWriter, r *http.Request) {
 		"WeekDays":     weekdays,
 		"WeekDates":    weekDates,
 		"EventsByDay":  eventsByDay,
+		"ClosuresByDay": closuresByDay,
 		"Today":        now.Format("2006-01-02"),
 		"Teachers":     teachers,
 		"ClassTypes":   classTypes,
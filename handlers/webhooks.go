@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kjernekraft/handlers/config"
+	"kjernekraft/models"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookSecret is shared with the payment providers to sign the events they deliver, see
+// LoadWebhookSecret
+var WebhookSecret string
+
+// webhookSecretEnv is the environment variable holding the webhook secret
+const webhookSecretEnv = "WEBHOOK_SECRET"
+
+// LoadWebhookSecret reads the webhook secret from the environment. The server must not start
+// without one, or anyone could deliver payment events.
+func LoadWebhookSecret() error {
+	secret := strings.TrimSpace(os.Getenv(webhookSecretEnv))
+	if secret == "" {
+		return fmt.Errorf("%s is not set, it must hold the secret the payment providers sign webhooks with", webhookSecretEnv)
+	}
+	WebhookSecret = secret
+	return nil
+}
+
+// WebhookSignatureHeader holds the signature of a delivered event, e.g. t=1700000000,v1=<hex>
+const WebhookSignatureHeader = "Webhook-Signature"
+
+// webhookTolerance is how old a signature may be, so a captured delivery can not be sent again
+// later
+const webhookTolerance = 5 * time.Minute
+
+// maxWebhookPayload is the largest event body read
+const maxWebhookPayload = 1 << 20
+
+// SignWebhookPayload signs an event body the way the payment providers do, an HMAC-SHA256 of
+// the timestamp and the body
+func SignWebhookPayload(secret string, payload []byte, at time.Time) string {
+	return fmt.Sprintf("t=%d,v1=%s", at.Unix(), webhookMAC(secret, at.Unix(), payload))
+}
+
+// webhookMAC is the hex HMAC-SHA256 of "<timestamp>.<body>"
+func webhookMAC(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWebhookSignature checks a signature header against the body. The header may hold
+// several v1 signatures while a secret is being rotated.
+func verifyWebhookSignature(secret string, payload []byte, header string, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return fmt.Errorf("mangler signatur")
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return fmt.Errorf("signaturen er utløpt")
+	}
+
+	expected := webhookMAC(secret, timestamp, payload)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("ugyldig signatur")
+}
+
+// NewSignedWebhookRequest builds a signed delivery of an event to the webhook endpoint, for
+// sending fake events in tests
+func NewSignedWebhookRequest(secret string, event models.WebhookEventPayload, at time.Time) (*http.Request, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, "/api/webhooks/payments", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(secret, payload, at))
+	return req, nil
+}
+
+// PaymentWebhookHandler receives events from the payment providers, e.g. card authentications,
+// disputes and expired cards. Every event is stored before it is processed, and is acknowledged
+// once stored. An event that fails is kept for a replay from admin rather than sent again.
+func PaymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(w, "Kunne ikke lese hendelsen", http.StatusBadRequest)
+		return
+	}
+
+	now := config.GetInstance().GetCurrentTime()
+	if err := verifyWebhookSignature(WebhookSecret, payload, r.Header.Get(WebhookSignatureHeader), now); err != nil {
+		log.Printf("Rejected webhook: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var event models.WebhookEventPayload
+	if err := json.Unmarshal(payload, &event); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if event.ID == "" || event.Type == "" {
+		http.Error(w, "Hendelsen mangler ID eller type", http.StatusBadRequest)
+		return
+	}
+	if event.Provider != models.PaymentProviderStripe && event.Provider != models.PaymentProviderVipps {
+		http.Error(w, "Ukjent betalingsleverandør", http.StatusBadRequest)
+		return
+	}
+
+	// The provider sends the event again until it is stored
+	stored, duplicate, err := DB.RecordWebhookEvent(event, payload, now)
+	if err != nil {
+		log.Printf("Error storing webhook event %s: %v", event.ID, err)
+		http.Error(w, "Kunne ikke lagre hendelsen", http.StatusInternalServerError)
+		return
+	}
+	if err := DB.ProcessWebhookEvent(int64(stored.ID), now); err != nil {
+		log.Printf("Error processing webhook event %s (%s): %v", event.ID, event.Type, err)
+	}
+	if stored, err = DB.GetWebhookEvent(int64(stored.ID)); err != nil {
+		http.Error(w, "Kunne ikke hente hendelsen", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"duplicate": duplicate,
+		"status":    stored.Status,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ReplayWebhookEventHandler processes a failed webhook event again
+func ReplayWebhookEventHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: Add admin authentication check here
+
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := AdminDB.ReplayWebhookEvent(req.ID, config.GetInstance().GetCurrentTime()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Hendelsen er behandlet",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
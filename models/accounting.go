@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// Kinds of account mappings. Revenue mappings are keyed by charge type, settlement mappings by
+// the payment method type the money came in through.
+const (
+	AccountMappingRevenue    = "revenue"
+	AccountMappingSettlement = "settlement"
+)
+
+// PaymentMethodTypeInvoice is the settlement key of company invoices, which are paid to the
+// studio's account receivable rather than through a payment method
+const PaymentMethodTypeInvoice = "invoice"
+
+// AccountMapping maps a charge type or payment method to an account in the chart of accounts
+type AccountMapping struct {
+	Kind    string `json:"kind"`
+	Key     string `json:"key"`
+	Account string `json:"account"`
+	VATRate int    `json:"vat_rate"` // Revenue only, the MVA rate of the charge type
+	VATCode string `json:"vat_code"` // Revenue only, the SAF-T standard MVA code for the rate
+}
+
+// Kinds of accounting entries
+const (
+	AccountingEntrySale           = "sale"
+	AccountingEntryCredit         = "credit"      // Money credited to a member, e.g. when changing plan
+	AccountingEntryCreditNote     = "credit_note" // A refund
+	AccountingEntryCompanyInvoice = "company_invoice"
+)
+
+// AccountingEntry is one sale, refund or credit booked from the settlement account to the
+// revenue account, with the MVA broken out. Amounts are in øre and negative for refunds and
+// credits.
+type AccountingEntry struct {
+	Date              time.Time `json:"date"`
+	Voucher           string    `json:"voucher"` // Receipt number, credit note number or company invoice
+	Kind              string    `json:"kind"`
+	Description       string    `json:"description"`
+	CustomerID        string    `json:"customer_id"` // M<user ID> for members, B<company ID> for companies
+	CustomerName      string    `json:"customer_name"`
+	ChargeType        string    `json:"charge_type"`
+	PaymentMethodType string    `json:"payment_method_type"`
+	SettlementAccount string    `json:"settlement_account"`
+	RevenueAccount    string    `json:"revenue_account"`
+	VATAccount        string    `json:"vat_account"` // Empty when there is no MVA
+	VATCode           string    `json:"vat_code"`
+	VATRate           int       `json:"vat_rate"`
+	NetAmount         int       `json:"net_amount"`
+	VATAmount         int       `json:"vat_amount"`
+	Amount            int       `json:"amount"`
+}
+
+// DeferredRevenueLine is paid revenue not yet earned on one klippekort or membership
+type DeferredRevenueLine struct {
+	ChargeType  string `json:"charge_type"`
+	UserID      int    `json:"user_id"`
+	MemberName  string `json:"member_name"`
+	Description string `json:"description"`
+	Units       int    `json:"units"`       // Unused klipp, or days left of the paid membership period
+	TotalUnits  int    `json:"total_units"` // Klipp paid for, or days in the period
+	Amount      int    `json:"amount"`      // Amount in øre including MVA
+	NetAmount   int    `json:"net_amount"`  // Amount in øre without MVA
+}
+
+// DeferredRevenue is the revenue members have paid for but not used yet on a date: unused
+// klipp and the rest of membership periods paid in advance
+type DeferredRevenue struct {
+	AsOf        time.Time             `json:"as_of"`
+	Lines       []DeferredRevenueLine `json:"lines"`
+	Klippekort  int                   `json:"klippekort"`  // Net amount in øre
+	Memberships int                   `json:"memberships"` // Net amount in øre
+	Total       int                   `json:"total"`       // Net amount in øre
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Admin override actions on a member's account
+const (
+	AdminActionAssignMembership = "assign_membership"
+	AdminActionChangeMembership = "change_membership"
+	AdminActionEndMembership    = "end_membership"
+	AdminActionSetStatus        = "set_status"
+	AdminActionSetBindingEnd    = "set_binding_end"
+	AdminActionGrantFreeMonths  = "grant_free_months"
+	AdminActionSetCustomPrice   = "set_custom_price"
+	AdminActionGrantKlippekort  = "grant_klippekort"
+	AdminActionAdjustKlippekort = "adjust_klippekort"
+	AdminActionExtendKlippekort = "extend_klippekort"
+	AdminActionRefundCharge     = "refund_charge"
+	AdminActionAdjustCredit     = "adjust_credit"
+)
+
+// AdminAction is a change an admin made by hand to a member's membership or klippekort,
+// kept with the reason given for it
+type AdminAction struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Who a campaign is offered to
+const (
+	CampaignForEveryone  = "all"
+	CampaignForFirstTime = "first_time"
+	CampaignForLapsed    = "lapsed"
+	CampaignForStudents  = "student"
+)
+
+// Campaign is a time-limited offer on a plan for new signups, with a reduced price or extra free weeks
+type Campaign struct {
+	ID               int       `json:"id"`
+	Name             string    `json:"name"`
+	Description      string    `json:"description"`
+	MembershipID     int       `json:"membership_id"`
+	MembershipName   string    `json:"membership_name"`
+	RegularPrice     int       `json:"regular_price"` // Price of the plan without the campaign, in øre
+	SpecialOfferPlan bool      `json:"special_offer_plan"`
+	StartDate        time.Time `json:"start_date"`
+	EndDate          time.Time `json:"end_date"` // Last day the campaign can be redeemed
+	Eligibility      string    `json:"eligibility"`
+	LapsedMonths     int       `json:"lapsed_months"`   // For lapsed members, how long since their last membership ended
+	DiscountPrice    *int      `json:"discount_price"`  // Reduced monthly price in øre, nil keeps the plan price
+	DiscountMonths   int       `json:"discount_months"` // How many months the reduced price lasts, 0 while on the plan
+	FreeWeeks        int       `json:"free_weeks"`      // Extra free weeks added to the first period
+	Active           bool      `json:"active"`
+	Redemptions      int       `json:"redemptions"`
+}
+
+// IsLive checks whether the campaign can be redeemed on a date
+func (c Campaign) IsLive(now time.Time) bool {
+	today := now.Format("2006-01-02")
+	return c.Active && c.StartDate.Format("2006-01-02") <= today && today <= c.EndDate.Format("2006-01-02")
+}
+
+// MembershipPeriod is a stretch of time a member was on one plan
+type MembershipPeriod struct {
+	UserID         int        `json:"user_id"`
+	MembershipID   int        `json:"membership_id"`
+	MembershipName string     `json:"membership_name"`
+	StartedAt      time.Time  `json:"started_at"`
+	EndedAt        *time.Time `json:"ended_at"` // NULL while the period is ongoing
+}
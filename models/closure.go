@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Closure represents a period when the studio is closed and no classes run
+type Closure struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	StartDate time.Time `json:"start_date"` // First closed day
+	EndDate   time.Time `json:"end_date"`   // Last closed day (inclusive)
+	IsHoliday bool      `json:"is_holiday"` // True for built-in Norwegian public holidays
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// How a company pays for its employees' memberships
+const (
+	CompanySubsidyFull    = "full"    // The company pays the whole price
+	CompanySubsidyPercent = "percent" // SubsidyValue is a percentage of the price
+	CompanySubsidyFixed   = "fixed"   // SubsidyValue is an amount in øre per month
+)
+
+// Company is a business paying for its employees' memberships by monthly invoice
+type Company struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	OrgNumber     string    `json:"org_number"`
+	InvoiceEmail  string    `json:"invoice_email"`
+	EmailDomain   string    `json:"email_domain"` // Employees with an email on this domain can ask to join, empty = invitation code only
+	InviteCode    string    `json:"invite_code"`
+	SubsidyType   string    `json:"subsidy_type"`
+	SubsidyValue  int       `json:"subsidy_value"`
+	MembershipIDs []int     `json:"membership_ids"` // Plans the company pays for
+	AdminUserIDs  []int     `json:"admin_user_ids"` // Members who can see the company's employees and invoices
+	AdminEmails   []string  `json:"admin_emails"`
+	Active        bool      `json:"active"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// Statistics
+	EnrolledCount int `json:"enrolled_count"`
+}
+
+// Covers checks whether the company pays for a plan
+func (c Company) Covers(membershipID int) bool {
+	for _, id := range c.MembershipIDs {
+		if id == membershipID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin checks whether a member can manage the company account
+func (c Company) IsAdmin(userID int) bool {
+	for _, id := range c.AdminUserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesEmail checks whether an email address belongs to the company's domain
+func (c Company) MatchesEmail(email string) bool {
+	if c.EmailDomain == "" {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	return at >= 0 && strings.EqualFold(email[at+1:], strings.TrimPrefix(c.EmailDomain, "@"))
+}
+
+// CompanyShare calculates how much of a monthly price in øre the company pays
+func (c Company) CompanyShare(price int) int {
+	share := 0
+	switch c.SubsidyType {
+	case CompanySubsidyFull:
+		share = price
+	case CompanySubsidyPercent:
+		share = (price*c.SubsidyValue + 50) / 100
+	case CompanySubsidyFixed:
+		share = c.SubsidyValue
+	}
+	if share > price {
+		share = price
+	}
+	if share < 0 {
+		share = 0
+	}
+	return share
+}
+
+// CompanyEmployee is a member enrolled in a company account
+type CompanyEmployee struct {
+	ID             int        `json:"id"`
+	CompanyID      int        `json:"company_id"`
+	UserID         int        `json:"user_id"`
+	Name           string     `json:"name"`
+	Email          string     `json:"email"`
+	MembershipName string     `json:"membership_name"`
+	EnrolledAt     time.Time  `json:"enrolled_at"`
+	ApprovedAt     *time.Time `json:"approved_at"` // NULL while a member who joined by email domain waits for a company admin
+	LeftAt         *time.Time `json:"left_at"`
+}
+
+// CompanyInvoice is the monthly consolidated invoice for a company's share of its employees' memberships
+type CompanyInvoice struct {
+	ID          int                  `json:"id"`
+	CompanyID   int                  `json:"company_id"`
+	CompanyName string               `json:"company_name"`
+	Period      string               `json:"period"` // Month invoiced, "2006-01"
+	Amount      int                  `json:"amount"` // Total in øre
+	Status      string               `json:"status"` // "sent", "paid"
+	DueDate     time.Time            `json:"due_date"`
+	CreatedAt   time.Time            `json:"created_at"`
+	Lines       []CompanyInvoiceLine `json:"lines"`
+}
+
+// CompanyInvoiceLine is one membership payment on a company invoice, backed by a charge
+type CompanyInvoiceLine struct {
+	ChargeID    int       `json:"charge_id"`
+	UserID      int       `json:"user_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Amount      int       `json:"amount"`
+	ChargeDate  time.Time `json:"charge_date"`
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// How a coupon reduces the price
+const (
+	CouponPercent = "percent" // DiscountValue is a percentage
+	CouponFixed   = "fixed"   // DiscountValue is an amount in øre
+)
+
+// Products a coupon can be used on, matching the charge types
+const (
+	CouponProductMembership = "medlemskap"
+	CouponProductKlippekort = "klippekort"
+)
+
+// Coupon is a discount code members can enter at checkout
+type Coupon struct {
+	ID             int        `json:"id"`
+	Code           string     `json:"code"`
+	Description    string     `json:"description"`
+	DiscountType   string     `json:"discount_type"`
+	DiscountValue  int        `json:"discount_value"`
+	DurationMonths int        `json:"duration_months"` // Monthly membership payments discounted, 1 = first payment only
+	ProductTypes   []string   `json:"product_types"`
+	MaxRedemptions int        `json:"max_redemptions"` // 0 = unlimited
+	PerUserLimit   int        `json:"per_user_limit"`  // 0 = unlimited
+	ValidFrom      *time.Time `json:"valid_from"`      // NULL = valid right away
+	ValidUntil     *time.Time `json:"valid_until"`     // NULL = no end date, otherwise the last valid day
+	Active         bool       `json:"active"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	// Statistics
+	Redemptions   int `json:"redemptions"`
+	UniqueUsers   int `json:"unique_users"`
+	TotalDiscount int `json:"total_discount"` // Total discount given in øre, including later membership payments
+}
+
+// AppliesTo checks whether the coupon can be used on a product type
+func (c Coupon) AppliesTo(productType string) bool {
+	for _, t := range c.ProductTypes {
+		if strings.EqualFold(t, productType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Discount calculates how much the coupon takes off an amount in øre
+func (c Coupon) Discount(amount int) int {
+	discount := 0
+	switch c.DiscountType {
+	case CouponPercent:
+		discount = (amount*c.DiscountValue + 50) / 100
+	case CouponFixed:
+		discount = c.DiscountValue
+	}
+	if discount > amount {
+		discount = amount
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+// CouponRedemption records a coupon used on a purchase
+type CouponRedemption struct {
+	ID                 int       `json:"id"`
+	CouponID           int       `json:"coupon_id"`
+	Code               string    `json:"code"`
+	UserID             int       `json:"user_id"`
+	UserName           string    `json:"user_name"`
+	ProductType        string    `json:"product_type"`
+	ProductName        string    `json:"product_name"`
+	ReferenceID        int       `json:"reference_id"` // User membership or user klippekort ID
+	OriginalAmount     int       `json:"original_amount"`
+	DiscountAmount     int       `json:"discount_amount"` // Total discount given so far in øre
+	PaymentsDiscounted int       `json:"payments_discounted"`
+	RedeemedAt         time.Time `json:"redeemed_at"`
+}
+
+// CouponQuote shows a member what a coupon takes off before they buy
+type CouponQuote struct {
+	Code           string `json:"code"`
+	Description    string `json:"description"`
+	OriginalAmount int    `json:"original_amount"`
+	DiscountAmount int    `json:"discount_amount"`
+	FinalAmount    int    `json:"final_amount"`
+	DurationMonths int    `json:"duration_months"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// PaymentMethodTypeCredit is the payment method type of charges paid in full by account credit,
+// and the settlement key credit is booked on in the accounting export
+const PaymentMethodTypeCredit = "credit"
+
+// Kinds of account credit movements. Credit is added or used, never both in one movement.
+const (
+	CreditAdjustment = "adjustment" // Added or taken off by an admin, e.g. as compensation
+	CreditDowngrade  = "downgrade"  // Owed for the rest of the period after moving to a cheaper plan
+	CreditRefund     = "refund"     // Part of a refund that was paid with credit
+	CreditApplied    = "applied"    // Used to pay a charge
+	CreditReversed   = "reversed"   // Given back when the charge it paid failed
+)
+
+// CreditEntry is one movement on a member's account credit. Amounts are in øre, positive when
+// credit is added and negative when it is used.
+type CreditEntry struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Amount      int       `json:"amount"`
+	Kind        string    `json:"kind"` // See the Credit constants
+	Description string    `json:"description"`
+	ChargeID    *int      `json:"charge_id"` // The charge the credit came from or paid, NULL for adjustments
+	Balance     int       `json:"balance"`   // The balance after the movement
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Discount verification types
+const (
+	VerificationStudent = "student"
+	VerificationSenior  = "senior"
+)
+
+// DiscountVerification is a member's proof that they qualify for student or senior plans.
+// Student verifications expire and must be renewed yearly, senior verifications never expire.
+type DiscountVerification struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	UserName   string     `json:"user_name"`
+	UserEmail  string     `json:"user_email"`
+	Type       string     `json:"type"`   // "student" or "senior"
+	Proof      string     `json:"proof"`  // Student ID or school entered by the member
+	Status     string     `json:"status"` // "pending", "approved", "rejected", "expired"
+	ExpiresAt  *time.Time `json:"expires_at"`
+	ReviewNote string     `json:"review_note"`
+	ReviewedAt *time.Time `json:"reviewed_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MembershipEntitlements describes what a membership plan gives access to.
+// Class signup, freezing and guest bookings are checked against these.
+type MembershipEntitlements struct {
+	ClassTypes          []string `json:"class_types"`            // Class types the plan can book, empty allows all
+	Locations           []string `json:"locations"`              // Locations the plan can book, empty allows all
+	WeeklyClassLimit    int      `json:"weekly_class_limit"`     // Classes per week (Monday to Sunday), 0 is unlimited
+	FreezeAllowed       bool     `json:"freeze_allowed"`         // Whether the membership can be frozen
+	FreezeDaysPerYear   int      `json:"freeze_days_per_year"`   // Days the membership can be frozen per calendar year, 0 is unlimited
+	GuestPassesPerMonth int      `json:"guest_passes_per_month"` // Guests the member can bring to classes per month
+	OnlineAccess        bool     `json:"online_access"`          // Online video library and streamed classes
+	Perks               []string `json:"perks"`                  // Other benefits shown to members, e.g. "Rabatt på workshops"
+}
+
+// AllowsClassType checks whether the plan can book classes of a type
+func (e MembershipEntitlements) AllowsClassType(classType string) bool {
+	return len(e.ClassTypes) == 0 || containsFold(e.ClassTypes, classType)
+}
+
+// AllowsLocation checks whether the plan can book classes at a location
+func (e MembershipEntitlements) AllowsLocation(location string) bool {
+	return len(e.Locations) == 0 || containsFold(e.Locations, location)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// EntitlementBullet is one line in the list of what a plan includes. Key is a locale key under
+// membership.entitlements with an optional Detail after it, Text is shown as is.
+type EntitlementBullet struct {
+	Key    string `json:"key,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Text   string `json:"text,omitempty"`
+}
+
+// Bullets lists what the plan includes, for showing to members
+func (e MembershipEntitlements) Bullets() []EntitlementBullet {
+	var bullets []EntitlementBullet
+	if e.WeeklyClassLimit > 0 {
+		bullets = append(bullets, EntitlementBullet{Key: "weekly_class_limit", Detail: strconv.Itoa(e.WeeklyClassLimit)})
+	} else {
+		bullets = append(bullets, EntitlementBullet{Key: "unlimited_classes"})
+	}
+	if len(e.ClassTypes) > 0 {
+		bullets = append(bullets, EntitlementBullet{Key: "class_types", Detail: strings.Join(e.ClassTypes, ", ")})
+	}
+	if len(e.Locations) > 0 {
+		bullets = append(bullets, EntitlementBullet{Key: "locations", Detail: strings.Join(e.Locations, ", ")})
+	} else {
+		bullets = append(bullets, EntitlementBullet{Key: "all_locations"})
+	}
+	if e.OnlineAccess {
+		bullets = append(bullets, EntitlementBullet{Key: "online_access"})
+	}
+	if e.FreezeAllowed && e.FreezeDaysPerYear > 0 {
+		bullets = append(bullets, EntitlementBullet{Key: "freeze_days", Detail: strconv.Itoa(e.FreezeDaysPerYear)})
+	} else if e.FreezeAllowed {
+		bullets = append(bullets, EntitlementBullet{Key: "freeze"})
+	}
+	if e.GuestPassesPerMonth > 0 {
+		bullets = append(bullets, EntitlementBullet{Key: "guest_passes", Detail: strconv.Itoa(e.GuestPassesPerMonth)})
+	}
+	for _, perk := range e.Perks {
+		bullets = append(bullets, EntitlementBullet{Text: perk})
+	}
+	return bullets
+}
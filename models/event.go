@@ -1,6 +1,16 @@
 package models
 
-import "time"
+import (
+	"sort"
+	"time"
+)
+
+// Delivery modes for classes
+const (
+	DeliveryInPerson = "in_person" // Only in the studio
+	DeliveryOnline   = "online"    // Only streamed
+	DeliveryHybrid   = "hybrid"    // In the studio and streamed
+)
 
 // Event represents a generic event with common fields.
 type Event struct {
@@ -19,6 +29,66 @@ type Event struct {
 	Capacity         int                 `json:"capacity"`          // Maximum number of attendees
 	CurrentEnrolment int                 `json:"current_enrolment"` // Current number of enrolled
 	Color            string              `json:"color"`             // Color for the class type
+	// Closure-related fields
+	IsCancelled        bool              `json:"is_cancelled"`        // Cancelled, e.g. because the studio is closed
+	CancellationReason string            `json:"cancellation_reason"` // Shown to members on the timeplan
+	// Livestream-related fields
+	DeliveryMode       string            `json:"delivery_mode"`     // in_person, online or hybrid
+	OnlineCapacity     int               `json:"online_capacity"`   // Maximum number of online attendees, 0 means unlimited
+	OnlineEnrolment    int               `json:"online_enrolment"`  // Current number of online signups
+	OnlineAttendance   int               `json:"online_attendance"` // Number of online signups that joined the stream
+	StreamURL          string            `json:"-"`                 // Never sent to clients, see EventStreamHandler
+	// Klippekort-related fields
+	KlippekortCategory string            `json:"klippekort_category"` // Category of the klippekort members without membership pay with
 	// User-specific fields (populated for specific users)
 	IsUserSignedUp   bool                `json:"is_user_signed_up"` // Whether the current user is signed up for this event
+	UserAttendanceMode string            `json:"user_attendance_mode,omitempty"` // in_person or online for the current user's signup
+	LockedReasons      []string          `json:"locked_reasons,omitempty"`       // Missing qualifications that keep the current user from signing up
+}
+
+// MissingRequirements returns the required roles the user does not have, sorted by name
+func (e Event) MissingRequirements(userRoles []string) []string {
+	hasRole := make(map[string]bool, len(userRoles))
+	for _, role := range userRoles {
+		hasRole[role] = true
+	}
+
+	var missing []string
+	for role := range e.RoleRequirements {
+		if !hasRole[role] {
+			missing = append(missing, role)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// OffersInPerson reports whether members can attend the class in the studio
+func (e Event) OffersInPerson() bool {
+	return e.DeliveryMode != DeliveryOnline
+}
+
+// OffersOnline reports whether the class is streamed
+func (e Event) OffersOnline() bool {
+	return e.DeliveryMode == DeliveryOnline || e.DeliveryMode == DeliveryHybrid
+}
+
+// OnlineSpotsLeft returns the number of free online spots, or -1 when online capacity is unlimited
+func (e Event) OnlineSpotsLeft() int {
+	if e.OnlineCapacity == 0 {
+		return -1
+	}
+	if e.OnlineEnrolment >= e.OnlineCapacity {
+		return 0
+	}
+	return e.OnlineCapacity - e.OnlineEnrolment
+}
+
+// EventParticipant is a member signed up for a class, as admins see it when checking members in
+type EventParticipant struct {
+	UserID         int64      `json:"user_id"`
+	Name           string     `json:"name"`
+	Email          string     `json:"email"`
+	AttendanceMode string     `json:"attendance_mode"`
+	CheckedInAt    *time.Time `json:"checked_in_at"` // Nil until the member is checked in at the studio
 }
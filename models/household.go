@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// MaxHouseholdMembers limits how many members a payer can link to their household
+const MaxHouseholdMembers = 5
+
+// Household groups members whose membership and purchases are paid by one payer
+type Household struct {
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	PayerUserID int               `json:"payer_user_id"`
+	PayerName   string            `json:"payer_name"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Members     []HouseholdMember `json:"members"`
+}
+
+// HouseholdMember is a member linked to a household. They keep their own login, bookings
+// and membership, but the household payer is billed for them.
+type HouseholdMember struct {
+	ID                int        `json:"id"`
+	HouseholdID       int        `json:"household_id"`
+	UserID            int        `json:"user_id"`
+	Name              string     `json:"name"`
+	Email             string     `json:"email"`
+	MembershipName    string     `json:"membership_name"`
+	JoinedAt          time.Time  `json:"joined_at"`
+	LeftAt            *time.Time `json:"left_at"`             // NULL while linked
+	EntitlementEndsAt *time.Time `json:"entitlement_ends_at"` // Last day covered by the payer after leaving
+}
+
+// IsPayer checks whether a user pays for the household
+func (h Household) IsPayer(userID int) bool {
+	return h.PayerUserID == userID
+}
+
+// HouseholdDiscount calculates the reduced price a linked member pays with a plan's household discount
+func HouseholdDiscount(price, percent int) int {
+	if percent <= 0 {
+		return price
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return price - (price*percent+50)/100
+}
+
+// HouseholdDiscountRule is the discount linked members get on a plan
+type HouseholdDiscountRule struct {
+	MembershipID   int    `json:"membership_id"`
+	MembershipName string `json:"membership_name"`
+	Percent        int    `json:"percent"` // 0 = no household discount
+}
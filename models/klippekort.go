@@ -14,6 +14,7 @@ type KlippekortPackage struct {
 	ValidDays   int    `json:"valid_days"`  // How many days the package is valid for
 	Active      bool   `json:"active"`
 	IsPopular   bool   `json:"is_popular"`  // For highlighting best value
+	SortOrder   int    `json:"sort_order"`  // Position within the category on the purchase page
 }
 
 // UserKlippekort represents a user's purchased klippekort
@@ -35,4 +36,84 @@ type KlippekortWithDetails struct {
 	ProgressPercentage int  `json:"progress_percentage"` // How much has been used
 	DaysUntilExpiry    int  `json:"days_until_expiry"`
 	IsExpiring         bool `json:"is_expiring"`         // True if expires within 30 days
-}
\ No newline at end of file
+}
+
+// Kinds of klipp movements in the klippekort ledger
+const (
+	KlippPurchase       = "purchase"
+	KlippTopUp          = "top_up"
+	KlippSignup         = "signup"
+	KlippRefund         = "refund"
+	KlippExpired        = "expired"
+	KlippRollover       = "rollover"
+	KlippAdjustment     = "adjustment"
+	KlippChargeRefunded = "charge_refunded" // Unused klipp taken back when the purchase was refunded
+	KlippPaymentFailed  = "payment_failed"  // Klipp taken back when the payment for them was declined
+)
+
+// KlippMovement is an entry in the append-only ledger of a klippekort. The card's
+// remaining klipp is the sum of its movements.
+type KlippMovement struct {
+	ID           int       `json:"id"`
+	KlippekortID int       `json:"klippekort_id"`
+	UserID       int       `json:"user_id"`
+	Kind         string    `json:"kind"`
+	Klipp        int       `json:"klipp"`   // Positive when klipp are added, negative when used or removed
+	Balance      int       `json:"balance"` // Remaining klipp on the card after the movement
+	EventID      *int      `json:"event_id,omitempty"`
+	EventTitle   *string   `json:"event_title,omitempty"`
+	Description  string    `json:"description"`
+	PackageName  string    `json:"package_name"`
+	CreatedAt    time.Time `json:"created_at"`
+	UsedByUserID *int      `json:"used_by_user_id,omitempty"` // Member who used a klipp from a card shared with them
+	UsedByName   *string   `json:"used_by_name,omitempty"`
+}
+
+// KlippekortRules configures how klippekort expire
+type KlippekortRules struct {
+	ID               int    `json:"id"`
+	ReminderDays     []int  `json:"reminder_days"`      // Days before expiry members with klipp left are reminded
+	RolloverEnabled  bool   `json:"rollover_enabled"`   // Move unused klipp from an expired card to the next card bought
+	RolloverDays     int    `json:"rollover_days"`      // How long after expiry unused klipp can be moved
+	RolloverMaxKlipp int    `json:"rollover_max_klipp"` // Most klipp moved, 0 for no limit
+	UpdatedAt        string `json:"updated_at"`
+}
+
+// How a klippekort bought in a category where the member already has an active card is handled
+const (
+	KlippekortTopUpMerge   = "merge"    // The klipp are added to the existing card
+	KlippekortTopUpNewCard = "new_card" // The purchase becomes a card of its own
+)
+
+// How the expiry date of a card is set when a top-up is merged into it
+const (
+	KlippekortExpiryLongest = "longest" // The later of the card's expiry and the new package's
+	KlippekortExpiryExtend  = "extend"  // The card's expiry moves by the new package's valid days
+	KlippekortExpiryKeep    = "keep"    // The card keeps its expiry date
+)
+
+// KlippekortCategoryPolicy configures how klippekort in a category are topped up
+type KlippekortCategoryPolicy struct {
+	Category   string `json:"category"`
+	MaxKlipp   int    `json:"max_klipp"`   // Most klipp a card can be topped up to, 0 for no limit
+	TopUpMode  string `json:"top_up_mode"` // KlippekortTopUpMerge or KlippekortTopUpNewCard
+	ExpiryMode string `json:"expiry_mode"` // One of the KlippekortExpiry constants
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// KlippekortShare lets another member draw klipp from a klippekort until the owner revokes it
+type KlippekortShare struct {
+	ID           int        `json:"id"`
+	KlippekortID int        `json:"klippekort_id"`
+	UserID       int        `json:"user_id"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+// SharedKlippekort is a klippekort another member has shared with the user
+type SharedKlippekort struct {
+	KlippekortWithDetails
+	OwnerName string `json:"owner_name"`
+}
@@ -8,10 +8,12 @@ type Membership struct {
 	Name            string  `json:"name"`
 	Price           int     `json:"price"`           // Price in Norwegian øre (1000 = 10.00 kr)
 	CommitmentMonths int    `json:"commitment_months"` // 0 for no commitment, 1, 6, 12 etc.
+	DurationDays    int     `json:"duration_days"`   // 0 runs until cancelled, otherwise the plan ends after this many days
+	IsTrial         bool    `json:"is_trial"`        // Trials can only be used once per person
 	IsStudentSenior bool    `json:"is_student_senior"`
 	IsSpecialOffer  bool    `json:"is_special_offer"`
 	Description     string  `json:"description"`
-	Features        string  `json:"features"`        // JSON string of features array
+	Entitlements    MembershipEntitlements `json:"entitlements"` // What the plan gives access to
 	Active          bool    `json:"active"`
 }
 
@@ -22,11 +24,13 @@ type UserMembership struct {
 	MembershipID  int       `json:"membership_id"`
 	Status        string    `json:"status"`        // "active", "paused", "cancelled", "freeze_requested"
 	StartDate     time.Time `json:"start_date"`
+	PlanSince     time.Time `json:"plan_since"`    // When the member moved onto the current plan, grandfathered prices count from here
 	RenewalDate   time.Time `json:"renewal_date"`
 	EndDate       *time.Time `json:"end_date"`     // NULL if ongoing
 	BindingEnd    *time.Time `json:"binding_end"`  // When binding period ends
 	LastBilled    time.Time `json:"last_billed"`   // When user was last billed
 	CreatedAt     time.Time `json:"created_at"`
+	CustomPrice   *int      `json:"custom_price"`  // Price in øre set by an admin, NULL for the plan's price
 }
 
 // MembershipWithDetails combines membership info with user-specific data
@@ -37,4 +41,6 @@ type MembershipWithDetails struct {
 	MonthsUntilBindingEnd   int  `json:"months_until_binding_end"`
 	CanCancel               bool `json:"can_cancel"`
 	CanPause                bool `json:"can_pause"`
+	ScheduledMembership     *Membership `json:"scheduled_membership"` // Plan the member switches to at the next renewal
+	TrialConversionOptions  []Membership `json:"trial_conversion_options"` // Plans a trial can continue on, chosen as the scheduled membership
 }
\ No newline at end of file
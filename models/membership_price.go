@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// MembershipPrice is a price version of a membership plan. New members pay it from
+// EffectiveDate, existing members from ExistingMembersDate once the notice period has passed.
+type MembershipPrice struct {
+	ID                  int       `json:"id"`
+	MembershipID        int       `json:"membership_id"`
+	MembershipName      string    `json:"membership_name"`
+	Price               int       `json:"price"`                 // Price in øre
+	EffectiveDate       time.Time `json:"effective_date"`        // When new members start paying this price
+	ExistingMembersDate time.Time `json:"existing_members_date"` // When existing members start paying this price
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// AffectedMember is an existing member who will be moved to a new price version
+type AffectedMember struct {
+	UserID       int       `json:"user_id"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	Phone        string    `json:"phone"`
+	CurrentPrice int       `json:"current_price"`  // Price in øre
+	NewPrice     int       `json:"new_price"`      // Price in øre
+	NewPriceFrom time.Time `json:"new_price_from"` // First renewal charged at the new price
+}
+
+// PriceChangePreview summarises the effect of a planned price change for admins
+type PriceChangePreview struct {
+	MembershipID         int              `json:"membership_id"`
+	NewPrice             int              `json:"new_price"`
+	EffectiveDate        time.Time        `json:"effective_date"`
+	ExistingMembersDate  time.Time        `json:"existing_members_date"`
+	AffectedMembers      []AffectedMember `json:"affected_members"`
+	MonthlyRevenueImpact int              `json:"monthly_revenue_impact"` // Change in monthly revenue in øre
+	AnnualRevenueImpact  int              `json:"annual_revenue_impact"`  // Change in annual revenue in øre
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Notification represents a message to a member, e.g. about a cancelled class
+type Notification struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Type      string    `json:"type"` // "class_cancelled", etc.
+	Title     string    `json:"title"`
+	Message   string    `json:"message"`
+	IsRead    bool      `json:"is_read"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -2,22 +2,48 @@ package models
 
 import "time"
 
-// PaymentMethod represents a user's payment method (Stripe)
+// PaymentMethod represents a user's payment method, a card through Stripe or Vipps
 type PaymentMethod struct {
 	ID                int       `json:"id"`
 	UserID            int       `json:"user_id"`
 	StripePaymentMethodID string `json:"stripe_payment_method_id"`
-	Type              string    `json:"type"`          // "card", "bank_account", etc.
+	Type              string    `json:"type"`          // See the PaymentMethodType constants
 	Last4             string    `json:"last4"`         // Last 4 digits
 	Brand             string    `json:"brand"`         // "visa", "mastercard", etc.
 	ExpiryMonth       int       `json:"expiry_month"`
 	ExpiryYear        int       `json:"expiry_year"`
+	PhoneNumber       string    `json:"phone_number"`     // Vipps only, 8 digits
+	AgreementID       string    `json:"agreement_id"`     // Vipps only, the recurring agreement memberships are charged on
+	AgreementStatus   string    `json:"agreement_status"` // Vipps only, see the VippsAgreement constants
 	IsDefault         bool      `json:"is_default"`
 	Active            bool      `json:"active"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 }
 
+// Payment method types
+const (
+	PaymentMethodTypeCard  = "card"
+	PaymentMethodTypeVipps = "vipps"
+)
+
+// Vipps agreement statuses. A pending agreement waits for the member to approve it in the
+// Vipps app, a stopped one can no longer be charged.
+const (
+	VippsAgreementPending = "PENDING"
+	VippsAgreementActive  = "ACTIVE"
+	VippsAgreementStopped = "STOPPED"
+	VippsAgreementExpired = "EXPIRED"
+)
+
+// MaskedPhoneNumber hides all but the last two digits of a Vipps phone number
+func (pm PaymentMethod) MaskedPhoneNumber() string {
+	if len(pm.PhoneNumber) < 2 {
+		return pm.PhoneNumber
+	}
+	return "••• •• •" + pm.PhoneNumber[len(pm.PhoneNumber)-2:]
+}
+
 // Charge represents a billing charge/transaction
 type Charge struct {
 	ID                int       `json:"id"`
@@ -26,17 +52,56 @@ type Charge struct {
 	StripeChargeID    string    `json:"stripe_charge_id"`
 	Amount            int       `json:"amount"`            // Amount in øre
 	Currency          string    `json:"currency"`          // "NOK"
-	Status            string    `json:"status"`            // "succeeded", "failed", "pending"
+	Status            string    `json:"status"`            // See the ChargeStatus constants
 	Description       string    `json:"description"`       // What the charge was for
 	Type              string    `json:"type"`              // "medlemskap", "klippekort", "utdanninger"
 	ChargeDate        time.Time `json:"charge_date"`
 	FailureReason     *string   `json:"failure_reason"`    // NULL if successful
 	CreatedAt         time.Time `json:"created_at"`
+	RefundedAmount    int       `json:"refunded_amount"`   // Amount in øre paid back through credit notes
+	PaymentMethodType string    `json:"payment_method_type"` // Type of payment method charged, empty for invoiced charges
+	ProviderReference string    `json:"provider_reference"`  // Vipps payment or agreement charge reference
+	CreditApplied     int       `json:"credit_applied"`      // Amount in øre paid with account credit, the rest went to the payment method
+}
+
+// Charge statuses. A refunded charge was paid back in full, a partially refunded one in part.
+// A disputed charge is contested by the card holder, a charged back one lost the dispute.
+const (
+	ChargeStatusSucceeded         = "succeeded"
+	ChargeStatusFailed            = "failed"
+	ChargeStatusPending           = "pending"
+	ChargeStatusInvoiced          = "invoiced"
+	ChargeStatusRefunded          = "refunded"
+	ChargeStatusPartiallyRefunded = "partially_refunded"
+	ChargeStatusDisputed          = "disputed"
+	ChargeStatusChargedBack       = "charged_back"
+)
+
+// Refundable reports whether any of the charge can still be paid back
+func (c Charge) Refundable() bool {
+	return (c.Status == ChargeStatusSucceeded || c.Status == ChargeStatusPartiallyRefunded) && c.Amount > c.RefundedAmount
+}
+
+// CreditNote records money paid back on a charge. Credit notes are numbered in a series of
+// their own, e.g. KN-2026-0001.
+type CreditNote struct {
+	ID               int       `json:"id"`
+	Number           string    `json:"number"`
+	ChargeID         int       `json:"charge_id"`
+	UserID           int       `json:"user_id"`
+	Amount           int       `json:"amount"` // Amount in øre
+	Reason           string    `json:"reason"`
+	ProviderRefundID string    `json:"provider_refund_id"`
+	KlippRemoved     int       `json:"klipp_removed"` // Unused klipp taken off the klippekort the charge paid for
+	CreditedAmount   int       `json:"credited_amount"` // Part of the amount put back on the account credit the charge was paid with
+	CreatedAt        time.Time `json:"created_at"`
 }
 
 // ChargeWithDetails includes payment method information for display
 type ChargeWithDetails struct {
 	Charge
-	PaymentMethodLast4 *string `json:"payment_method_last4"`
-	PaymentMethodBrand *string `json:"payment_method_brand"`
+	PaymentMethodLast4 *string `json:"payment_method_last4"` // Last digits of the card or the Vipps phone number
+	PaymentMethodBrand *string `json:"payment_method_brand"` // Card brand, or "vipps"
+	BeneficiaryName    *string `json:"beneficiary_name"` // Household member the payer was charged for, NULL for own purchases
+	ReceiptNumber      *int    `json:"receipt_number"`   // NULL when the charge has no receipt, e.g. a credit
 }
\ No newline at end of file
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// ProrationQuote shows a member what changing membership costs before they confirm.
+// The current billing period is kept, so only the remaining days are charged or credited.
+type ProrationQuote struct {
+	CurrentMembershipID   int       `json:"current_membership_id"`
+	CurrentMembershipName string    `json:"current_membership_name"`
+	CurrentPrice          int       `json:"current_price"` // Monthly price in øre
+	NewMembershipID       int       `json:"new_membership_id"`
+	NewMembershipName     string    `json:"new_membership_name"`
+	NewPrice              int       `json:"new_price"` // Monthly price in øre
+	PeriodStart           time.Time `json:"period_start"`
+	PeriodEnd             time.Time `json:"period_end"` // Next renewal, when the new price is charged in full
+	DaysInPeriod          int       `json:"days_in_period"`
+	DaysRemaining         int       `json:"days_remaining"`
+	Amount                int       `json:"amount"` // Charged now in øre, negative amounts are credited
+	IsUpgrade             bool      `json:"is_upgrade"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// Qualification is a role that unlocks classes with prerequisites, e.g. "reformer"
+// after completing Reformer Intro. Admins grant qualifications to users.
+type Qualification struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`        // Role name used in Event.RoleRequirements
+	Description string `json:"description"` // Shown to members when a class is locked, e.g. "Fullført Reformer Intro"
+}
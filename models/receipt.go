@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// BusinessDetails is the studio's own information printed on receipts
+type BusinessDetails struct {
+	Name          string    `json:"name"`
+	OrgNumber     string    `json:"org_number"`
+	Address       string    `json:"address"`
+	PostalCode    string    `json:"postal_code"`
+	City          string    `json:"city"`
+	Email         string    `json:"email"`
+	VATRegistered bool      `json:"vat_registered"` // Registered for MVA, printed as "MVA" after the organisation number
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// VATRate is the MVA rate in percent used for charges of a type, e.g. "medlemskap"
+type VATRate struct {
+	ChargeType string `json:"charge_type"`
+	Rate       int    `json:"rate"`
+}
+
+// ReceiptLine is a line on a receipt with its MVA broken out. Amounts are in øre.
+type ReceiptLine struct {
+	Description string `json:"description"`
+	NetAmount   int    `json:"net_amount"`
+	VATRate     int    `json:"vat_rate"`
+	VATAmount   int    `json:"vat_amount"`
+	Amount      int    `json:"amount"` // Including MVA
+}
+
+// Receipt is the sales document for a paid charge. Receipts are numbered in one sequence
+// in the order the charges were made.
+type Receipt struct {
+	Number      int             `json:"number"`
+	ChargeID    int             `json:"charge_id"`
+	IssuedAt    time.Time       `json:"issued_at"`
+	Seller      BusinessDetails `json:"seller"`
+	Customer    User            `json:"customer"`
+	Lines       []ReceiptLine   `json:"lines"`
+	NetAmount   int             `json:"net_amount"`
+	VATAmount   int             `json:"vat_amount"`
+	Total       int             `json:"total"`
+	Currency    string          `json:"currency"`
+	CreditNotes []CreditNote    `json:"credit_notes"` // Refunds made on the charge after it was paid
+}
@@ -0,0 +1,36 @@
+package models
+
+// Questionnaire questions that recommendation rules can react to
+const (
+	QuestionCommitment    = "commitment"
+	QuestionStudentSenior = "is_student_senior"
+	QuestionStartTime     = "start_time"
+)
+
+// RecommendationAnyAnswer makes a rule apply whatever the member answered
+const RecommendationAnyAnswer = "*"
+
+// RecommendationRule scores plans for a questionnaire answer. A rule applies when the member
+// gave Answer to Question, and matches plans that have all the attributes that are set.
+// Matching plans get Weight added to their score. Required rules remove plans that don't match.
+type RecommendationRule struct {
+	ID               int    `json:"id"`
+	Question         string `json:"question"`
+	Answer           string `json:"answer"`
+	MembershipID     *int   `json:"membership_id"`
+	CommitmentMonths *int   `json:"commitment_months"`
+	IsStudentSenior  *bool  `json:"is_student_senior"`
+	IsSpecialOffer   *bool  `json:"is_special_offer"`
+	IsTrial          *bool  `json:"is_trial"`
+	Required         bool   `json:"required"`
+	Weight           int    `json:"weight"`
+	Highlight        string `json:"highlight"` // Banner shown with the recommendations when the rule matches a plan
+	Active           bool   `json:"active"`
+}
+
+// Recommendation is a plan recommended to a member with its score
+type Recommendation struct {
+	Membership
+	Score       int  `json:"score"`
+	Highlighted bool `json:"highlighted"`
+}
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// KPIReport is the revenue, membership and class figures admins follow, for a period. Amounts
+// are in øre including MVA.
+type KPIReport struct {
+	From             time.Time          `json:"from"`
+	To               time.Time          `json:"to"`    // Excluded
+	AsOf             time.Time          `json:"as_of"` // The last day of the period, or now if it has not ended
+	MRR              int                `json:"mrr"`   // Monthly price of the memberships running on AsOf
+	ActiveMembers    int                `json:"active_members"`
+	MembersByPlan    []PlanMembers      `json:"members_by_plan"`
+	Months           []MonthlyKPI       `json:"months"`
+	KlippekortSales  []KlippekortSales  `json:"klippekort_sales"`
+	OutstandingKlipp int                `json:"outstanding_klipp"` // Unused klipp on valid klippekort now
+	KlippLiability   int                `json:"klipp_liability"`   // What the unused klipp were paid
+	ClassTypes       []ClassUtilisation `json:"class_types"`
+	Teachers         []ClassUtilisation `json:"teachers"`
+	TimeSlots        []ClassUtilisation `json:"time_slots"`
+}
+
+// PlanMembers is how many members are on a plan and what they pay a month
+type PlanMembers struct {
+	MembershipID int    `json:"membership_id"`
+	Name         string `json:"name"`
+	Members      int    `json:"members"`
+	MRR          int    `json:"mrr"`
+}
+
+// MonthlyKPI is the member movement and revenue of one month of the period
+type MonthlyKPI struct {
+	Month             time.Time `json:"month"`
+	Signups           int       `json:"signups"`         // New user accounts
+	NewMembers        int       `json:"new_members"`     // Members starting their first membership
+	ActiveAtStart     int       `json:"active_at_start"` // Members with a membership on the first day
+	Cancellations     int       `json:"cancellations"`   // Memberships cancelled
+	Churned           int       `json:"churned"`         // Members whose membership ended without a new one
+	MembershipRevenue int       `json:"membership_revenue"`
+	KlippekortRevenue int       `json:"klippekort_revenue"`
+}
+
+// ChurnRate returns the share of the members at the start of the month who left, in percent
+func (m MonthlyKPI) ChurnRate() float64 {
+	if m.ActiveAtStart == 0 {
+		return 0
+	}
+	return float64(m.Churned) * 100 / float64(m.ActiveAtStart)
+}
+
+// KlippekortSales is what one klippekort package sold in the period, less refunds
+type KlippekortSales struct {
+	Name   string `json:"name"`
+	Sold   int    `json:"sold"`
+	Klipp  int    `json:"klipp"`
+	Amount int    `json:"amount"`
+}
+
+// ClassUtilisation is how full the classes of a class type, teacher or time slot were, and how
+// many who signed up did not turn up. Time slots have a weekday, e.g. "monday", and the hour as key.
+type ClassUtilisation struct {
+	Key      string `json:"key"`
+	Weekday  string `json:"weekday,omitempty"`
+	Classes  int    `json:"classes"`
+	Capacity int    `json:"capacity"` // Places in the studio
+	Booked   int    `json:"booked"`   // Signups in the studio
+	Tracked  int    `json:"tracked"`  // Signups to past classes where attendance was registered
+	NoShows  int    `json:"no_shows"` // Tracked signups that did not check in or join the stream
+}
+
+// UtilisationRate returns the share of the studio places that were booked, in percent
+func (u ClassUtilisation) UtilisationRate() float64 {
+	if u.Capacity == 0 {
+		return 0
+	}
+	return float64(u.Booked) * 100 / float64(u.Capacity)
+}
+
+// NoShowRate returns the share of the tracked signups that did not turn up, in percent
+func (u ClassUtilisation) NoShowRate() float64 {
+	if u.Tracked == 0 {
+		return 0
+	}
+	return float64(u.NoShows) * 100 / float64(u.Tracked)
+}
@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// Payment providers that deliver webhook events
+const (
+	PaymentProviderStripe = "stripe"
+	PaymentProviderVipps  = "vipps"
+)
+
+// Webhook event types the studio acts on. Other events, e.g. payouts, are stored and ignored.
+const (
+	WebhookChargeSucceeded      = "charge.succeeded"
+	WebhookChargeFailed         = "charge.failed"
+	WebhookDisputeCreated       = "charge.dispute.created"
+	WebhookDisputeClosed        = "charge.dispute.closed"
+	WebhookPaymentMethodUpdated = "payment_method.updated"
+	WebhookPaymentMethodExpired = "payment_method.expired"
+	WebhookAgreementActivated   = "agreement.activated"
+	WebhookAgreementStopped     = "agreement.stopped"
+	WebhookAgreementExpired     = "agreement.expired"
+)
+
+// Webhook event statuses. A failed event can be replayed from admin, an ignored one needed
+// nothing done.
+const (
+	WebhookEventReceived   = "received"
+	WebhookEventProcessing = "processing"
+	WebhookEventProcessed  = "processed"
+	WebhookEventIgnored    = "ignored"
+	WebhookEventFailed     = "failed"
+)
+
+// Dispute outcomes
+const (
+	DisputeWon  = "won"
+	DisputeLost = "lost"
+)
+
+// WebhookEventPayload is an event as delivered by a payment provider
+type WebhookEventPayload struct {
+	ID       string           `json:"id"`       // The provider's event ID, the same on every delivery
+	Type     string           `json:"type"`     // See the Webhook constants
+	Provider string           `json:"provider"` // See the PaymentProvider constants
+	Created  int64            `json:"created"`  // Unix time the provider created the event
+	Data     WebhookEventData `json:"data"`
+}
+
+// WebhookEventData is what an event is about. Charges are found by the provider's reference or
+// by the studio's charge ID sent along as metadata, payment methods by the card or agreement ID.
+type WebhookEventData struct {
+	Charge        string `json:"charge,omitempty"`
+	ChargeID      int    `json:"charge_id,omitempty"`
+	PaymentMethod string `json:"payment_method,omitempty"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	Outcome       string `json:"outcome,omitempty"` // Dispute outcome, see the Dispute constants
+	ExpiryMonth   int    `json:"expiry_month,omitempty"`
+	ExpiryYear    int    `json:"expiry_year,omitempty"`
+}
+
+// WebhookEvent is a stored webhook event. Every delivery is stored before it is processed so
+// an event delivered twice is only processed once.
+type WebhookEvent struct {
+	ID          int        `json:"id"`
+	Provider    string     `json:"provider"`
+	EventID     string     `json:"event_id"`
+	Type        string     `json:"type"`
+	Payload     string     `json:"payload"`
+	Status      string     `json:"status"` // See the WebhookEvent constants
+	Error       string     `json:"error"`  // Why processing failed or was ignored
+	Attempts    int        `json:"attempts"`
+	ReceivedAt  time.Time  `json:"received_at"`
+	ProcessedAt *time.Time `json:"processed_at"`
+	ClaimedAt   *time.Time `json:"claimed_at"` // When processing last started
+}
@@ -18,29 +18,29 @@ func main() {
 
 	// Seed membership data
 	memberships := []string{
-		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(1, '12-måneder', 104000, 12, false, false, 'Vår mest populære medlemskap med 12 måneders binding', '["Ubegrenset gruppeklasser", "Rabatt på workshops", "Kan fryses", "Tilgang til alle lokasjoner"]', true)`,
+		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(1, '12-måneder', 104000, 12, false, false, 'Vår mest populære medlemskap med 12 måneders binding', '{"freeze_allowed": true, "online_access": true, "perks": ["Rabatt på workshops"]}', true)`,
 		
-		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(2, '6-måneder', 115000, 6, false, false, '6 måneders binding med fleksibilitet', '["Ubegrenset gruppeklasser", "Rabatt på workshops", "Kan fryses", "Tilgang til alle lokasjoner"]', true)`,
+		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(2, '6-måneder', 115000, 6, false, false, '6 måneders binding med fleksibilitet', '{"freeze_allowed": true, "online_access": true, "perks": ["Rabatt på workshops"]}', true)`,
 		
-		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(3, 'Ingen binding', 125000, 0, false, false, 'Full fleksibilitet uten binding', '["Ubegrenset gruppeklasser", "Rabatt på workshops", "Kan fryses", "1 måned oppsigelse"]', true)`,
+		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(3, 'Ingen binding', 125000, 0, false, false, 'Full fleksibilitet uten binding', '{"freeze_allowed": true, "online_access": true, "perks": ["Rabatt på workshops", "1 måned oppsigelse"]}', true)`,
 		
-		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(4, 'Student/Senior 12-måneder', 83000, 12, true, false, 'Studentrabatt på 12-måneder medlemskap', '["20% studentrabatt", "Ubegrenset gruppeklasser", "Rabatt på workshops", "Kan fryses"]', true)`,
+		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(4, 'Student/Senior 12-måneder', 83000, 12, true, false, 'Studentrabatt på 12-måneder medlemskap', '{"freeze_allowed": true, "online_access": true, "perks": ["20% studentrabatt", "Rabatt på workshops"]}', true)`,
 		
-		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(5, 'Student/Senior ingen binding', 104000, 0, true, false, 'Studentrabatt uten binding', '["20% studentrabatt", "Ubegrenset gruppeklasser", "Rabatt på workshops", "Kan fryses"]', true)`,
+		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(5, 'Student/Senior ingen binding', 104000, 0, true, false, 'Studentrabatt uten binding', '{"freeze_allowed": true, "online_access": true, "perks": ["20% studentrabatt", "Rabatt på workshops"]}', true)`,
 		
-		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(6, 'Høsttilbud', 104000, 4, false, true, 'Spesialtilbud for høsten - 12-måneders pris med kun 4 måneders binding', '["12-måneders pris", "Kun 4 måneders binding", "Online videobibliotek", "Gratis mattegjenlegging", "Ubegrenset gruppeklasser"]', true)`,
+		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(6, 'Høsttilbud', 104000, 4, false, true, 'Spesialtilbud for høsten - 12-måneders pris med kun 4 måneders binding', '{"online_access": true, "perks": ["12-måneders pris", "Kun 4 måneders binding", "Gratis mattegjenlegging"]}', true)`,
 		
-		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(7, '2-ukers prøve', 52500, 0, false, false, 'Prøv oss i 2 uker', '["2 ukers ubegrenset tilgang", "Alle gruppeklasser", "Ingen binding", "Engangsbeløp"]', true)`,
+		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, duration_days, is_trial, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(7, '2-ukers prøve', 52500, 0, 14, true, false, false, 'Prøv oss i 2 uker', '{"online_access": true, "perks": ["Ingen binding", "Engangsbeløp"]}', true)`,
 		
-		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, features, active) VALUES 
-		(8, 'Månedskort', 150000, 1, false, false, 'Ett måneds full tilgang', '["1 måned ubegrenset tilgang", "Automatisk utløp", "Ingen oppsigelse nødvendig"]', true)`,
+		`INSERT OR IGNORE INTO memberships (id, name, price, commitment_months, is_student_senior, is_special_offer, description, entitlements, active) VALUES 
+		(8, 'Månedskort', 150000, 1, false, false, 'Ett måneds full tilgang', '{"online_access": true, "perks": ["Automatisk utløp", "Ingen oppsigelse nødvendig"]}', true)`,
 	}
 
 	// Seed klippekort packages
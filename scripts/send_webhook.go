@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"kjernekraft/handlers"
+	"kjernekraft/models"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Sends a fake payment provider event to a locally running server, signed with WEBHOOK_SECRET
+// like the providers sign theirs, e.g.
+//
+//	WEBHOOK_SECRET=dev go run scripts/send_webhook.go -type charge.failed -charge-id 12 -reason card_declined
+func main() {
+	server := flag.String("server", "http://localhost:8080", "the server to deliver the event to")
+	eventType := flag.String("type", models.WebhookChargeSucceeded, "the event type, e.g. charge.succeeded or payment_method.expired")
+	provider := flag.String("provider", models.PaymentProviderStripe, "the payment provider, stripe or vipps")
+	eventID := flag.String("id", "", "the provider's event ID, a new one if empty. Send the same ID again to test duplicates.")
+	charge := flag.String("charge", "", "the provider's reference of the charge")
+	chargeID := flag.Int("charge-id", 0, "the studio's charge ID")
+	paymentMethod := flag.String("payment-method", "", "the card or agreement ID")
+	reason := flag.String("reason", "", "why the charge failed")
+	outcome := flag.String("outcome", "", "the dispute outcome, won or lost")
+	expiryMonth := flag.Int("expiry-month", 0, "the new expiry month of the card")
+	expiryYear := flag.Int("expiry-year", 0, "the new expiry year of the card")
+	flag.Parse()
+
+	if err := handlers.LoadWebhookSecret(); err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+	if *eventID == "" {
+		*eventID = fmt.Sprintf("evt_dev_%d", now.UnixNano())
+	}
+	event := models.WebhookEventPayload{
+		ID:       *eventID,
+		Type:     *eventType,
+		Provider: *provider,
+		Created:  now.Unix(),
+		Data: models.WebhookEventData{
+			Charge:        *charge,
+			ChargeID:      *chargeID,
+			PaymentMethod: *paymentMethod,
+			FailureReason: *reason,
+			Outcome:       *outcome,
+			ExpiryMonth:   *expiryMonth,
+			ExpiryYear:    *expiryYear,
+		},
+	}
+
+	req, err := handlers.NewSignedWebhookRequest(handlers.WebhookSecret, event, now)
+	if err != nil {
+		log.Fatal(err)
+	}
+	target, err := url.Parse(*server + req.URL.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.URL = target
+	req.Host = target.Host
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	fmt.Printf("%s %s: %s\n%s\n", event.Type, event.ID, resp.Status, body)
+	if resp.StatusCode >= 300 {
+		os.Exit(1)
+	}
+}
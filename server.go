@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -23,6 +24,11 @@ func main() {
 	// Initialize session store
 	handlers.InitializeSessionStore()
 
+	// Payment providers sign their webhooks with this secret
+	if err := handlers.LoadWebhookSecret(); err != nil {
+		log.Fatal(err)
+	}
+
 	dbConn, err := database.Connect()
 	if err != nil {
 		log.Fatal(err)
@@ -37,6 +43,9 @@ func main() {
 	handlers.DB = db
 	handlers.AdminDB = db
 
+	// Price changes, renewal billing and other periodic work
+	handlers.StartBackgroundJobs(time.Hour)
+
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 
@@ -65,18 +74,67 @@ func main() {
 
 	// Admin routes
 	r.Get("/admin", handlers.AdminPageHandler)
+	r.Get("/admin/medlem", handlers.MemberDetailPageHandler)
 	r.Get("/api/admin/users", handlers.GetUsersAPIHandler)
+	r.Post("/api/admin/members/override", handlers.MemberOverrideHandler)
+	r.Get("/api/admin/members/actions", handlers.GetMemberActionsHandler)
 	r.Get("/api/admin/membership-rules", handlers.GetMembershipRulesHandler)
 	r.Post("/api/admin/membership-rules", handlers.SaveMembershipRulesHandler)
+	r.Get("/api/admin/klippekort-rules", handlers.GetKlippekortRulesHandler)
+	r.Post("/api/admin/klippekort-rules", handlers.SaveKlippekortRulesHandler)
+	r.Get("/api/admin/klippekort-packages", handlers.GetKlippekortPackagesAdminHandler)
+	r.Post("/api/admin/klippekort-packages", handlers.SaveKlippekortPackageHandler)
+	r.Delete("/api/admin/klippekort-packages", handlers.RetireKlippekortPackageHandler)
+	r.Post("/api/admin/klippekort-packages/reorder", handlers.ReorderKlippekortPackagesHandler)
+	r.Post("/api/admin/klippekort-policies", handlers.SaveKlippekortPolicyHandler)
+	r.Get("/api/admin/recommendation-rules", handlers.GetRecommendationRulesHandler)
+	r.Post("/api/admin/recommendation-rules", handlers.SaveRecommendationRuleHandler)
+	r.Delete("/api/admin/recommendation-rules", handlers.DeleteRecommendationRuleHandler)
+	r.Get("/api/admin/campaigns", handlers.GetCampaignsHandler)
+	r.Post("/api/admin/campaigns", handlers.SaveCampaignHandler)
+	r.Delete("/api/admin/campaigns", handlers.DeactivateCampaignHandler)
+	r.Get("/api/admin/coupons", handlers.GetCouponsHandler)
+	r.Post("/api/admin/coupons", handlers.SaveCouponHandler)
+	r.Delete("/api/admin/coupons", handlers.DeleteCouponHandler)
+	r.Get("/api/admin/coupons/redemptions", handlers.GetCouponRedemptionsHandler)
+	r.Get("/api/admin/households", handlers.GetHouseholdsHandler)
+	r.Post("/api/admin/household-discounts", handlers.SaveHouseholdDiscountHandler)
+	r.Get("/api/admin/companies", handlers.GetCompaniesHandler)
+	r.Post("/api/admin/companies", handlers.SaveCompanyHandler)
+	r.Delete("/api/admin/companies", handlers.DeleteCompanyHandler)
+	r.Get("/api/admin/company-invoices", handlers.GetCompanyInvoicesHandler)
+	r.Post("/api/admin/company-invoices/paid", handlers.MarkCompanyInvoicePaidHandler)
+	r.Post("/api/admin/business-details", handlers.SaveBusinessDetailsHandler)
+	r.Post("/api/admin/vat-rates", handlers.SaveVATRateHandler)
+	r.Post("/api/admin/account-mappings", handlers.SaveAccountMappingHandler)
+	r.Get("/api/admin/accounting/export", handlers.AccountingExportHandler)
+	r.Get("/api/admin/reports/export", handlers.KPIReportExportHandler)
+	r.Post("/api/admin/webhooks/replay", handlers.ReplayWebhookEventHandler)
 	r.Post("/api/admin/membership-price", handlers.UpdateMembershipPriceHandler)
+	r.Get("/api/admin/membership-price/preview", handlers.PreviewMembershipPriceHandler)
+	r.Get("/api/admin/membership-prices", handlers.GetMembershipPriceVersionsHandler)
+	r.Get("/api/admin/membership-prices/notice-list", handlers.PriceNoticeListHandler)
+	r.Post("/api/admin/jobs/run", handlers.RunBackgroundJobsHandler)
 	r.Post("/api/admin/membership", handlers.CreateMembershipHandler)
 	r.Delete("/api/admin/membership", handlers.DeleteMembershipHandler)
+	r.Post("/api/admin/membership-entitlements", handlers.SaveMembershipEntitlementsHandler)
 	r.Post("/api/admin/class", handlers.CreateClassHandler)
 	r.Put("/api/admin/class/*", handlers.UpdateClassHandler)
 	r.Delete("/api/admin/class/*", handlers.DeleteClassHandler)
 	r.Post("/api/admin/events/update-time", handlers.UpdateEventTimeHandler)
+	r.Get("/api/admin/events/participants", handlers.GetEventParticipantsHandler)
+	r.Post("/api/admin/events/check-in", handlers.CheckInHandler)
+	r.Get("/api/admin/closures", handlers.GetClosuresHandler)
+	r.Post("/api/admin/closures", handlers.CreateClosureHandler)
+	r.Delete("/api/admin/closures", handlers.DeleteClosureHandler)
+	r.Post("/api/admin/closures/apply", handlers.ApplyClosuresHandler)
+	r.Get("/api/admin/qualifications", handlers.GetQualificationsHandler)
+	r.Post("/api/admin/qualifications", handlers.CreateQualificationHandler)
+	r.Post("/api/admin/qualifications/grant", handlers.GrantQualificationHandler)
 	r.Post("/api/admin/freeze-requests/approve", handlers.ApproveFreezeRequestHandler)
 	r.Post("/api/admin/freeze-requests/reject", handlers.RejectFreezeRequestHandler)
+	r.Get("/api/admin/discount-verifications", handlers.GetPendingDiscountVerificationsHandler)
+	r.Post("/api/admin/discount-verifications/review", handlers.ReviewDiscountVerificationHandler)
 	r.Route("/api/admin/settings", func(r chi.Router) {
 		r.Get("/", handlers.AdminSettingsHandler)
 		r.Post("/", handlers.AdminSettingsHandler)
@@ -107,30 +165,58 @@ func main() {
 
 	// Dashboard component routes (HTMX endpoints)
 	r.Get("/api/user/klippekort", handlers.UserKlippekortHandler)
+	r.Get("/api/user/klippekort/history", handlers.UserKlippHistoryHandler)
 	r.Get("/api/user/membership", handlers.UserMembershipHandler)
 	r.Get("/api/user/signups", handlers.UserSignupsHandler)
+	r.Get("/api/user/notifications", handlers.UserNotificationsHandler)
+	r.Post("/api/user/notifications/read", handlers.MarkNotificationsReadHandler)
 
 	// Payment API routes
 	r.Get("/api/payment-methods", handlers.PaymentMethodsHandler)
 	r.Get("/api/charges", handlers.ChargesHandler)
+	r.Get("/api/charges/receipt", handlers.ReceiptHandler)
 	r.Post("/api/payment-methods/set-default", handlers.SetDefaultPaymentMethodHandler)
 	r.Post("/api/payment-methods/remove", handlers.RemovePaymentMethodHandler)
+	r.Post("/api/payment-methods/vipps", handlers.AddVippsPaymentMethodHandler)
+
+	// Payment provider webhooks
+	r.Post("/api/webhooks/payments", handlers.PaymentWebhookHandler)
+
+	// Household API routes
+	r.Get("/api/household", handlers.HouseholdHandler)
+	r.Post("/api/household/create", handlers.CreateHouseholdHandler)
+	r.Post("/api/household/members/add", handlers.AddHouseholdMemberHandler)
+	r.Post("/api/household/members/remove", handlers.RemoveHouseholdMemberHandler)
+
+	// Company account API routes
+	r.Post("/api/company/enroll", handlers.EnrollInCompanyHandler)
+	r.Post("/api/company/leave", handlers.LeaveCompanyHandler)
+	r.Post("/api/company/approve", handlers.ApproveCompanyEmployeeHandler)
+	r.Get("/api/company/invoice", handlers.CompanyInvoiceHandler)
 
 	// Membership management API routes
 	r.Post("/api/membership/freeze", handlers.FreezeMembershipHandler)
 	r.Post("/api/membership/cancel-freeze", handlers.CancelFreezeRequestHandler)
 	r.Post("/api/membership/unfreeze", handlers.UnfreezeMembershipHandler)
 	r.Post("/api/membership/add", handlers.AddMembershipHandler)
+	r.Get("/api/coupons/quote", handlers.QuoteCouponHandler)
 	r.Post("/api/membership/change", handlers.ChangeMembershipHandler)
 	r.Get("/api/membership/can-change", handlers.CanChangeMembershipHandler)
+	r.Post("/api/membership/cancel-scheduled-change", handlers.CancelScheduledMembershipChangeHandler)
+	r.Post("/api/membership/trial-conversion", handlers.TrialConversionHandler)
+	r.Post("/api/membership/verification", handlers.RequestDiscountVerificationHandler)
 	r.Post("/api/membership/remove", handlers.RemoveMembershipHandler)
 
 	// Klippekort management API routes
 	r.Post("/api/klippekort/purchase", handlers.PurchaseKlippekortHandler)
+	r.Post("/api/klippekort/share", handlers.ShareKlippekortHandler)
+	r.Post("/api/klippekort/share/revoke", handlers.RevokeKlippekortShareHandler)
 
 	// Event signup API routes
 	r.Post("/api/events/signup", handlers.EventSignupHandler)
 	r.Post("/api/events/cancel-signup", handlers.EventCancelSignupHandler)
+	r.Post("/api/events/guest", handlers.EventGuestHandler)
+	r.Get("/api/events/stream", handlers.EventStreamHandler)
 
 	// Elev dashboard routes
 	r.Get("/elev", func(w http.ResponseWriter, r *http.Request) {
@@ -142,6 +228,7 @@ func main() {
 	r.Get("/elev/medlemskap", handlers.MembershipSelectorHandler)
 	r.Post("/elev/medlemskap/recommendations", handlers.MembershipRecommendationsHandler)
 	r.Get("/elev/betaling", handlers.BetalingHandler)
+	r.Get("/elev/bedrift", handlers.CompanyPageHandler)
 	r.Get("/elev/min-profil", handlers.MinProfilHandler)
 	r.Post("/elev/min-profil", handlers.MinProfilHandler)
 	r.Get("/elev/testdata", handlers.TestDataPageHandler)
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test that account credit pays for klippekort and memberships before the payment method, and
+// that refunds put back the part credit paid
+func TestAccountCredit(t *testing.T) {
+	db := openTestDB(t)
+	userID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	if err := db.AdminAdjustCredit(userID, 30000, "", now); err == nil {
+		t.Errorf("expected credit without a reason to be rejected")
+	}
+	if err := db.AdminAdjustCredit(userID, 30000, "Kompensasjon for avlyst time", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AdminAdjustCredit(userID, -40000, "Feilføring", now); err == nil {
+		t.Errorf("expected the balance not to go below zero")
+	}
+
+	// The credit pays part of the klippekort, the card the rest
+	start := now.Add(-time.Minute)
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	charges, err := db.GetUserCharges(userID, "klippekort")
+	if err != nil || len(charges) != 1 {
+		t.Fatalf("expected a klippekort charge, got %v (%v)", charges, err)
+	}
+	charge := charges[0]
+	if charge.Amount != 110000 || charge.CreditApplied != 30000 || charge.PaymentMethodType != models.PaymentMethodTypeCard {
+		t.Errorf("expected 300 kr of the charge to be paid with credit, got %+v", charge)
+	}
+	if balance, err := db.GetCreditBalance(userID); err != nil || balance != 0 {
+		t.Errorf("expected the credit to be used, got %d (%v)", balance, err)
+	}
+
+	entries, err := db.GetAccountingEntries(start, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settled := map[string]int{}
+	for _, e := range entries {
+		settled[e.SettlementAccount] += e.Amount
+	}
+	if len(entries) != 2 || settled["1920"] != 80000 || settled["2900"] != 30000 {
+		t.Errorf("expected the sale to be settled from the bank and the credit account, got %+v", entries)
+	}
+
+	// A full refund pays the card back what it paid and puts the rest back on the credit
+	if err := db.AdminRefundCharge(userID, int64(charge.ID), 110000, "Angret kjøp", now); err != nil {
+		t.Fatal(err)
+	}
+	notes, err := db.GetCreditNotes(userID)
+	if err != nil || len(notes) != 1 || notes[0].CreditedAmount != 30000 {
+		t.Errorf("expected 300 kr of the refund to go back on the credit, got %+v (%v)", notes, err)
+	}
+	if balance, err := db.GetCreditBalance(userID); err != nil || balance != 30000 {
+		t.Errorf("expected 300 kr credit after the refund, got %d (%v)", balance, err)
+	}
+
+	// A member without a payment method can pay a membership in full with credit
+	memberID, membershipID := insertOverrideMember(t, db)
+	if err := db.AdminAdjustCredit(memberID, 100000, "Gavekort", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutMembership(memberID, membershipID, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	charges, err = db.GetUserCharges(memberID, "medlemskap")
+	if err != nil || len(charges) != 1 || charges[0].PaymentMethodType != models.PaymentMethodTypeCredit ||
+		charges[0].CreditApplied != charges[0].Amount || charges[0].PaymentMethodID != nil {
+		t.Errorf("expected the membership to be paid with credit, got %+v (%v)", charges, err)
+	}
+	history, err := db.GetCreditHistory(memberID)
+	if err != nil || len(history) != 2 || history[0].Kind != models.CreditApplied || history[0].Balance != 100000-charges[0].Amount {
+		t.Errorf("expected the credit to be used in the ledger, got %+v (%v)", history, err)
+	}
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"kjernekraft/handlers"
+	"kjernekraft/models"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that sales, credits and refunds are booked on the mapped accounts with MVA broken out,
+// and the unused klipp and membership days are deferred
+func TestAccountingExport(t *testing.T) {
+	db := openTestDB(t)
+	userID, packageID := insertKlippekortCustomer(t, db)
+	memberID, membershipID := insertOverrideMember(t, db)
+	for _, id := range []int64{userID, memberID} {
+		if err := db.CreateDefaultPaymentMethods(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SaveAccountMapping(models.AccountMapping{Kind: models.AccountMappingRevenue, Key: "klippekort", Account: "30x0"}); err == nil {
+		t.Errorf("expected an account that is not 4 digits to be rejected")
+	}
+
+	start := time.Now().Add(-time.Minute)
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SimulateBilling(userID, -5000, "Kreditering", "medlemskap"); err != nil {
+		t.Fatal(err)
+	}
+	charges, err := db.GetUserCharges(userID, "klippekort")
+	if err != nil || len(charges) != 1 {
+		t.Fatalf("expected a klippekort charge, got %v (%v)", charges, err)
+	}
+	if err := db.AdminRefundCharge(userID, int64(charges[0].ID), 22000, "Ett klipp", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutMembership(memberID, membershipID, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.GetAccountingEntries(start, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]models.AccountingEntry{}
+	for _, e := range entries {
+		if e.CustomerID == "M"+strconv.FormatInt(userID, 10) {
+			kinds[e.Kind] = e
+		}
+	}
+	if len(entries) != 4 || len(kinds) != 3 {
+		t.Fatalf("expected two sales, a credit and a credit note, got %+v", entries)
+	}
+	sale := kinds[models.AccountingEntrySale]
+	if sale.SettlementAccount != "1920" || sale.RevenueAccount != "3010" || sale.VATAccount != "2702" || sale.VATCode != "33" ||
+		sale.NetAmount != 98214 || sale.VATAmount != 11786 || !strings.HasPrefix(sale.Voucher, "K-") {
+		t.Errorf("unexpected klippekort sale %+v", sale)
+	}
+	if credit := kinds[models.AccountingEntryCredit]; credit.Amount != -5000 || credit.RevenueAccount != "3000" || credit.NetAmount+credit.VATAmount != -5000 {
+		t.Errorf("unexpected credit %+v", credit)
+	}
+	if note := kinds[models.AccountingEntryCreditNote]; note.Amount != -22000 || !strings.HasPrefix(note.Voucher, "KN-") {
+		t.Errorf("unexpected credit note %+v", note)
+	}
+
+	var csv bytes.Buffer
+	if err := handlers.WriteFikenCSV(&csv, entries); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(csv.String(), ";3010;1920;220,00;33;") {
+		t.Errorf("expected the refund to be booked from revenue back to the bank, got\n%s", csv.String())
+	}
+
+	var saft bytes.Buffer
+	if err := handlers.WriteSAFT(&saft, entries, models.BusinessDetails{Name: "Kjernekraft AS", OrgNumber: "123456789", VATRegistered: true},
+		start, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		Entries struct {
+			Count  int    `xml:"NumberOfEntries"`
+			Debit  string `xml:"TotalDebit"`
+			Credit string `xml:"TotalCredit"`
+		} `xml:"GeneralLedgerEntries"`
+	}
+	if err := xml.Unmarshal(saft.Bytes(), &file); err != nil {
+		t.Fatalf("expected a well-formed SAF-T file: %v", err)
+	}
+	if file.Entries.Count != 4 || file.Entries.Debit != file.Entries.Credit {
+		t.Errorf("expected four balanced transactions, got %+v", file.Entries)
+	}
+
+	// 4 klipp are left of the 4 not refunded, worth the 880 kr still paid for them
+	asOf := time.Now().AddDate(0, 0, 10)
+	deferred, err := db.GetDeferredRevenue(asOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deferred.Klippekort != 78571 {
+		t.Errorf("expected 785,71 kr deferred for unused klipp, got %d", deferred.Klippekort)
+	}
+	var membership *models.DeferredRevenueLine
+	for i, line := range deferred.Lines {
+		if line.ChargeType == "medlemskap" {
+			membership = &deferred.Lines[i]
+		}
+	}
+	if membership == nil || membership.Units < 17 || membership.Units > 22 || membership.Amount != 69900*membership.Units/membership.TotalUnits {
+		t.Errorf("expected the rest of the paid membership month to be deferred, got %+v", membership)
+	}
+}
@@ -0,0 +1,171 @@
+package test
+
+import (
+	"database/sql"
+	"kjernekraft/database"
+	"kjernekraft/models"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestDB migrates a fresh database in a temporary directory
+func openTestDB(t *testing.T) *database.Database {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := database.Migrate(conn); err != nil {
+		t.Fatalf("could not migrate: %v", err)
+	}
+	return &database.Database{Conn: conn}
+}
+
+// insertOverrideMember creates a member and a monthly plan to apply overrides to
+func insertOverrideMember(t *testing.T, db *database.Database) (int64, int64) {
+	result, err := db.Conn.Exec(`INSERT INTO users (name, birthdate, email, phone, password)
+		VALUES ('Overstyrt Medlem', '1990-01-01', 'overstyrt@example.com', '99887766', 'x')`)
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+	userID, _ := result.LastInsertId()
+
+	result, err = db.Conn.Exec(`INSERT INTO memberships (name, price, commitment_months, description, active)
+		VALUES ('Overstyrt Fleks', 69900, 0, '', TRUE)`)
+	if err != nil {
+		t.Fatalf("could not create membership: %v", err)
+	}
+	membershipID, _ := result.LastInsertId()
+	return userID, membershipID
+}
+
+// Test that overrides need a reason and are logged with it
+func TestAdminOverridesRequireReason(t *testing.T) {
+	db := openTestDB(t)
+	userID, membershipID := insertOverrideMember(t, db)
+	now := time.Date(2025, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	if err := db.AdminAssignMembership(userID, membershipID, "  ", now); err == nil {
+		t.Fatalf("expected an override without reason to be rejected")
+	}
+	if membership, _ := db.GetUserMembership(userID); membership != nil {
+		t.Fatalf("expected nothing to change when the reason is missing")
+	}
+
+	if err := db.AdminAssignMembership(userID, membershipID, "Vant konkurranse", now); err != nil {
+		t.Fatalf("could not assign membership: %v", err)
+	}
+	charges, err := db.GetUserCharges(userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charges) != 0 {
+		t.Errorf("expected an assigned membership not to be charged, got %d charges", len(charges))
+	}
+
+	actions, err := db.GetAdminActions(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].Action != models.AdminActionAssignMembership || actions[0].Reason != "Vant konkurranse" {
+		t.Errorf("expected the assignment to be logged with its reason, got %+v", actions)
+	}
+}
+
+// Test custom prices and free months on a member's membership
+func TestAdminMembershipAdjustments(t *testing.T) {
+	db := openTestDB(t)
+	userID, membershipID := insertOverrideMember(t, db)
+	now := time.Date(2025, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	if err := db.AdminAssignMembership(userID, membershipID, "Ansatt", now); err != nil {
+		t.Fatal(err)
+	}
+
+	price := 0
+	if err := db.AdminSetCustomPrice(userID, &price, "Ansattmedlemskap", now); err != nil {
+		t.Fatal(err)
+	}
+	membership, err := db.GetUserMembership(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if membership.Membership.Price != 0 || membership.CustomPrice == nil {
+		t.Errorf("expected the custom price to replace the plan's price, got %d", membership.Membership.Price)
+	}
+
+	if err := db.AdminSetCustomPrice(userID, nil, "Sluttet", now); err != nil {
+		t.Fatal(err)
+	}
+	membership, _ = db.GetUserMembership(userID)
+	if membership.Membership.Price != 69900 {
+		t.Errorf("expected the plan's price after clearing the custom price, got %d", membership.Membership.Price)
+	}
+
+	renewal := membership.RenewalDate
+	if err := db.AdminGrantFreeMonths(userID, 2, "Kompensasjon for stengt senter", now); err != nil {
+		t.Fatal(err)
+	}
+	membership, _ = db.GetUserMembership(userID)
+	if !membership.RenewalDate.Equal(renewal.AddDate(0, 2, 0)) {
+		t.Errorf("expected the renewal to move two months, got %v", membership.RenewalDate)
+	}
+
+	if err := db.AdminSetMembershipStatus(userID, "cancelled", "Feilregistrert", now); err != nil {
+		t.Fatal(err)
+	}
+	if membership, _ := db.GetUserMembership(userID); membership != nil {
+		t.Errorf("expected the membership to be ended")
+	}
+	if err := db.AdminSetMembershipStatus(userID, "active", "Avsluttet ved en feil", now); err != nil {
+		t.Fatal(err)
+	}
+	if membership, _ := db.GetUserMembership(userID); membership == nil {
+		t.Errorf("expected the membership to be active again")
+	}
+
+	actions, _ := db.GetAdminActions(userID)
+	if len(actions) != 6 {
+		t.Errorf("expected 6 logged overrides, got %d", len(actions))
+	}
+}
+
+// Test that an override is not made when it cannot be logged
+func TestAdminOverrideRollsBack(t *testing.T) {
+	db := openTestDB(t)
+	userID, membershipID := insertOverrideMember(t, db)
+	now := time.Date(2025, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	if err := db.AdminAssignMembership(userID, membershipID, "Ansatt", now); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Conn.Exec(`CREATE TRIGGER fail_admin_actions BEFORE INSERT ON admin_actions
+		BEGIN SELECT RAISE(ABORT, 'logg utilgjengelig'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	price := 0
+	if err := db.AdminSetCustomPrice(userID, &price, "Ansattmedlemskap", now); err == nil {
+		t.Fatalf("expected the override to fail when it cannot be logged")
+	}
+	membership, err := db.GetUserMembership(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if membership.CustomPrice != nil {
+		t.Errorf("expected the custom price not to be set, got %d", *membership.CustomPrice)
+	}
+
+	if err := db.AdminEndMembership(userID, now, "Flyttet", now); err == nil {
+		t.Fatalf("expected the override to fail when it cannot be logged")
+	}
+	if membership, _ := db.GetUserMembership(userID); membership == nil {
+		t.Errorf("expected the membership not to be ended")
+	}
+	var open int
+	if err := db.Conn.QueryRow("SELECT COUNT(*) FROM membership_history WHERE user_id = ? AND ended_at IS NULL", userID).Scan(&open); err != nil || open != 1 {
+		t.Errorf("expected the membership period to stay open, got %d (%v)", open, err)
+	}
+}
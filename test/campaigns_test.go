@@ -0,0 +1,72 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+func campaignDate(value string) time.Time {
+	date, _ := time.Parse("2006-01-02", value)
+	return date
+}
+
+func endedPeriod(started, ended string) models.MembershipPeriod {
+	end := campaignDate(ended)
+	return models.MembershipPeriod{MembershipID: 1, StartedAt: campaignDate(started), EndedAt: &end}
+}
+
+// Test campaign eligibility against the full membership history
+func TestCampaignEligible(t *testing.T) {
+	now := campaignDate("2025-10-15")
+	campaign := func(eligibility string) models.Campaign {
+		return models.Campaign{
+			StartDate:    campaignDate("2025-10-01"),
+			EndDate:      campaignDate("2025-10-31"),
+			Eligibility:  eligibility,
+			LapsedMonths: 6,
+			Active:       true,
+		}
+	}
+	ongoing := models.MembershipPeriod{MembershipID: 1, StartedAt: campaignDate("2025-01-01")}
+
+	cases := []struct {
+		name            string
+		campaign        models.Campaign
+		history         []models.MembershipPeriod
+		studentVerified bool
+		expected        bool
+	}{
+		{"first-time member without history", campaign(models.CampaignForFirstTime), nil, false, true},
+		{"first-time campaign for a former member", campaign(models.CampaignForFirstTime), []models.MembershipPeriod{endedPeriod("2023-01-01", "2023-06-01")}, false, false},
+		{"current members cannot redeem campaigns", campaign(models.CampaignForEveryone), []models.MembershipPeriod{ongoing}, false, false},
+		{"everyone without a membership", campaign(models.CampaignForEveryone), []models.MembershipPeriod{endedPeriod("2025-01-01", "2025-09-01")}, false, true},
+		{"lapsed long enough", campaign(models.CampaignForLapsed), []models.MembershipPeriod{endedPeriod("2024-01-01", "2025-04-15")}, false, true},
+		{"lapsed too recently", campaign(models.CampaignForLapsed), []models.MembershipPeriod{endedPeriod("2024-01-01", "2025-04-16")}, false, false},
+		{"lapsed uses the latest period", campaign(models.CampaignForLapsed), []models.MembershipPeriod{endedPeriod("2022-01-01", "2023-01-01"), endedPeriod("2025-05-01", "2025-08-01")}, false, false},
+		{"lapsed campaign for a first-time member", campaign(models.CampaignForLapsed), nil, false, false},
+		{"student campaign for a verified student", campaign(models.CampaignForStudents), nil, true, true},
+		{"student campaign without verification", campaign(models.CampaignForStudents), nil, false, false},
+	}
+
+	for _, c := range cases {
+		if actual := database.CampaignEligible(c.campaign, c.history, c.studentVerified, now); actual != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, actual)
+		}
+	}
+
+	// Campaigns are hidden outside their dates and once deactivated
+	expired := campaign(models.CampaignForEveryone)
+	if database.CampaignEligible(expired, nil, false, campaignDate("2025-11-01")) {
+		t.Errorf("expected campaign to be hidden after its end date")
+	}
+	if !database.CampaignEligible(expired, nil, false, campaignDate("2025-10-31")) {
+		t.Errorf("expected campaign to be available on its last day")
+	}
+	inactive := campaign(models.CampaignForEveryone)
+	inactive.Active = false
+	if database.CampaignEligible(inactive, nil, false, now) {
+		t.Errorf("expected deactivated campaign to be hidden")
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test that the Easter-based holidays land on the right dates for known years
+func TestNorwegianHolidays(t *testing.T) {
+	expected := map[int]map[string]string{
+		2024: {
+			"Skjærtorsdag":          "2024-03-28",
+			"Langfredag":            "2024-03-29",
+			"1. påskedag":           "2024-03-31",
+			"2. påskedag":           "2024-04-01",
+			"Kristi himmelfartsdag": "2024-05-09",
+			"1. pinsedag":           "2024-05-19",
+			"2. pinsedag":           "2024-05-20",
+			"Grunnlovsdag":          "2024-05-17",
+		},
+		2025: {
+			"1. påskedag":           "2025-04-20",
+			"Kristi himmelfartsdag": "2025-05-29",
+			"2. pinsedag":           "2025-06-09",
+		},
+		2026: {
+			"Skjærtorsdag": "2026-04-02",
+			"1. påskedag":  "2026-04-05",
+			"1. juledag":   "2026-12-25",
+		},
+	}
+
+	for year, holidays := range expected {
+		actual := make(map[string]string)
+		for _, holiday := range database.NorwegianHolidays(year) {
+			if !holiday.IsHoliday {
+				t.Errorf("%s %d should be marked as a holiday", holiday.Name, year)
+			}
+			actual[holiday.Name] = holiday.StartDate.Format("2006-01-02")
+		}
+
+		for name, date := range holidays {
+			if actual[name] != date {
+				t.Errorf("%s %d: expected %s, got %s", name, year, date, actual[name])
+			}
+		}
+	}
+}
+
+// Test that only the days of a closure inside the given range have their classes cancelled
+func TestCancelEventsInClosuresRange(t *testing.T) {
+	db := openTestDB(t)
+	day := func(d int) time.Time { return time.Date(2031, time.July, d, 10, 0, 0, 0, time.Local) }
+
+	if _, err := db.CreateClosure(models.Closure{Name: "Sommerstengt", StartDate: day(1), EndDate: day(10)}); err != nil {
+		t.Fatal(err)
+	}
+	var eventIDs []int64
+	for _, d := range []int{2, 8} {
+		eventID, err := db.CreateEvent(models.Event{Title: "Yoga", StartTime: day(d), EndTime: day(d).Add(time.Hour), ClassType: "yoga",
+			Capacity: 10, DeliveryMode: models.DeliveryInPerson})
+		if err != nil {
+			t.Fatal(err)
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+
+	cancelled, err := db.CancelEventsInClosures(day(5), day(31))
+	if err != nil || cancelled != 1 {
+		t.Fatalf("expected one class cancelled, got %d (%v)", cancelled, err)
+	}
+	for i, expected := range []bool{false, true} {
+		event, err := db.GetEventByID(eventIDs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.IsCancelled != expected {
+			t.Errorf("class on %s: expected cancelled to be %v", event.StartTime.Format("02.01"), expected)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test how much of a monthly price the company pays for each subsidy type
+func TestCompanyShare(t *testing.T) {
+	cases := []struct {
+		name     string
+		company  models.Company
+		price    int
+		expected int
+	}{
+		{"full", models.Company{SubsidyType: models.CompanySubsidyFull}, 104000, 104000},
+		{"50 percent", models.Company{SubsidyType: models.CompanySubsidyPercent, SubsidyValue: 50}, 104000, 52000},
+		{"percent rounds to nearest øre", models.Company{SubsidyType: models.CompanySubsidyPercent, SubsidyValue: 15}, 999, 150},
+		{"fixed", models.Company{SubsidyType: models.CompanySubsidyFixed, SubsidyValue: 30000}, 104000, 30000},
+		{"fixed never above price", models.Company{SubsidyType: models.CompanySubsidyFixed, SubsidyValue: 80000}, 52500, 52500},
+		{"unknown type pays nothing", models.Company{SubsidyType: "other", SubsidyValue: 50}, 52500, 0},
+	}
+
+	for _, c := range cases {
+		if actual := c.company.CompanyShare(c.price); actual != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.name, c.expected, actual)
+		}
+	}
+}
+
+// Test enrollment eligibility by email domain
+func TestCompanyMatchesEmail(t *testing.T) {
+	company := models.Company{EmailDomain: "bedrift.no"}
+
+	cases := map[string]bool{
+		"ola@bedrift.no":       true,
+		"Kari@BEDRIFT.NO":      true,
+		"ola@annen-bedrift.no": false,
+		"ola@bedrift.no.com":   false,
+		"bedrift.no":           false,
+	}
+	for email, expected := range cases {
+		if actual := company.MatchesEmail(email); actual != expected {
+			t.Errorf("%s: expected %v, got %v", email, expected, actual)
+		}
+	}
+
+	if (models.Company{}).MatchesEmail("ola@bedrift.no") {
+		t.Errorf("expected a company without domain to only accept invitation codes")
+	}
+}
+
+// Test which plans and admins belong to a company
+func TestCompanyPlansAndAdmins(t *testing.T) {
+	company := models.Company{MembershipIDs: []int{2, 3}, AdminUserIDs: []int{7}}
+
+	if !company.Covers(3) || company.Covers(1) {
+		t.Errorf("expected the company to cover plans 2 and 3 only")
+	}
+	if !company.IsAdmin(7) || company.IsAdmin(8) {
+		t.Errorf("expected only user 7 to manage the company")
+	}
+}
+
+// Test that members joining by email domain are only enrolled once a company admin approves them,
+// while the invitation code enrolls right away
+func TestCompanyEnrollmentApproval(t *testing.T) {
+	db := openTestDB(t)
+	adminID, membershipID := insertOverrideMember(t, db)
+	insertUser := func(name, email, phone string) int64 {
+		result, err := db.Conn.Exec(`INSERT INTO users (name, birthdate, email, phone, password) VALUES (?, '1990-01-01', ?, ?, 'x')`, name, email, phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userID, _ := result.LastInsertId()
+		return userID
+	}
+	employeeID := insertUser("Ansatt", "ansatt@bedrift.no", "91000001")
+	invitedID := insertUser("Invitert", "invitert@gmail.com", "91000002")
+	now := time.Now()
+
+	companyID, err := db.SaveCompany(models.Company{Name: "Bedrift AS", EmailDomain: "bedrift.no", InviteCode: "BEDRIFT",
+		SubsidyType: models.CompanySubsidyFull, MembershipIDs: []int{int(membershipID)}, Active: true, AdminEmails: []string{"overstyrt@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	company, pending, err := db.EnrollInCompany(employeeID, "", now)
+	if err != nil || !pending || int64(company.ID) != companyID {
+		t.Fatalf("expected the domain match to wait for approval, got %v, %v (%v)", company, pending, err)
+	}
+	if enrolled, _ := db.GetCompanyForEmployee(employeeID); enrolled != nil {
+		t.Errorf("expected the company not to pay before the employee is approved")
+	}
+	if _, _, err := db.EnrollInCompany(employeeID, "", now); err == nil {
+		t.Errorf("expected a second request to be refused while the first waits")
+	}
+	if err := db.ApproveCompanyEmployee(invitedID, employeeID, now); err == nil {
+		t.Errorf("expected only company admins to approve")
+	}
+	if err := db.ApproveCompanyEmployee(adminID, employeeID, now); err != nil {
+		t.Fatal(err)
+	}
+	if enrolled, err := db.GetCompanyForEmployee(employeeID); err != nil || enrolled == nil || enrolled.EnrolledCount != 1 {
+		t.Errorf("expected the approved employee to be enrolled, got %v (%v)", enrolled, err)
+	}
+
+	if _, pending, err := db.EnrollInCompany(invitedID, "bedrift", now); err != nil || pending {
+		t.Errorf("expected the invitation code to enroll right away, got %v (%v)", pending, err)
+	}
+
+	// A company nobody can approve for only takes invitation codes
+	otherID := insertUser("Annen", "annen@utenadmin.no", "91000003")
+	if _, err := db.SaveCompany(models.Company{Name: "Uten Admin AS", EmailDomain: "utenadmin.no",
+		SubsidyType: models.CompanySubsidyFull, MembershipIDs: []int{int(membershipID)}, Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.EnrollInCompany(otherID, "", now); err == nil {
+		t.Errorf("expected a domain match without company admins to be refused")
+	}
+}
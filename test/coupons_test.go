@@ -0,0 +1,133 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test the discount for percentage and fixed coupons
+func TestCouponDiscount(t *testing.T) {
+	cases := []struct {
+		name     string
+		coupon   models.Coupon
+		amount   int
+		expected int
+	}{
+		{"20 percent", models.Coupon{DiscountType: models.CouponPercent, DiscountValue: 20}, 104000, 20800},
+		{"percent rounds to nearest øre", models.Coupon{DiscountType: models.CouponPercent, DiscountValue: 15}, 999, 150},
+		{"100 percent", models.Coupon{DiscountType: models.CouponPercent, DiscountValue: 100}, 52500, 52500},
+		{"fixed amount", models.Coupon{DiscountType: models.CouponFixed, DiscountValue: 20000}, 110000, 20000},
+		{"fixed amount never exceeds the price", models.Coupon{DiscountType: models.CouponFixed, DiscountValue: 50000}, 25000, 25000},
+		{"unknown type gives no discount", models.Coupon{DiscountType: "other", DiscountValue: 50}, 25000, 0},
+	}
+
+	for _, c := range cases {
+		if actual := c.coupon.Discount(c.amount); actual != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.name, c.expected, actual)
+		}
+	}
+}
+
+// Test when a coupon can be redeemed
+func TestValidateCoupon(t *testing.T) {
+	now := time.Date(2025, 10, 15, 12, 0, 0, 0, time.UTC)
+	from := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 10, 15, 0, 0, 0, 0, time.UTC)
+	coupon := func() models.Coupon {
+		return models.Coupon{
+			Code:           "INSTA20",
+			DiscountType:   models.CouponPercent,
+			DiscountValue:  20,
+			ProductTypes:   []string{models.CouponProductMembership},
+			MaxRedemptions: 100,
+			PerUserLimit:   1,
+			ValidFrom:      &from,
+			ValidUntil:     &until,
+			Active:         true,
+		}
+	}
+
+	if err := database.ValidateCoupon(coupon(), models.CouponProductMembership, now, 99, 0); err != nil {
+		t.Errorf("expected coupon to be valid on its last day, got %v", err)
+	}
+	if err := database.ValidateCoupon(coupon(), models.CouponProductKlippekort, now, 0, 0); err == nil {
+		t.Errorf("expected coupon to be rejected for klippekort")
+	}
+	if err := database.ValidateCoupon(coupon(), models.CouponProductMembership, now.AddDate(0, 0, 1), 0, 0); err == nil {
+		t.Errorf("expected coupon to be rejected after its validity window")
+	}
+	if err := database.ValidateCoupon(coupon(), models.CouponProductMembership, from.AddDate(0, 0, -1), 0, 0); err == nil {
+		t.Errorf("expected coupon to be rejected before its validity window")
+	}
+	if err := database.ValidateCoupon(coupon(), models.CouponProductMembership, now, 100, 0); err == nil {
+		t.Errorf("expected coupon to be rejected once all redemptions are used")
+	}
+	if err := database.ValidateCoupon(coupon(), models.CouponProductMembership, now, 10, 1); err == nil {
+		t.Errorf("expected coupon to be rejected when the member has used it")
+	}
+
+	inactive := coupon()
+	inactive.Active = false
+	if err := database.ValidateCoupon(inactive, models.CouponProductMembership, now, 0, 0); err == nil {
+		t.Errorf("expected inactive coupon to be rejected")
+	}
+
+	unlimited := coupon()
+	unlimited.MaxRedemptions, unlimited.PerUserLimit = 0, 0
+	unlimited.ValidFrom, unlimited.ValidUntil = nil, nil
+	if err := database.ValidateCoupon(unlimited, models.CouponProductMembership, now.AddDate(5, 0, 0), 1000, 10); err != nil {
+		t.Errorf("expected unlimited coupon to be valid, got %v", err)
+	}
+}
+
+// Test that a renewal discount only counts towards the coupon once the renewal is billed
+func TestCouponRenewalBilledDiscount(t *testing.T) {
+	db := openTestDB(t)
+	userID, membershipID := insertOverrideMember(t, db)
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	couponID, err := db.SaveCoupon(models.Coupon{Code: "TREMND", DiscountType: models.CouponPercent, DiscountValue: 50, DurationMonths: 3,
+		ProductTypes: []string{models.CouponProductMembership}, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutMembership(userID, membershipID, 0, "TREMND"); err != nil {
+		t.Fatal(err)
+	}
+	redemption := func() models.CouponRedemption {
+		redemptions, err := db.GetCouponRedemptions(couponID)
+		if err != nil || len(redemptions) != 1 {
+			t.Fatalf("expected one redemption, got %+v (%v)", redemptions, err)
+		}
+		return redemptions[0]
+	}
+	first := redemption()
+
+	// Without a payment method the renewal is not billed and the coupon is not used
+	now := time.Now()
+	if _, err := db.Conn.Exec("UPDATE user_memberships SET renewal_date = ? WHERE user_id = ?", now.Format("2006-01-02"), userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Conn.Exec("DELETE FROM payment_methods WHERE user_id = ?", userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RunMembershipRenewals(now); err != nil {
+		t.Fatal(err)
+	}
+	if unbilled := redemption(); unbilled.PaymentsDiscounted != first.PaymentsDiscounted || unbilled.DiscountAmount != first.DiscountAmount {
+		t.Errorf("expected a renewal that was not billed not to count, got %+v", unbilled)
+	}
+
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RunMembershipRenewals(now); err != nil {
+		t.Fatal(err)
+	}
+	if billed := redemption(); billed.PaymentsDiscounted != first.PaymentsDiscounted+1 || billed.DiscountAmount != first.DiscountAmount+34950 {
+		t.Errorf("expected the billed renewal to count, got %+v", billed)
+	}
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"testing"
+	"time"
+)
+
+// Test that senior eligibility is derived from the birthdate
+func TestIsSeniorEligible(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		birthdate string
+		expected  bool
+	}{
+		{"1958-06-15", true},  // 67 today
+		{"1958-06-16", false}, // 67 tomorrow
+		{"1940-01-01", true},
+		{"1995-03-20", false},
+		{"", false},
+		{"not a date", false},
+	}
+
+	for _, c := range cases {
+		if actual := database.IsSeniorEligible(c.birthdate, now); actual != c.expected {
+			t.Errorf("birthdate %q: expected %v, got %v", c.birthdate, c.expected, actual)
+		}
+	}
+}
+
+// Test that a student without a valid verification moves to the regular plan at renewal, also
+// when they have scheduled a change to another discounted plan
+func TestLapsedVerificationScheduledChange(t *testing.T) {
+	db := openTestDB(t)
+	userID, regularID := insertOverrideMember(t, db)
+
+	var studentIDs []int64
+	for _, name := range []string{"Student 12-måneder", "Student fleks"} {
+		result, err := db.Conn.Exec(`INSERT INTO memberships (name, price, commitment_months, is_student_senior, regular_membership_id, description, active)
+			VALUES (?, 49900, 0, TRUE, ?, '', TRUE)`, name, regularID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		studentID, _ := result.LastInsertId()
+		studentIDs = append(studentIDs, studentID)
+	}
+	now := time.Now()
+	if _, err := db.Conn.Exec(`INSERT INTO user_memberships (user_id, membership_id, status, start_date, renewal_date, scheduled_membership_id)
+		VALUES (?, ?, 'active', ?, ?, ?)`, userID, studentIDs[0], now.AddDate(0, -1, 0), now.AddDate(0, 0, 10), studentIDs[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ExpireDiscountVerifications(now); err != nil {
+		t.Fatal(err)
+	}
+	scheduled, err := db.GetScheduledMembershipChange(userID)
+	if err != nil || scheduled == nil || int64(scheduled.ID) != regularID {
+		t.Errorf("expected the change to move to the regular plan, got %+v (%v)", scheduled, err)
+	}
+}
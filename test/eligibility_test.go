@@ -0,0 +1,37 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"reflect"
+	"testing"
+)
+
+// Test that only the required roles a user lacks are reported, in a stable order
+func TestEventMissingRequirements(t *testing.T) {
+	event := models.Event{
+		RoleRequirements: map[string]struct{}{
+			"reformer": {},
+			"gravid":   {},
+		},
+	}
+
+	cases := []struct {
+		roles    []string
+		expected []string
+	}{
+		{roles: nil, expected: []string{"gravid", "reformer"}},
+		{roles: []string{"user", "reformer"}, expected: []string{"gravid"}},
+		{roles: []string{"gravid", "reformer"}, expected: nil},
+	}
+
+	for _, c := range cases {
+		missing := event.MissingRequirements(c.roles)
+		if !reflect.DeepEqual(missing, c.expected) {
+			t.Errorf("roles %v: expected %v, got %v", c.roles, c.expected, missing)
+		}
+	}
+
+	if missing := (models.Event{}).MissingRequirements(nil); len(missing) != 0 {
+		t.Errorf("event without requirements should be open to everyone, got %v", missing)
+	}
+}
@@ -0,0 +1,60 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"testing"
+)
+
+// Test which classes a plan can book
+func TestEntitlementsAllowClasses(t *testing.T) {
+	open := models.MembershipEntitlements{}
+	if !open.AllowsClassType("yoga") || !open.AllowsLocation("Studio 2") {
+		t.Errorf("expected a plan without limits to book every class")
+	}
+
+	limited := models.MembershipEntitlements{ClassTypes: []string{"yoga", "pilates"}, Locations: []string{"Hovedstudio"}}
+	if !limited.AllowsClassType("Pilates") {
+		t.Errorf("expected class types to match regardless of case")
+	}
+	if limited.AllowsClassType("strength") {
+		t.Errorf("expected strength classes to be outside the plan")
+	}
+	if !limited.AllowsLocation("hovedstudio") || limited.AllowsLocation("Studio 2") {
+		t.Errorf("expected only Hovedstudio to be included")
+	}
+}
+
+// Test that the bullets shown to members come from the entitlements
+func TestEntitlementBullets(t *testing.T) {
+	entitlements := models.MembershipEntitlements{
+		WeeklyClassLimit:    3,
+		ClassTypes:          []string{"yoga", "pilates"},
+		FreezeAllowed:       true,
+		FreezeDaysPerYear:   60,
+		GuestPassesPerMonth: 2,
+		Perks:               []string{"Rabatt på workshops"},
+	}
+
+	expected := []models.EntitlementBullet{
+		{Key: "weekly_class_limit", Detail: "3"},
+		{Key: "class_types", Detail: "yoga, pilates"},
+		{Key: "all_locations"},
+		{Key: "freeze_days", Detail: "60"},
+		{Key: "guest_passes", Detail: "2"},
+		{Text: "Rabatt på workshops"},
+	}
+	bullets := entitlements.Bullets()
+	if len(bullets) != len(expected) {
+		t.Fatalf("expected %d bullets, got %d: %v", len(expected), len(bullets), bullets)
+	}
+	for i := range expected {
+		if bullets[i] != expected[i] {
+			t.Errorf("bullet %d: expected %v, got %v", i, expected[i], bullets[i])
+		}
+	}
+
+	unlimited := models.MembershipEntitlements{OnlineAccess: true, FreezeAllowed: true}.Bullets()
+	if unlimited[0].Key != "unlimited_classes" || unlimited[2].Key != "online_access" || unlimited[3].Key != "freeze" {
+		t.Errorf("unexpected bullets for an unlimited plan: %v", unlimited)
+	}
+}
@@ -0,0 +1,44 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"testing"
+)
+
+// Test the reduced price linked household members pay
+func TestHouseholdDiscount(t *testing.T) {
+	cases := []struct {
+		name     string
+		price    int
+		percent  int
+		expected int
+	}{
+		{"no discount", 104000, 0, 104000},
+		{"25 percent", 104000, 25, 78000},
+		{"rounds the discount to nearest øre", 999, 15, 849},
+		{"free", 52500, 100, 0},
+		{"never below zero", 52500, 150, 0},
+		{"negative percent is ignored", 52500, -10, 52500},
+	}
+
+	for _, c := range cases {
+		if actual := models.HouseholdDiscount(c.price, c.percent); actual != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.name, c.expected, actual)
+		}
+	}
+}
+
+// Test that only the payer is treated as paying for the household
+func TestHouseholdPayer(t *testing.T) {
+	household := models.Household{
+		PayerUserID: 1,
+		Members:     []models.HouseholdMember{{UserID: 2}, {UserID: 3}},
+	}
+
+	if !household.IsPayer(1) {
+		t.Errorf("expected user 1 to pay for the household")
+	}
+	if household.IsPayer(2) {
+		t.Errorf("expected linked member not to be the payer")
+	}
+}
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// insertKlippekortCustomer creates a member without membership and a klippekort package
+func insertKlippekortCustomer(t *testing.T, db *database.Database) (int64, int64) {
+	result, err := db.Conn.Exec(`INSERT INTO users (name, birthdate, email, phone, password)
+		VALUES ('Klipp Kunde', '1990-01-01', 'utlop@example.com', '99445566', 'x')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _ := result.LastInsertId()
+
+	result, err = db.Conn.Exec(`INSERT INTO klippekort_packages (name, category, klipp_count, price, price_per_session, description, valid_days, active)
+		VALUES ('5 klipp', 'Gruppetimer Sal', 5, 110000, 22000, '', 90, TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packageID, _ := result.LastInsertId()
+	return userID, packageID
+}
+
+// expireCard moves a card's expiry date into the past and runs the expiry job
+func expireCard(t *testing.T, db *database.Database, userID int64, now time.Time) int64 {
+	cards, err := db.GetAllUserKlippekort(userID)
+	if err != nil || len(cards) == 0 {
+		t.Fatalf("expected a klippekort, got %v", err)
+	}
+	cardID := int64(cards[0].UserKlippekort.ID)
+	if _, err := db.Conn.Exec("UPDATE user_klippekort SET expiry_date = ? WHERE id = ?", now.Add(-time.Hour), cardID); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := db.ExpireKlippekort(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 1 {
+		t.Fatalf("expected one card to expire, got %d", expired)
+	}
+	return cardID
+}
+
+// Test reminders before expiry, the expiry itself and rollover into the next card
+func TestKlippekortExpiryAndRollover(t *testing.T) {
+	db := openTestDB(t)
+	userID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// Both the 30 and 7 day thresholds have passed, which gives a single reminder
+	sent, err := db.SendKlippekortReminders(now.AddDate(0, 0, 85))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 {
+		t.Errorf("expected one reminder, got %d", sent)
+	}
+	if sent, _ := db.SendKlippekortReminders(now.AddDate(0, 0, 86)); sent != 0 {
+		t.Errorf("expected no reminder to be sent twice, got %d", sent)
+	}
+
+	err = db.SaveKlippekortRules(&models.KlippekortRules{ReminderDays: []int{7}, RolloverEnabled: true, RolloverDays: 30, RolloverMaxKlipp: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expireCard(t, db, userID, now)
+
+	if cards, _ := db.GetUserKlippekort(userID); len(cards) != 0 {
+		t.Errorf("expected the expired card to be inactive, got %+v", cards)
+	}
+	history, err := db.GetKlippHistory(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) == 0 || history[0].Kind != models.KlippExpired || history[0].Klipp != -5 || history[0].Balance != 0 {
+		t.Errorf("expected the unused klipp to be written off, got %+v", history)
+	}
+
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	cards, err := db.GetUserKlippekort(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].RemainingKlipp != 8 || cards[0].TotalKlipp != 8 {
+		t.Fatalf("expected 3 klipp rolled over to the new card, got %+v", cards)
+	}
+
+	// Without a limit only the second card's klipp move, the first card's were already rolled over
+	err = db.SaveKlippekortRules(&models.KlippekortRules{ReminderDays: []int{7}, RolloverEnabled: true, RolloverDays: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expireCard(t, db, userID, time.Now())
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = db.GetUserKlippekort(userID)
+	if len(cards) != 1 || cards[0].RemainingKlipp != 13 {
+		t.Errorf("expected the second expired card to roll over 8 klipp, got %+v", cards)
+	}
+}
+
+// Test that an admin can extend an expired card and give back the klipp it lost
+func TestAdminExtendKlippekort(t *testing.T) {
+	db := openTestDB(t)
+	userID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cardID := expireCard(t, db, userID, now)
+
+	if err := db.AdminExtendKlippekort(userID, cardID, now.AddDate(0, 0, -1), "Skade", now); err == nil {
+		t.Errorf("expected an expiry date in the past to be rejected")
+	}
+	if err := db.AdminExtendKlippekort(userID, cardID, now.AddDate(0, 1, 0), "Skade i kneet", now); err != nil {
+		t.Fatal(err)
+	}
+
+	cards, err := db.GetUserKlippekort(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].RemainingKlipp != 5 {
+		t.Fatalf("expected the card to be active again with 5 klipp, got %+v", cards)
+	}
+
+	actions, _ := db.GetAdminActions(userID)
+	if len(actions) != 1 || actions[0].Action != models.AdminActionExtendKlippekort {
+		t.Errorf("expected the extension to be logged, got %+v", actions)
+	}
+}
@@ -0,0 +1,213 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that every klipp movement is in the ledger and the balance follows it
+func TestKlippekortLedger(t *testing.T) {
+	db := openTestDB(t)
+
+	result, err := db.Conn.Exec(`INSERT INTO users (name, birthdate, email, phone, password)
+		VALUES ('Klipp Kunde', '1990-01-01', 'klipp@example.com', '99112233', 'x')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _ := result.LastInsertId()
+
+	result, err = db.Conn.Exec(`INSERT INTO klippekort_packages (name, category, klipp_count, price, price_per_session, description, valid_days, active)
+		VALUES ('5 klipp', 'Gruppetimer Sal', 5, 110000, 22000, '', 90, TRUE), ('1 klipp', 'Gruppetimer Sal', 1, 25000, 25000, '', 30, TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topUpID, _ := result.LastInsertId()
+	packageID := topUpID - 1
+
+	start := time.Now().Add(48 * time.Hour)
+	result, err = db.Conn.Exec("INSERT INTO events (title, start_time, end_time, capacity, class_type) VALUES ('Pilates', ?, ?, 10, 'pilates')",
+		start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventID, _ := result.LastInsertId()
+
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatalf("could not buy klippekort: %v", err)
+	}
+	if err := db.CheckoutKlippekort(userID, topUpID, ""); err != nil {
+		t.Fatalf("could not top up klippekort: %v", err)
+	}
+	if err := db.SignupUserForEvent(userID, eventID); err != nil {
+		t.Fatalf("could not sign up: %v", err)
+	}
+
+	cards, err := db.GetUserKlippekort(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].RemainingKlipp != 5 || cards[0].UserKlippekort.PackageID != int(packageID) {
+		t.Fatalf("expected one card from the first package with 5 klipp left, got %+v", cards)
+	}
+
+	if err := db.CancelUserSignupForEvent(userID, eventID); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := db.GetKlippHistory(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		kind    string
+		klipp   int
+		balance int
+	}{
+		{models.KlippRefund, 1, 6},
+		{models.KlippSignup, -1, 5},
+		{models.KlippTopUp, 1, 6},
+		{models.KlippPurchase, 5, 5},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d movements, got %d", len(expected), len(history))
+	}
+	for i, e := range expected {
+		m := history[i]
+		if m.Kind != e.kind || m.Klipp != e.klipp || m.Balance != e.balance {
+			t.Errorf("movement %d: expected %s %d (balance %d), got %s %d (balance %d)", i, e.kind, e.klipp, e.balance, m.Kind, m.Klipp, m.Balance)
+		}
+	}
+	if history[1].EventTitle == nil || *history[1].EventTitle != "Pilates" {
+		t.Errorf("expected the signup to name the class")
+	}
+	if history[2].PackageName != "1 klipp" {
+		t.Errorf("expected the top-up to name its own package, got %s", history[2].PackageName)
+	}
+
+	if err := db.SetKlippekortBalance(int64(cards[0].UserKlippekort.ID), -1, "", time.Now()); err == nil {
+		t.Errorf("expected a negative balance to be rejected")
+	}
+}
+
+// Test that a signup only takes a klipp from a card of the class's category, and that members
+// without a membership or klipp for it are refused
+func TestKlippForSignupCategory(t *testing.T) {
+	db := openTestDB(t)
+	userID, _ := insertKlippekortCustomer(t, db)
+
+	result, err := db.Conn.Exec(`INSERT INTO klippekort_packages (name, category, klipp_count, price, price_per_session, description, valid_days, active)
+		VALUES ('5 reformer', 'Reformer/Apparatus', 5, 200000, 40000, '', 90, TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reformerPackageID, _ := result.LastInsertId()
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutKlippekort(userID, reformerPackageID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(48 * time.Hour)
+	yogaID, err := db.CreateEvent(models.Event{Title: "Yoga", StartTime: start, EndTime: start.Add(time.Hour), ClassType: "yoga", Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reformerID, err := db.CreateEvent(models.Event{Title: "Reformer", StartTime: start, EndTime: start.Add(time.Hour), ClassType: "pilates",
+		Capacity: 10, KlippekortCategory: "Reformer/Apparatus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SignupUserForEvent(userID, yogaID); err == nil {
+		t.Errorf("expected a group class not to be paid with a reformer klipp")
+	}
+	var signups int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM event_signups WHERE event_id = ?", yogaID).Scan(&signups)
+	if signups != 0 {
+		t.Errorf("expected the refused signup not to be saved, got %d", signups)
+	}
+
+	if err := db.SignupUserForEvent(userID, reformerID); err != nil {
+		t.Fatal(err)
+	}
+	cards, err := db.GetUserKlippekort(userID)
+	if err != nil || len(cards) != 1 || cards[0].RemainingKlipp != 4 {
+		t.Errorf("expected the reformer class to use a reformer klipp, got %+v (%v)", cards, err)
+	}
+}
+
+// Test that members pay with klipp for classes their plan does not include, and that a frozen
+// membership does not book classes
+func TestKlippForSignupOutsidePlan(t *testing.T) {
+	db := openTestDB(t)
+	userID, _ := insertKlippekortCustomer(t, db)
+	result, err := db.Conn.Exec(`INSERT INTO memberships (name, price, commitment_months, description, entitlements, active)
+		VALUES ('Yoga 1 i uka', 49900, 0, '', '{"class_types": ["yoga"], "weekly_class_limit": 1}', TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	membershipID, _ := result.LastInsertId()
+	result, err = db.Conn.Exec(`INSERT INTO klippekort_packages (name, category, klipp_count, price, price_per_session, description, valid_days, active)
+		VALUES ('5 reformer', 'Reformer/Apparatus', 5, 200000, 40000, '', 90, TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reformerPackageID, _ := result.LastInsertId()
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutMembership(userID, membershipID, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutKlippekort(userID, reformerPackageID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(48 * time.Hour)
+	createClass := func(title, classType, category string) int64 {
+		eventID, err := db.CreateEvent(models.Event{Title: title, StartTime: start, EndTime: start.Add(time.Hour), ClassType: classType,
+			Capacity: 10, KlippekortCategory: category})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return eventID
+	}
+
+	// The plan pays for the first yoga class of the week, the reformer class is outside the plan
+	if err := db.SignupUserForEvent(userID, createClass("Yoga", "yoga", "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SignupUserForEvent(userID, createClass("Reformer", "pilates", "Reformer/Apparatus")); err != nil {
+		t.Fatalf("expected the reformer klippekort to pay for a class outside the plan: %v", err)
+	}
+	cards, _ := db.GetUserKlippekort(userID)
+	if len(cards) != 1 || cards[0].RemainingKlipp != 4 {
+		t.Errorf("expected one reformer klipp to be used, got %+v", cards)
+	}
+
+	// Past the weekly limit, and without a group klippekort, the plan's reason is given
+	if err := db.SignupUserForEvent(userID, createClass("Yoga 2", "yoga", "")); err == nil || !strings.Contains(err.Error(), "weekly limit") {
+		t.Errorf("expected the weekly limit to stop a second yoga class, got %v", err)
+	}
+	// A reformer class paid with klipp does not use up the week
+	if err := db.SignupUserForEvent(userID, createClass("Reformer 2", "pilates", "Reformer/Apparatus")); err != nil {
+		t.Errorf("expected a second reformer class on klipp: %v", err)
+	}
+
+	// A frozen membership books nothing, only klipp can pay
+	if _, err := db.Conn.Exec("UPDATE user_memberships SET status = 'paused' WHERE user_id = ?", userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Conn.Exec("DELETE FROM event_signups WHERE user_id = ? AND klippekort_id IS NULL", userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SignupUserForEvent(userID, createClass("Yoga 3", "yoga", "")); err == nil {
+		t.Errorf("expected a frozen membership not to book classes")
+	}
+	if err := db.SignupUserForEvent(userID, createClass("Reformer 3", "pilates", "Reformer/Apparatus")); err != nil {
+		t.Errorf("expected klipp to pay while the membership is frozen: %v", err)
+	}
+}
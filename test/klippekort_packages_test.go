@@ -0,0 +1,106 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"testing"
+)
+
+// Test creating, ordering and retiring klippekort packages
+func TestKlippekortPackageAdmin(t *testing.T) {
+	db := openTestDB(t)
+
+	ten, err := db.SaveKlippekortPackage(models.KlippekortPackage{Name: "10 klipp", Category: "Reformer", KlippCount: 10, Price: 250000, ValidDays: 365, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	five, err := db.SaveKlippekortPackage(models.KlippekortPackage{Name: "5 klipp", Category: "Reformer", KlippCount: 5, Price: 140000, ValidDays: 180, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SaveKlippekortPackage(models.KlippekortPackage{Name: "Tom", Category: "Reformer", ValidDays: 30}); err == nil {
+		t.Errorf("expected a package without klipp to be rejected")
+	}
+
+	packages, err := db.GetAllKlippekortPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 2 || int64(packages[0].ID) != ten || packages[0].PricePerSession != 25000 {
+		t.Fatalf("expected new packages last in their category with a price per session, got %+v", packages)
+	}
+
+	if err := db.ReorderKlippekortPackages("Reformer", []int64{five, ten}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReorderKlippekortPackages("Gruppetimer", []int64{five}); err == nil {
+		t.Errorf("expected reordering a package outside its category to fail")
+	}
+	packages, _ = db.GetAllKlippekortPackages()
+	if int64(packages[0].ID) != five {
+		t.Errorf("expected the 5 klipp package first after reordering, got %+v", packages)
+	}
+
+	if err := db.RetireKlippekortPackage(five); err != nil {
+		t.Fatal(err)
+	}
+	packages, _ = db.GetAllKlippekortPackages()
+	if len(packages) != 1 {
+		t.Errorf("expected the retired package to be off sale, got %+v", packages)
+	}
+	all, _ := db.GetKlippekortPackagesForAdmin()
+	if len(all) != 2 {
+		t.Errorf("expected admins to still see the retired package, got %d", len(all))
+	}
+}
+
+// Test the per-category top-up policy when buying klippekort
+func TestKlippekortTopUpPolicy(t *testing.T) {
+	db := openTestDB(t)
+	userID, packageID := insertKlippekortCustomer(t, db)
+
+	policy, err := db.GetKlippekortCategoryPolicy("Gruppetimer Sal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.MaxKlipp != 20 || policy.TopUpMode != models.KlippekortTopUpMerge {
+		t.Errorf("expected the default policy to merge up to 20 klipp, got %+v", policy)
+	}
+
+	err = db.SaveKlippekortCategoryPolicy(models.KlippekortCategoryPolicy{Category: "Gruppetimer Sal", MaxKlipp: 10,
+		TopUpMode: models.KlippekortTopUpMerge, ExpiryMode: models.KlippekortExpiryExtend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ := db.GetUserKlippekort(userID)
+	firstExpiry := cards[0].ExpiryDate
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = db.GetUserKlippekort(userID)
+	if len(cards) != 1 || cards[0].RemainingKlipp != 10 || !cards[0].ExpiryDate.Equal(firstExpiry.AddDate(0, 0, 90)) {
+		t.Fatalf("expected the top-up to merge and extend the card by 90 days, got %+v", cards)
+	}
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err == nil {
+		t.Errorf("expected a top-up past 10 klipp to be rejected")
+	}
+
+	err = db.SaveKlippekortCategoryPolicy(models.KlippekortCategoryPolicy{Category: "Gruppetimer Sal", MaxKlipp: 10,
+		TopUpMode: models.KlippekortTopUpNewCard, ExpiryMode: models.KlippekortExpiryKeep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = db.GetUserKlippekort(userID)
+	if len(cards) != 2 {
+		t.Errorf("expected the purchase to become a card of its own, got %+v", cards)
+	}
+
+	if err := db.SaveKlippekortCategoryPolicy(models.KlippekortCategoryPolicy{Category: "Gruppetimer Sal", TopUpMode: "split"}); err == nil {
+		t.Errorf("expected an unknown top-up mode to be rejected")
+	}
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test that a shared klippekort is used by a member without klipp and that the owner can revoke it
+func TestShareKlippekort(t *testing.T) {
+	db := openTestDB(t)
+	ownerID, packageID := insertKlippekortCustomer(t, db)
+
+	result, err := db.Conn.Exec(`INSERT INTO users (name, birthdate, email, phone, password)
+		VALUES ('Partner', '1991-01-01', 'partner@example.com', '99778899', 'x')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partnerID, _ := result.LastInsertId()
+
+	start := time.Now().Add(48 * time.Hour)
+	result, err = db.Conn.Exec(`INSERT INTO events (title, start_time, end_time, capacity, class_type)
+		VALUES ('Yoga', ?, ?, 10, 'yoga'), ('Pilates', ?, ?, 10, 'pilates')`, start, start.Add(time.Hour), start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pilatesID, _ := result.LastInsertId()
+	yogaID := pilatesID - 1
+
+	if err := db.CheckoutKlippekort(ownerID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ := db.GetUserKlippekort(ownerID)
+	cardID := int64(cards[0].UserKlippekort.ID)
+
+	if err := db.ShareKlippekort(partnerID, cardID, "utlop@example.com", time.Now()); err == nil {
+		t.Errorf("expected only the owner to be able to share the card")
+	}
+	if err := db.ShareKlippekort(ownerID, cardID, "utlop@example.com", time.Now()); err == nil {
+		t.Errorf("expected sharing with yourself to be rejected")
+	}
+	if err := db.ShareKlippekort(ownerID, cardID, "PARTNER@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if shared, _ := db.GetSharedKlippekort(partnerID); len(shared) != 1 || shared[0].OwnerName != "Klipp Kunde" {
+		t.Fatalf("expected the card to be shared with the partner, got %+v", shared)
+	}
+
+	if err := db.SignupUserForEvent(partnerID, yogaID); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = db.GetUserKlippekort(ownerID)
+	if cards[0].RemainingKlipp != 4 {
+		t.Errorf("expected the partner's class to use a klipp from the shared card, got %d left", cards[0].RemainingKlipp)
+	}
+	history, err := db.GetKlippHistory(partnerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].UsedByName == nil || *history[0].UsedByName != "Partner" {
+		t.Errorf("expected the partner's use to be in their history with their name, got %+v", history)
+	}
+	if history, _ := db.GetKlippHistory(ownerID); len(history) != 2 {
+		t.Errorf("expected the owner to see the purchase and the partner's use, got %+v", history)
+	}
+
+	if err := db.CancelUserSignupForEvent(partnerID, yogaID); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = db.GetUserKlippekort(ownerID)
+	if cards[0].RemainingKlipp != 5 {
+		t.Errorf("expected the cancelled class to give the klipp back to the shared card, got %d", cards[0].RemainingKlipp)
+	}
+
+	shares, err := db.GetKlippekortShares(ownerID)
+	if err != nil || len(shares) != 1 {
+		t.Fatalf("expected one share, got %+v (%v)", shares, err)
+	}
+	if err := db.RevokeKlippekortShare(partnerID, int64(shares[0].ID), time.Now()); err == nil {
+		t.Errorf("expected only the owner to be able to revoke the share")
+	}
+	if err := db.RevokeKlippekortShare(ownerID, int64(shares[0].ID), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SignupUserForEvent(partnerID, pilatesID); err == nil {
+		t.Errorf("expected a member without klipp after the share was revoked to be refused")
+	}
+	cards, _ = db.GetUserKlippekort(ownerID)
+	if cards[0].RemainingKlipp != 5 {
+		t.Errorf("expected a revoked share not to be used, got %d left", cards[0].RemainingKlipp)
+	}
+}
+
+// Test that a member with klipp of their own in another category still uses a shared card for
+// a class in the shared card's category, and never a shared card of another category
+func TestSharedKlippekortCategory(t *testing.T) {
+	db := openTestDB(t)
+	ownerID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CheckoutKlippekort(ownerID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ := db.GetUserKlippekort(ownerID)
+	cardID := int64(cards[0].UserKlippekort.ID)
+
+	result, err := db.Conn.Exec(`INSERT INTO users (name, birthdate, email, phone, password)
+		VALUES ('Partner', '1991-01-01', 'partner@example.com', '99778899', 'x')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partnerID, _ := result.LastInsertId()
+	result, err = db.Conn.Exec(`INSERT INTO klippekort_packages (name, category, klipp_count, price, price_per_session, description, valid_days, active)
+		VALUES ('5 reformer', 'Reformer/Apparatus', 5, 200000, 40000, '', 90, TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reformerPackageID, _ := result.LastInsertId()
+	if err := db.CheckoutKlippekort(partnerID, reformerPackageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ShareKlippekort(ownerID, cardID, "partner@example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(48 * time.Hour)
+	yogaID, err := db.CreateEvent(models.Event{Title: "Yoga", StartTime: start, EndTime: start.Add(time.Hour), ClassType: "yoga", Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SignupUserForEvent(partnerID, yogaID); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = db.GetUserKlippekort(ownerID)
+	if cards[0].RemainingKlipp != 4 {
+		t.Errorf("expected the group class to use the shared group card, got %d left", cards[0].RemainingKlipp)
+	}
+	partnerCards, _ := db.GetUserKlippekort(partnerID)
+	if len(partnerCards) != 1 || partnerCards[0].RemainingKlipp != 5 {
+		t.Errorf("expected the partner's reformer card to be left alone, got %+v", partnerCards)
+	}
+
+	// The owner's group card is no use for a reformer class when the partner has no reformer klipp
+	if err := db.SetKlippekortBalance(int64(partnerCards[0].UserKlippekort.ID), 0, "", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	reformerID, err := db.CreateEvent(models.Event{Title: "Reformer", StartTime: start, EndTime: start.Add(time.Hour), ClassType: "pilates",
+		Capacity: 10, KlippekortCategory: "Reformer/Apparatus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SignupUserForEvent(partnerID, reformerID); err == nil {
+		t.Errorf("expected a shared group card not to pay for a reformer class")
+	}
+}
@@ -0,0 +1,206 @@
+package test
+
+import (
+	"fmt"
+	"kjernekraft/database"
+	"kjernekraft/handlers"
+	"kjernekraft/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// insertStreamMembers creates members on a plan that includes online classes
+func insertStreamMembers(t *testing.T, db *database.Database, count int) []int64 {
+	result, err := db.Conn.Exec(`INSERT INTO memberships (name, price, commitment_months, description, entitlements, active)
+		VALUES ('Online Fleks', 59900, 0, '', '{"online_access": true}', TRUE)`)
+	if err != nil {
+		t.Fatalf("could not create membership: %v", err)
+	}
+	membershipID, _ := result.LastInsertId()
+
+	var userIDs []int64
+	for i := 0; i < count; i++ {
+		result, err := db.Conn.Exec(`INSERT INTO users (name, birthdate, email, phone, password) VALUES (?, '1990-01-01', ?, ?, 'x')`,
+			fmt.Sprintf("Strøm %d", i), fmt.Sprintf("strom%d@example.com", i), fmt.Sprintf("9900000%d", i))
+		if err != nil {
+			t.Fatalf("could not create user: %v", err)
+		}
+		userID, _ := result.LastInsertId()
+		if err := db.CheckoutMembership(userID, membershipID, 0, ""); err != nil {
+			t.Fatal(err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+// createStreamEvent creates a class starting at start with the given delivery mode and capacities
+func createStreamEvent(t *testing.T, db *database.Database, start time.Time, deliveryMode string, capacity, onlineCapacity int) int64 {
+	eventID, err := db.CreateEvent(models.Event{Title: "Strømmet yoga", StartTime: start, EndTime: start.Add(time.Hour), ClassType: "yoga",
+		Capacity: capacity, DeliveryMode: deliveryMode, OnlineCapacity: onlineCapacity, StreamURL: "https://stream.example.com/yoga"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eventID
+}
+
+// Test which attendance modes each delivery mode accepts, and what an empty mode picks
+func TestAttendanceModes(t *testing.T) {
+	db := openTestDB(t)
+	start := time.Now().Add(48 * time.Hour)
+
+	cases := []struct {
+		deliveryMode   string
+		attendanceMode string
+		expected       string // Empty when the signup is rejected
+	}{
+		{models.DeliveryInPerson, "", models.DeliveryInPerson},
+		{models.DeliveryInPerson, models.DeliveryInPerson, models.DeliveryInPerson},
+		{models.DeliveryInPerson, models.DeliveryOnline, ""},
+		{models.DeliveryOnline, "", models.DeliveryOnline},
+		{models.DeliveryOnline, models.DeliveryOnline, models.DeliveryOnline},
+		{models.DeliveryOnline, models.DeliveryInPerson, ""},
+		{models.DeliveryHybrid, "", models.DeliveryInPerson},
+		{models.DeliveryHybrid, models.DeliveryInPerson, models.DeliveryInPerson},
+		{models.DeliveryHybrid, models.DeliveryOnline, models.DeliveryOnline},
+		{models.DeliveryHybrid, "sofa", ""},
+	}
+
+	userIDs := insertStreamMembers(t, db, len(cases))
+	for i, c := range cases {
+		eventID := createStreamEvent(t, db, start, c.deliveryMode, 10, 0)
+		err := db.SignupUserForEventWithMode(userIDs[i], eventID, c.attendanceMode)
+		if c.expected == "" {
+			if err == nil {
+				t.Errorf("%s class, %q: expected the signup to be rejected", c.deliveryMode, c.attendanceMode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s class, %q: %v", c.deliveryMode, c.attendanceMode, err)
+			continue
+		}
+		if mode, err := db.GetUserAttendanceMode(userIDs[i], eventID); err != nil || mode != c.expected {
+			t.Errorf("%s class, %q: expected to attend %s, got %q (%v)", c.deliveryMode, c.attendanceMode, c.expected, mode, err)
+		}
+	}
+}
+
+// Test that the studio and the stream fill up separately, and that cancelling frees the right place
+func TestHybridCapacity(t *testing.T) {
+	db := openTestDB(t)
+	userIDs := insertStreamMembers(t, db, 4)
+	eventID := createStreamEvent(t, db, time.Now().Add(48*time.Hour), models.DeliveryHybrid, 1, 2)
+
+	if err := db.SignupUserForEventWithMode(userIDs[0], eventID, models.DeliveryInPerson); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SignupUserForEventWithMode(userIDs[1], eventID, models.DeliveryInPerson); err == nil {
+		t.Errorf("expected the studio to be full")
+	}
+	for _, userID := range userIDs[1:3] {
+		if err := db.SignupUserForEventWithMode(userID, eventID, models.DeliveryOnline); err != nil {
+			t.Fatalf("expected a place online: %v", err)
+		}
+	}
+	if err := db.SignupUserForEventWithMode(userIDs[3], eventID, models.DeliveryOnline); err == nil {
+		t.Errorf("expected the stream to be full")
+	}
+
+	event, err := db.GetEventByID(eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.CurrentEnrolment != 1 || event.OnlineEnrolment != 2 || event.OnlineSpotsLeft() != 0 {
+		t.Errorf("expected 1 in the studio and 2 online, got %d and %d", event.CurrentEnrolment, event.OnlineEnrolment)
+	}
+
+	// An online cancellation frees an online place and leaves the studio full
+	if err := db.CancelUserSignupForEvent(userIDs[1], eventID); err != nil {
+		t.Fatal(err)
+	}
+	event, _ = db.GetEventByID(eventID)
+	if event.CurrentEnrolment != 1 || event.OnlineEnrolment != 1 {
+		t.Errorf("expected 1 in the studio and 1 online after cancelling, got %d and %d", event.CurrentEnrolment, event.OnlineEnrolment)
+	}
+	if err := db.SignupUserForEventWithMode(userIDs[3], eventID, models.DeliveryInPerson); err == nil {
+		t.Errorf("expected the studio to still be full")
+	}
+	if err := db.SignupUserForEventWithMode(userIDs[3], eventID, models.DeliveryOnline); err != nil {
+		t.Errorf("expected the freed online place: %v", err)
+	}
+}
+
+// Test that an online capacity of 0 lets everyone join the stream
+func TestUnlimitedOnlineCapacity(t *testing.T) {
+	db := openTestDB(t)
+	userIDs := insertStreamMembers(t, db, 5)
+	eventID := createStreamEvent(t, db, time.Now().Add(48*time.Hour), models.DeliveryOnline, 0, 0)
+
+	for _, userID := range userIDs {
+		if err := db.SignupUserForEventWithMode(userID, eventID, ""); err != nil {
+			t.Fatalf("expected no limit online: %v", err)
+		}
+	}
+	event, err := db.GetEventByID(eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.OnlineEnrolment != 5 || event.CurrentEnrolment != 0 || event.OnlineSpotsLeft() != -1 {
+		t.Errorf("expected 5 online and none in the studio, got %d and %d", event.OnlineEnrolment, event.CurrentEnrolment)
+	}
+}
+
+// requestStream asks for the stream of a class as a logged in member
+func requestStream(t *testing.T, user models.User, eventID int64) *httptest.ResponseRecorder {
+	login := httptest.NewRecorder()
+	if err := handlers.SetUserInSession(login, httptest.NewRequest(http.MethodGet, "/", nil), &user); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/events/stream?event_id=%d", eventID), nil)
+	for _, cookie := range login.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handlers.EventStreamHandler(rec, req)
+	return rec
+}
+
+// Test that the stream link is only handed out to online members from 15 minutes before start
+func TestEventStreamReveal(t *testing.T) {
+	db := openTestDB(t)
+	handlers.DB = db
+	handlers.InitializeSessionStore()
+	userIDs := insertStreamMembers(t, db, 2)
+	online := models.User{ID: int(userIDs[0]), Email: "strom0@example.com"}
+	studio := models.User{ID: int(userIDs[1]), Email: "strom1@example.com"}
+
+	later := createStreamEvent(t, db, time.Now().Add(20*time.Minute), models.DeliveryHybrid, 10, 0)
+	soon := createStreamEvent(t, db, time.Now().Add(10*time.Minute), models.DeliveryHybrid, 10, 0)
+	for _, eventID := range []int64{later, soon} {
+		if err := db.SignupUserForEventWithMode(userIDs[0], eventID, models.DeliveryOnline); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SignupUserForEventWithMode(userIDs[1], eventID, models.DeliveryInPerson); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if rec := requestStream(t, online, later); rec.Code != http.StatusForbidden || rec.Header().Get("Location") != "" {
+		t.Errorf("expected the stream to stay hidden 20 minutes before start, got %d", rec.Code)
+	}
+	if rec := requestStream(t, studio, soon); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the stream to be hidden from members in the studio, got %d", rec.Code)
+	}
+	rec := requestStream(t, online, soon)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://stream.example.com/yoga" {
+		t.Fatalf("expected to be sent on to the stream 10 minutes before start, got %d", rec.Code)
+	}
+
+	var joined int
+	if err := db.Conn.QueryRow("SELECT COUNT(*) FROM event_signups WHERE event_id = ? AND online_joined_at IS NOT NULL", soon).Scan(&joined); err != nil || joined != 1 {
+		t.Errorf("expected joining to count as online attendance, got %d (%v)", joined, err)
+	}
+}
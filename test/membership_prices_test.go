@@ -0,0 +1,35 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"testing"
+	"time"
+)
+
+// Test that price increases reach existing members only after the notice period
+func TestExistingMembersPriceDate(t *testing.T) {
+	announced := time.Date(2025, 3, 10, 14, 30, 0, 0, time.UTC)
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2025, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name      string
+		effective time.Time
+		current   int
+		newPrice  int
+		expected  time.Time
+	}{
+		{"increase effective immediately waits for notice", date(3, 10), 59900, 64900, date(4, 9)},
+		{"increase effective after notice keeps its date", date(5, 1), 59900, 64900, date(5, 1)},
+		{"decrease applies right away", date(3, 10), 59900, 49900, date(3, 10)},
+		{"unchanged price applies right away", date(3, 15), 59900, 59900, date(3, 15)},
+	}
+
+	for _, c := range cases {
+		actual := database.ExistingMembersPriceDate(c.effective, announced, c.current, c.newPrice)
+		if !actual.Equal(c.expected) {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected.Format("2006-01-02"), actual.Format("2006-01-02"))
+		}
+	}
+}
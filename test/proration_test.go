@@ -0,0 +1,170 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"testing"
+	"time"
+)
+
+// Test that plan changes charge or credit only the rest of the billing period
+func TestProrateMembershipChange(t *testing.T) {
+	periodStart := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	periodEnd := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	date := func(day int) time.Time {
+		return time.Date(2025, 4, day, 18, 45, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name          string
+		current       int
+		newPrice      int
+		changeDate    time.Time
+		amount        int
+		daysRemaining int
+	}{
+		{"upgrade halfway charges half the difference", 59900, 89900, date(16), 15000, 15},
+		{"downgrade halfway credits half the difference", 89900, 59900, date(16), -15000, 15},
+		{"change on first day covers the whole period", 59900, 89900, date(1), 30000, 30},
+		{"same price costs nothing", 59900, 59900, date(10), 0, 21},
+		{"change after the period costs nothing", 59900, 89900, time.Date(2025, 5, 3, 0, 0, 0, 0, time.UTC), 0, 0},
+	}
+
+	for _, c := range cases {
+		amount, daysRemaining, daysInPeriod := database.ProrateMembershipChange(c.current, c.newPrice, periodStart, periodEnd, c.changeDate)
+		if amount != c.amount || daysRemaining != c.daysRemaining || daysInPeriod != 30 {
+			t.Errorf("%s: expected %d øre for %d/30 days, got %d øre for %d/%d days",
+				c.name, c.amount, c.daysRemaining, amount, daysRemaining, daysInPeriod)
+		}
+	}
+}
+
+// Test that a plan change prices the new plan as of the change, not grandfathered from when
+// the member joined their current plan
+func TestQuoteMembershipChangeGrandfathering(t *testing.T) {
+	db := openTestDB(t)
+	userID, membershipID := insertOverrideMember(t, db)
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutMembership(userID, membershipID, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Conn.Exec("UPDATE user_memberships SET start_date = '2020-01-01' WHERE user_id = ?", userID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new plan went up from 599 kr yesterday, members who joined before keep 599 kr for now
+	now := time.Now()
+	result, err := db.Conn.Exec(`INSERT INTO memberships (name, price, commitment_months, description, active)
+		VALUES ('Premium', 89900, 0, '', TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	premiumID, _ := result.LastInsertId()
+	for _, version := range []struct {
+		price                  int
+		effective, forExisting time.Time
+	}{
+		{59900, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{89900, now.AddDate(0, 0, -1), now.AddDate(0, 2, 0)},
+	} {
+		if _, err := db.Conn.Exec("INSERT INTO membership_prices (membership_id, price, effective_date, existing_members_date) VALUES (?, ?, ?, ?)",
+			premiumID, version.price, version.effective.Format("2006-01-02"), version.forExisting.Format("2006-01-02")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	quote, err := db.QuoteMembershipChange(userID, premiumID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.CurrentPrice != 69900 || quote.NewPrice != 89900 || !quote.IsUpgrade {
+		t.Errorf("expected a change from 699 kr to the new 899 kr price, got %+v", quote)
+	}
+
+	// A custom price set by an admin stays with the current plan
+	if _, err := db.Conn.Exec("UPDATE user_memberships SET custom_price = 49900 WHERE user_id = ?", userID); err != nil {
+		t.Fatal(err)
+	}
+	quote, err = db.QuoteMembershipChange(userID, premiumID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.CurrentPrice != 49900 || quote.NewPrice != 89900 || quote.Amount <= 0 {
+		t.Errorf("expected the custom price not to follow the member to the new plan, got %+v", quote)
+	}
+
+	// After the change the member is shown and billed the price they were quoted
+	if err := db.ChangeUserMembership(userID, premiumID); err != nil {
+		t.Fatal(err)
+	}
+	membership, err := db.GetUserMembership(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if membership.Membership.Price != 89900 || membership.CustomPrice != nil {
+		t.Errorf("expected 899 kr without the custom price after the change, got %d (%v)", membership.Membership.Price, membership.CustomPrice)
+	}
+	charges, err := db.GetUserCharges(userID, "medlemskap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := len(charges)
+
+	if _, err := db.Conn.Exec("UPDATE user_memberships SET renewal_date = ? WHERE user_id = ?", now.Format("2006-01-02"), userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RunMembershipRenewals(now); err != nil {
+		t.Fatal(err)
+	}
+	charges, err = db.GetUserCharges(userID, "medlemskap")
+	if err != nil || len(charges) != before+1 {
+		t.Fatalf("expected a renewal charge, got %+v (%v)", charges, err)
+	}
+	for _, charge := range charges {
+		if charge.Amount == 59900 {
+			t.Errorf("expected the renewal not to use the grandfathered price, got %+v", charges)
+		}
+	}
+}
+
+// Test that a plan changed at renewal is billed from the renewal date, not at the price the
+// member would have been grandfathered at on their old plan
+func TestScheduledChangeRenewalPrice(t *testing.T) {
+	db := openTestDB(t)
+	userID, membershipID := insertOverrideMember(t, db)
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutMembership(userID, membershipID, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := db.Conn.Exec("UPDATE user_memberships SET start_date = '2020-01-01', renewal_date = ? WHERE user_id = ?",
+		now.Format("2006-01-02"), userID); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.Conn.Exec(`INSERT INTO memberships (name, price, commitment_months, description, active)
+		VALUES ('Premium', 89900, 0, '', TRUE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	premiumID, _ := result.LastInsertId()
+	if _, err := db.Conn.Exec(`INSERT INTO membership_prices (membership_id, price, effective_date, existing_members_date)
+		VALUES (?, 59900, '2019-01-01', '2019-01-01'), (?, 89900, ?, ?)`, premiumID, premiumID,
+		now.AddDate(0, 0, -1).Format("2006-01-02"), now.AddDate(0, 2, 0).Format("2006-01-02")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.ScheduleMembershipChange(userID, premiumID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RunMembershipRenewals(now); err != nil {
+		t.Fatal(err)
+	}
+	charges, err := db.GetUserCharges(userID, "medlemskap")
+	if err != nil || len(charges) == 0 || charges[0].Amount != 89900 {
+		t.Errorf("expected the renewal onto Premium to cost 899 kr, got %+v (%v)", charges, err)
+	}
+}
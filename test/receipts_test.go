@@ -0,0 +1,108 @@
+package test
+
+import (
+	"bytes"
+	"kjernekraft/handlers/pdf"
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test that paid charges get receipts numbered in sequence with the MVA broken out
+func TestReceipts(t *testing.T) {
+	db := openTestDB(t)
+	userID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+
+	details := models.BusinessDetails{Name: "Kjernekraft AS", OrgNumber: "12345678", VATRegistered: true}
+	if err := db.SaveBusinessDetails(&details); err == nil {
+		t.Errorf("expected an organisation number without 9 digits to be rejected")
+	}
+	details.OrgNumber = "123 456 789"
+	if err := db.SaveBusinessDetails(&details); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveVATRate(models.VATRate{ChargeType: "klippekort", Rate: 13}); err == nil {
+		t.Errorf("expected a rate that is not a Norwegian MVA rate to be rejected")
+	}
+
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SimulateBilling(userID, -5000, "Kreditering", "medlemskap"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	charges, err := db.GetUserCharges(userID, "")
+	if err != nil || len(charges) != 3 {
+		t.Fatalf("expected three charges, got %d (%v)", len(charges), err)
+	}
+	var receipts []int
+	for _, c := range charges {
+		if c.ReceiptNumber != nil {
+			receipts = append(receipts, *c.ReceiptNumber)
+		}
+	}
+	if len(receipts) != 2 || receipts[0] != receipts[1]+1 {
+		t.Fatalf("expected the two purchases to have receipts in sequence and the credit none, got %v", receipts)
+	}
+
+	receipt, err := db.GetReceipt(userID, int64(charges[0].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Seller.OrgNumber != "123456789" || receipt.Customer.Name != "Klipp Kunde" {
+		t.Errorf("expected seller and customer details, got %+v and %+v", receipt.Seller, receipt.Customer)
+	}
+	line := receipt.Lines[0]
+	// 1100 kr at 12 % is 982,14 kr plus 117,86 kr MVA
+	if line.VATRate != 12 || line.NetAmount != 98214 || line.VATAmount != 11786 || receipt.Total != 110000 {
+		t.Errorf("unexpected MVA breakdown %+v", line)
+	}
+
+	if _, err := db.GetReceipt(userID+1, int64(charges[0].ID)); err == nil {
+		t.Errorf("expected other members not to get the receipt")
+	}
+	if _, err := db.GetReceipt(userID, int64(charges[1].ID)); err == nil {
+		t.Errorf("expected no receipt for a credit")
+	}
+
+	// Refunds keep the receipt and are listed on it
+	if err := db.AdminRefundCharge(userID, int64(charges[0].ID), 110000, "Angret", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	receipt, err = db.GetReceipt(userID, int64(charges[0].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Number != receipts[0] || len(receipt.CreditNotes) != 1 {
+		t.Errorf("expected the refunded charge to keep its receipt with the credit note, got %+v", receipt)
+	}
+}
+
+// Test that the PDF writer makes a complete document with Norwegian letters
+func TestReceiptPDF(t *testing.T) {
+	doc := pdf.New("Kvittering 1")
+	doc.Text(50, 70, 12, true, "Årskort for Ørjan (æøå)")
+	doc.TextRight(545, 90, 10, false, "1 100,00 kr")
+	doc.Line(45, 100, 550, 100)
+	out := doc.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("expected a complete PDF")
+	}
+	if !bytes.Contains(out, []byte("(\xc5rskort for \xd8rjan \\(\xe6\xf8\xe5\\)) Tj")) {
+		t.Errorf("expected the text in WinAnsiEncoding with brackets escaped")
+	}
+	if w := pdf.TextWidth("100", 10, false); w != 16.68 {
+		t.Errorf("expected digits to be 5.56 points wide at 10 points, got %v", w)
+	}
+	if lines := pdf.Wrap("Klippekort: 10 klipp Gruppetimer Sal", 10, false, 80); len(lines) < 2 {
+		t.Errorf("expected a long description to wrap, got %v", lines)
+	}
+}
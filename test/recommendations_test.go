@@ -0,0 +1,147 @@
+package test
+
+import (
+	"fmt"
+	"kjernekraft/database"
+	"kjernekraft/models"
+	"testing"
+)
+
+// openRecommendationDB migrates a test database with the studio's plans, as scripts/seed_data.go seeds them,
+// and an inactive plan
+func openRecommendationDB(t *testing.T) *database.Database {
+	db := openTestDB(t)
+	plans := []string{
+		`(1, '12-måneder', 104000, 12, 0, false, false, false, '', true)`,
+		`(2, '6-måneder', 115000, 6, 0, false, false, false, '', true)`,
+		`(3, 'Ingen binding', 125000, 0, 0, false, false, false, '', true)`,
+		`(4, 'Student/Senior 12-måneder', 83000, 12, 0, false, true, false, '', true)`,
+		`(5, 'Student/Senior ingen binding', 104000, 0, 0, false, true, false, '', true)`,
+		`(6, 'Høsttilbud', 104000, 4, 0, false, false, true, '', true)`,
+		`(7, '2-ukers prøve', 52500, 0, 14, true, false, false, '', true)`,
+		`(8, 'Månedskort', 150000, 1, 0, false, false, false, '', true)`,
+		`(9, 'Gammelt tilbud', 99000, 12, 0, false, false, false, '', false)`,
+	}
+	for _, plan := range plans {
+		if _, err := db.Conn.Exec(`INSERT INTO memberships (id, name, price, commitment_months, duration_days, is_trial, is_student_senior, is_special_offer, description, active)
+			VALUES ` + plan); err != nil {
+			t.Fatalf("could not create membership: %v", err)
+		}
+	}
+	return db
+}
+
+// scoreSeeded scores the active plans with the rules in the database
+func scoreSeeded(t *testing.T, db *database.Database, answers map[string]string) ([]models.Recommendation, []string) {
+	plans, err := db.GetAllMemberships()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := db.GetRecommendationRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database.ScoreMemberships(plans, rules, answers)
+}
+
+func recommendedIDs(recommendations []models.Recommendation) string {
+	ids := make([]int, len(recommendations))
+	for i, recommendation := range recommendations {
+		ids[i] = recommendation.ID
+	}
+	return fmt.Sprint(ids)
+}
+
+// Test the recommendations for every questionnaire combination with the rules the migration seeds
+func TestScoreMembershipsDefaultRules(t *testing.T) {
+	db := openRecommendationDB(t)
+	cases := []struct {
+		commitment      string
+		isStudentSenior bool
+		expected        string
+		highlights      int
+	}{
+		{"", false, "[]", 0},
+		{"12", false, "[1]", 0},
+		{"6", false, "[2]", 0},
+		{"0", false, "[7 3]", 0},
+		{"trial", false, "[7 8]", 0},
+		{"autumn_special", false, "[6]", 1},
+		{"", true, "[]", 0},
+		{"12", true, "[4]", 0},
+		{"6", true, "[4 5]", 0}, // No 6 month student plan, all student plans are suggested
+		{"0", true, "[5]", 0},
+		{"trial", true, "[4 5]", 0},
+		{"autumn_special", true, "[4 5]", 0}, // Special offers are not for students
+	}
+
+	for _, c := range cases {
+		for _, startTime := range []string{"now", "custom"} {
+			answers := map[string]string{
+				models.QuestionCommitment:    c.commitment,
+				models.QuestionStudentSenior: fmt.Sprint(c.isStudentSenior),
+				models.QuestionStartTime:     startTime,
+			}
+			recommendations, highlights := scoreSeeded(t, db, answers)
+			if actual := recommendedIDs(recommendations); actual != c.expected || len(highlights) != c.highlights {
+				t.Errorf("commitment %q, student %v, start %q: expected %s with %d highlight(s), got %s with %v",
+					c.commitment, c.isStudentSenior, startTime, c.expected, c.highlights, actual, highlights)
+			}
+		}
+	}
+}
+
+// Test that admin-added rules reorder and highlight plans without touching the handler
+func TestScoreMembershipsCustomRules(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+
+	db := openRecommendationDB(t)
+	rules := []models.RecommendationRule{
+		// Members starting later get the monthly pass suggested first
+		{Question: models.QuestionStartTime, Answer: "custom", MembershipID: intPtr(8), Weight: 20, Highlight: "Start når du vil", Active: true},
+		// Inactive rules are ignored
+		{Question: models.QuestionCommitment, Answer: "12", MembershipID: intPtr(2), Weight: 50, Active: false},
+		// Rules for any answer apply to everyone
+		{Question: models.QuestionCommitment, Answer: models.RecommendationAnyAnswer, MembershipID: intPtr(3), Weight: 1, Active: true},
+	}
+	for _, rule := range rules {
+		if _, err := db.SaveRecommendationRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	answers := map[string]string{
+		models.QuestionCommitment:    "12",
+		models.QuestionStudentSenior: "false",
+		models.QuestionStartTime:     "custom",
+	}
+	recommendations, highlights := scoreSeeded(t, db, answers)
+	if actual := recommendedIDs(recommendations); actual != "[8 1 3]" {
+		t.Errorf("expected [8 1 3], got %s", actual)
+	}
+	if len(highlights) != 1 || highlights[0] != "Start når du vil" {
+		t.Errorf("expected the start time highlight, got %v", highlights)
+	}
+	if !recommendations[0].Highlighted || recommendations[1].Highlighted {
+		t.Errorf("expected only the monthly pass to be highlighted")
+	}
+}
+
+// Test that the trial answer finds trial plans by what they are, whatever IDs they were given
+func TestScoreMembershipsTrialByAttribute(t *testing.T) {
+	db := openRecommendationDB(t)
+	if _, err := db.Conn.Exec(`INSERT INTO memberships (id, name, price, commitment_months, duration_days, is_trial, is_student_senior, is_special_offer, description, active)
+		VALUES (20, 'Prøveuke', 19900, 0, 7, true, false, false, '', true)`); err != nil {
+		t.Fatalf("could not create membership: %v", err)
+	}
+
+	answers := map[string]string{
+		models.QuestionCommitment:    "trial",
+		models.QuestionStudentSenior: "false",
+		models.QuestionStartTime:     "now",
+	}
+	recommendations, _ := scoreSeeded(t, db, answers)
+	if actual := recommendedIDs(recommendations); actual != "[20 7 8]" {
+		t.Errorf("expected both trials and the monthly pass, got %s", actual)
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"fmt"
+	"kjernekraft/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingPaymentProvider turns every refund down
+type failingPaymentProvider struct{}
+
+func (failingPaymentProvider) Refund(charge models.Charge, amount int) (string, error) {
+	return "", fmt.Errorf("kortet er sperret")
+}
+
+// Test partial and full refunds of a klippekort purchase, the credit notes and the klipp taken back
+func TestRefundKlippekortCharge(t *testing.T) {
+	db := openTestDB(t)
+	userID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	charges, err := db.GetUserCharges(userID, "")
+	if err != nil || len(charges) != 1 {
+		t.Fatalf("expected one charge, got %v (%v)", charges, err)
+	}
+	chargeID := int64(charges[0].ID)
+	now := time.Now()
+
+	if err := db.AdminRefundCharge(userID, chargeID, 10000, " ", now); err == nil {
+		t.Errorf("expected a refund without a reason to be rejected")
+	}
+	if err := db.AdminRefundCharge(userID, chargeID, 110001, "For mye", now); err == nil {
+		t.Errorf("expected a refund of more than the charge to be rejected")
+	}
+
+	db.Payments = failingPaymentProvider{}
+	if err := db.AdminRefundCharge(userID, chargeID, 44000, "Flyttet", now); err == nil {
+		t.Errorf("expected a refund the provider turns down to fail")
+	}
+	db.Payments = nil
+	if notes, _ := db.GetCreditNotes(userID); len(notes) != 0 {
+		t.Fatalf("expected no credit note for a failed refund, got %+v", notes)
+	}
+
+	// 2 of the 5 klipp follow 44000 of the 110000 øre
+	if err := db.AdminRefundCharge(userID, chargeID, 44000, "Flyttet", now); err != nil {
+		t.Fatal(err)
+	}
+	charges, _ = db.GetUserCharges(userID, "")
+	if charges[0].Status != models.ChargeStatusPartiallyRefunded || charges[0].RefundedAmount != 44000 {
+		t.Errorf("expected the charge to be partially refunded, got %s with %d refunded", charges[0].Status, charges[0].RefundedAmount)
+	}
+	cards, _ := db.GetAllUserKlippekort(userID)
+	if cards[0].RemainingKlipp != 3 {
+		t.Errorf("expected 2 klipp to be taken back, got %d left", cards[0].RemainingKlipp)
+	}
+
+	// The rest takes back the unused klipp, one of which has been used meanwhile
+	cardID := int64(cards[0].UserKlippekort.ID)
+	if err := db.SetKlippekortBalance(cardID, 2, "Brukt", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AdminRefundCharge(userID, chargeID, 66000, "Flyttet", now); err != nil {
+		t.Fatal(err)
+	}
+	charges, _ = db.GetUserCharges(userID, "")
+	if charges[0].Status != models.ChargeStatusRefunded || charges[0].Refundable() {
+		t.Errorf("expected the charge to be fully refunded, got %s", charges[0].Status)
+	}
+	cards, _ = db.GetAllUserKlippekort(userID)
+	if cards[0].RemainingKlipp != 0 {
+		t.Errorf("expected no klipp left after a full refund, got %d", cards[0].RemainingKlipp)
+	}
+	if err := db.AdminRefundCharge(userID, chargeID, 100, "Igjen", now); err == nil {
+		t.Errorf("expected a refunded charge not to be refundable again")
+	}
+
+	notes, err := db.GetCreditNotes(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 {
+		t.Fatalf("expected two credit notes, got %+v", notes)
+	}
+	prefix := fmt.Sprintf("KN-%d-", now.Year())
+	if notes[1].Number != prefix+"0001" || notes[0].Number != prefix+"0002" {
+		t.Errorf("expected credit notes numbered in their own series, got %s and %s", notes[1].Number, notes[0].Number)
+	}
+	if notes[1].KlippRemoved != 2 || notes[0].KlippRemoved != 2 || !strings.HasPrefix(notes[0].ProviderRefundID, "re_sim_") {
+		t.Errorf("unexpected credit notes %+v", notes)
+	}
+
+	history, _ := db.GetKlippHistory(userID)
+	if history[0].Kind != models.KlippChargeRefunded || history[0].Klipp != -2 {
+		t.Errorf("expected the refund in the klipp history, got %+v", history[0])
+	}
+	actions, _ := db.GetAdminActions(userID)
+	if len(actions) != 2 || actions[0].Action != models.AdminActionRefundCharge {
+		t.Errorf("expected the refunds in the admin log, got %+v", actions)
+	}
+}
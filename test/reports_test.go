@@ -0,0 +1,121 @@
+package test
+
+import (
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test that the KPI report counts members, revenue, klippekort and class attendance
+func TestKPIReport(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+
+	memberID, membershipID := insertOverrideMember(t, db)
+	if err := db.CreateDefaultPaymentMethods(memberID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutMembership(memberID, membershipID, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	customerID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CreateDefaultPaymentMethods(customerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutKlippekort(customerID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// A class yesterday where one of the two who signed up checked in
+	eventID, err := db.CreateEvent(models.Event{Title: "Morgenyoga", StartTime: now.AddDate(0, 0, -1), EndTime: now.AddDate(0, 0, -1).Add(time.Hour),
+		ClassType: "yoga", TeacherName: "Lærer", Capacity: 10, DeliveryMode: models.DeliveryInPerson})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []int64{memberID, customerID} {
+		if _, err := db.Conn.Exec("INSERT INTO event_signups (user_id, event_id, signup_date, attendance_mode) VALUES (?, ?, ?, 'in_person')",
+			userID, eventID, now.AddDate(0, 0, -2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetCheckedIn(memberID, eventID, true, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCheckedIn(memberID, eventID+1, true, now); err == nil {
+		t.Errorf("expected a check-in without signup to be rejected")
+	}
+	participants, err := db.GetEventParticipants(eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkedIn := 0
+	for _, participant := range participants {
+		if participant.CheckedInAt != nil {
+			checkedIn++
+		}
+	}
+	if len(participants) != 2 || checkedIn != 1 {
+		t.Errorf("expected two participants with one checked in, got %+v", participants)
+	}
+
+	report, err := db.GetKPIReport(from, to, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ActiveMembers != 1 || report.MRR != 69900 || len(report.MembersByPlan) != 1 || report.MembersByPlan[0].Name != "Overstyrt Fleks" {
+		t.Errorf("expected one member paying 699 kr a month, got %d members, MRR %d, %+v", report.ActiveMembers, report.MRR, report.MembersByPlan)
+	}
+	if len(report.Months) != 2 {
+		t.Fatalf("expected last month and this month, got %+v", report.Months)
+	}
+	month := report.Months[1]
+	if month.Signups != 2 || month.NewMembers != 1 || month.MembershipRevenue != 69900 || month.KlippekortRevenue != 110000 {
+		t.Errorf("expected this month's signups and revenue, got %+v", month)
+	}
+	if len(report.KlippekortSales) != 1 || report.KlippekortSales[0].Sold != 1 || report.KlippekortSales[0].Klipp != 5 {
+		t.Errorf("expected one 5 klipp card sold, got %+v", report.KlippekortSales)
+	}
+	if report.OutstandingKlipp != 5 || report.KlippLiability != 110000 {
+		t.Errorf("expected 5 unused klipp worth 1100 kr, got %d and %d", report.OutstandingKlipp, report.KlippLiability)
+	}
+	if len(report.ClassTypes) != 1 || report.ClassTypes[0].Booked != 2 || report.ClassTypes[0].UtilisationRate() != 20 ||
+		report.ClassTypes[0].Tracked != 2 || report.ClassTypes[0].NoShows != 1 {
+		t.Errorf("expected a yoga class 20%% full with one no-show, got %+v", report.ClassTypes)
+	}
+	if len(report.TimeSlots) != 1 || report.TimeSlots[0].Weekday == "" {
+		t.Errorf("expected the class in a weekday time slot, got %+v", report.TimeSlots)
+	}
+
+	// MRR is what billing charges, here with the household discount of a member paid for by someone else
+	if _, err := db.CreateHousehold(customerID, "Familien"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddHouseholdMember(customerID, "overstyrt@example.com", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetHouseholdDiscount(membershipID, 20); err != nil {
+		t.Fatal(err)
+	}
+	report, err = db.GetKPIReport(from, to, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := models.HouseholdDiscount(69900, 20); report.MRR != expected || report.MembersByPlan[0].MRR != expected {
+		t.Errorf("expected the household price %d as MRR, got %d", expected, report.MRR)
+	}
+
+	// Leaving counts as churn and the member no longer counts towards MRR
+	if err := db.RemoveUserMembership(memberID); err != nil {
+		t.Fatal(err)
+	}
+	report, err = db.GetKPIReport(from, to, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	month = report.Months[1]
+	if report.ActiveMembers != 0 || report.MRR != 0 || month.Cancellations != 1 || month.Churned != 1 {
+		t.Errorf("expected the member to have churned, got %d members, MRR %d, %+v", report.ActiveMembers, report.MRR, month)
+	}
+}
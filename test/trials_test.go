@@ -0,0 +1,28 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test that fixed-term plans end after their duration and other plans run until cancelled
+func TestMembershipTermEnd(t *testing.T) {
+	start := time.Date(2025, 10, 20, 9, 30, 0, 0, time.UTC)
+
+	trial := models.Membership{Name: "2-ukers prøve", DurationDays: 14, IsTrial: true}
+	end, ok := database.MembershipTermEnd(trial, start)
+	if !ok {
+		t.Fatalf("expected trial to have an end")
+	}
+	if expected := time.Date(2025, 11, 3, 9, 30, 0, 0, time.UTC); !end.Equal(expected) {
+		t.Errorf("expected trial to end %v, got %v", expected, end)
+	}
+
+	// Binding is not duration, a 12 month plan keeps renewing
+	regular := models.Membership{Name: "12-måneder", CommitmentMonths: 12}
+	if _, ok := database.MembershipTermEnd(regular, start); ok {
+		t.Errorf("expected plan without duration to run until cancelled")
+	}
+}
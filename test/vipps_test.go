@@ -0,0 +1,113 @@
+package test
+
+import (
+	"kjernekraft/database"
+	"kjernekraft/models"
+	"testing"
+	"time"
+)
+
+// Test paying klippekort with one-off Vipps payments and memberships on a recurring agreement,
+// with the payment method recorded in the charge ledger
+func TestVippsPayments(t *testing.T) {
+	db := openTestDB(t)
+	vipps := database.NewMockVipps()
+	vipps.ManualApproval = true
+	db.Vipps = vipps
+	userID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	if _, err := db.AddVippsPaymentMethod(userID, "2212 3456", now); err == nil {
+		t.Errorf("expected a number that is not a Norwegian mobile number to be rejected")
+	}
+	vippsID, err := db.AddVippsPaymentMethod(userID, "+47 412 34 567", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddVippsPaymentMethod(userID, "41234567", now); err == nil {
+		t.Errorf("expected the same number not to be added twice")
+	}
+	methods, err := db.GetPaymentMethods(userID)
+	if err != nil || len(methods) != 3 {
+		t.Fatalf("expected two cards and Vipps, got %v (%v)", methods, err)
+	}
+	pm := methods[0]
+	if pm.Type != models.PaymentMethodTypeVipps || !pm.IsDefault || pm.PhoneNumber != "41234567" || pm.AgreementStatus != models.VippsAgreementPending {
+		t.Errorf("expected Vipps to be the default with a pending agreement, got %+v", pm)
+	}
+
+	// Klippekort are one-off payments and need no agreement
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	charges, err := db.GetUserCharges(userID, "klippekort")
+	if err != nil || len(charges) != 1 {
+		t.Fatalf("expected one klippekort charge, got %d (%v)", len(charges), err)
+	}
+	klippekortCharge := charges[0]
+	if klippekortCharge.PaymentMethodType != models.PaymentMethodTypeVipps || klippekortCharge.Status != models.ChargeStatusSucceeded ||
+		klippekortCharge.PaymentMethodLast4 == nil || *klippekortCharge.PaymentMethodLast4 != "4567" {
+		t.Errorf("expected a succeeded Vipps charge, got %+v", klippekortCharge)
+	}
+	if captured, _ := vipps.Captured(klippekortCharge.ProviderReference); captured != 110000 {
+		t.Errorf("expected the payment to be captured in Vipps, got %d", captured)
+	}
+
+	// Memberships wait for the agreement to be approved
+	if err := db.SimulateBilling(userID, 69900, "Medlemskap: Fleks", "medlemskap"); err == nil {
+		t.Errorf("expected a membership not to be charged on a pending agreement")
+	}
+	if err := vipps.ApproveAgreement(pm.AgreementID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SimulateBilling(userID, 69900, "Medlemskap: Fleks", "medlemskap"); err != nil {
+		t.Fatal(err)
+	}
+	charges, err = db.GetUserCharges(userID, "medlemskap")
+	if err != nil || len(charges) != 2 {
+		t.Fatalf("expected a failed and a succeeded membership charge, got %d (%v)", len(charges), err)
+	}
+	if charges[0].Status != models.ChargeStatusSucceeded || charges[1].Status != models.ChargeStatusFailed || charges[1].FailureReason == nil {
+		t.Errorf("expected the charge before the approval to have failed, got %+v", charges)
+	}
+	if captured, _ := vipps.Captured(charges[0].ProviderReference); captured != 69900 {
+		t.Errorf("expected the membership to be charged on the agreement, got %d", captured)
+	}
+
+	// A declined payment gives no klipp
+	vipps.Decline("41234567")
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err == nil {
+		t.Errorf("expected a declined payment to fail the purchase")
+	}
+	cards, err := db.GetAllUserKlippekort(userID)
+	if err != nil || len(cards) != 1 || cards[0].UserKlippekort.RemainingKlipp != 5 {
+		t.Errorf("expected the klipp of the declined purchase to be taken back, got %+v (%v)", cards, err)
+	}
+
+	// Refunds go back through Vipps
+	if err := db.AdminRefundCharge(userID, int64(klippekortCharge.ID), 22000, "Ett klipp for mye", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, refunded := vipps.Captured(klippekortCharge.ProviderReference); refunded != 22000 {
+		t.Errorf("expected the refund to be paid back through Vipps, got %d", refunded)
+	}
+
+	// Removing Vipps stops the agreement and the cards take over
+	if err := db.RemovePaymentMethod(userID, vippsID); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := vipps.GetAgreement(pm.AgreementID); status != models.VippsAgreementStopped {
+		t.Errorf("expected the agreement to be stopped, got %s", status)
+	}
+	methods, err = db.GetPaymentMethods(userID)
+	if err != nil || len(methods) != 2 || !methods[0].IsDefault || methods[0].Type != models.PaymentMethodTypeCard {
+		t.Errorf("expected a card to become the default, got %+v (%v)", methods, err)
+	}
+	charges, err = db.GetUserCharges(userID, "klippekort")
+	if err != nil || charges[len(charges)-1].PaymentMethodType != models.PaymentMethodTypeVipps || charges[len(charges)-1].PaymentMethodID != nil {
+		t.Errorf("expected the ledger to keep the Vipps charges without the payment method, got %+v (%v)", charges, err)
+	}
+}
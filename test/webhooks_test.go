@@ -0,0 +1,189 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"kjernekraft/database"
+	"kjernekraft/handlers"
+	"kjernekraft/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// deliverWebhook signs an event with the webhook secret and delivers it to the webhook endpoint
+func deliverWebhook(t *testing.T, event models.WebhookEventPayload, at time.Time) (int, map[string]interface{}) {
+	t.Helper()
+	req, err := handlers.NewSignedWebhookRequest(handlers.WebhookSecret, event, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.PaymentWebhookHandler(rec, req)
+
+	var response map[string]interface{}
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, response
+}
+
+// Test that signed events update charges and payment methods once, however often they are
+// delivered, and that a failed event can be replayed
+func TestPaymentWebhooks(t *testing.T) {
+	db := openTestDB(t)
+	handlers.DB = db
+	t.Setenv("WEBHOOK_SECRET", "")
+	if err := handlers.LoadWebhookSecret(); err == nil {
+		t.Errorf("expected a missing webhook secret to be refused")
+	}
+	t.Setenv("WEBHOOK_SECRET", "whsec-test")
+	if err := handlers.LoadWebhookSecret(); err != nil {
+		t.Fatal(err)
+	}
+	userID, packageID := insertKlippekortCustomer(t, db)
+	if err := db.CreateDefaultPaymentMethods(userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckoutKlippekort(userID, packageID, ""); err != nil {
+		t.Fatal(err)
+	}
+	charges, err := db.GetUserCharges(userID, "klippekort")
+	if err != nil || len(charges) != 1 {
+		t.Fatalf("expected a klippekort charge, got %v (%v)", charges, err)
+	}
+	now := time.Now()
+
+	failed := models.WebhookEventPayload{ID: fmt.Sprintf("evt_failed_%d", userID), Type: models.WebhookChargeFailed,
+		Provider: models.PaymentProviderStripe, Data: models.WebhookEventData{ChargeID: charges[0].ID, FailureReason: "3-D Secure feilet"}}
+
+	// Unsigned, wrongly signed and old deliveries are rejected
+	req, err := handlers.NewSignedWebhookRequest("whsec-wrong", failed, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.PaymentWebhookHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an event signed with the wrong secret to be rejected, got %d", rec.Code)
+	}
+	if code, _ := deliverWebhook(t, failed, now.Add(-time.Hour)); code != http.StatusBadRequest {
+		t.Errorf("expected an old signature to be rejected, got %d", code)
+	}
+
+	// The failed authentication fails the charge and takes the klipp back, once
+	for i := 0; i < 2; i++ {
+		code, response := deliverWebhook(t, failed, now)
+		if code != http.StatusOK || response["status"] != models.WebhookEventProcessed || response["duplicate"] != (i == 1) {
+			t.Fatalf("delivery %d: unexpected response %d %v", i+1, code, response)
+		}
+	}
+	charges, err = db.GetUserCharges(userID, "klippekort")
+	if err != nil || charges[0].Status != models.ChargeStatusFailed || charges[0].FailureReason == nil || *charges[0].FailureReason != "3-D Secure feilet" {
+		t.Errorf("expected the charge to fail, got %+v (%v)", charges, err)
+	}
+	cards, err := db.GetUserKlippekort(userID)
+	if err != nil || len(cards) != 1 || cards[0].RemainingKlipp != 0 {
+		t.Errorf("expected the unpaid klipp to be taken back, got %+v (%v)", cards, err)
+	}
+	events, err := db.GetWebhookEvents(models.WebhookEventProcessed, 10)
+	if err != nil || len(events) != 1 || events[0].Attempts != 1 {
+		t.Errorf("expected the event to be stored and processed once, got %+v (%v)", events, err)
+	}
+
+	// A dispute on a charge not stored yet fails until it is replayed
+	if err := db.SimulateBilling(userID, 69900, "Medlemskap", "medlemskap"); err != nil {
+		t.Fatal(err)
+	}
+	disputed := models.WebhookEventPayload{ID: fmt.Sprintf("evt_dispute_%d", userID), Type: models.WebhookDisputeCreated,
+		Provider: models.PaymentProviderStripe, Data: models.WebhookEventData{Charge: fmt.Sprintf("ch_late_%d", userID)}}
+	if _, response := deliverWebhook(t, disputed, now); response["status"] != models.WebhookEventFailed {
+		t.Fatalf("expected an event about an unknown charge to fail, got %v", response)
+	}
+	failedEvents, err := db.GetWebhookEvents(models.WebhookEventFailed, 10)
+	if err != nil || len(failedEvents) != 1 {
+		t.Fatalf("expected one failed event, got %v (%v)", failedEvents, err)
+	}
+	if _, err := db.Conn.Exec("UPDATE charges SET stripe_charge_id = ? WHERE user_id = ? AND type = 'medlemskap'", disputed.Data.Charge, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplayWebhookEvent(int64(failedEvents[0].ID), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplayWebhookEvent(int64(failedEvents[0].ID), now); err == nil {
+		t.Errorf("expected a processed event not to be replayed")
+	}
+	lost := models.WebhookEventPayload{ID: fmt.Sprintf("evt_lost_%d", userID), Type: models.WebhookDisputeClosed,
+		Provider: models.PaymentProviderStripe, Data: models.WebhookEventData{Charge: disputed.Data.Charge, Outcome: models.DisputeLost}}
+	deliverWebhook(t, lost, now)
+	charges, err = db.GetUserCharges(userID, "medlemskap")
+	if err != nil || len(charges) != 1 || charges[0].Status != models.ChargeStatusChargedBack || charges[0].Refundable() {
+		t.Errorf("expected the membership charge to be charged back, got %+v (%v)", charges, err)
+	}
+
+	// An expired card is no longer charged, a renewed one is again
+	visa := fmt.Sprintf("pm_default_visa_%d", userID)
+	expired := models.WebhookEventPayload{ID: fmt.Sprintf("evt_expired_%d", userID), Type: models.WebhookPaymentMethodExpired,
+		Provider: models.PaymentProviderStripe, Data: models.WebhookEventData{PaymentMethod: visa, ExpiryMonth: 9, ExpiryYear: 2026}}
+	deliverWebhook(t, expired, now)
+	methods, err := db.GetPaymentMethods(userID)
+	if err != nil || len(methods) != 2 || methods[0].Brand != "mastercard" || !methods[0].IsDefault || methods[1].Active {
+		t.Errorf("expected the mastercard to take over from the expired visa, got %+v (%v)", methods, err)
+	}
+	if err := db.SetDefaultPaymentMethod(userID, int64(methods[1].ID)); err == nil {
+		t.Errorf("expected an expired card not to become the default")
+	}
+	renewed := models.WebhookEventPayload{ID: fmt.Sprintf("evt_renewed_%d", userID), Type: models.WebhookPaymentMethodUpdated,
+		Provider: models.PaymentProviderStripe, Data: models.WebhookEventData{PaymentMethod: visa, ExpiryMonth: 9, ExpiryYear: 2030}}
+	deliverWebhook(t, renewed, now)
+	methods, err = db.GetPaymentMethods(userID)
+	if err != nil || !methods[1].Active || methods[1].ExpiryYear != 2030 {
+		t.Errorf("expected the renewed visa to be active, got %+v (%v)", methods, err)
+	}
+
+	// Events the studio does not act on are kept
+	payout := models.WebhookEventPayload{ID: fmt.Sprintf("evt_payout_%d", userID), Type: "payout.paid", Provider: models.PaymentProviderStripe}
+	if _, response := deliverWebhook(t, payout, now); response["status"] != models.WebhookEventIgnored {
+		t.Errorf("expected a payout to be ignored, got %v", response)
+	}
+}
+
+// Test that an event left processing, e.g. by a restart, can be replayed once it is stuck
+func TestStuckWebhookEvent(t *testing.T) {
+	db := openTestDB(t)
+	now := time.Now()
+	event := models.WebhookEventPayload{ID: "evt_stuck", Type: "payout.paid", Provider: models.PaymentProviderStripe}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _, err := db.RecordWebhookEvent(event, payload, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Conn.Exec("UPDATE webhook_events SET status = ?, claimed_at = ? WHERE id = ?",
+		models.WebhookEventProcessing, now, stored.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.ReplayWebhookEvent(int64(stored.ID), now.Add(time.Minute)); err == nil {
+		t.Errorf("expected an event being processed not to be replayed")
+	}
+	if stuck, err := db.GetStuckWebhookEvents(now.Add(time.Minute), 10); err != nil || len(stuck) != 0 {
+		t.Errorf("expected no stuck events yet, got %v (%v)", stuck, err)
+	}
+
+	later := now.Add(database.WebhookProcessingTimeout + time.Minute)
+	if stuck, err := db.GetStuckWebhookEvents(later, 10); err != nil || len(stuck) != 1 {
+		t.Fatalf("expected the event to be stuck, got %v (%v)", stuck, err)
+	}
+	if err := db.ReplayWebhookEvent(int64(stored.ID), later); err != nil {
+		t.Fatal(err)
+	}
+	if replayed, err := db.GetWebhookEvent(int64(stored.ID)); err != nil || replayed.Status != models.WebhookEventIgnored || replayed.Attempts != 1 {
+		t.Errorf("expected the replayed event to be done, got %+v (%v)", replayed, err)
+	}
+}